@@ -0,0 +1,354 @@
+package addrman
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	mrand "math/rand"
+	"sync"
+	"time"
+
+	"github.com/senseyman/bitcoin-handshake/model"
+	"github.com/senseyman/bitcoin-handshake/utils"
+)
+
+// The layout of the tables follows Bitcoin Core's addrman: addresses we have only heard about live in the
+// "new" table, addresses we have successfully connected to are moved into the "tried" table.
+const (
+	newBucketCount        = 1024
+	triedBucketCount      = 256
+	bucketSize            = 64
+	newBucketsPerSrcGroup = 64
+	triedBucketsPerGroup  = 8
+)
+
+const (
+	// how old addresses can maximally be
+	horizon = 30 * 24 * time.Hour
+	// after how many failed attempts we give up on a new node
+	maxRetries = 3
+	// how many successive failures are allowed
+	maxFailures = 10
+	// in at least this duration
+	minFailPeriod = 7 * 24 * time.Hour
+	// how recent a successful connection should be before we allow an address to be evicted from tried
+	replacementPeriod = 4 * time.Hour
+	// how often the last seen time of a connected address is updated
+	seenUpdateInterval = 20 * time.Minute
+)
+
+type AddrMan struct {
+	mu sync.Mutex
+
+	filePath string
+	key      [32]byte
+
+	nextID     int
+	addrs      map[int]*knownAddress
+	addrIndex  map[string]int
+	newTable   [newBucketCount][bucketSize]int
+	triedTable [triedBucketCount][bucketSize]int
+	newCount   int
+	triedCount int
+
+	rnd *mrand.Rand
+	now func() time.Time
+}
+
+// New creates address manager. If filePath is not empty, addresses are loaded from the file if it exists
+// and Save writes them back to it.
+func New(filePath string) (*AddrMan, error) {
+	a := newAddrMan(filePath)
+	if _, err := rand.Read(a.key[:]); err != nil {
+		return nil, err
+	}
+
+	if filePath != "" {
+		if err := a.load(); err != nil {
+			return nil, err
+		}
+	}
+
+	return a, nil
+}
+
+func newAddrMan(filePath string) *AddrMan {
+	return &AddrMan{
+		filePath:  filePath,
+		nextID:    1,
+		addrs:     make(map[int]*knownAddress),
+		addrIndex: make(map[string]int),
+		rnd:       mrand.New(mrand.NewSource(time.Now().UnixNano())), //nolint:gosec // not used for security
+		now:       time.Now,
+	}
+}
+
+// Size returns the number of known addresses in new and tried tables.
+func (a *AddrMan) Size() (newCount, triedCount int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.newCount, a.triedCount
+}
+
+// Add puts addresses learned from source into the new table and returns how many of them were not known before.
+func (a *AddrMan) Add(addrs []model.NetAddressV2, source model.NetAddressV2) int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	added := 0
+	for _, addr := range addrs {
+		if a.add(addr, source) {
+			added++
+		}
+	}
+
+	return added
+}
+
+func (a *AddrMan) add(addr model.NetAddressV2, source model.NetAddressV2) bool {
+	if !isRoutable(addr) {
+		return false
+	}
+
+	now := a.now()
+	seen := time.Unix(addr.Timestamp, 0)
+	if addr.Timestamp <= 0 || seen.After(now.Add(10*time.Minute)) {
+		// don't trust timestamps from the future, treat such address as seen some days ago
+		seen = now.Add(-5 * 24 * time.Hour)
+	}
+
+	if id, ok := a.addrIndex[addr.Key()]; ok {
+		ka := a.addrs[id]
+		if seen.After(ka.LastSeen) {
+			ka.LastSeen = seen
+		}
+		ka.Addr.Services |= addr.Services
+
+		return false
+	}
+
+	ka := &knownAddress{
+		Addr:     addr,
+		Source:   source,
+		LastSeen: seen,
+	}
+	ka.Addr.Timestamp = 0
+
+	bucket := a.newBucket(addr, source)
+	pos := a.bucketPosition(true, bucket, addr)
+	if existingID := a.newTable[bucket][pos]; existingID != 0 {
+		// the slot is taken, replace the old entry only if it's useless
+		if !a.addrs[existingID].isTerrible(now) {
+			return false
+		}
+		a.delete(existingID)
+	}
+
+	id := a.create(ka)
+	a.newTable[bucket][pos] = id
+	a.newCount++
+
+	return true
+}
+
+// Attempt marks that we tried to connect to the address.
+func (a *AddrMan) Attempt(addr model.NetAddressV2) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	id, ok := a.addrIndex[addr.Key()]
+	if !ok {
+		return
+	}
+
+	ka := a.addrs[id]
+	ka.LastAttempt = a.now()
+	ka.Attempts++
+}
+
+// Connected updates the last seen time of the address we are currently connected to.
+func (a *AddrMan) Connected(addr model.NetAddressV2) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	id, ok := a.addrIndex[addr.Key()]
+	if !ok {
+		return
+	}
+
+	ka := a.addrs[id]
+	now := a.now()
+	if now.Sub(ka.LastSeen) > seenUpdateInterval {
+		ka.LastSeen = now
+	}
+}
+
+// Good marks the address as successfully connected and moves it into the tried table.
+func (a *AddrMan) Good(addr model.NetAddressV2) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	id, ok := a.addrIndex[addr.Key()]
+	if !ok {
+		// manually added nodes may be unknown, remember them as seen by themselves
+		if !a.add(addr, addr) {
+			return
+		}
+		id = a.addrIndex[addr.Key()]
+	}
+
+	now := a.now()
+	ka := a.addrs[id]
+	ka.LastSuccess = now
+	ka.LastAttempt = now
+	ka.LastSeen = now
+	ka.Attempts = 0
+
+	if ka.Tried {
+		return
+	}
+
+	a.removeFromNew(id)
+	a.makeTried(id)
+}
+
+// Select returns the next candidate for an outbound connection. If newOnly is true, only addresses
+// we have never connected to are considered.
+func (a *AddrMan) Select(newOnly bool) (model.NetAddressV2, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.newCount == 0 && (newOnly || a.triedCount == 0) {
+		return model.NetAddressV2{}, false
+	}
+
+	useTried := !newOnly && a.triedCount > 0 && (a.newCount == 0 || a.rnd.Intn(2) == 0)
+
+	now := a.now()
+	chanceFactor := 1.0
+	for {
+		var id int
+		if useTried {
+			id = a.randomEntry(a.triedTable[:])
+		} else {
+			id = a.randomEntry(a.newTable[:])
+		}
+
+		ka := a.addrs[id]
+		if a.rnd.Float64() < chanceFactor*ka.chance(now) {
+			addr := ka.Addr
+			addr.Timestamp = ka.LastSeen.Unix()
+			return addr, true
+		}
+		chanceFactor *= 1.2
+	}
+}
+
+// randomEntry picks a random non-empty slot of the table, the table must not be empty.
+func (a *AddrMan) randomEntry(table [][bucketSize]int) int {
+	for {
+		bucket := a.rnd.Intn(len(table))
+		start := a.rnd.Intn(bucketSize)
+		for i := 0; i < bucketSize; i++ {
+			if id := table[bucket][(start+i)%bucketSize]; id != 0 {
+				return id
+			}
+		}
+	}
+}
+
+func (a *AddrMan) create(ka *knownAddress) int {
+	id := a.nextID
+	a.nextID++
+
+	a.addrs[id] = ka
+	a.addrIndex[ka.Addr.Key()] = id
+
+	return id
+}
+
+func (a *AddrMan) delete(id int) {
+	ka := a.addrs[id]
+	a.removeFromNew(id)
+	delete(a.addrIndex, ka.Addr.Key())
+	delete(a.addrs, id)
+}
+
+func (a *AddrMan) removeFromNew(id int) {
+	ka := a.addrs[id]
+	bucket := a.newBucket(ka.Addr, ka.Source)
+	pos := a.bucketPosition(true, bucket, ka.Addr)
+	if a.newTable[bucket][pos] == id {
+		a.newTable[bucket][pos] = 0
+		a.newCount--
+	}
+}
+
+func (a *AddrMan) makeTried(id int) {
+	ka := a.addrs[id]
+
+	bucket := a.triedBucket(ka.Addr)
+	pos := a.bucketPosition(false, bucket, ka.Addr)
+
+	// the slot is taken, move the old entry back into the new table
+	if evictedID := a.triedTable[bucket][pos]; evictedID != 0 {
+		evicted := a.addrs[evictedID]
+		evicted.Tried = false
+		a.triedTable[bucket][pos] = 0
+		a.triedCount--
+
+		newBucket := a.newBucket(evicted.Addr, evicted.Source)
+		newPos := a.bucketPosition(true, newBucket, evicted.Addr)
+		if occupiedID := a.newTable[newBucket][newPos]; occupiedID != 0 {
+			a.delete(occupiedID)
+		}
+		a.newTable[newBucket][newPos] = evictedID
+		a.newCount++
+	}
+
+	ka.Tried = true
+	a.triedTable[bucket][pos] = id
+	a.triedCount++
+}
+
+func (a *AddrMan) triedBucket(addr model.NetAddressV2) int {
+	h1 := a.hash([]byte(addr.Key())) % triedBucketsPerGroup
+	h2 := a.hash(netGroup(addr), uint64Bytes(h1)) % triedBucketCount
+
+	return int(h2)
+}
+
+func (a *AddrMan) newBucket(addr, source model.NetAddressV2) int {
+	srcGroup := netGroup(source)
+	h1 := a.hash(netGroup(addr), srcGroup) % newBucketsPerSrcGroup
+	h2 := a.hash(srcGroup, uint64Bytes(h1)) % newBucketCount
+
+	return int(h2)
+}
+
+func (a *AddrMan) bucketPosition(isNew bool, bucket int, addr model.NetAddressV2) int {
+	tableID := []byte{'K'}
+	if isNew {
+		tableID = []byte{'N'}
+	}
+	h := a.hash(tableID, uint64Bytes(uint64(bucket)), []byte(addr.Key())) % bucketSize
+
+	return int(h)
+}
+
+// hash mixes the secret key into the data, so the placement of addresses can't be predicted by an attacker.
+func (a *AddrMan) hash(parts ...[]byte) uint64 {
+	data := append([]byte(nil), a.key[:]...)
+	for _, p := range parts {
+		data = append(data, uint64Bytes(uint64(len(p)))...)
+		data = append(data, p...)
+	}
+
+	return binary.LittleEndian.Uint64(utils.DoubleHashB(data)[:8])
+}
+
+func uint64Bytes(v uint64) []byte {
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint64(b, v)
+	return b
+}
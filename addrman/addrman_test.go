@@ -0,0 +1,280 @@
+package addrman
+
+import (
+	"fmt"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/senseyman/bitcoin-handshake/model"
+)
+
+func testAddr(ip string, port uint16) model.NetAddressV2 {
+	return model.NewNetAddressV2FromIP(net.ParseIP(ip), port, 1, time.Now().Unix())
+}
+
+func TestAddrMan_Add(t *testing.T) {
+	source := testAddr("8.8.8.8", 18333)
+
+	testCases := []struct {
+		name     string
+		addrs    []model.NetAddressV2
+		expAdded int
+		expNew   int
+	}{
+		{
+			name:     "success",
+			addrs:    []model.NetAddressV2{testAddr("1.2.3.4", 18333), testAddr("2001:db9::1", 18333)},
+			expAdded: 2,
+			expNew:   2,
+		},
+		{
+			name:     "duplicates",
+			addrs:    []model.NetAddressV2{testAddr("1.2.3.4", 18333), testAddr("1.2.3.4", 18333)},
+			expAdded: 1,
+			expNew:   1,
+		},
+		{
+			name:     "same_ip_different_port",
+			addrs:    []model.NetAddressV2{testAddr("1.2.3.4", 18333), testAddr("1.2.3.4", 18334)},
+			expAdded: 2,
+			expNew:   2,
+		},
+		{
+			name: "unroutable",
+			addrs: []model.NetAddressV2{
+				testAddr("127.0.0.1", 18333),
+				testAddr("10.0.0.1", 18333),
+				testAddr("0.0.0.0", 18333),
+				{NetworkID: model.NetTorV3, Addr: []byte{1, 2, 3}},
+			},
+			expAdded: 0,
+			expNew:   0,
+		},
+		{
+			name:     "onion",
+			addrs:    []model.NetAddressV2{{NetworkID: model.NetTorV3, Addr: make([]byte, 32), Port: 18333}},
+			expAdded: 1,
+			expNew:   1,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			a, err := New("")
+			require.NoError(t, err)
+
+			assert.Equal(t, tc.expAdded, a.Add(tc.addrs, source))
+
+			newCount, triedCount := a.Size()
+			assert.Equal(t, tc.expNew, newCount)
+			assert.Zero(t, triedCount)
+		})
+	}
+}
+
+func TestAddrMan_Good(t *testing.T) {
+	a, err := New("")
+	require.NoError(t, err)
+
+	addr := testAddr("1.2.3.4", 18333)
+	a.Add([]model.NetAddressV2{addr, testAddr("5.6.7.8", 18333)}, testAddr("8.8.8.8", 18333))
+	a.Attempt(addr)
+	a.Good(addr)
+
+	newCount, triedCount := a.Size()
+	assert.Equal(t, 1, newCount)
+	assert.Equal(t, 1, triedCount)
+
+	// unknown address is added straight into tried table
+	a.Good(testAddr("9.9.9.9", 8333))
+	newCount, triedCount = a.Size()
+	assert.Equal(t, 1, newCount)
+	assert.Equal(t, 2, triedCount)
+}
+
+func TestAddrMan_Select(t *testing.T) {
+	a, err := New("")
+	require.NoError(t, err)
+
+	_, ok := a.Select(false)
+	assert.False(t, ok)
+
+	newAddr := testAddr("1.2.3.4", 18333)
+	triedAddr := testAddr("5.6.7.8", 18333)
+	a.Add([]model.NetAddressV2{newAddr, triedAddr}, testAddr("8.8.8.8", 18333))
+	a.Good(triedAddr)
+
+	for i := 0; i < 10; i++ {
+		addr, ok := a.Select(true)
+		require.True(t, ok)
+		assert.Equal(t, newAddr.Key(), addr.Key())
+	}
+
+	seen := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		addr, ok := a.Select(false)
+		require.True(t, ok)
+		seen[addr.Key()] = true
+	}
+	assert.Len(t, seen, 2)
+}
+
+// addrManWith returns the address manager with count routable addresses, the first terrible of them are
+// tried and failed too many times.
+func addrManWith(t *testing.T, count, terrible int) (*AddrMan, int) {
+	a, err := New("")
+	require.NoError(t, err)
+
+	now := time.Now()
+	a.now = func() time.Time { return now }
+	addrs := make([]model.NetAddressV2, 0, count)
+	for i := 0; i < count; i++ {
+		addrs = append(addrs, testAddr(fmt.Sprintf("1.%d.0.1", i+1), 18333))
+	}
+	added := a.Add(addrs, testAddr("8.8.8.8", 18333))
+	for _, addr := range addrs[:terrible] {
+		for i := 0; i < maxRetries; i++ {
+			a.Attempt(addr)
+		}
+	}
+	a.now = func() time.Time { return now.Add(time.Hour) }
+
+	return a, added
+}
+
+func TestAddrMan_GetAddresses(t *testing.T) {
+	testCases := []struct {
+		name         string
+		maxAddresses int
+		maxPct       int
+		expCount     func(good int) int
+	}{
+		{name: "all", expCount: func(good int) int { return good }},
+		{name: "max_pct", maxPct: 23, expCount: func(good int) int { return 23 * (good + 10) / 100 }},
+		{name: "max_addresses", maxAddresses: 7, expCount: func(int) int { return 7 }},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			a, added := addrManWith(t, 100, 10)
+			good := added - 10
+
+			addrs := a.GetAddresses(tc.maxAddresses, tc.maxPct)
+			assert.Len(t, addrs, tc.expCount(good))
+			seen := make(map[string]bool)
+			for _, addr := range addrs {
+				assert.False(t, seen[addr.Key()], "duplicated %s", addr)
+				seen[addr.Key()] = true
+				assert.NotZero(t, addr.Timestamp)
+			}
+			// the terrible addresses are never sent
+			for i := 1; i <= 10; i++ {
+				assert.False(t, seen[testAddr(fmt.Sprintf("1.%d.0.1", i), 18333).Key()])
+			}
+		})
+	}
+}
+
+func TestResponseCache(t *testing.T) {
+	a, added := addrManWith(t, 100, 0)
+	cache := NewResponseCache(a)
+	now := time.Now()
+	cache.now = func() time.Time { return now }
+
+	ipv4 := cache.Addresses(model.NetIPv4)
+	assert.Len(t, ipv4, added*MaxPctAddrToSend/100)
+	// the same response during the lifetime of the cache, the networks have their own responses
+	assert.Equal(t, ipv4, cache.Addresses(model.NetIPv4))
+	onion := cache.Addresses(model.NetTorV3)
+	assert.Len(t, onion, added*MaxPctAddrToSend/100)
+	assert.NotEqual(t, ipv4, onion)
+
+	now = now.Add(responseCacheLifetime + responseCacheJitter)
+	assert.NotEqual(t, ipv4, cache.Addresses(model.NetIPv4))
+}
+
+func TestAddrMan_SaveLoad(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "peers.json")
+
+	a, err := New(filePath)
+	require.NoError(t, err)
+
+	triedAddr := testAddr("5.6.7.8", 18333)
+	a.Add([]model.NetAddressV2{testAddr("1.2.3.4", 18333), triedAddr}, testAddr("8.8.8.8", 18333))
+	a.Good(triedAddr)
+	require.NoError(t, a.Save())
+
+	loaded, err := New(filePath)
+	require.NoError(t, err)
+	assert.Equal(t, a.key, loaded.key)
+
+	newCount, triedCount := loaded.Size()
+	assert.Equal(t, 1, newCount)
+	assert.Equal(t, 1, triedCount)
+
+	addr, ok := loaded.Select(false)
+	require.True(t, ok)
+	assert.Contains(t, []string{"1.2.3.4:18333", "5.6.7.8:18333"}, addr.String())
+}
+
+func TestKnownAddress_IsTerrible(t *testing.T) {
+	now := time.Now()
+
+	testCases := []struct {
+		name  string
+		ka    knownAddress
+		isBad bool
+	}{
+		{
+			name:  "fresh",
+			ka:    knownAddress{LastSeen: now.Add(-time.Hour)},
+			isBad: false,
+		},
+		{
+			name:  "recent_attempt",
+			ka:    knownAddress{LastSeen: now.Add(-horizon * 2), LastAttempt: now.Add(-time.Second)},
+			isBad: false,
+		},
+		{
+			name:  "from_future",
+			ka:    knownAddress{LastSeen: now.Add(time.Hour)},
+			isBad: true,
+		},
+		{
+			name:  "too_old",
+			ka:    knownAddress{LastSeen: now.Add(-horizon - time.Hour)},
+			isBad: true,
+		},
+		{
+			name:  "never_succeeded",
+			ka:    knownAddress{LastSeen: now.Add(-time.Hour), Attempts: maxRetries},
+			isBad: true,
+		},
+		{
+			name: "many_failures",
+			ka: knownAddress{
+				LastSeen:    now.Add(-time.Hour),
+				LastSuccess: now.Add(-minFailPeriod - time.Hour),
+				Attempts:    maxFailures,
+			},
+			isBad: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tc.isBad, tc.ka.isTerrible(now))
+		})
+	}
+}
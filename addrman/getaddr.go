@@ -0,0 +1,100 @@
+package addrman
+
+import (
+	mrand "math/rand"
+	"sync"
+	"time"
+
+	"github.com/senseyman/bitcoin-handshake/model"
+)
+
+const (
+	// MaxPctAddrToSend is the share of known addresses in percent which is sent in the response to getaddr
+	MaxPctAddrToSend = 23
+	// responseCacheLifetime is how long the response to getaddr is reused, the random part up to
+	// responseCacheJitter is added, so the responses don't change at predictable times
+	responseCacheLifetime = 21 * time.Hour
+	responseCacheJitter   = 6 * time.Hour
+)
+
+// GetAddresses returns the random addresses which aren't terrible: maxPct percent of all known addresses,
+// but no more than maxAddresses. Zero means no limit. The timestamps are the last time the addresses were seen.
+func (a *AddrMan) GetAddresses(maxAddresses, maxPct int) []model.NetAddressV2 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	ids := make([]int, 0, len(a.addrs))
+	for id := range a.addrs {
+		ids = append(ids, id)
+	}
+
+	n := len(ids)
+	if maxPct > 0 {
+		n = maxPct * n / 100
+	}
+	if maxAddresses > 0 {
+		n = min(n, maxAddresses)
+	}
+
+	now := a.now()
+	addrs := make([]model.NetAddressV2, 0, n)
+	a.rnd.Shuffle(len(ids), func(i, j int) { ids[i], ids[j] = ids[j], ids[i] })
+	for _, id := range ids {
+		if len(addrs) >= n {
+			break
+		}
+		ka := a.addrs[id]
+		if ka.isTerrible(now) {
+			continue
+		}
+		addr := ka.Addr
+		addr.Timestamp = ka.LastSeen.Unix()
+		addrs = append(addrs, addr)
+	}
+
+	return addrs
+}
+
+type cachedResponse struct {
+	addrs   []model.NetAddressV2
+	expires time.Time
+}
+
+// ResponseCache keeps the responses to getaddr, one per network the peers are connected over. Like in Bitcoin
+// Core, the peers get the same addresses for a day, so repeated getaddr don't reveal the whole address book,
+// and the peers connected over different networks can't link our addresses. It's safe for concurrent use.
+type ResponseCache struct {
+	book *AddrMan
+
+	mu        sync.Mutex
+	responses map[model.NetworkID]cachedResponse
+
+	now func() time.Time
+}
+
+func NewResponseCache(book *AddrMan) *ResponseCache {
+	return &ResponseCache{
+		book:      book,
+		responses: make(map[model.NetworkID]cachedResponse),
+		now:       time.Now,
+	}
+}
+
+// Addresses returns the response to getaddr of the peer connected over the network.
+func (c *ResponseCache) Addresses(network model.NetworkID) []model.NetAddressV2 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	if response, ok := c.responses[network]; ok && now.Before(response.expires) {
+		return response.addrs
+	}
+
+	response := cachedResponse{
+		addrs:   c.book.GetAddresses(model.MaxAddrPerMessage, MaxPctAddrToSend),
+		expires: now.Add(responseCacheLifetime + time.Duration(mrand.Int63n(int64(responseCacheJitter)))), //nolint:gosec // not used for security
+	}
+	c.responses[network] = response
+
+	return response.addrs
+}
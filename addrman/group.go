@@ -0,0 +1,56 @@
+package addrman
+
+import (
+	"net"
+
+	"github.com/senseyman/bitcoin-handshake/model"
+)
+
+// unroutableGroup is a shared group for all addresses we can't classify, e.g. sources learned from a hostname.
+var unroutableGroup = []byte{0}
+
+// netGroup returns the group identifier of the address. Addresses from the same group are likely to be
+// controlled by the same operator, so we don't want them to fill all buckets of the table.
+func netGroup(na model.NetAddressV2) []byte {
+	if ip := na.IP(); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			// IPv4 addresses are grouped by /16
+			return []byte{byte(model.NetIPv4), ip4[0], ip4[1]}
+		}
+
+		// IPv6 addresses are grouped by /32
+		ip16 := ip.To16()
+		return []byte{byte(model.NetIPv6), ip16[0], ip16[1], ip16[2], ip16[3]}
+	}
+
+	switch na.NetworkID {
+	case model.NetTorV2, model.NetTorV3, model.NetI2P, model.NetCJDNS:
+		if len(na.Addr) == 0 {
+			return unroutableGroup
+		}
+		// overlay networks don't have any topology, so use just first 4 bits like Bitcoin Core does
+		return []byte{byte(na.NetworkID), na.Addr[0] | 0x0f}
+	}
+
+	return unroutableGroup
+}
+
+// isRoutable reports whether the address can be used to connect to a public node.
+func isRoutable(na model.NetAddressV2) bool {
+	size := na.NetworkID.AddrSize()
+	if size == 0 || len(na.Addr) != size {
+		return false
+	}
+
+	switch na.NetworkID {
+	case model.NetIPv4, model.NetIPv6:
+		ip := net.IP(na.Addr)
+		return !(ip.IsUnspecified() || ip.IsLoopback() || ip.IsPrivate() ||
+			ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsMulticast())
+	case model.NetTorV2:
+		// Tor v2 addresses are deprecated and not reachable anymore
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,63 @@
+package addrman
+
+import (
+	"math"
+	"time"
+
+	"github.com/senseyman/bitcoin-handshake/model"
+)
+
+type knownAddress struct {
+	Addr        model.NetAddressV2
+	Source      model.NetAddressV2
+	LastSeen    time.Time
+	LastAttempt time.Time
+	LastSuccess time.Time
+	Attempts    int
+	Tried       bool
+}
+
+// isTerrible reports whether the address is not worth keeping, so it can be replaced by another one.
+func (ka *knownAddress) isTerrible(now time.Time) bool {
+	// never remove things tried in the last minute
+	if now.Sub(ka.LastAttempt) < time.Minute {
+		return false
+	}
+
+	// came in a flying DeLorean
+	if ka.LastSeen.After(now.Add(10 * time.Minute)) {
+		return true
+	}
+
+	// not seen in recent history
+	if now.Sub(ka.LastSeen) > horizon {
+		return true
+	}
+
+	// tried N times and never a success
+	if ka.LastSuccess.IsZero() && ka.Attempts >= maxRetries {
+		return true
+	}
+
+	// N successive failures in the last week
+	if now.Sub(ka.LastSuccess) > minFailPeriod && ka.Attempts >= maxFailures {
+		return true
+	}
+
+	return false
+}
+
+// chance returns relative probability of the address to be selected for the connection.
+func (ka *knownAddress) chance(now time.Time) float64 {
+	c := 1.0
+
+	// deprioritize very recent attempts away
+	if now.Sub(ka.LastAttempt) < 10*time.Minute {
+		c *= 0.01
+	}
+
+	// deprioritize 66% after each failed attempt, but at most 1/28th to avoid the search taking forever
+	c *= math.Pow(0.66, math.Min(float64(ka.Attempts), 8))
+
+	return c
+}
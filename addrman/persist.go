@@ -0,0 +1,120 @@
+package addrman
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+const fileVersion = 1
+
+type fileData struct {
+	Version   int             `json:"version"`
+	Key       string          `json:"key"`
+	Addresses []*knownAddress `json:"addresses"`
+}
+
+// Save writes all known addresses to the file the manager was created with.
+func (a *AddrMan) Save() error {
+	if a.filePath == "" {
+		return nil
+	}
+
+	a.mu.Lock()
+	data := fileData{
+		Version:   fileVersion,
+		Key:       hex.EncodeToString(a.key[:]),
+		Addresses: make([]*knownAddress, 0, len(a.addrs)),
+	}
+	for _, ka := range a.addrs {
+		data.Addresses = append(data.Addresses, ka)
+	}
+	raw, err := json.Marshal(data)
+	a.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to encode address book: %w", err)
+	}
+
+	// write into temporary file first, so we never leave a broken file behind
+	tmp, err := os.CreateTemp(filepath.Dir(a.filePath), filepath.Base(a.filePath)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to save address book: %w", err)
+	}
+	defer os.Remove(tmp.Name()) //nolint:errcheck // file is already renamed on success
+
+	if _, err := tmp.Write(raw); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("failed to save address book: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to save address book: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), a.filePath); err != nil {
+		return fmt.Errorf("failed to save address book: %w", err)
+	}
+
+	return nil
+}
+
+func (a *AddrMan) load() error {
+	raw, err := os.ReadFile(a.filePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read address book: %w", err)
+	}
+
+	var data fileData
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return fmt.Errorf("failed to decode address book: %w", err)
+	}
+	if data.Version != fileVersion {
+		return fmt.Errorf("unsupported address book version %d", data.Version)
+	}
+
+	key, err := hex.DecodeString(data.Key)
+	if err != nil || len(key) != len(a.key) {
+		return fmt.Errorf("invalid address book key")
+	}
+	copy(a.key[:], key)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	// tried entries are placed first, so they are not evicted by new ones
+	for _, ka := range data.Addresses {
+		if !ka.Tried || !isRoutable(ka.Addr) {
+			continue
+		}
+		bucket := a.triedBucket(ka.Addr)
+		pos := a.bucketPosition(false, bucket, ka.Addr)
+		if a.triedTable[bucket][pos] != 0 {
+			continue
+		}
+		a.triedTable[bucket][pos] = a.create(ka)
+		a.triedCount++
+	}
+
+	for _, ka := range data.Addresses {
+		if ka.Tried || !isRoutable(ka.Addr) {
+			continue
+		}
+		if _, ok := a.addrIndex[ka.Addr.Key()]; ok {
+			continue
+		}
+		bucket := a.newBucket(ka.Addr, ka.Source)
+		pos := a.bucketPosition(true, bucket, ka.Addr)
+		if a.newTable[bucket][pos] != 0 {
+			continue
+		}
+		a.newTable[bucket][pos] = a.create(ka)
+		a.newCount++
+	}
+
+	return nil
+}
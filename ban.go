@@ -0,0 +1,110 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/senseyman/bitcoin-handshake/banman"
+)
+
+// runBan is the ban subcommand: it lists and edits the ban list file shared with the handshake and the daemon.
+func runBan(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("ban", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	file := fs.String("file", "banlist.json", "Path to the ban list file")
+	duration := fs.Duration("duration", banman.DefaultBanDuration, "Duration of the ban for add")
+	reason := fs.String("reason", "manual", "Reason of the ban for add")
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: ban [-file path] list | add [-duration d] [-reason r] <ip|subnet> ... | remove <ip|subnet> ... | clear")
+		fmt.Fprintln(stderr, "Manages the list of banned nodes, the flags go before the action.")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	bans, err := banman.New(*file)
+	if err != nil {
+		fmt.Fprintf(stderr, "ban: %v\n", err)
+		return 1
+	}
+
+	action, targets := fs.Arg(0), fs.Args()[1:]
+	switch action {
+	case "list":
+		err = writeBans(stdout, bans.List())
+	case "add":
+		err = editBans(targets, func(s string) error {
+			subnet, err := banman.ParseSubnet(s)
+			if err != nil {
+				return err
+			}
+			bans.Ban(subnet, *duration, *reason)
+			fmt.Fprintf(stdout, "banned %s for %s\n", subnet, *duration)
+			return nil
+		})
+	case "remove":
+		err = editBans(targets, func(s string) error {
+			subnet, err := banman.ParseSubnet(s)
+			if err != nil {
+				return err
+			}
+			if !bans.Unban(subnet) {
+				return fmt.Errorf("%s is not banned", subnet)
+			}
+			fmt.Fprintf(stdout, "unbanned %s\n", subnet)
+			return nil
+		})
+	case "clear":
+		bans.Clear()
+	default:
+		fs.Usage()
+		return 2
+	}
+	if err == nil && action != "list" {
+		err = bans.Save()
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "ban: %v\n", err)
+		return 1
+	}
+
+	return 0
+}
+
+func editBans(targets []string, edit func(s string) error) error {
+	if len(targets) == 0 {
+		return errors.New("no IP or subnet is given")
+	}
+	for _, target := range targets {
+		if err := edit(target); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func writeBans(w io.Writer, entries []banman.Entry) error {
+	if len(entries) == 0 {
+		_, err := fmt.Fprintln(w, "no banned nodes")
+		return err
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "SUBNET\tBANNED\tUNTIL\tREASON")
+	for _, e := range entries {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n",
+			e.Subnet, e.Created.Format(time.RFC3339), e.Until.Format(time.RFC3339), e.Reason)
+	}
+
+	return tw.Flush()
+}
@@ -0,0 +1,200 @@
+package bandwidth
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/senseyman/bitcoin-handshake/client"
+	"github.com/senseyman/bitcoin-handshake/model"
+	"github.com/senseyman/bitcoin-handshake/nodetest"
+)
+
+// fakeClock moves the time only when somebody sleeps.
+type fakeClock struct {
+	now   time.Time
+	slept time.Duration
+}
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func (c *fakeClock) Sleep(d time.Duration) {
+	c.slept += d
+	c.now = c.now.Add(d)
+}
+
+func fakeBucket(rate, burst int64) (*Bucket, *fakeClock) {
+	clock := &fakeClock{now: time.Unix(1700000000, 0)}
+	b := NewBucket(rate, burst)
+	b.now, b.sleep, b.last = clock.Now, clock.Sleep, clock.now
+
+	return b, clock
+}
+
+func TestBucket_Take(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name     string
+		rate     int64
+		burst    int64
+		takes    []int
+		expSlept time.Duration
+	}{
+		{name: "within_burst", rate: 1000, burst: 500, takes: []int{200, 300}},
+		{name: "over_burst", rate: 1000, burst: 500, takes: []int{500, 500}, expSlept: 500 * time.Millisecond},
+		{name: "debt", rate: 1000, burst: 500, takes: []int{2500}, expSlept: 2 * time.Second},
+		{name: "default_burst", rate: 100, takes: []int{100, 100}, expSlept: time.Second},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			b, clock := fakeBucket(tc.rate, tc.burst)
+			for _, n := range tc.takes {
+				b.Take(n)
+			}
+			assert.Equal(t, tc.expSlept, clock.slept)
+		})
+	}
+
+	// no rate means no limit
+	assert.Nil(t, NewBucket(0, 100))
+	NewBucket(0, 100).Take(100)
+}
+
+func TestMeter(t *testing.T) {
+	t.Parallel()
+
+	ping := nodetest.EncodeFrame(model.TestNetMagic, "ping", make([]byte, 8))
+	verack := nodetest.EncodeFrame(model.TestNetMagic, model.VerackCMD, nil)
+	stream := append(append(append([]byte(nil), ping...), verack...), ping...)
+
+	target := NewUploadTarget(1 << 20)
+	m := NewMeter(WithUploadTarget(target))
+	local, remote := net.Pipe()
+	conn := m.ConnectionFn(func(string, int) (client.Connection, error) { return local, nil })
+	c, err := conn("127.0.0.1", 18333)
+	require.NoError(t, err)
+	defer c.Close()
+	defer remote.Close()
+
+	// the messages are written in pieces which don't match the frames
+	written := make(chan struct{})
+	go func() {
+		defer close(written)
+		for i := 0; i < len(stream); i += 7 {
+			_, _ = c.Write(stream[i:min(i+7, len(stream))])
+		}
+	}()
+	got := make([]byte, len(stream))
+	for read := 0; read < len(got); {
+		n, err := remote.Read(got[read:])
+		require.NoError(t, err)
+		read += n
+	}
+	assert.Equal(t, stream, got)
+	<-written
+
+	go func() { _, _ = remote.Write(verack) }()
+	buf := make([]byte, len(verack))
+	for read := 0; read < len(buf); {
+		n, err := c.Read(buf[read:])
+		require.NoError(t, err)
+		read += n
+	}
+
+	stats := m.Stats()
+	assert.Equal(t, DirectionStats{
+		Messages: 3,
+		Bytes:    uint64(len(stream)),
+		Commands: map[string]CommandStats{
+			"ping":          {Messages: 2, Bytes: uint64(2 * len(ping))},
+			model.VerackCMD: {Messages: 1, Bytes: uint64(len(verack))},
+		},
+	}, stats.Sent)
+	assert.Equal(t, DirectionStats{
+		Messages: 1,
+		Bytes:    uint64(len(verack)),
+		Commands: map[string]CommandStats{model.VerackCMD: {Messages: 1, Bytes: uint64(len(verack))}},
+	}, stats.Received)
+	assert.Equal(t, uint64(len(stream)), target.Status().Used)
+}
+
+type bufferConn struct {
+	bytes.Buffer
+	writes []int
+}
+
+func (c *bufferConn) Write(b []byte) (int, error) {
+	c.writes = append(c.writes, len(b))
+	return c.Buffer.Write(b)
+}
+
+func (c *bufferConn) Close() error { return nil }
+
+func TestMeter_RateLimit(t *testing.T) {
+	t.Parallel()
+
+	m := NewMeter(WithUploadRate(1000, 100), WithDownloadRate(1000, 50))
+	upload, uploadClock := fakeBucket(1000, 100)
+	download, downloadClock := fakeBucket(1000, 50)
+	m.upload, m.download = upload, download
+
+	conn := &bufferConn{}
+	c := m.Wrap(conn)
+
+	n, err := c.Write(make([]byte, 350))
+	require.NoError(t, err)
+	assert.Equal(t, 350, n)
+	// the message is split into chunks of the burst size and sent at the rate
+	assert.Equal(t, []int{100, 100, 100, 50}, conn.writes)
+	assert.Equal(t, 250*time.Millisecond, uploadClock.slept)
+
+	n, err = c.Read(make([]byte, 350))
+	require.NoError(t, err)
+	assert.Equal(t, 50, n)
+	n, err = c.Read(make([]byte, 350))
+	require.NoError(t, err)
+	assert.Equal(t, 50, n)
+	assert.Equal(t, 50*time.Millisecond, downloadClock.slept)
+}
+
+func TestUploadTarget(t *testing.T) {
+	t.Parallel()
+
+	now := time.Unix(1700000000, 0)
+	target := NewUploadTarget(model.MaxPayloadSize + 1000)
+	target.now = func() time.Time { return now }
+	target.cycleStart = now
+
+	assert.True(t, target.ServeHistoricalBlocks())
+	target.Add(1000)
+	// the largest block doesn't fit anymore
+	assert.False(t, target.ServeHistoricalBlocks())
+	assert.False(t, target.Reached())
+
+	target.Add(model.MaxPayloadSize)
+	status := target.Status()
+	assert.True(t, status.Reached)
+	assert.Equal(t, uint64(model.MaxPayloadSize+1000), status.Used)
+	assert.Equal(t, now.Add(TargetCycle), status.CycleEnd)
+
+	// the next cycle starts from zero
+	now = now.Add(TargetCycle + time.Hour)
+	status = target.Status()
+	assert.False(t, status.Reached)
+	assert.Zero(t, status.Used)
+	assert.Equal(t, now.Add(-time.Hour), status.CycleStart)
+
+	// no limit means no target
+	var noTarget *UploadTarget
+	noTarget.Add(100)
+	assert.True(t, noTarget.ServeHistoricalBlocks())
+	assert.False(t, noTarget.Reached())
+}
@@ -0,0 +1,67 @@
+package bandwidth
+
+import (
+	"sync"
+	"time"
+)
+
+// Bucket is the token bucket which limits the rate of bytes. Tokens may be taken in advance, then the next
+// takers wait until the debt is paid off. It's safe for concurrent use.
+type Bucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+
+	now   func() time.Time
+	sleep func(d time.Duration)
+}
+
+// NewBucket creates the bucket which refills with rate bytes per second up to burst bytes. The bucket is full
+// at start. Nil is returned for non-positive rate, nil bucket doesn't limit anything.
+func NewBucket(rate, burst int64) *Bucket {
+	if rate <= 0 {
+		return nil
+	}
+	if burst <= 0 {
+		burst = rate
+	}
+
+	return &Bucket{
+		rate:   float64(rate),
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+		now:    time.Now,
+		sleep:  time.Sleep,
+	}
+}
+
+// Burst is the maximal number of bytes which can be taken without waiting.
+func (b *Bucket) Burst() int {
+	if b == nil {
+		return 0
+	}
+
+	return int(b.burst)
+}
+
+// Take takes n tokens and waits until the bucket isn't in debt.
+func (b *Bucket) Take(n int) {
+	if b == nil || n <= 0 {
+		return
+	}
+
+	b.mu.Lock()
+	now := b.now()
+	b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	b.tokens -= float64(n)
+	debt := b.tokens
+	b.mu.Unlock()
+
+	if debt < 0 {
+		b.sleep(time.Duration(-debt / b.rate * float64(time.Second)))
+	}
+}
@@ -0,0 +1,234 @@
+package bandwidth
+
+import (
+	"bytes"
+	"encoding/binary"
+	"maps"
+	"net"
+	"sync"
+
+	"github.com/senseyman/bitcoin-handshake/client"
+	"github.com/senseyman/bitcoin-handshake/model"
+)
+
+const (
+	headerSize = model.MagicSize + model.CommandSize + model.LengthSize + model.ChecksumSize
+)
+
+type CommandStats struct {
+	Messages uint64 `json:"messages"`
+	Bytes    uint64 `json:"bytes"`
+}
+
+// DirectionStats counts the traffic in one direction, the bytes of commands include the headers of messages.
+type DirectionStats struct {
+	Messages uint64                  `json:"messages"`
+	Bytes    uint64                  `json:"bytes"`
+	Commands map[string]CommandStats `json:"commands"`
+}
+
+type Stats struct {
+	Sent     DirectionStats `json:"sent"`
+	Received DirectionStats `json:"received"`
+}
+
+type Option func(m *Meter)
+
+// WithUploadRate limits the upload to rate bytes per second with bursts of burst bytes.
+func WithUploadRate(rate, burst int64) Option {
+	return func(m *Meter) {
+		m.upload = NewBucket(rate, burst)
+	}
+}
+
+// WithDownloadRate limits the download to rate bytes per second with bursts of burst bytes.
+func WithDownloadRate(rate, burst int64) Option {
+	return func(m *Meter) {
+		m.download = NewBucket(rate, burst)
+	}
+}
+
+// WithUploadTarget counts the uploaded bytes into the target, the target is usually shared by all peers.
+func WithUploadTarget(target *UploadTarget) Option {
+	return func(m *Meter) {
+		m.target = target
+	}
+}
+
+// Meter counts the traffic of the peer and limits its rate. The counters are kept across reconnects. It's safe
+// for concurrent use.
+type Meter struct {
+	upload   *Bucket
+	download *Bucket
+	target   *UploadTarget
+
+	mu       sync.Mutex
+	sent     counter
+	received counter
+}
+
+func NewMeter(opts ...Option) *Meter {
+	m := &Meter{
+		sent:     counter{stats: DirectionStats{Commands: make(map[string]CommandStats)}},
+		received: counter{stats: DirectionStats{Commands: make(map[string]CommandStats)}},
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+
+	return m
+}
+
+// Stats returns the copy of the counters.
+func (m *Meter) Stats() Stats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return Stats{Sent: m.sent.snapshot(), Received: m.received.snapshot()}
+}
+
+// Wrap meters the connection, the message which was partially transferred over the previous connection
+// is lost.
+func (m *Meter) Wrap(conn client.Connection) *Conn {
+	m.mu.Lock()
+	m.sent.resetFrame()
+	m.received.resetFrame()
+	m.mu.Unlock()
+
+	return &Conn{conn: conn, meter: m}
+}
+
+// ConnectionFn wraps every connection made by connectionFn.
+func (m *Meter) ConnectionFn(
+	connectionFn func(host string, port int) (client.Connection, error),
+) func(host string, port int) (client.Connection, error) {
+	return func(host string, port int) (client.Connection, error) {
+		conn, err := connectionFn(host, port)
+		if err != nil {
+			return nil, err
+		}
+
+		return m.Wrap(conn), nil
+	}
+}
+
+func (m *Meter) count(c *counter, b []byte) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c.feed(b)
+}
+
+// Conn is a client.Connection which is metered and rate limited by the meter.
+type Conn struct {
+	conn  client.Connection
+	meter *Meter
+}
+
+func (c *Conn) Read(p []byte) (int, error) {
+	if burst := c.meter.download.Burst(); burst > 0 && len(p) > burst {
+		p = p[:burst]
+	}
+
+	n, err := c.conn.Read(p)
+	c.meter.count(&c.meter.received, p[:n])
+	c.meter.download.Take(n)
+
+	return n, err
+}
+
+// Write waits for the upload bucket before every chunk, so a large message doesn't exceed the rate.
+func (c *Conn) Write(b []byte) (int, error) {
+	chunkSize := len(b)
+	if burst := c.meter.upload.Burst(); burst > 0 && burst < chunkSize {
+		chunkSize = burst
+	}
+
+	written := 0
+	for written < len(b) {
+		chunk := b[written:min(written+chunkSize, len(b))]
+		c.meter.upload.Take(len(chunk))
+
+		n, err := c.conn.Write(chunk)
+		c.meter.count(&c.meter.sent, chunk[:n])
+		c.meter.target.Add(n)
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+
+	return written, nil
+}
+
+func (c *Conn) Close() error {
+	return c.conn.Close()
+}
+
+// RemoteAddr returns the address of the wrapped connection if it has one.
+func (c *Conn) RemoteAddr() net.Addr {
+	if conn, ok := c.conn.(interface{ RemoteAddr() net.Addr }); ok {
+		return conn.RemoteAddr()
+	}
+
+	return nil
+}
+
+// counter follows the frames of the stream, so the bytes are counted per command without buffering payloads.
+type counter struct {
+	stats DirectionStats
+
+	header    [headerSize]byte
+	headerLen int
+	command   string
+	remaining uint32
+}
+
+func (c *counter) feed(b []byte) {
+	c.stats.Bytes += uint64(len(b))
+
+	for len(b) > 0 {
+		if c.headerLen < headerSize {
+			n := copy(c.header[c.headerLen:], b)
+			c.headerLen += n
+			b = b[n:]
+			if c.headerLen < headerSize {
+				return
+			}
+
+			c.command = string(bytes.TrimRight(c.header[model.MagicSize:model.MagicSize+model.CommandSize], "\x00"))
+			c.remaining = binary.LittleEndian.Uint32(c.header[model.MagicSize+model.CommandSize:])
+			c.add(headerSize)
+		} else {
+			n := min(uint32(len(b)), c.remaining)
+			c.remaining -= n
+			b = b[n:]
+			c.add(int(n))
+		}
+
+		if c.remaining == 0 {
+			cmd := c.stats.Commands[c.command]
+			cmd.Messages++
+			c.stats.Commands[c.command] = cmd
+			c.stats.Messages++
+			c.headerLen = 0
+		}
+	}
+}
+
+func (c *counter) add(n int) {
+	cmd := c.stats.Commands[c.command]
+	cmd.Bytes += uint64(n)
+	c.stats.Commands[c.command] = cmd
+}
+
+func (c *counter) resetFrame() {
+	c.headerLen, c.remaining = 0, 0
+}
+
+func (c *counter) snapshot() DirectionStats {
+	s := c.stats
+	s.Commands = maps.Clone(c.stats.Commands)
+
+	return s
+}
@@ -0,0 +1,98 @@
+package bandwidth
+
+import (
+	"sync"
+	"time"
+
+	"github.com/senseyman/bitcoin-handshake/model"
+)
+
+// TargetCycle is the period of the upload target, the same as in Bitcoin Core.
+const TargetCycle = 24 * time.Hour
+
+// UploadTarget is the equivalent of Bitcoin Core's -maxuploadtarget: once the bytes uploaded to all peers
+// during the cycle come close to the limit, historical blocks aren't served anymore. It's safe for
+// concurrent use, one target is shared by all connections.
+type UploadTarget struct {
+	mu         sync.Mutex
+	limit      uint64
+	used       uint64
+	cycleStart time.Time
+
+	now func() time.Time
+}
+
+// TargetStatus is the state of the upload target in the current cycle.
+type TargetStatus struct {
+	Limit      uint64    `json:"limit"`
+	Used       uint64    `json:"used"`
+	CycleStart time.Time `json:"cycle_start"`
+	CycleEnd   time.Time `json:"cycle_end"`
+	Reached    bool      `json:"reached"`
+	// ServeHistoricalBlocks is false when the rest of the target can't fit the largest block
+	ServeHistoricalBlocks bool `json:"serve_historical_blocks"`
+}
+
+// NewUploadTarget creates the target of limit bytes per cycle, nil is returned for zero limit, nil target
+// is never reached.
+func NewUploadTarget(limit uint64) *UploadTarget {
+	if limit == 0 {
+		return nil
+	}
+
+	return &UploadTarget{limit: limit, cycleStart: time.Now(), now: time.Now}
+}
+
+// Add counts the uploaded bytes.
+func (t *UploadTarget) Add(n int) {
+	if t == nil || n <= 0 {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.rollCycle()
+	t.used += uint64(n)
+}
+
+// Reached reports if the upload target of the current cycle is reached.
+func (t *UploadTarget) Reached() bool {
+	return t.Status().Reached
+}
+
+// ServeHistoricalBlocks reports if there is room for the largest block in the current cycle, like Bitcoin
+// Core the historical blocks aren't served otherwise. The blocks near the tip are served regardless.
+func (t *UploadTarget) ServeHistoricalBlocks() bool {
+	return t.Status().ServeHistoricalBlocks
+}
+
+// Status returns the state of the target in the current cycle.
+func (t *UploadTarget) Status() TargetStatus {
+	if t == nil {
+		return TargetStatus{ServeHistoricalBlocks: true}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.rollCycle()
+
+	return TargetStatus{
+		Limit:                 t.limit,
+		Used:                  t.used,
+		CycleStart:            t.cycleStart,
+		CycleEnd:              t.cycleStart.Add(TargetCycle),
+		Reached:               t.used >= t.limit,
+		ServeHistoricalBlocks: t.used+model.MaxPayloadSize < t.limit,
+	}
+}
+
+// rollCycle starts the new cycle if the current one is over.
+func (t *UploadTarget) rollCycle() {
+	now := t.now()
+	if elapsed := now.Sub(t.cycleStart); elapsed >= TargetCycle {
+		t.cycleStart = t.cycleStart.Add(elapsed.Truncate(TargetCycle))
+		t.used = 0
+	}
+}
@@ -0,0 +1,185 @@
+package banman
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/senseyman/bitcoin-handshake/client"
+	"github.com/senseyman/bitcoin-handshake/model"
+)
+
+// DefaultBanDuration is the duration of bans for misbehaviour, the same as in Bitcoin Core.
+const DefaultBanDuration = 24 * time.Hour
+
+var (
+	ErrInvalidSubnet = errors.New("invalid IP or subnet")
+)
+
+// Entry is the banned subnet, a single IP is stored as /32 or /128 subnet.
+type Entry struct {
+	Subnet  string    `json:"subnet"`
+	Created time.Time `json:"created"`
+	Until   time.Time `json:"until"`
+	Reason  string    `json:"reason,omitempty"`
+
+	ipNet *net.IPNet
+}
+
+// BanMan keeps the banned subnets, the bans expire by themselves. It's safe for concurrent use.
+type BanMan struct {
+	mu sync.Mutex
+
+	filePath string
+	bans     map[string]*Entry
+
+	now func() time.Time
+}
+
+// New creates the ban list. If filePath is not empty, bans are loaded from the file if it exists
+// and Save writes them back to it.
+func New(filePath string) (*BanMan, error) {
+	b := &BanMan{
+		filePath: filePath,
+		bans:     make(map[string]*Entry),
+		now:      time.Now,
+	}
+	if filePath == "" {
+		return b, nil
+	}
+	if err := b.load(); err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// ParseSubnet parses the IP or the subnet in CIDR notation, the IP is the subnet of a single address.
+func ParseSubnet(s string) (*net.IPNet, error) {
+	if strings.Contains(s, "/") {
+		_, ipNet, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidSubnet, s)
+		}
+		return ipNet, nil
+	}
+
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidSubnet, s)
+	}
+
+	return singleIP(ip), nil
+}
+
+func singleIP(ip net.IP) *net.IPNet {
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}
+	}
+	return &net.IPNet{IP: ip.To16(), Mask: net.CIDRMask(128, 128)}
+}
+
+// Ban bans the subnet for the duration, the ban of the same subnet is replaced.
+func (b *BanMan) Ban(subnet *net.IPNet, duration time.Duration, reason string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.now()
+	e := &Entry{
+		Subnet:  subnet.String(),
+		Created: now,
+		Until:   now.Add(duration),
+		Reason:  reason,
+		ipNet:   subnet,
+	}
+	b.bans[e.Subnet] = e
+}
+
+// Unban removes the ban of the subnet, false is returned if it isn't banned.
+func (b *BanMan) Unban(subnet *net.IPNet) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	_, ok := b.bans[subnet.String()]
+	delete(b.bans, subnet.String())
+
+	return ok
+}
+
+// Clear removes all bans.
+func (b *BanMan) Clear() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.bans = make(map[string]*Entry)
+}
+
+// IsBanned reports if the address is in any of the banned subnets.
+func (b *BanMan) IsBanned(ip net.IP) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.now()
+	for _, e := range b.bans {
+		if now.Before(e.Until) && e.ipNet.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// List returns the bans which didn't expire sorted by subnet.
+func (b *BanMan) List() []Entry {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.sweep()
+	entries := make([]Entry, 0, len(b.bans))
+	for _, e := range b.bans {
+		entries = append(entries, *e)
+	}
+	slices.SortFunc(entries, func(a, b Entry) int { return strings.Compare(a.Subnet, b.Subnet) })
+
+	return entries
+}
+
+// sweep removes the expired bans.
+func (b *BanMan) sweep() {
+	now := b.now()
+	for key, e := range b.bans {
+		if !now.Before(e.Until) {
+			delete(b.bans, key)
+		}
+	}
+}
+
+// Dial wraps the dial function so banned nodes are never connected to. The host which isn't an IP is checked
+// by the address of the connection, as it's known only after the dial.
+func (b *BanMan) Dial(dial func(host string, port int) (client.Connection, error)) func(host string, port int) (client.Connection, error) {
+	return func(host string, port int) (client.Connection, error) {
+		if ip := net.ParseIP(host); ip != nil && b.IsBanned(ip) {
+			return nil, fmt.Errorf("%w: %s", model.ErrPeerBanned, host)
+		}
+
+		conn, err := dial(host, port)
+		if err != nil {
+			return nil, err
+		}
+
+		remote, ok := conn.(interface{ RemoteAddr() net.Addr })
+		if !ok || remote.RemoteAddr() == nil {
+			return conn, nil
+		}
+		if addr, ok := remote.RemoteAddr().(*net.TCPAddr); ok && b.IsBanned(addr.IP) {
+			_ = conn.Close()
+			return nil, fmt.Errorf("%w: %s", model.ErrPeerBanned, addr.IP)
+		}
+
+		return conn, nil
+	}
+}
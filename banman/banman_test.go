@@ -0,0 +1,149 @@
+package banman
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/senseyman/bitcoin-handshake/client"
+	"github.com/senseyman/bitcoin-handshake/model"
+)
+
+func mustSubnet(t *testing.T, s string) *net.IPNet {
+	t.Helper()
+
+	subnet, err := ParseSubnet(s)
+	require.NoError(t, err)
+
+	return subnet
+}
+
+func TestParseSubnet(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name   string
+		in     string
+		exp    string
+		expErr error
+	}{
+		{name: "ipv4", in: "1.2.3.4", exp: "1.2.3.4/32"},
+		{name: "ipv6", in: "2001:db8::1", exp: "2001:db8::1/128"},
+		{name: "cidr", in: "1.2.3.4/24", exp: "1.2.3.0/24"},
+		{name: "ipv6_cidr", in: "2001:db8::1/32", exp: "2001:db8::/32"},
+		{name: "hostname", in: "example.com", expErr: ErrInvalidSubnet},
+		{name: "bad_prefix", in: "1.2.3.4/33", expErr: ErrInvalidSubnet},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			subnet, err := ParseSubnet(tc.in)
+			if tc.expErr != nil {
+				assert.ErrorIs(t, err, tc.expErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.exp, subnet.String())
+		})
+	}
+}
+
+func TestBanMan_IsBanned(t *testing.T) {
+	t.Parallel()
+
+	b, err := New("")
+	require.NoError(t, err)
+	now := time.Now()
+	b.now = func() time.Time { return now }
+
+	b.Ban(mustSubnet(t, "10.0.0.0/8"), time.Hour, "test")
+	b.Ban(mustSubnet(t, "1.2.3.4"), time.Minute, "test")
+	b.Ban(mustSubnet(t, "2001:db8::/32"), time.Hour, "test")
+
+	assert.True(t, b.IsBanned(net.ParseIP("10.1.2.3")))
+	assert.True(t, b.IsBanned(net.ParseIP("1.2.3.4")))
+	assert.True(t, b.IsBanned(net.ParseIP("2001:db8::5")))
+	assert.False(t, b.IsBanned(net.ParseIP("1.2.3.5")))
+	assert.Len(t, b.List(), 3)
+
+	// the ban of the single address expires
+	now = now.Add(2 * time.Minute)
+	assert.False(t, b.IsBanned(net.ParseIP("1.2.3.4")))
+	assert.Len(t, b.List(), 2)
+
+	assert.True(t, b.Unban(mustSubnet(t, "10.0.0.0/8")))
+	assert.False(t, b.Unban(mustSubnet(t, "10.0.0.0/8")))
+	assert.False(t, b.IsBanned(net.ParseIP("10.1.2.3")))
+
+	b.Clear()
+	assert.Empty(t, b.List())
+}
+
+func TestBanMan_SaveLoad(t *testing.T) {
+	t.Parallel()
+
+	filePath := filepath.Join(t.TempDir(), "banlist.json")
+
+	b, err := New(filePath)
+	require.NoError(t, err)
+	b.Ban(mustSubnet(t, "1.2.3.4"), time.Hour, "bad magic")
+	b.Ban(mustSubnet(t, "5.6.7.0/24"), time.Hour, "")
+	b.Ban(mustSubnet(t, "9.9.9.9"), -time.Second, "expired")
+	require.NoError(t, b.Save())
+
+	loaded, err := New(filePath)
+	require.NoError(t, err)
+	entries := loaded.List()
+	require.Len(t, entries, 2)
+	assert.Equal(t, "1.2.3.4/32", entries[0].Subnet)
+	assert.Equal(t, "bad magic", entries[0].Reason)
+	assert.True(t, loaded.IsBanned(net.ParseIP("5.6.7.8")))
+	assert.False(t, loaded.IsBanned(net.ParseIP("9.9.9.9")))
+
+	require.NoError(t, os.WriteFile(filePath, []byte(`{"version":2}`), 0o600))
+	_, err = New(filePath)
+	assert.Error(t, err)
+}
+
+type addrConn struct {
+	client.Connection
+	addr net.Addr
+}
+
+func (c addrConn) RemoteAddr() net.Addr { return c.addr }
+func (c addrConn) Close() error         { return nil }
+
+func TestBanMan_Dial(t *testing.T) {
+	t.Parallel()
+
+	b, err := New("")
+	require.NoError(t, err)
+	b.Ban(mustSubnet(t, "1.2.3.0/24"), time.Hour, "test")
+
+	dials := 0
+	dial := b.Dial(func(host string, _ int) (client.Connection, error) {
+		dials++
+		return addrConn{addr: &net.TCPAddr{IP: net.ParseIP("1.2.3.4"), Port: 18333}}, nil
+	})
+
+	_, err = dial("1.2.3.4", 18333)
+	assert.ErrorIs(t, err, model.ErrPeerBanned)
+	assert.Equal(t, 0, dials)
+
+	// the node behind the name is known only after the dial
+	_, err = dial("seed.example.com", 18333)
+	assert.ErrorIs(t, err, model.ErrPeerBanned)
+	assert.Equal(t, 1, dials)
+
+	b.Clear()
+	conn, err := dial("1.2.3.4", 18333)
+	require.NoError(t, err)
+	assert.NotNil(t, conn)
+}
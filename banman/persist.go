@@ -0,0 +1,92 @@
+package banman
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+)
+
+const fileVersion = 1
+
+type fileData struct {
+	Version int      `json:"version"`
+	Bans    []*Entry `json:"bans"`
+}
+
+// Save writes the bans which didn't expire to the file the ban list was created with.
+func (b *BanMan) Save() error {
+	if b.filePath == "" {
+		return nil
+	}
+
+	raw, err := json.MarshalIndent(fileData{Version: fileVersion, Bans: ptrs(b.List())}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode ban list: %w", err)
+	}
+
+	// write into temporary file first, so we never leave a broken file behind
+	tmp, err := os.CreateTemp(filepath.Dir(b.filePath), filepath.Base(b.filePath)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to save ban list: %w", err)
+	}
+	defer os.Remove(tmp.Name()) //nolint:errcheck // file is already renamed on success
+
+	if _, err := tmp.Write(raw); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("failed to save ban list: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to save ban list: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), b.filePath); err != nil {
+		return fmt.Errorf("failed to save ban list: %w", err)
+	}
+
+	return nil
+}
+
+func ptrs(entries []Entry) []*Entry {
+	res := make([]*Entry, 0, len(entries))
+	for i := range entries {
+		res = append(res, &entries[i])
+	}
+
+	return res
+}
+
+func (b *BanMan) load() error {
+	raw, err := os.ReadFile(b.filePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read ban list: %w", err)
+	}
+
+	var data fileData
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return fmt.Errorf("failed to decode ban list: %w", err)
+	}
+	if data.Version != fileVersion {
+		return fmt.Errorf("unsupported ban list version %d", data.Version)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, e := range data.Bans {
+		_, ipNet, err := net.ParseCIDR(e.Subnet)
+		if err != nil {
+			return fmt.Errorf("%w in ban list: %s", ErrInvalidSubnet, e.Subnet)
+		}
+		e.ipNet = ipNet
+		b.bans[ipNet.String()] = e
+	}
+	b.sweep()
+
+	return nil
+}
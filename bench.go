@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/senseyman/bitcoin-handshake/bench"
+	"github.com/senseyman/bitcoin-handshake/probe"
+)
+
+// runBench is the bench subcommand: it handshakes the node repeatedly and reports latency distribution.
+func runBench(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	cfg := bench.DefaultConfig()
+
+	fs := flag.NewFlagSet("bench", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	host := fs.String("node.host", "127.0.0.1", "Host of blockchain node")
+	port := fs.Int("node.port", 18333, "Port of blockchain node")
+	fs.IntVar(&cfg.Iterations, "n", cfg.Iterations, "Number of handshakes, 0 means no limit")
+	fs.DurationVar(&cfg.Duration, "duration", cfg.Duration, "Stop starting handshakes after the duration, 0 means no limit")
+	fs.IntVar(&cfg.Concurrency, "concurrency", cfg.Concurrency, "Maximum number of handshakes in flight")
+	fs.Float64Var(&cfg.Rate, "rate", cfg.Rate, "Handshakes started per second, 0 means as fast as concurrency allows")
+	fs.DurationVar(&cfg.Timeout, "timeout", cfg.Timeout, "Timeout of every handshake, including connect")
+	proxyAddr := fs.String("proxy", "", "Connect through SOCKS5 proxy, e.g. Tor at 127.0.0.1:9050")
+	verbose := fs.Bool("v", false, "Write the logs of handshakes to stderr")
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: bench [flags]")
+		fmt.Fprintln(stderr, "Handshakes the node repeatedly and reports latency percentiles and errors.")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	switch {
+	case cfg.Iterations < 0 || cfg.Duration < 0 || cfg.Rate < 0:
+		fmt.Fprintln(stderr, "bench: n, duration and rate can't be negative")
+		return 2
+	case cfg.Iterations == 0 && cfg.Duration == 0:
+		fmt.Fprintln(stderr, "bench: n or duration has to be set")
+		return 2
+	case cfg.Concurrency <= 0 || cfg.Timeout <= 0:
+		fmt.Fprintln(stderr, "bench: concurrency and timeout must be positive")
+		return 2
+	}
+
+	if !*verbose {
+		log.SetOutput(io.Discard)
+	}
+
+	fmt.Fprintf(stderr, "benchmarking handshakes with %s:%d...\n", *host, *port)
+	report := bench.Run(ctx, probe.New(probeDial(cfg.Timeout, *proxyAddr)), *host, *port, cfg)
+	if err := report.WriteText(stdout); err != nil {
+		return 1
+	}
+
+	return 0
+}
@@ -0,0 +1,149 @@
+package bench
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/senseyman/bitcoin-handshake/probe"
+	"github.com/senseyman/bitcoin-handshake/utils"
+)
+
+type Handshaker interface {
+	Handshake(ctx context.Context, host string, port int) (probe.Result, error)
+}
+
+type Config struct {
+	// Iterations is the number of handshakes to make, zero means no limit
+	Iterations int
+	// Duration stops starting new handshakes after it passes, zero means no limit
+	Duration time.Duration
+	// Concurrency is the maximum number of handshakes in flight
+	Concurrency int
+	// Rate is the number of handshakes started per second, zero means as fast as Concurrency allows
+	Rate float64
+	// Timeout limits every handshake, including connect
+	Timeout time.Duration
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Iterations:  100,
+		Concurrency: 1,
+		Timeout:     10 * time.Second,
+	}
+}
+
+// Report aggregates the results of the benchmark. Latencies are counted for the successful handshakes only.
+type Report struct {
+	Iterations int
+	Succeeded  int
+	Failed     int
+	Elapsed    time.Duration
+	// Throughput is the number of successful handshakes per second
+	Throughput float64
+
+	Connect    utils.DurationStats
+	VersionRTT utils.DurationStats
+	VerackRTT  utils.DurationStats
+	Total      utils.DurationStats
+
+	// Failures is the number of failed handshakes by failure reason
+	Failures utils.FailureCounts
+}
+
+type collector struct {
+	mu                              sync.Mutex
+	connect, version, verack, total []time.Duration
+	report                          Report
+}
+
+func (c *collector) add(result probe.Result, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.report.Iterations++
+	if err != nil {
+		c.report.Failed++
+		c.report.Failures.Add(probe.Reason(err), err)
+		return
+	}
+
+	c.report.Succeeded++
+	c.connect = append(c.connect, result.Connect)
+	c.version = append(c.version, result.VersionRTT)
+	c.verack = append(c.verack, result.VerackRTT)
+	c.total = append(c.total, result.Connect+result.Total)
+}
+
+// Run handshakes the node repeatedly until the number of iterations is made, the duration passes or the context
+// is done. Handshakes in flight are completed when the duration passes.
+func Run(ctx context.Context, h Handshaker, host string, port int, cfg Config) Report {
+	c := &collector{report: Report{Failures: utils.NewFailureCounts()}}
+
+	dispatchCtx := ctx
+	if cfg.Duration > 0 {
+		var cancel context.CancelFunc
+		dispatchCtx, cancel = context.WithTimeout(ctx, cfg.Duration)
+		defer cancel()
+	}
+
+	var tick <-chan time.Time
+	if cfg.Rate > 0 {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / cfg.Rate))
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	start := time.Now()
+
+	jobs := make(chan struct{})
+	var wg sync.WaitGroup
+	for w := 0; w < max(cfg.Concurrency, 1); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range jobs {
+				handshakeCtx, cancel := context.WithTimeout(ctx, cfg.Timeout)
+				result, err := h.Handshake(handshakeCtx, host, port)
+				cancel()
+				c.add(result, err)
+			}
+		}()
+	}
+
+dispatch:
+	for n := 0; cfg.Iterations == 0 || n < cfg.Iterations; n++ {
+		if dispatchCtx.Err() != nil {
+			break
+		}
+		// the first handshake starts right away, the next ones wait for their turn
+		if tick != nil && n > 0 {
+			select {
+			case <-tick:
+			case <-dispatchCtx.Done():
+				break dispatch
+			}
+		}
+
+		select {
+		case jobs <- struct{}{}:
+		case <-dispatchCtx.Done():
+			break dispatch
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	report := c.report
+	report.Elapsed = time.Since(start)
+	if report.Elapsed > 0 {
+		report.Throughput = float64(report.Succeeded) / report.Elapsed.Seconds()
+	}
+	report.Connect = utils.NewDurationStats(c.connect)
+	report.VersionRTT = utils.NewDurationStats(c.version)
+	report.VerackRTT = utils.NewDurationStats(c.verack)
+	report.Total = utils.NewDurationStats(c.total)
+
+	return report
+}
@@ -0,0 +1,169 @@
+package bench
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/senseyman/bitcoin-handshake/client"
+	"github.com/senseyman/bitcoin-handshake/model"
+	"github.com/senseyman/bitcoin-handshake/nodetest"
+	"github.com/senseyman/bitcoin-handshake/probe"
+)
+
+// fakeHandshaker fails every failEvery-th handshake.
+type fakeHandshaker struct {
+	delay     time.Duration
+	failEvery int64
+
+	calls      atomic.Int64
+	running    atomic.Int32
+	maxRunning atomic.Int32
+}
+
+func (h *fakeHandshaker) Handshake(ctx context.Context, _ string, _ int) (probe.Result, error) {
+	running := h.running.Add(1)
+	defer h.running.Add(-1)
+	for {
+		maxRunning := h.maxRunning.Load()
+		if running <= maxRunning || h.maxRunning.CompareAndSwap(maxRunning, running) {
+			break
+		}
+	}
+
+	select {
+	case <-time.After(h.delay):
+	case <-ctx.Done():
+		return probe.Result{}, model.ErrContextTimeout
+	}
+
+	if call := h.calls.Add(1); h.failEvery > 0 && call%h.failEvery == 0 {
+		return probe.Result{}, fmt.Errorf("%w: refused", probe.ErrConnect)
+	}
+
+	return probe.Result{
+		Connect: time.Millisecond,
+		HandshakeStats: model.HandshakeStats{
+			VersionRTT: 2 * time.Millisecond,
+			VerackRTT:  3 * time.Millisecond,
+			Total:      5 * time.Millisecond,
+		},
+	}, nil
+}
+
+func TestRun(t *testing.T) {
+	testCases := []struct {
+		name           string
+		cfg            Config
+		failEvery      int64
+		expIterations  int
+		expMinIter     int
+		expConcurrency int32
+		expMinElapsed  time.Duration
+		expErrors      map[string]int
+	}{
+		{
+			name:           "success/iterations",
+			cfg:            Config{Iterations: 20, Concurrency: 4, Timeout: time.Second},
+			expIterations:  20,
+			expConcurrency: 4,
+			expErrors:      map[string]int{},
+		},
+		{
+			name:           "success/rate",
+			cfg:            Config{Iterations: 5, Concurrency: 5, Rate: 100, Timeout: time.Second},
+			expIterations:  5,
+			expConcurrency: 5,
+			// the first handshake starts right away, the other 4 wait 10ms each
+			expMinElapsed: 40 * time.Millisecond,
+			expErrors:     map[string]int{},
+		},
+		{
+			name:           "success/duration",
+			cfg:            Config{Duration: 50 * time.Millisecond, Concurrency: 2, Timeout: time.Second},
+			expMinIter:     2,
+			expConcurrency: 2,
+			expMinElapsed:  50 * time.Millisecond,
+			expErrors:      map[string]int{},
+		},
+		{
+			name:           "err/counted_by_reason",
+			cfg:            Config{Iterations: 9, Concurrency: 1, Timeout: time.Second},
+			failEvery:      3,
+			expIterations:  9,
+			expConcurrency: 1,
+			expErrors:      map[string]int{probe.ReasonConnect: 3},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			h := &fakeHandshaker{delay: 2 * time.Millisecond, failEvery: tc.failEvery}
+			report := Run(context.Background(), h, "127.0.0.1", 18333, tc.cfg)
+
+			if tc.expIterations > 0 {
+				assert.Equal(t, tc.expIterations, report.Iterations)
+			} else {
+				assert.GreaterOrEqual(t, report.Iterations, tc.expMinIter)
+			}
+			assert.LessOrEqual(t, h.maxRunning.Load(), tc.expConcurrency)
+			assert.GreaterOrEqual(t, report.Elapsed, tc.expMinElapsed)
+			assert.Equal(t, tc.expErrors, report.Failures.Counts)
+			assert.Equal(t, report.Iterations, report.Succeeded+report.Failed)
+
+			assert.Equal(t, report.Succeeded, report.Total.Count)
+			assert.Equal(t, time.Millisecond, report.Connect.P99)
+			assert.Equal(t, 2*time.Millisecond, report.VersionRTT.P50)
+			assert.Equal(t, 3*time.Millisecond, report.VerackRTT.Max)
+			assert.Equal(t, 6*time.Millisecond, report.Total.Min)
+		})
+	}
+}
+
+func TestRun_Canceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	report := Run(ctx, &fakeHandshaker{}, "127.0.0.1", 18333, Config{Concurrency: 1, Timeout: time.Second})
+	assert.Zero(t, report.Iterations)
+}
+
+func TestRun_FakeNode(t *testing.T) {
+	const iterations = 3
+
+	// every handshake gets its own fake node, as a peer serves one connection
+	peers := make(chan *nodetest.Peer, iterations)
+	for i := 0; i < iterations; i++ {
+		peers <- nodetest.NewPeer(nodetest.Handshake()...)
+	}
+	prober := probe.New(func(string, int) (client.Connection, error) {
+		peer := <-peers
+		host, port, err := peer.ListenTCP()
+		if err != nil {
+			return nil, err
+		}
+		return net.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+	})
+
+	report := Run(context.Background(), prober, "127.0.0.1", 18333,
+		Config{Iterations: iterations, Concurrency: 1, Timeout: time.Second})
+	require.Equal(t, iterations, report.Succeeded, report.Failures.Samples)
+	assert.Positive(t, report.Connect.Min)
+	assert.Positive(t, report.VersionRTT.Min)
+	assert.Positive(t, report.VerackRTT.Min)
+
+	var text bytes.Buffer
+	require.NoError(t, report.WriteText(&text))
+	assert.Contains(t, text.String(), "handshakes: 3, succeeded: 3, failed: 0")
+	assert.Contains(t, text.String(), "verack")
+}
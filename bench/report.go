@@ -0,0 +1,26 @@
+package bench
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/senseyman/bitcoin-handshake/utils"
+)
+
+func (r Report) WriteText(w io.Writer) error {
+	fmt.Fprintf(w, "handshakes: %d, succeeded: %d, failed: %d, elapsed: %s, throughput: %.1f/s\n",
+		r.Iterations, r.Succeeded, r.Failed, r.Elapsed.Round(time.Millisecond), r.Throughput)
+	r.Failures.WriteText(w)
+
+	if r.Succeeded == 0 {
+		return nil
+	}
+
+	return utils.WriteLatencyTable(w,
+		utils.LatencyRow{Name: "connect", Stats: r.Connect},
+		utils.LatencyRow{Name: "version", Stats: r.VersionRTT},
+		utils.LatencyRow{Name: "verack", Stats: r.VerackRTT},
+		utils.LatencyRow{Name: "total", Stats: r.Total},
+	)
+}
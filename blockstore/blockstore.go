@@ -0,0 +1,270 @@
+package blockstore
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/senseyman/bitcoin-handshake/model"
+)
+
+const (
+	// NetworkLimitedBlocks is the number of the latest blocks a NODE_NETWORK_LIMITED node serves, BIP159.
+	NetworkLimitedBlocks = 288
+	// HistoricalBlockAge is the age of the block relative to the tip after which it's historical, such blocks
+	// aren't served once the upload target is nearly reached.
+	HistoricalBlockAge = 7 * 24 * time.Hour
+)
+
+var (
+	ErrNotConnected = errors.New("header doesn't connect to the chain")
+	ErrInvalidBlock = errors.New("invalid block")
+)
+
+// Store is the chain of headers from the genesis and the blocks of some of them, the blocks are kept as they
+// were received. It's safe for concurrent use.
+type Store struct {
+	mu      sync.RWMutex
+	headers []model.BlockHeader
+	heights map[[32]byte]int
+	blocks  map[[32]byte][]byte
+	// segwit is the blocks with witness data, they are stripped when requested without it
+	segwit map[[32]byte]struct{}
+}
+
+// New creates the store with the chain of the genesis header only.
+func New(genesis model.BlockHeader) *Store {
+	return &Store{
+		headers: []model.BlockHeader{genesis},
+		heights: map[[32]byte]int{genesis.Hash(): 0},
+		blocks:  make(map[[32]byte][]byte),
+		segwit:  make(map[[32]byte]struct{}),
+	}
+}
+
+// AddHeaders extends the chain with the headers, the known headers are skipped and the rest has to connect to
+// the tip. The number of added headers is returned.
+func (s *Store) AddHeaders(headers []model.BlockHeader) (int, error) {
+	if err := model.ValidateHeaders(headers); err != nil {
+		return 0, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	added := 0
+	for _, h := range headers {
+		hash := h.Hash()
+		if _, ok := s.heights[hash]; ok {
+			continue
+		}
+		if h.PrevBlock != s.headers[len(s.headers)-1].Hash() {
+			return added, fmt.Errorf("%w: %s", ErrNotConnected, model.HashString(hash))
+		}
+		s.heights[hash] = len(s.headers)
+		s.headers = append(s.headers, h)
+		added++
+	}
+
+	return added, nil
+}
+
+// AddBlock stores the serialized block, its header has to be in the chain or connect to the tip.
+func (s *Store) AddBlock(raw []byte) error {
+	header, err := ParseHeader(raw)
+	if err != nil {
+		return err
+	}
+	_, witness, err := StripWitness(raw)
+	if err != nil {
+		return err
+	}
+	if _, err := s.AddHeaders([]model.BlockHeader{header}); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.blocks[header.Hash()] = raw
+	if witness {
+		s.segwit[header.Hash()] = struct{}{}
+	}
+
+	return nil
+}
+
+// ParseHeader returns the header of the serialized block.
+func ParseHeader(raw []byte) (model.BlockHeader, error) {
+	if len(raw) < model.BlockHeaderSize {
+		return model.BlockHeader{}, fmt.Errorf("%w: too short, %d bytes", ErrInvalidBlock, len(raw))
+	}
+
+	var h model.BlockHeader
+	h.Version = int32(binary.LittleEndian.Uint32(raw[0:4]))
+	copy(h.PrevBlock[:], raw[4:36])
+	copy(h.MerkleRoot[:], raw[36:68])
+	h.Timestamp = binary.LittleEndian.Uint32(raw[68:72])
+	h.Bits = binary.LittleEndian.Uint32(raw[72:76])
+	h.Nonce = binary.LittleEndian.Uint32(raw[76:80])
+
+	return h, nil
+}
+
+// Tip returns the last header of the chain and its height.
+func (s *Store) Tip() (model.BlockHeader, int) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.headers[len(s.headers)-1], len(s.headers) - 1
+}
+
+// Height returns the height of the header in the chain.
+func (s *Store) Height(hash [32]byte) (int, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	height, ok := s.heights[hash]
+
+	return height, ok
+}
+
+// Locator returns the block locator of the chain: the latest 10 hashes, then with doubling steps back to the
+// genesis, as Bitcoin Core builds it.
+func (s *Store) Locator() [][32]byte {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var locator [][32]byte
+	step := 1
+	for height := len(s.headers) - 1; height > 0; height -= step {
+		locator = append(locator, s.headers[height].Hash())
+		if len(locator) >= 10 {
+			step *= 2
+		}
+	}
+
+	return append(locator, s.headers[0].Hash())
+}
+
+// Headers returns the headers after the first locator hash found in the chain up to stop or max headers. If
+// no locator hash is known, the headers after the genesis are returned. Empty locator asks for the stop header
+// only.
+func (s *Store) Headers(locator [][32]byte, stop [32]byte, max int) []model.BlockHeader {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if len(locator) == 0 {
+		if height, ok := s.heights[stop]; ok {
+			return []model.BlockHeader{s.headers[height]}
+		}
+		return nil
+	}
+
+	var headers []model.BlockHeader
+	for height := s.fork(locator) + 1; height < len(s.headers) && len(headers) < max; height++ {
+		headers = append(headers, s.headers[height])
+		if s.headers[height].Hash() == stop {
+			break
+		}
+	}
+
+	return headers
+}
+
+// Hashes is like Headers, but returns the hashes of the blocks which are stored, as getblocks is answered
+// with the blocks the node can serve.
+func (s *Store) Hashes(locator [][32]byte, stop [32]byte, max int) [][32]byte {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var hashes [][32]byte
+	for height := s.fork(locator) + 1; height < len(s.headers) && len(hashes) < max; height++ {
+		hash := s.headers[height].Hash()
+		if _, ok := s.blocks[hash]; !ok {
+			break
+		}
+		hashes = append(hashes, hash)
+		if hash == stop {
+			break
+		}
+	}
+
+	return hashes
+}
+
+// fork returns the height of the first locator hash which is in the chain.
+func (s *Store) fork(locator [][32]byte) int {
+	for _, hash := range locator {
+		if height, ok := s.heights[hash]; ok {
+			return height
+		}
+	}
+
+	return 0
+}
+
+// Block returns the serialized block, with the witness data or without it.
+func (s *Store) Block(hash [32]byte, witness bool) ([]byte, bool) {
+	s.mu.RLock()
+	raw, ok := s.blocks[hash]
+	_, segwit := s.segwit[hash]
+	s.mu.RUnlock()
+
+	if !ok || witness || !segwit {
+		return raw, ok
+	}
+	// the stored block is valid, so it's stripped without an error
+	stripped, _, err := StripWitness(raw)
+	if err != nil {
+		return nil, false
+	}
+
+	return stripped, true
+}
+
+// IsHistorical reports if the block is older than a week relative to the tip.
+func (s *Store) IsHistorical(hash [32]byte) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	height, ok := s.heights[hash]
+	if !ok {
+		return false
+	}
+	tip := s.headers[len(s.headers)-1]
+
+	return int64(s.headers[height].Timestamp) < int64(tip.Timestamp)-int64(HistoricalBlockAge.Seconds())
+}
+
+// Services returns the services the store allows to provide: NODE_NETWORK if it has all blocks, NODE_NETWORK_LIMITED
+// if it has the latest 288 blocks and none otherwise. NODE_WITNESS is added to them if it has blocks with witness data.
+func (s *Store) Services() model.ServiceFlag {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	held := 0
+	for height := len(s.headers) - 1; height >= 0; height-- {
+		if _, ok := s.blocks[s.headers[height].Hash()]; !ok {
+			break
+		}
+		held++
+	}
+
+	var services model.ServiceFlag
+	switch {
+	case held == len(s.headers):
+		services = model.ServiceNodeNetwork
+	case held >= NetworkLimitedBlocks:
+		services = model.ServiceNodeNetworkLimited
+	default:
+		return 0
+	}
+	if len(s.segwit) > 0 {
+		services |= model.ServiceNodeWitness
+	}
+
+	return services
+}
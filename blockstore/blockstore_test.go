@@ -0,0 +1,315 @@
+package blockstore
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/senseyman/bitcoin-handshake/model"
+)
+
+// mineChain returns the genesis and count headers on top of it, the blocks are spaced by step seconds.
+func mineChain(tb testing.TB, count int, step uint32) (model.BlockHeader, []model.BlockHeader) {
+	tb.Helper()
+
+	var chain []model.BlockHeader
+	var prev [32]byte
+	for i := 0; i <= count; i++ {
+		h := model.BlockHeader{Version: 4, PrevBlock: prev, Timestamp: 1700000000 + uint32(i)*step, Bits: 0x207fffff}
+		for !h.CheckProofOfWork() {
+			h.Nonce++
+		}
+		chain = append(chain, h)
+		prev = h.Hash()
+	}
+
+	return chain[0], chain[1:]
+}
+
+// legacyTx is the tx with one empty input and output.
+var legacyTx = []byte{
+	0x01, 0x00, 0x00, 0x00,
+	0x01, 0xaa, 0xaa, 0xaa, 0xaa, 0xaa, 0xaa, 0xaa, 0xaa, 0xaa, 0xaa, 0xaa, 0xaa, 0xaa, 0xaa, 0xaa, 0xaa,
+	0xaa, 0xaa, 0xaa, 0xaa, 0xaa, 0xaa, 0xaa, 0xaa, 0xaa, 0xaa, 0xaa, 0xaa, 0xaa, 0xaa, 0xaa, 0xaa,
+	0x00, 0x00, 0x00, 0x00, 0x00, 0xff, 0xff, 0xff, 0xff,
+	0x01, 0x10, 0x27, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	0x00, 0x00, 0x00, 0x00,
+}
+
+// witnessTx is legacyTx with the marker, the flag and the witness of two items.
+func witnessTx() []byte {
+	tx := append([]byte{}, legacyTx[:4]...)
+	tx = append(tx, 0x00, 0x01)
+	tx = append(tx, legacyTx[4:len(legacyTx)-4]...)
+	tx = append(tx, 0x02, 0x01, 0xbb, 0x00)
+
+	return append(tx, legacyTx[len(legacyTx)-4:]...)
+}
+
+// rawBlock is the header followed by the transactions.
+func rawBlock(h model.BlockHeader, txs ...[]byte) []byte {
+	if len(txs) == 0 {
+		txs = [][]byte{legacyTx}
+	}
+	raw := append(h.Bytes(), byte(len(txs)))
+	for _, tx := range txs {
+		raw = append(raw, tx...)
+	}
+
+	return raw
+}
+
+func hashes(headers []model.BlockHeader) [][32]byte {
+	var result [][32]byte
+	for _, h := range headers {
+		result = append(result, h.Hash())
+	}
+
+	return result
+}
+
+func TestStore_AddHeaders(t *testing.T) {
+	t.Parallel()
+
+	genesis, chain := mineChain(t, 5, 600)
+	// the fork of the genesis
+	_, other := mineChain(t, 2, 1)
+	invalid := chain[0]
+	invalid.Nonce++
+	for invalid.CheckProofOfWork() {
+		invalid.Nonce++
+	}
+
+	testCases := []struct {
+		name      string
+		headers   []model.BlockHeader
+		expAdded  int
+		expHeight int
+		expErr    error
+	}{
+		{name: "ok", headers: chain, expAdded: 5, expHeight: 5},
+		{name: "ok/known_skipped", headers: append([]model.BlockHeader{genesis}, chain[:2]...), expAdded: 2, expHeight: 2},
+		{name: "err/not_connected", headers: chain[1:], expErr: ErrNotConnected},
+		{name: "err/other_chain", headers: other[1:], expErr: ErrNotConnected},
+		{name: "err/invalid_pow", headers: []model.BlockHeader{invalid}, expErr: model.ErrInvalidHeaders},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			s := New(genesis)
+			added, err := s.AddHeaders(tc.headers)
+			if tc.expErr != nil {
+				assert.ErrorIs(t, err, tc.expErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expAdded, added)
+
+			tip, height := s.Tip()
+			assert.Equal(t, tc.expHeight, height)
+			assert.Equal(t, chain[tc.expHeight-1], tip)
+			got, ok := s.Height(tip.Hash())
+			assert.True(t, ok)
+			assert.Equal(t, tc.expHeight, got)
+		})
+	}
+}
+
+func TestStore_Headers(t *testing.T) {
+	t.Parallel()
+
+	genesis, chain := mineChain(t, 10, 600)
+	_, other := mineChain(t, 3, 1)
+	s := New(genesis)
+	_, err := s.AddHeaders(chain)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name       string
+		locator    [][32]byte
+		stop       [32]byte
+		max        int
+		expHeaders []model.BlockHeader
+	}{
+		{name: "from_genesis", locator: [][32]byte{genesis.Hash()}, max: 2000, expHeaders: chain},
+		{name: "from_fork", locator: [][32]byte{other[2].Hash(), chain[4].Hash(), genesis.Hash()}, max: 2000, expHeaders: chain[5:]},
+		{name: "unknown_locator", locator: hashes(other), max: 2000, expHeaders: chain},
+		{name: "max", locator: [][32]byte{chain[2].Hash()}, max: 3, expHeaders: chain[3:6]},
+		{name: "stop", locator: [][32]byte{chain[2].Hash()}, stop: chain[4].Hash(), max: 2000, expHeaders: chain[3:5]},
+		{name: "up_to_date", locator: [][32]byte{chain[9].Hash()}, max: 2000},
+		{name: "empty_locator", stop: chain[6].Hash(), max: 2000, expHeaders: chain[6:7]},
+		{name: "empty_locator/unknown_stop", stop: other[0].Hash(), max: 2000},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tc.expHeaders, s.Headers(tc.locator, tc.stop, tc.max))
+		})
+	}
+}
+
+func TestStore_Blocks(t *testing.T) {
+	t.Parallel()
+
+	const count = NetworkLimitedBlocks + 10
+	genesis, chain := mineChain(t, count, 3600)
+	s := New(genesis)
+	_, err := s.AddHeaders(chain)
+	require.NoError(t, err)
+	assert.Equal(t, model.ServiceFlag(0), s.Services())
+
+	// the latest blocks only
+	for _, h := range chain[count-NetworkLimitedBlocks:] {
+		require.NoError(t, s.AddBlock(rawBlock(h)))
+	}
+	assert.Equal(t, model.ServiceNodeNetworkLimited, s.Services())
+
+	raw, ok := s.Block(chain[count-1].Hash(), true)
+	assert.True(t, ok)
+	assert.Equal(t, rawBlock(chain[count-1]), raw)
+	raw, ok = s.Block(chain[count-1].Hash(), false)
+	assert.True(t, ok)
+	assert.Equal(t, rawBlock(chain[count-1]), raw)
+	_, ok = s.Block(chain[0].Hash(), true)
+	assert.False(t, ok)
+
+	// getblocks gets the hashes of the stored blocks only
+	assert.Empty(t, s.Hashes([][32]byte{genesis.Hash()}, [32]byte{}, 500))
+	from := count - NetworkLimitedBlocks - 1
+	assert.Equal(t, hashes(chain[from+1:from+4]), s.Hashes([][32]byte{chain[from].Hash()}, chain[from+3].Hash(), 500))
+	assert.Len(t, s.Hashes([][32]byte{chain[from].Hash()}, [32]byte{}, 100), 100)
+
+	// the blocks are spaced by an hour, a week is 168 blocks
+	assert.True(t, s.IsHistorical(genesis.Hash()))
+	assert.False(t, s.IsHistorical(chain[count-1].Hash()))
+
+	for _, h := range append([]model.BlockHeader{genesis}, chain[:count-NetworkLimitedBlocks-1]...) {
+		require.NoError(t, s.AddBlock(rawBlock(h)))
+	}
+	assert.Equal(t, model.ServiceNodeNetworkLimited, s.Services())
+
+	// the segwit block is served with or without the witness data
+	segwit := chain[count-NetworkLimitedBlocks-1]
+	require.NoError(t, s.AddBlock(rawBlock(segwit, legacyTx, witnessTx())))
+	assert.Equal(t, model.ServiceNodeNetwork|model.ServiceNodeWitness, s.Services())
+	raw, ok = s.Block(segwit.Hash(), true)
+	assert.True(t, ok)
+	assert.Equal(t, rawBlock(segwit, legacyTx, witnessTx()), raw)
+	raw, ok = s.Block(segwit.Hash(), false)
+	assert.True(t, ok)
+	assert.Equal(t, rawBlock(segwit, legacyTx, legacyTx), raw)
+
+	err = s.AddBlock([]byte{0x01})
+	assert.ErrorIs(t, err, ErrInvalidBlock)
+}
+
+func TestStripWitness(t *testing.T) {
+	t.Parallel()
+
+	_, chain := mineChain(t, 1, 600)
+	h := chain[0]
+
+	testCases := []struct {
+		name       string
+		raw        []byte
+		expRaw     []byte
+		expWitness bool
+		expErr     error
+	}{
+		{name: "ok/legacy", raw: rawBlock(h, legacyTx, legacyTx), expRaw: rawBlock(h, legacyTx, legacyTx)},
+		{name: "ok/segwit", raw: rawBlock(h, witnessTx(), legacyTx), expRaw: rawBlock(h, legacyTx, legacyTx), expWitness: true},
+		{name: "ok/no_txs", raw: append(h.Bytes(), 0x00), expRaw: append(h.Bytes(), 0x00)},
+		{name: "err/truncated_tx", raw: rawBlock(h, legacyTx[:len(legacyTx)-1]), expErr: ErrInvalidBlock},
+		{name: "err/truncated_witness", raw: rawBlock(h, witnessTx()[:len(legacyTx)+3]), expErr: ErrInvalidBlock},
+		{name: "err/trailing_bytes", raw: append(rawBlock(h), 0xaa), expErr: ErrInvalidBlock},
+		{name: "err/no_tx_count", raw: h.Bytes(), expErr: ErrInvalidBlock},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			raw, witness, err := StripWitness(tc.raw)
+			if tc.expErr != nil {
+				assert.ErrorIs(t, err, tc.expErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expRaw, raw)
+			assert.Equal(t, tc.expWitness, witness)
+		})
+	}
+}
+
+func TestStore_Locator(t *testing.T) {
+	t.Parallel()
+
+	genesis, chain := mineChain(t, 30, 600)
+	s := New(genesis)
+	assert.Equal(t, [][32]byte{genesis.Hash()}, s.Locator())
+
+	_, err := s.AddHeaders(chain)
+	require.NoError(t, err)
+
+	locator := s.Locator()
+	heights := make([]int, 0, len(locator))
+	for _, hash := range locator {
+		height, ok := s.Height(hash)
+		require.True(t, ok)
+		heights = append(heights, height)
+	}
+	assert.Equal(t, []int{30, 29, 28, 27, 26, 25, 24, 23, 22, 21, 19, 15, 7, 0}, heights)
+}
+
+func TestStore_Import(t *testing.T) {
+	t.Parallel()
+
+	genesis, chain := mineChain(t, 3, 600)
+
+	record := func(magic uint32, raw []byte) []byte {
+		b := binary.LittleEndian.AppendUint32(nil, magic)
+		b = binary.LittleEndian.AppendUint32(b, uint32(len(raw)))
+		return append(b, raw...)
+	}
+	var file []byte
+	for _, h := range append([]model.BlockHeader{genesis}, chain...) {
+		file = append(file, record(model.TestNetMagic, rawBlock(h))...)
+	}
+
+	testCases := []struct {
+		name        string
+		file        []byte
+		expImported int
+		expErr      error
+	}{
+		{name: "ok", file: file, expImported: 4},
+		{name: "ok/padding", file: append(append([]byte(nil), file...), make([]byte, 16)...), expImported: 4},
+		{name: "err/magic", file: record(0xd9b4bef9, rawBlock(genesis)), expErr: model.ErrInvalidMagicNumber},
+		{name: "err/not_connected", file: record(model.TestNetMagic, rawBlock(chain[1])), expErr: ErrNotConnected},
+		{name: "err/truncated", file: file[:len(file)-1], expImported: 3, expErr: io.ErrUnexpectedEOF},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			s := New(genesis)
+			imported, err := s.Import(bytes.NewReader(tc.file), model.TestNetMagic)
+			assert.Equal(t, tc.expImported, imported)
+			if tc.expErr != nil {
+				assert.ErrorIs(t, err, tc.expErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, model.ServiceNodeNetwork, s.Services())
+		})
+	}
+}
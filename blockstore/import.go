@@ -0,0 +1,48 @@
+package blockstore
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/senseyman/bitcoin-handshake/model"
+)
+
+// Import reads the blocks in the format of Bitcoin Core's blk*.dat files: every block is prefixed with the network
+// magic and its size. The blocks have to be in chain order. The zero padding at the end of the file is skipped.
+// The number of imported blocks is returned.
+func (s *Store) Import(r io.Reader, magic uint32) (int, error) {
+	imported := 0
+	var prefix [8]byte
+	for {
+		if _, err := io.ReadFull(r, prefix[:]); err != nil {
+			if errors.Is(err, io.EOF) {
+				return imported, nil
+			}
+			return imported, fmt.Errorf("read block %d: %w", imported, err)
+		}
+
+		gotMagic := binary.LittleEndian.Uint32(prefix[:4])
+		if gotMagic == 0 {
+			return imported, nil
+		}
+		if gotMagic != magic {
+			return imported, fmt.Errorf("read block %d: %w: %#x", imported, model.ErrInvalidMagicNumber, gotMagic)
+		}
+
+		size := binary.LittleEndian.Uint32(prefix[4:])
+		if size > model.MaxPayloadSize {
+			return imported, fmt.Errorf("read block %d: %w: %d bytes", imported, model.ErrPayloadTooLarge, size)
+		}
+		raw := make([]byte, size)
+		if _, err := io.ReadFull(r, raw); err != nil {
+			return imported, fmt.Errorf("read block %d: %w", imported, err)
+		}
+
+		if err := s.AddBlock(raw); err != nil {
+			return imported, fmt.Errorf("import block %d: %w", imported, err)
+		}
+		imported++
+	}
+}
@@ -0,0 +1,157 @@
+package blockstore
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/senseyman/bitcoin-handshake/model"
+)
+
+// StripWitness returns the serialized block without the witness data of its transactions, as it's sent for
+// MSG_BLOCK requests, BIP144. It also reports if any transaction of the block has the witness data, the block
+// without it is returned as is.
+func StripWitness(raw []byte) ([]byte, bool, error) {
+	r := &blockReader{raw: raw}
+	if _, err := r.next(model.BlockHeaderSize); err != nil {
+		return nil, false, err
+	}
+	txCount, err := r.varInt()
+	if err != nil {
+		return nil, false, err
+	}
+
+	stripped := make([]byte, 0, len(raw))
+	stripped = append(stripped, raw[:r.pos]...)
+	witness := false
+	for i := uint64(0); i < txCount; i++ {
+		version, err := r.next(4)
+		if err != nil {
+			return nil, false, err
+		}
+		stripped = append(stripped, version...)
+
+		// the segwit tx has the zero marker and the flag in place of the input count
+		hasWitness := r.pos+1 < len(raw) && raw[r.pos] == 0x00 && raw[r.pos+1] == 0x01
+		if hasWitness {
+			witness = true
+			r.pos += 2
+		}
+
+		start := r.pos
+		inputs, err := r.varInt()
+		if err != nil {
+			return nil, false, err
+		}
+		for j := uint64(0); j < inputs; j++ {
+			// the previous output, the script and the sequence
+			if err := r.skip(36); err != nil {
+				return nil, false, err
+			}
+			if err := r.skipVarBytes(); err != nil {
+				return nil, false, err
+			}
+			if err := r.skip(4); err != nil {
+				return nil, false, err
+			}
+		}
+		outputs, err := r.varInt()
+		if err != nil {
+			return nil, false, err
+		}
+		for j := uint64(0); j < outputs; j++ {
+			// the value and the script
+			if err := r.skip(8); err != nil {
+				return nil, false, err
+			}
+			if err := r.skipVarBytes(); err != nil {
+				return nil, false, err
+			}
+		}
+		stripped = append(stripped, raw[start:r.pos]...)
+
+		if hasWitness {
+			// every input has the stack of items
+			for j := uint64(0); j < inputs; j++ {
+				items, err := r.varInt()
+				if err != nil {
+					return nil, false, err
+				}
+				for k := uint64(0); k < items; k++ {
+					if err := r.skipVarBytes(); err != nil {
+						return nil, false, err
+					}
+				}
+			}
+		}
+
+		lockTime, err := r.next(4)
+		if err != nil {
+			return nil, false, err
+		}
+		stripped = append(stripped, lockTime...)
+	}
+	if r.pos != len(raw) {
+		return nil, false, fmt.Errorf("%w: %d bytes after the transactions", ErrInvalidBlock, len(raw)-r.pos)
+	}
+	if !witness {
+		return raw, false, nil
+	}
+
+	return stripped, true, nil
+}
+
+// blockReader reads the serialized block, reading past its end is ErrInvalidBlock.
+type blockReader struct {
+	raw []byte
+	pos int
+}
+
+func (r *blockReader) next(n uint64) ([]byte, error) {
+	if n > uint64(len(r.raw)-r.pos) {
+		return nil, fmt.Errorf("%w: unexpected end at %d bytes", ErrInvalidBlock, len(r.raw))
+	}
+	b := r.raw[r.pos : r.pos+int(n)]
+	r.pos += int(n)
+
+	return b, nil
+}
+
+func (r *blockReader) skip(n uint64) error {
+	_, err := r.next(n)
+	return err
+}
+
+func (r *blockReader) skipVarBytes() error {
+	size, err := r.varInt()
+	if err != nil {
+		return err
+	}
+
+	return r.skip(size)
+}
+
+func (r *blockReader) varInt() (uint64, error) {
+	prefix, err := r.next(1)
+	if err != nil {
+		return 0, err
+	}
+
+	var b []byte
+	switch prefix[0] {
+	case 0xfd:
+		b, err = r.next(2)
+	case 0xfe:
+		b, err = r.next(4)
+	case 0xff:
+		b, err = r.next(8)
+	default:
+		return uint64(prefix[0]), nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	var buf [8]byte
+	copy(buf[:], b)
+
+	return binary.LittleEndian.Uint64(buf[:]), nil
+}
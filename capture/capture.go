@@ -0,0 +1,146 @@
+package capture
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/senseyman/bitcoin-handshake/model"
+)
+
+// The files have the same format as the ones written by Bitcoin Core with -capturemessages, so the captures can be
+// read by its contrib/message-capture/message-capture-parser.py and the other way around.
+const (
+	ReceivedFile = "msgs_recv.dat"
+	SentFile     = "msgs_sent.dat"
+
+	recordHeaderSize = 8 + model.CommandSize + model.LengthSize
+)
+
+var (
+	ErrInvalidRecord = errors.New("invalid capture record")
+)
+
+type Direction int
+
+const (
+	Received Direction = iota
+	Sent
+)
+
+func (d Direction) String() string {
+	if d == Sent {
+		return "sent"
+	}
+	return "recv"
+}
+
+func (d Direction) fileName() string {
+	if d == Sent {
+		return SentFile
+	}
+	return ReceivedFile
+}
+
+// Record is a single message sent to or received from the node.
+type Record struct {
+	Time      time.Time
+	Direction Direction
+	Command   string
+	Payload   []byte
+}
+
+// writeRecord writes record as: time in microseconds (int64), command (12 bytes, zero padded),
+// payload length (uint32) and payload. All numbers are little endian.
+func writeRecord(w io.Writer, r Record) error {
+	var command [model.CommandSize]byte
+	copy(command[:], r.Command)
+
+	buf := make([]byte, 0, recordHeaderSize+len(r.Payload))
+	buf = binary.LittleEndian.AppendUint64(buf, uint64(r.Time.UnixMicro()))
+	buf = append(buf, command[:]...)
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(r.Payload)))
+	buf = append(buf, r.Payload...)
+
+	_, err := w.Write(buf)
+	return err
+}
+
+func readRecord(r io.Reader, direction Direction) (Record, error) {
+	var hdr [recordHeaderSize]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return Record{}, err
+	}
+
+	length := binary.LittleEndian.Uint32(hdr[8+model.CommandSize:])
+	if length > model.MaxPayloadSize {
+		return Record{}, fmt.Errorf("%w: payload is too large: %d", ErrInvalidRecord, length)
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return Record{}, fmt.Errorf("%w: %v", ErrInvalidRecord, err)
+	}
+
+	return Record{
+		Time:      time.UnixMicro(int64(binary.LittleEndian.Uint64(hdr[:8]))),
+		Direction: direction,
+		Command:   string(bytes.TrimRight(hdr[8:8+model.CommandSize], "\x00")),
+		Payload:   payload,
+	}, nil
+}
+
+// ReadFile reads all records of the capture file.
+func ReadFile(path string, direction Direction) ([]Record, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var (
+		records []Record
+		r       = bufio.NewReader(f)
+	)
+	for {
+		record, err := readRecord(r, direction)
+		if errors.Is(err, io.EOF) {
+			return records, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s, record %d: %w", path, len(records), err)
+		}
+		records = append(records, record)
+	}
+}
+
+// ReadDir reads both files of the capture directory and returns all records in the order they happened.
+func ReadDir(dir string) ([]Record, error) {
+	var records []Record
+	for _, direction := range []Direction{Received, Sent} {
+		dirRecords, err := ReadFile(filepath.Join(dir, direction.fileName()), direction)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, dirRecords...)
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("no capture files in %s", dir)
+	}
+
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].Time.Before(records[j].Time)
+	})
+
+	return records, nil
+}
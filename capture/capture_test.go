@@ -0,0 +1,92 @@
+package capture
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/senseyman/bitcoin-handshake/model"
+)
+
+func TestRecorder(t *testing.T) {
+	dir := t.TempDir()
+	rec, err := NewRecorder(dir)
+	require.NoError(t, err)
+
+	start := time.UnixMicro(1713282931000000)
+	tick := start
+	rec.now = func() time.Time {
+		tick = tick.Add(time.Millisecond)
+		return tick
+	}
+
+	rec.RecordSent(model.MessageHeader{Command: model.VersionCMD}, []byte{1, 2, 3})
+	rec.RecordReceived(model.MessageHeader{Command: model.VersionCMD}, []byte{4, 5})
+	rec.RecordReceived(model.MessageHeader{Command: model.VerackCMD}, nil)
+	rec.RecordSent(model.MessageHeader{Command: model.VerackCMD}, nil)
+	require.NoError(t, rec.Close())
+
+	// records are in the file of their direction
+	raw, err := os.ReadFile(filepath.Join(dir, SentFile))
+	require.NoError(t, err)
+	assert.Len(t, raw, 2*recordHeaderSize+3)
+
+	records, err := ReadDir(dir)
+	require.NoError(t, err)
+	assert.Equal(t, []Record{
+		{Time: start.Add(time.Millisecond), Direction: Sent, Command: model.VersionCMD, Payload: []byte{1, 2, 3}},
+		{Time: start.Add(2 * time.Millisecond), Direction: Received, Command: model.VersionCMD, Payload: []byte{4, 5}},
+		{Time: start.Add(3 * time.Millisecond), Direction: Received, Command: model.VerackCMD, Payload: []byte{}},
+		{Time: start.Add(4 * time.Millisecond), Direction: Sent, Command: model.VerackCMD, Payload: []byte{}},
+	}, records)
+}
+
+func TestReadFile(t *testing.T) {
+	testCases := []struct {
+		name   string
+		data   []byte
+		expLen int
+		hasErr bool
+	}{
+		{
+			name: "success/empty",
+			data: nil,
+		},
+		{
+			name:   "success",
+			data:   make([]byte, recordHeaderSize),
+			expLen: 1,
+		},
+		{
+			name:   "err/truncated_payload",
+			data:   append(make([]byte, recordHeaderSize-4), 10, 0, 0, 0, 1),
+			hasErr: true,
+		},
+		{
+			name:   "err/too_large_payload",
+			data:   append(make([]byte, recordHeaderSize-4), 0xff, 0xff, 0xff, 0xff),
+			hasErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			path := filepath.Join(t.TempDir(), ReceivedFile)
+			require.NoError(t, os.WriteFile(path, tc.data, 0o600))
+
+			records, err := ReadFile(path, Received)
+			if tc.hasErr {
+				assert.ErrorIs(t, err, ErrInvalidRecord)
+				return
+			}
+			require.NoError(t, err)
+			assert.Len(t, records, tc.expLen)
+		})
+	}
+}
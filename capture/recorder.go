@@ -0,0 +1,95 @@
+package capture
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/senseyman/bitcoin-handshake/model"
+)
+
+// Recorder writes messages into capture directory. It can be used as a recorder of client.BitcoinClient.
+type Recorder struct {
+	mu     sync.Mutex
+	files  map[Direction]*os.File
+	out    map[Direction]*bufio.Writer
+	now    func() time.Time
+	closed bool
+}
+
+// NewRecorder creates the directory if needed and appends to capture files in it.
+func NewRecorder(dir string) (*Recorder, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create capture directory: %w", err)
+	}
+
+	r := &Recorder{
+		files: make(map[Direction]*os.File),
+		out:   make(map[Direction]*bufio.Writer),
+		now:   time.Now,
+	}
+	for _, direction := range []Direction{Received, Sent} {
+		f, err := os.OpenFile(filepath.Join(dir, direction.fileName()), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			_ = r.Close()
+			return nil, fmt.Errorf("failed to open capture file: %w", err)
+		}
+		r.files[direction] = f
+		r.out[direction] = bufio.NewWriter(f)
+	}
+
+	return r, nil
+}
+
+func (r *Recorder) RecordReceived(header model.MessageHeader, payload []byte) {
+	r.record(Received, header, payload)
+}
+
+func (r *Recorder) RecordSent(header model.MessageHeader, payload []byte) {
+	r.record(Sent, header, payload)
+}
+
+func (r *Recorder) record(direction Direction, header model.MessageHeader, payload []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		return
+	}
+
+	record := Record{Time: r.now(), Direction: direction, Command: header.Command, Payload: payload}
+	if err := writeRecord(r.out[direction], record); err != nil {
+		log.Warnf("failed to capture %s message: %v", header.Command, err)
+		return
+	}
+	// flush every message, so the capture is complete even if the app crashes
+	if err := r.out[direction].Flush(); err != nil {
+		log.Warnf("failed to capture %s message: %v", header.Command, err)
+	}
+}
+
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.closed = true
+
+	var firstErr error
+	for direction, f := range r.files {
+		if out := r.out[direction]; out != nil {
+			if err := out.Flush(); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+		if err := f.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return firstErr
+}
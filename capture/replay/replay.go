@@ -0,0 +1,169 @@
+package replay
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/senseyman/bitcoin-handshake/capture"
+	"github.com/senseyman/bitcoin-handshake/model"
+	"github.com/senseyman/bitcoin-handshake/utils"
+)
+
+const (
+	headerSize = model.MagicSize + model.CommandSize + model.LengthSize + model.ChecksumSize
+
+	// expectTimeout is how long the peer waits for the next message we sent in the captured session
+	expectTimeout = 5 * time.Second
+)
+
+var (
+	ErrUnexpectedMessage = errors.New("message differs from the captured one")
+	ErrTimeout           = errors.New("timeout waiting for the captured message")
+)
+
+// Peer plays the node side of the capture: messages received from the node are sent again and messages sent by us
+// are expected in the same order. With realtime the pauses between messages are kept.
+type Peer struct {
+	records  []capture.Record
+	realtime bool
+
+	conn  net.Conn
+	inbox chan model.MessageHeader
+
+	mu       sync.Mutex
+	commands []string
+
+	done chan struct{}
+	err  error
+}
+
+func NewPeer(records []capture.Record, realtime bool) *Peer {
+	return &Peer{
+		records:  records,
+		realtime: realtime,
+		inbox:    make(chan model.MessageHeader, 100),
+		done:     make(chan struct{}),
+	}
+}
+
+// Pipe starts the replay over in-memory connection and returns our side of it.
+func (p *Peer) Pipe() net.Conn {
+	conn, peerConn := net.Pipe()
+	p.conn = peerConn
+
+	go p.readLoop()
+	go p.run()
+
+	return conn
+}
+
+// Wait waits until the capture is played back and returns the error if our messages differ from the captured ones.
+func (p *Peer) Wait(ctx context.Context) error {
+	select {
+	case <-p.done:
+		return p.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Commands returns commands of all messages we sent so far.
+func (p *Peer) Commands() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return append([]string(nil), p.commands...)
+}
+
+func (p *Peer) run() {
+	var err error
+	for i, record := range p.records {
+		if p.realtime && i > 0 {
+			if gap := record.Time.Sub(p.records[i-1].Time); gap > 0 {
+				time.Sleep(gap)
+			}
+		}
+
+		if record.Direction == capture.Sent {
+			err = p.expect(record.Command)
+		} else {
+			err = p.send(record.Command, record.Payload)
+		}
+		if err != nil {
+			err = fmt.Errorf("record %d: %w", i, err)
+			break
+		}
+	}
+
+	p.err = err
+	close(p.done)
+}
+
+func (p *Peer) expect(command string) error {
+	select {
+	case hdr, ok := <-p.inbox:
+		if !ok {
+			return fmt.Errorf("expecting %s: %w", command, model.ErrConnectionClosed)
+		}
+		if hdr.Command != command {
+			return fmt.Errorf("%w: expected %s, got %s", ErrUnexpectedMessage, command, hdr.Command)
+		}
+		return nil
+	case <-time.After(expectTimeout):
+		return fmt.Errorf("%w: %s", ErrTimeout, command)
+	}
+}
+
+func (p *Peer) send(command string, payload []byte) error {
+	var cmd [model.CommandSize]byte
+	copy(cmd[:], command)
+
+	frame := make([]byte, 0, headerSize+len(payload))
+	frame = binary.LittleEndian.AppendUint32(frame, model.TestNetMagic)
+	frame = append(frame, cmd[:]...)
+	frame = binary.LittleEndian.AppendUint32(frame, uint32(len(payload)))
+	frame = append(frame, utils.DoubleHashB(payload)[:model.ChecksumSize]...)
+	frame = append(frame, payload...)
+
+	if err := p.conn.SetWriteDeadline(time.Now().Add(expectTimeout)); err != nil {
+		return err
+	}
+	_, err := p.conn.Write(frame)
+
+	return err
+}
+
+// readLoop reads our messages all the time, so we are never blocked on writing to the pipe.
+func (p *Peer) readLoop() {
+	defer close(p.inbox)
+
+	for {
+		var hdrBytes [headerSize]byte
+		if _, err := io.ReadFull(p.conn, hdrBytes[:]); err != nil {
+			return
+		}
+		hdr := model.MessageHeader{
+			Command: string(bytes.TrimRight(hdrBytes[4:16], "\x00")),
+			Length:  binary.LittleEndian.Uint32(hdrBytes[16:20]),
+		}
+		if hdr.Length > model.MaxPayloadSize {
+			return
+		}
+		if _, err := io.CopyN(io.Discard, p.conn, int64(hdr.Length)); err != nil {
+			return
+		}
+
+		p.mu.Lock()
+		p.commands = append(p.commands, hdr.Command)
+		p.mu.Unlock()
+
+		p.inbox <- hdr
+	}
+}
@@ -0,0 +1,121 @@
+package replay
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/senseyman/bitcoin-handshake/capture"
+	"github.com/senseyman/bitcoin-handshake/client"
+	"github.com/senseyman/bitcoin-handshake/core"
+	"github.com/senseyman/bitcoin-handshake/model"
+	"github.com/senseyman/bitcoin-handshake/nodetest"
+	"github.com/senseyman/bitcoin-handshake/service"
+)
+
+// fakePeer is the node side of the session, either scripted or replayed.
+type fakePeer interface {
+	Pipe() net.Conn
+	Wait(ctx context.Context) error
+}
+
+// handshake makes the handshake with the fake peer and waits until its side of the session is done, the errors of
+// both sides are returned.
+func handshake(t *testing.T, peer fakePeer, opts ...client.Option) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	cli, err := client.NewBitcoinClient("127.0.0.1", 18333, func(string, int) (client.Connection, error) {
+		return peer.Pipe(), nil
+	}, opts...)
+	require.NoError(t, err)
+
+	c := core.New(service.NewDecodeService(), service.NewEncodeService(), service.NewMessageGenerator(), cli)
+	c.ReceiveMessages(ctx)
+	_, err = c.Handshake(ctx)
+	waitCtx, waitCancel := context.WithTimeout(context.Background(), time.Second)
+	defer waitCancel()
+
+	return errors.Join(err, peer.Wait(waitCtx))
+}
+
+// captureHandshake captures the session with a node which sends more than needed for the handshake.
+func captureHandshake(t *testing.T) []capture.Record {
+	dir := t.TempDir()
+	rec, err := capture.NewRecorder(dir)
+	require.NoError(t, err)
+
+	var steps []nodetest.Step
+	for _, command := range nodetest.HandshakeSequence {
+		payload, _ := nodetest.CapturedPayload(command)
+		steps = append(steps, nodetest.SendMessage(command, payload))
+	}
+	steps = append(steps, nodetest.Expect(model.VersionCMD), nodetest.Expect(model.VerackCMD))
+	require.NoError(t, handshake(t, nodetest.NewPeer(steps...), client.WithRecorder(rec)))
+
+	// the last messages may be still being received when the handshake is done
+	var records []capture.Record
+	require.Eventually(t, func() bool {
+		records, err = capture.ReadDir(dir)
+		return err == nil && len(records) == len(nodetest.HandshakeSequence)+2
+	}, time.Second, time.Millisecond)
+	require.NoError(t, rec.Close())
+
+	commands := make(map[capture.Direction][]string)
+	for _, r := range records {
+		commands[r.Direction] = append(commands[r.Direction], r.Command)
+	}
+	require.Equal(t, []string{model.VersionCMD, model.VerackCMD}, commands[capture.Sent])
+	require.Equal(t, nodetest.HandshakeSequence, commands[capture.Received])
+
+	return records
+}
+
+func TestPeer(t *testing.T) {
+	records := captureHandshake(t)
+
+	testCases := []struct {
+		name    string
+		records func() []capture.Record
+		expErr  error
+	}{
+		{
+			name:    "success",
+			records: func() []capture.Record { return records },
+		},
+		{
+			name: "err/differs",
+			records: func() []capture.Record {
+				// we sent something else in the captured session
+				changed := append([]capture.Record(nil), records...)
+				for i := range changed {
+					if changed[i].Direction == capture.Sent && changed[i].Command == model.VerackCMD {
+						changed[i].Command = model.SendHeadersCMD
+					}
+				}
+				return changed
+			},
+			expErr: ErrUnexpectedMessage,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			peer := NewPeer(tc.records(), false)
+			err := handshake(t, peer)
+			if tc.expErr != nil {
+				assert.ErrorIs(t, err, tc.expErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, []string{model.VersionCMD, model.VerackCMD}, peer.Commands())
+		})
+	}
+}
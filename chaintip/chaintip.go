@@ -0,0 +1,457 @@
+package chaintip
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"slices"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/senseyman/bitcoin-handshake/model"
+)
+
+const (
+	// DefaultStaleTipAfter is how long the tip may stay the same before it's reported as stale, three block
+	// intervals as in Bitcoin Core.
+	DefaultStaleTipAfter = 30 * time.Minute
+	// MaxUnconnectingHeaders is the number of headers messages which don't connect to the known headers after
+	// which the peer isn't asked for the missing headers anymore, as in Bitcoin Core.
+	MaxUnconnectingHeaders = 10
+	// MaxSideHeaders is the number of headers off the chain which are kept per peer, so the peer can't fill
+	// the memory with the cheap headers of a branch which never wins, e.g. on testnet with its minimum difficulty.
+	// It's enough for the competing branch as deep as the headers are kept.
+	MaxSideHeaders = 2 * pruneDepth
+	// pruneDepth is how deep below the tip the headers are kept, the reorgs deeper than it aren't detected
+	pruneDepth = 1000
+	// recentTipAge is the age of the tip since which the tip events are emitted, so the initial sync isn't
+	// reported block by block
+	recentTipAge = 24 * time.Hour
+	// minStaleCheckInterval keeps the tip checks sane when the stale tip timeout is tiny
+	minStaleCheckInterval = time.Second
+	eventsChannelSize     = 100
+	subscriberChannelSize = 100
+)
+
+var (
+	ErrNotConnected       = errors.New("headers don't connect to the known headers")
+	ErrTooManySideHeaders = errors.New("too many headers off the chain")
+)
+
+type EventType string
+
+const (
+	// EventTip is the new tip on top of the previous one.
+	EventTip EventType = "tip"
+	// EventReorg is the new tip on a competing branch, the headers of the previous branch are disconnected.
+	EventReorg EventType = "reorg"
+	// EventStaleTip is emitted once per tip when it hasn't changed for longer than the stale tip timeout.
+	EventStaleTip EventType = "stale_tip"
+)
+
+type HeaderInfo struct {
+	Hash   string    `json:"hash"`
+	Height int       `json:"height"`
+	Time   time.Time `json:"time"`
+}
+
+type Event struct {
+	Type EventType `json:"type"`
+	Time time.Time `json:"time"`
+	// Peer has sent the headers of the new tip, it's empty for stale tips
+	Peer string     `json:"peer,omitempty"`
+	Tip  HeaderInfo `json:"tip"`
+	// ForkPoint is the last common header of the previous and the new tip. Depth is the number of the
+	// disconnected headers. Both are set for reorgs only
+	ForkPoint    *HeaderInfo  `json:"fork_point,omitempty"`
+	Depth        int          `json:"depth,omitempty"`
+	Disconnected []HeaderInfo `json:"disconnected,omitempty"`
+	Connected    []HeaderInfo `json:"connected,omitempty"`
+	// StaleFor is the number of seconds since the tip has changed, it's set for stale tips only
+	StaleFor float64 `json:"stale_for_s,omitempty"`
+}
+
+// Status is the state of the tracked chain.
+type Status struct {
+	Tip          HeaderInfo `json:"tip"`
+	TipChangedAt time.Time  `json:"tip_changed_at"`
+	Stale        bool       `json:"stale"`
+	Headers      int        `json:"headers"`
+	Reorgs       int        `json:"reorgs"`
+	LastReorg    *Event     `json:"last_reorg,omitempty"`
+}
+
+// Sink gets every event. It's called from Run one event at a time, so the slow sink delays the next events.
+type Sink interface {
+	Notify(event Event) error
+}
+
+type node struct {
+	header model.BlockHeader
+	hash   [32]byte
+	height int
+	// work is the cumulative work of the chain up to the header
+	work   *big.Int
+	parent *node
+}
+
+func (n *node) info() HeaderInfo {
+	return HeaderInfo{
+		Hash:   model.HashString(n.hash),
+		Height: n.height,
+		Time:   time.Unix(int64(n.header.Timestamp), 0).UTC(),
+	}
+}
+
+type Option func(t *Tracker)
+
+// WithSink adds the sink the events are delivered to.
+func WithSink(sink Sink) Option {
+	return func(t *Tracker) {
+		t.sinks = append(t.sinks, sink)
+	}
+}
+
+// WithStaleTipAfter sets how long the tip may stay the same before it's reported as stale, zero disables
+// the stale tip events.
+func WithStaleTipAfter(d time.Duration) Option {
+	return func(t *Tracker) {
+		t.staleTipAfter = d
+	}
+}
+
+// Tracker follows the headers announced by the peers as a tree of branches from the root header, the branch with
+// the most work is the chain. When the chain switches to another branch, the reorg event is emitted. The events are
+// delivered by Run. It's safe for concurrent use.
+type Tracker struct {
+	sinks         []Sink
+	staleTipAfter time.Duration
+	events        chan Event
+
+	mu           sync.Mutex
+	nodes        map[[32]byte]*node
+	tip          *node
+	tipChangedAt time.Time
+	staleEmitted bool
+	reorgs       int
+	lastReorg    *Event
+	// unconnecting counts the headers messages of the peers which don't connect, it's reset when they connect
+	unconnecting map[string]int
+	// sideHeaders counts the headers of the peers which didn't make the chain, it's reset when the peer's
+	// headers become the tip
+	sideHeaders map[string]int
+	subscribers []chan Event
+
+	now func() time.Time
+}
+
+// New creates the tracker of the chain starting at the root header at the height, e.g. the genesis or the tip
+// of the block store. The reorgs below the root aren't detected.
+func New(root model.BlockHeader, rootHeight int, opts ...Option) *Tracker {
+	rootNode := &node{header: root, hash: root.Hash(), height: rootHeight, work: root.Work()}
+	t := &Tracker{
+		staleTipAfter: DefaultStaleTipAfter,
+		events:        make(chan Event, eventsChannelSize),
+		nodes:         map[[32]byte]*node{rootNode.hash: rootNode},
+		tip:           rootNode,
+		unconnecting:  make(map[string]int),
+		sideHeaders:   make(map[string]int),
+		now:           time.Now,
+	}
+	for _, opt := range opts {
+		opt(t)
+	}
+	t.tipChangedAt = t.now()
+
+	return t
+}
+
+// AddHeaders adds the headers received from the peer, the known ones are skipped. It reports if the peer should be
+// asked for more headers: the message was full, or it didn't connect and the peer hasn't sent too many of such.
+// The headers which don't make the chain are rejected once the peer has sent more than MaxSideHeaders of them.
+func (t *Tracker) AddHeaders(peer string, headers []model.BlockHeader) (bool, error) {
+	if err := model.ValidateHeaders(headers); err != nil {
+		return false, err
+	}
+	if len(headers) == 0 {
+		return false, nil
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	parent, ok := t.nodes[headers[0].PrevBlock]
+	if !ok {
+		if _, known := t.nodes[headers[0].Hash()]; !known {
+			t.unconnecting[peer]++
+			return t.unconnecting[peer] <= MaxUnconnectingHeaders,
+				fmt.Errorf("%w: %s", ErrNotConnected, model.HashString(headers[0].Hash()))
+		}
+	}
+	delete(t.unconnecting, peer)
+
+	var added []*node
+	for _, h := range headers {
+		hash := h.Hash()
+		if n, known := t.nodes[hash]; known {
+			parent = n
+			continue
+		}
+		n := &node{
+			header: h,
+			hash:   hash,
+			height: parent.height + 1,
+			work:   new(big.Int).Add(parent.work, h.Work()),
+			parent: parent,
+		}
+		added = append(added, n)
+		parent = n
+	}
+	if len(added) == 0 {
+		return len(headers) == model.MaxHeadersPerMessage, nil
+	}
+
+	// the first seen branch wins on equal work, as in Bitcoin Core
+	last := added[len(added)-1]
+	tip := last.work.Cmp(t.tip.work) > 0
+	if !tip {
+		if t.sideHeaders[peer]+len(added) > MaxSideHeaders {
+			return false, fmt.Errorf("%w: %d from %s", ErrTooManySideHeaders, t.sideHeaders[peer]+len(added), peer)
+		}
+		t.sideHeaders[peer] += len(added)
+	}
+	for _, n := range added {
+		t.nodes[n.hash] = n
+	}
+	if tip {
+		delete(t.sideHeaders, peer)
+		t.setTip(peer, last)
+	}
+
+	return len(headers) == model.MaxHeadersPerMessage, nil
+}
+
+// setTip switches the chain to the tip and emits the event about it.
+func (t *Tracker) setTip(peer string, tip *node) {
+	now := t.now()
+	fork := forkPoint(t.tip, tip)
+	disconnected := path(t.tip, fork)
+	connected := path(tip, fork)
+	t.tip, t.tipChangedAt, t.staleEmitted = tip, now, false
+	t.prune()
+
+	event := Event{Time: now, Peer: peer, Tip: tip.info(), Connected: connected}
+	switch {
+	case len(disconnected) > 0:
+		event.Type = EventReorg
+		event.Depth = len(disconnected)
+		event.Disconnected = disconnected
+		if fork != nil {
+			forkInfo := fork.info()
+			event.ForkPoint = &forkInfo
+		}
+		t.reorgs++
+		t.lastReorg = &event
+		log.Warnf("chain reorg of depth %d, new tip %s at %d", event.Depth, event.Tip.Hash, event.Tip.Height)
+	case now.Sub(event.Tip.Time) < recentTipAge:
+		event.Type = EventTip
+		log.Infof("new chain tip %s at %d", event.Tip.Hash, event.Tip.Height)
+	default:
+		// the headers of the initial sync
+		return
+	}
+	t.emit(event)
+}
+
+// forkPoint returns the last common header of the branches, nil if it's pruned.
+func forkPoint(a, b *node) *node {
+	for a != nil && b != nil && a != b {
+		if a.height >= b.height {
+			a = a.parent
+		} else {
+			b = b.parent
+		}
+	}
+	if a != b {
+		return nil
+	}
+
+	return a
+}
+
+// path returns the headers from the fork point, exclusive, to the tip.
+func path(tip, fork *node) []HeaderInfo {
+	var infos []HeaderInfo
+	for n := tip; n != nil && n != fork; n = n.parent {
+		infos = append(infos, n.info())
+	}
+	for i, j := 0, len(infos)-1; i < j; i, j = i+1, j-1 {
+		infos[i], infos[j] = infos[j], infos[i]
+	}
+
+	return infos
+}
+
+// prune forgets the headers deeper than pruneDepth below the tip, so the tree doesn't grow with the chain.
+func (t *Tracker) prune() {
+	cutoff := t.tip.height - pruneDepth
+	if cutoff <= 0 {
+		return
+	}
+	for hash, n := range t.nodes {
+		switch {
+		case n.height < cutoff:
+			delete(t.nodes, hash)
+		case n.parent != nil && n.parent.height < cutoff:
+			n.parent = nil
+		}
+	}
+}
+
+// emit queues the event for Run, the event is dropped if Run doesn't keep up.
+func (t *Tracker) emit(event Event) {
+	select {
+	case t.events <- event:
+	default:
+		log.Warnf("chain tip events are delivered too slowly, dropping %s event", event.Type)
+	}
+}
+
+// checkStaleTip emits the stale tip event once per tip.
+func (t *Tracker) checkStaleTip() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.staleTipAfter <= 0 || t.staleEmitted {
+		return
+	}
+	now := t.now()
+	staleFor := now.Sub(t.tipChangedAt)
+	if staleFor <= t.staleTipAfter {
+		return
+	}
+	t.staleEmitted = true
+	log.Warnf("chain tip %s hasn't changed for %s", model.HashString(t.tip.hash), staleFor.Round(time.Second))
+	t.emit(Event{Type: EventStaleTip, Time: now, Tip: t.tip.info(), StaleFor: staleFor.Seconds()})
+}
+
+// Has reports if the header is known.
+func (t *Tracker) Has(hash [32]byte) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	_, ok := t.nodes[hash]
+
+	return ok
+}
+
+// Tip returns the header of the tip of the chain.
+func (t *Tracker) Tip() HeaderInfo {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.tip.info()
+}
+
+// Locator returns the block locator of the chain: the latest 10 hashes, then with doubling steps back to the
+// oldest known header.
+func (t *Tracker) Locator() [][32]byte {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var locator [][32]byte
+	step := 1
+	for n := t.tip; ; {
+		locator = append(locator, n.hash)
+		if len(locator) >= 10 {
+			step *= 2
+		}
+		for i := 0; i < step && n.parent != nil; i++ {
+			n = n.parent
+		}
+		if n.hash == locator[len(locator)-1] {
+			return locator
+		}
+	}
+}
+
+func (t *Tracker) Status() Status {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return Status{
+		Tip:          t.tip.info(),
+		TipChangedAt: t.tipChangedAt,
+		Stale:        t.staleEmitted,
+		Headers:      len(t.nodes),
+		Reorgs:       t.reorgs,
+		LastReorg:    t.lastReorg,
+	}
+}
+
+// Subscribe returns a channel which receives all events delivered by Run and the function which unsubscribes and
+// closes the channel. If the subscriber is too slow, the events are dropped.
+func (t *Tracker) Subscribe() (<-chan Event, func()) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	ch := make(chan Event, subscriberChannelSize)
+	t.subscribers = append(t.subscribers, ch)
+
+	return ch, func() { t.unsubscribe(ch) }
+}
+
+func (t *Tracker) unsubscribe(ch chan Event) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for i, sub := range t.subscribers {
+		if sub == ch {
+			t.subscribers = slices.Delete(t.subscribers, i, i+1)
+			close(ch)
+			return
+		}
+	}
+}
+
+// Run delivers the events to the sinks and the subscribers and checks the tip for staleness until ctx is done.
+func (t *Tracker) Run(ctx context.Context) {
+	interval := time.Minute
+	if t.staleTipAfter > 0 {
+		interval = max(min(interval, t.staleTipAfter/2), minStaleCheckInterval)
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			t.checkStaleTip()
+		case event := <-t.events:
+			t.deliver(event)
+		}
+	}
+}
+
+func (t *Tracker) deliver(event Event) {
+	for _, sink := range t.sinks {
+		if err := sink.Notify(event); err != nil {
+			log.Warnf("err while delivering %s event: %v", event.Type, err)
+		}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, ch := range t.subscribers {
+		select {
+		case ch <- event:
+		default:
+			log.Warnf("chain tip subscriber is too slow, dropping %s event", event.Type)
+		}
+	}
+}
@@ -0,0 +1,421 @@
+package chaintip
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/senseyman/bitcoin-handshake/model"
+)
+
+const baseTime = 1700000000
+
+// mine returns count headers on top of the parent, the blocks are spaced by ten minutes. The salt makes
+// the branches from the same parent differ.
+func mine(tb testing.TB, parent model.BlockHeader, count int, salt byte) []model.BlockHeader {
+	tb.Helper()
+
+	var headers []model.BlockHeader
+	prev := parent
+	for i := 0; i < count; i++ {
+		h := model.BlockHeader{Version: 4, PrevBlock: prev.Hash(), Timestamp: prev.Timestamp + 600, Bits: 0x207fffff}
+		h.MerkleRoot[0] = salt
+		for !h.CheckProofOfWork() {
+			h.Nonce++
+		}
+		headers = append(headers, h)
+		prev = h
+	}
+
+	return headers
+}
+
+func rootHeader() model.BlockHeader {
+	return model.BlockHeader{Version: 4, Timestamp: baseTime, Bits: 0x207fffff}
+}
+
+func newTestTracker(opts ...Option) *Tracker {
+	t := New(rootHeader(), 100, opts...)
+	t.now = func() time.Time { return time.Unix(baseTime+3600, 0) }
+
+	return t
+}
+
+func info(h model.BlockHeader, height int) HeaderInfo {
+	return HeaderInfo{Hash: model.HashString(h.Hash()), Height: height, Time: time.Unix(int64(h.Timestamp), 0).UTC()}
+}
+
+func infos(headers []model.BlockHeader, height int) []HeaderInfo {
+	var result []HeaderInfo
+	for i, h := range headers {
+		result = append(result, info(h, height+i))
+	}
+
+	return result
+}
+
+func emitted(t *Tracker) []Event {
+	var events []Event
+	for {
+		select {
+		case event := <-t.events:
+			events = append(events, event)
+		default:
+			return events
+		}
+	}
+}
+
+func TestTracker_AddHeaders(t *testing.T) {
+	t.Parallel()
+
+	root := rootHeader()
+	chain := mine(t, root, 4, 0)
+	longer := mine(t, chain[1], 3, 1)
+	equal := mine(t, chain[1], 2, 2)
+	// the header is two days older than now
+	old := model.BlockHeader{Version: 4, PrevBlock: root.Hash(), Timestamp: baseTime - 2*86400, Bits: 0x207fffff}
+	for !old.CheckProofOfWork() {
+		old.Nonce++
+	}
+	full := mine(t, root, model.MaxHeadersPerMessage, 3)
+
+	testCases := []struct {
+		name      string
+		headers   [][]model.BlockHeader
+		expNeed   bool
+		expTip    HeaderInfo
+		expEvents []Event
+		expErr    error
+	}{
+		{
+			name:    "ok/tip",
+			headers: [][]model.BlockHeader{chain[:2], chain[2:]},
+			expTip:  info(chain[3], 104),
+			expEvents: []Event{
+				{Type: EventTip, Peer: "peer", Tip: info(chain[1], 102), Connected: infos(chain[:2], 101)},
+				{Type: EventTip, Peer: "peer", Tip: info(chain[3], 104), Connected: infos(chain[2:], 103)},
+			},
+		},
+		{
+			name:    "ok/known_skipped",
+			headers: [][]model.BlockHeader{chain, chain[1:]},
+			expTip:  info(chain[3], 104),
+			expEvents: []Event{
+				{Type: EventTip, Peer: "peer", Tip: info(chain[3], 104), Connected: infos(chain, 101)},
+			},
+		},
+		{
+			name:    "ok/reorg",
+			headers: [][]model.BlockHeader{chain, longer},
+			expTip:  info(longer[2], 105),
+			expEvents: []Event{
+				{Type: EventTip, Peer: "peer", Tip: info(chain[3], 104), Connected: infos(chain, 101)},
+				{
+					Type:         EventReorg,
+					Peer:         "peer",
+					Tip:          info(longer[2], 105),
+					ForkPoint:    &HeaderInfo{Hash: model.HashString(chain[1].Hash()), Height: 102, Time: time.Unix(int64(chain[1].Timestamp), 0).UTC()},
+					Depth:        2,
+					Disconnected: infos(chain[2:], 103),
+					Connected:    infos(longer, 103),
+				},
+			},
+		},
+		{
+			name:    "ok/equal_work_first_seen_wins",
+			headers: [][]model.BlockHeader{chain, equal},
+			expTip:  info(chain[3], 104),
+			expEvents: []Event{
+				{Type: EventTip, Peer: "peer", Tip: info(chain[3], 104), Connected: infos(chain, 101)},
+			},
+		},
+		{
+			name:    "ok/initial_sync",
+			headers: [][]model.BlockHeader{{old}},
+			expTip:  info(old, 101),
+		},
+		{
+			name:    "ok/full_message",
+			headers: [][]model.BlockHeader{full},
+			expNeed: true,
+			expTip:  info(full[len(full)-1], 100+len(full)),
+			expEvents: []Event{
+				{Type: EventTip, Peer: "peer", Tip: info(full[len(full)-1], 100+len(full)), Connected: infos(full, 101)},
+			},
+		},
+		{
+			name:    "err/not_connected",
+			headers: [][]model.BlockHeader{chain[1:]},
+			expNeed: true,
+			expTip:  info(root, 100),
+			expErr:  ErrNotConnected,
+		},
+		{
+			name:    "err/invalid",
+			headers: [][]model.BlockHeader{{chain[1], chain[0]}},
+			expTip:  info(root, 100),
+			expErr:  model.ErrInvalidHeaders,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			tracker := newTestTracker()
+			var need bool
+			var err error
+			for _, headers := range tc.headers {
+				need, err = tracker.AddHeaders("peer", headers)
+			}
+			assert.Equal(t, tc.expNeed, need)
+			if tc.expErr != nil {
+				assert.ErrorIs(t, err, tc.expErr)
+			} else {
+				require.NoError(t, err)
+			}
+			assert.Equal(t, tc.expTip, tracker.Tip())
+
+			events := emitted(tracker)
+			for i := range events {
+				events[i].Time = time.Time{}
+			}
+			if tc.expEvents == nil {
+				assert.Empty(t, events)
+				return
+			}
+			assert.Equal(t, tc.expEvents, events)
+		})
+	}
+}
+
+func TestTracker_Unconnecting(t *testing.T) {
+	t.Parallel()
+
+	tracker := newTestTracker()
+	chain := mine(t, rootHeader(), 2, 0)
+
+	for i := 1; i <= MaxUnconnectingHeaders; i++ {
+		need, err := tracker.AddHeaders("peer", chain[1:])
+		assert.ErrorIs(t, err, ErrNotConnected)
+		assert.True(t, need, i)
+	}
+	need, err := tracker.AddHeaders("peer", chain[1:])
+	assert.ErrorIs(t, err, ErrNotConnected)
+	assert.False(t, need)
+	// the other peers are still asked
+	need, _ = tracker.AddHeaders("other", chain[1:])
+	assert.True(t, need)
+
+	// the counter is reset once the headers connect
+	_, err = tracker.AddHeaders("peer", chain)
+	require.NoError(t, err)
+	need, _ = tracker.AddHeaders("peer", mine(t, chain[0], 2, 1)[1:])
+	assert.True(t, need)
+}
+
+func TestTracker_SideHeaders(t *testing.T) {
+	t.Parallel()
+
+	tracker := newTestTracker()
+	chain := mine(t, rootHeader(), pruneDepth, 0)
+	for i := 0; i < len(chain); i += model.MaxHeadersPerMessage {
+		_, err := tracker.AddHeaders("peer", chain[i:min(i+model.MaxHeadersPerMessage, len(chain))])
+		require.NoError(t, err)
+	}
+
+	// the branches have less work than the chain, so they never win
+	fork := chain[len(chain)/2]
+	branchLen := 400
+	var branches [][]model.BlockHeader
+	for i := 0; i <= MaxSideHeaders/branchLen; i++ {
+		branches = append(branches, mine(t, fork, branchLen, byte(i+1)))
+	}
+	for _, branch := range branches[:len(branches)-1] {
+		_, err := tracker.AddHeaders("peer", branch)
+		require.NoError(t, err)
+	}
+	last := branches[len(branches)-1]
+	_, err := tracker.AddHeaders("peer", last)
+	require.ErrorIs(t, err, ErrTooManySideHeaders)
+	assert.False(t, tracker.Has(last[0].Hash()))
+
+	// the limit is per peer
+	_, err = tracker.AddHeaders("other", last[:1])
+	require.NoError(t, err)
+
+	// the counter is reset once the headers of the peer become the tip
+	longer := mine(t, branches[0][branchLen-1], len(chain)/2, 1)
+	_, err = tracker.AddHeaders("peer", longer)
+	require.NoError(t, err)
+	assert.Equal(t, model.HashString(longer[len(longer)-1].Hash()), tracker.Tip().Hash)
+	_, err = tracker.AddHeaders("peer", last)
+	require.NoError(t, err)
+}
+
+func TestTracker_StaleTip(t *testing.T) {
+	t.Parallel()
+
+	tracker := newTestTracker(WithStaleTipAfter(time.Hour))
+	now := time.Unix(baseTime+3600, 0)
+	tracker.now = func() time.Time { return now }
+	chain := mine(t, rootHeader(), 2, 0)
+	_, err := tracker.AddHeaders("peer", chain[:1])
+	require.NoError(t, err)
+	emitted(tracker)
+
+	now = now.Add(time.Hour)
+	tracker.checkStaleTip()
+	assert.Empty(t, emitted(tracker))
+
+	now = now.Add(time.Minute)
+	tracker.checkStaleTip()
+	tracker.checkStaleTip()
+	events := emitted(tracker)
+	require.Len(t, events, 1)
+	assert.Equal(t, Event{Type: EventStaleTip, Time: now, Tip: info(chain[0], 101), StaleFor: 3660}, events[0])
+	assert.True(t, tracker.Status().Stale)
+
+	// the new tip is stale again after the timeout
+	_, err = tracker.AddHeaders("peer", chain[1:])
+	require.NoError(t, err)
+	assert.False(t, tracker.Status().Stale)
+	now = now.Add(2 * time.Hour)
+	tracker.checkStaleTip()
+	events = emitted(tracker)
+	require.Len(t, events, 2)
+	assert.Equal(t, EventStaleTip, events[1].Type)
+	assert.Equal(t, info(chain[1], 102), events[1].Tip)
+}
+
+func TestTracker_Locator(t *testing.T) {
+	t.Parallel()
+
+	tracker := newTestTracker()
+	assert.Equal(t, [][32]byte{rootHeader().Hash()}, tracker.Locator())
+
+	chain := mine(t, rootHeader(), 30, 0)
+	_, err := tracker.AddHeaders("peer", chain)
+	require.NoError(t, err)
+
+	var heights []int
+	for _, hash := range tracker.Locator() {
+		for i, h := range append([]model.BlockHeader{rootHeader()}, chain...) {
+			if h.Hash() == hash {
+				heights = append(heights, 100+i)
+			}
+		}
+	}
+	assert.Equal(t, []int{130, 129, 128, 127, 126, 125, 124, 123, 122, 121, 119, 115, 107, 100}, heights)
+}
+
+func TestTracker_Prune(t *testing.T) {
+	t.Parallel()
+
+	tracker := newTestTracker()
+	chain := mine(t, rootHeader(), pruneDepth+10, 0)
+	for i := 0; i < len(chain); i += model.MaxHeadersPerMessage {
+		_, err := tracker.AddHeaders("peer", chain[i:min(i+model.MaxHeadersPerMessage, len(chain))])
+		require.NoError(t, err)
+	}
+
+	assert.Equal(t, pruneDepth+1, tracker.Status().Headers)
+	assert.False(t, tracker.Has(rootHeader().Hash()))
+	assert.True(t, tracker.Has(chain[9].Hash()))
+	// the locator ends at the oldest kept header
+	locator := tracker.Locator()
+	assert.Equal(t, chain[9].Hash(), locator[len(locator)-1])
+
+	// the fork below the kept headers doesn't connect
+	_, err := tracker.AddHeaders("peer", mine(t, chain[5], 2, 1))
+	assert.ErrorIs(t, err, ErrNotConnected)
+}
+
+func TestTracker_Run(t *testing.T) {
+	t.Parallel()
+
+	bodies := make(chan []byte, 10)
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodies <- body
+	}))
+	t.Cleanup(webhook.Close)
+
+	var out bytes.Buffer
+	jsonSink := NewJSONSink(&out)
+	tracker := newTestTracker(WithSink(jsonSink), WithSink(NewWebhookSink(webhook.URL)))
+	sub, unsubscribe := tracker.Subscribe()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		tracker.Run(ctx)
+		close(done)
+	}()
+
+	chain := mine(t, rootHeader(), 1, 0)
+	_, err := tracker.AddHeaders("peer", chain)
+	require.NoError(t, err)
+
+	select {
+	case event := <-sub:
+		assert.Equal(t, EventTip, event.Type)
+		assert.Equal(t, info(chain[0], 101), event.Tip)
+	case <-time.After(time.Second):
+		t.Fatal("no event delivered to the subscriber")
+	}
+	var posted Event
+	select {
+	case body := <-bodies:
+		require.NoError(t, json.Unmarshal(body, &posted))
+	case <-time.After(time.Second):
+		t.Fatal("no event posted to the webhook")
+	}
+	cancel()
+	<-done
+
+	// the channel is closed once unsubscribed
+	unsubscribe()
+	unsubscribe()
+	_, ok := <-sub
+	assert.False(t, ok)
+	assert.Empty(t, tracker.subscribers)
+
+	assert.Equal(t, info(chain[0], 101), posted.Tip)
+	// the sinks are called before the subscribers
+	var written Event
+	require.NoError(t, json.Unmarshal(out.Bytes(), &written))
+	assert.Equal(t, posted, written)
+}
+
+func TestTracker_Run_TinyStaleTipAfter(t *testing.T) {
+	t.Parallel()
+
+	tracker := newTestTracker(WithStaleTipAfter(time.Nanosecond))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	// the interval of the checks isn't zero
+	assert.NotPanics(t, func() { tracker.Run(ctx) })
+}
+
+func TestWebhookSink_Status(t *testing.T) {
+	t.Parallel()
+
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	t.Cleanup(webhook.Close)
+
+	err := NewWebhookSink(webhook.URL).Notify(Event{Type: EventTip})
+	assert.ErrorIs(t, err, ErrWebhookStatus)
+}
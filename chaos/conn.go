@@ -0,0 +1,219 @@
+package chaos
+
+import (
+	"errors"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/senseyman/bitcoin-handshake/client"
+)
+
+const (
+	maxFragments = 8
+)
+
+var (
+	ErrInjectedClose = errors.New("connection closed by fault injection")
+)
+
+// Conn is a client.Connection which injects faults described by the profile. The faults follow the schedule
+// defined by the seed: the same seed and the same sequence of reads and writes give the same faults.
+type Conn struct {
+	conn    client.Connection
+	profile Profile
+	sleep   func(time.Duration)
+
+	// reads and writes may run concurrently, so each direction has its own schedule
+	readMu   sync.Mutex
+	readRnd  *rand.Rand
+	writeMu  sync.Mutex
+	writeRnd *rand.Rand
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+func Wrap(conn client.Connection, profile Profile, seed int64) *Conn {
+	return &Conn{
+		conn:     conn,
+		profile:  profile,
+		sleep:    time.Sleep,
+		readRnd:  rand.New(rand.NewSource(seed)),     //nolint:gosec // the schedule has to be reproducible
+		writeRnd: rand.New(rand.NewSource(seed + 1)), //nolint:gosec // the schedule has to be reproducible
+		closed:   make(chan struct{}),
+	}
+}
+
+// ConnectionFn wraps every connection made by connectionFn. Every next connection gets the next seed, so
+// reconnects don't repeat the same faults.
+func ConnectionFn(
+	connectionFn func(host string, port int) (client.Connection, error),
+	profile Profile, seed int64,
+) func(host string, port int) (client.Connection, error) {
+	var (
+		mu    sync.Mutex
+		count int64
+	)
+
+	return func(host string, port int) (client.Connection, error) {
+		conn, err := connectionFn(host, port)
+		if err != nil {
+			return nil, err
+		}
+
+		mu.Lock()
+		connSeed := seed + 2*count
+		count++
+		mu.Unlock()
+
+		return Wrap(conn, profile, connSeed), nil
+	}
+}
+
+func (c *Conn) Read(p []byte) (int, error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+
+	if c.happens(c.readRnd, c.profile.CloseRate) {
+		log.Debug("chaos: closing connection on read")
+		c.abort()
+		return 0, ErrInjectedClose
+	}
+
+	for {
+		n, err := c.conn.Read(p)
+		n = c.damage(p[:n])
+		if n > 0 || err != nil {
+			c.delay(c.readRnd, n)
+			return n, err
+		}
+		// all received bytes were dropped, wait for more
+	}
+}
+
+func (c *Conn) Write(p []byte) (int, error) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	if c.happens(c.writeRnd, c.profile.CloseRate) {
+		log.Debug("chaos: closing connection on write")
+		n, _ := c.conn.Write(p[:c.writeRnd.Intn(len(p)+1)])
+		c.abort()
+		return n, ErrInjectedClose
+	}
+
+	fragments := [][]byte{p}
+	if len(p) > 1 && c.happens(c.writeRnd, c.profile.FragmentRate) {
+		fragments = c.fragment(p)
+	}
+
+	written := 0
+	for _, fragment := range fragments {
+		c.delay(c.writeRnd, len(fragment))
+		n, err := c.conn.Write(fragment)
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+
+	return written, nil
+}
+
+func (c *Conn) Close() error {
+	err := net.ErrClosed
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		err = c.conn.Close()
+	})
+
+	return err
+}
+
+// RemoteAddr returns the address of the wrapped connection if it has one.
+func (c *Conn) RemoteAddr() net.Addr {
+	if conn, ok := c.conn.(interface{ RemoteAddr() net.Addr }); ok {
+		return conn.RemoteAddr()
+	}
+
+	return nil
+}
+
+func (c *Conn) abort() {
+	_ = c.Close()
+}
+
+func (c *Conn) happens(rnd *rand.Rand, rate float64) bool {
+	return rate > 0 && rnd.Float64() < rate
+}
+
+// damage drops and corrupts received bytes in place and returns the number of bytes left.
+func (c *Conn) damage(p []byte) int {
+	if c.profile.DropRate == 0 && c.profile.CorruptRate == 0 {
+		return len(p)
+	}
+
+	n := 0
+	for _, b := range p {
+		if c.happens(c.readRnd, c.profile.DropRate) {
+			continue
+		}
+		if c.happens(c.readRnd, c.profile.CorruptRate) {
+			b ^= 1 << c.readRnd.Intn(8)
+		}
+		p[n] = b
+		n++
+	}
+
+	return n
+}
+
+// fragment splits data into random parts.
+func (c *Conn) fragment(p []byte) [][]byte {
+	count := 2 + c.writeRnd.Intn(maxFragments-1)
+	if count > len(p) {
+		count = len(p)
+	}
+
+	// pick count-1 distinct cut points
+	cuts := c.writeRnd.Perm(len(p) - 1)[:count-1]
+	marks := make([]bool, len(p))
+	for _, cut := range cuts {
+		marks[cut+1] = true
+	}
+
+	fragments := make([][]byte, 0, count)
+	start := 0
+	for i := 1; i < len(p); i++ {
+		if marks[i] {
+			fragments = append(fragments, p[start:i])
+			start = i
+		}
+	}
+
+	return append(fragments, p[start:])
+}
+
+// delay simulates latency and bandwidth limit for n bytes.
+func (c *Conn) delay(rnd *rand.Rand, n int) {
+	d := c.profile.Latency
+	if c.profile.Jitter > 0 {
+		d += time.Duration(rnd.Int63n(int64(c.profile.Jitter)))
+	}
+	if c.profile.Bandwidth > 0 {
+		d += time.Duration(n) * time.Second / time.Duration(c.profile.Bandwidth)
+	}
+	if d <= 0 {
+		return
+	}
+
+	select {
+	case <-c.closed:
+	default:
+		c.sleep(d)
+	}
+}
@@ -0,0 +1,165 @@
+package chaos
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeConn reads the data and records every write separately.
+type fakeConn struct {
+	*bytes.Reader
+	writes [][]byte
+	closed bool
+}
+
+func newFakeConn(data []byte) *fakeConn {
+	return &fakeConn{Reader: bytes.NewReader(data)}
+}
+
+func (c *fakeConn) Write(p []byte) (int, error) {
+	c.writes = append(c.writes, append([]byte(nil), p...))
+	return len(p), nil
+}
+
+func (c *fakeConn) Close() error {
+	c.closed = true
+	return nil
+}
+
+func testData() []byte {
+	data := make([]byte, 1000)
+	for i := range data {
+		data[i] = byte(i)
+	}
+
+	return data
+}
+
+func TestConn_Read(t *testing.T) {
+	data := testData()
+
+	testCases := []struct {
+		name    string
+		profile Profile
+		check   func(t *testing.T, got []byte)
+	}{
+		{
+			name:    "success/no_faults",
+			profile: Profile{},
+			check: func(t *testing.T, got []byte) {
+				assert.Equal(t, data, got)
+			},
+		},
+		{
+			name:    "success/corrupt",
+			profile: Profile{CorruptRate: 1},
+			check: func(t *testing.T, got []byte) {
+				require.Len(t, got, len(data))
+				for i := range got {
+					// exactly one bit is flipped
+					diff := got[i] ^ data[i]
+					assert.NotZero(t, diff)
+					assert.Zero(t, diff&(diff-1))
+				}
+			},
+		},
+		{
+			name:    "success/drop",
+			profile: Profile{DropRate: 0.5},
+			check: func(t *testing.T, got []byte) {
+				assert.Less(t, len(got), len(data))
+				assert.NotEmpty(t, got)
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := io.ReadAll(Wrap(newFakeConn(data), tc.profile, 1))
+			require.NoError(t, err)
+			tc.check(t, got)
+
+			// the same seed gives the same faults
+			again, err := io.ReadAll(Wrap(newFakeConn(data), tc.profile, 1))
+			require.NoError(t, err)
+			assert.Equal(t, got, again)
+		})
+	}
+}
+
+func TestConn_Write(t *testing.T) {
+	data := testData()
+
+	testCases := []struct {
+		name         string
+		profile      Profile
+		expFragments bool
+		hasErr       bool
+		expErr       error
+	}{
+		{
+			name:    "success",
+			profile: Profile{},
+		},
+		{
+			name:         "success/fragmented",
+			profile:      Profile{FragmentRate: 1},
+			expFragments: true,
+		},
+		{
+			name:    "err/closed",
+			profile: Profile{CloseRate: 1},
+			hasErr:  true,
+			expErr:  ErrInjectedClose,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			raw := newFakeConn(nil)
+			n, err := Wrap(raw, tc.profile, 1).Write(data)
+			written := bytes.Join(raw.writes, nil)
+			assert.Equal(t, n, len(written))
+			assert.Equal(t, data[:n], written)
+
+			if tc.hasErr {
+				assert.ErrorIs(t, err, tc.expErr)
+				assert.True(t, raw.closed)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, len(data), n)
+			assert.Equal(t, tc.expFragments, len(raw.writes) > 1)
+		})
+	}
+}
+
+func TestConn_Delay(t *testing.T) {
+	var delays []time.Duration
+	c := Wrap(newFakeConn(nil), Profile{Latency: 100 * time.Millisecond, Bandwidth: 1000}, 1)
+	c.sleep = func(d time.Duration) {
+		delays = append(delays, d)
+	}
+
+	_, err := c.Write(make([]byte, 500))
+	require.NoError(t, err)
+	assert.Equal(t, []time.Duration{600 * time.Millisecond}, delays)
+}
+
+func TestParseProfile(t *testing.T) {
+	profile, err := ParseProfile("Satellite")
+	require.NoError(t, err)
+	assert.Equal(t, Profiles["satellite"], profile)
+
+	_, err = ParseProfile("dialup")
+	assert.Error(t, err)
+}
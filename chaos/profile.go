@@ -0,0 +1,78 @@
+package chaos
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Profile describes faults injected into the connection. Zero value injects nothing.
+type Profile struct {
+	// Latency is added to every read and write, with uniformly distributed Jitter on top of it.
+	Latency time.Duration
+	Jitter  time.Duration
+	// Bandwidth limits bytes per second in each direction, 0 means no limit.
+	Bandwidth int
+	// FragmentRate is the probability that a write is split into several smaller writes with delays between them,
+	// so the other side gets the message in parts.
+	FragmentRate float64
+	// CorruptRate is the probability that a received byte has one bit flipped.
+	CorruptRate float64
+	// DropRate is the probability that a received byte is lost.
+	DropRate float64
+	// CloseRate is the probability that the connection is closed abruptly on a read or write.
+	// Writes are closed after a random part of the data is sent, so the other side gets an incomplete message.
+	CloseRate float64
+}
+
+// Profiles are predefined profiles which can be selected by name, e.g. from the command line.
+var Profiles = map[string]Profile{
+	// mobile is a 3G link on the move: high jitter, small segments and occasional drops of the connection
+	"mobile": {
+		Latency:      150 * time.Millisecond,
+		Jitter:       250 * time.Millisecond,
+		Bandwidth:    128 * 1024,
+		FragmentRate: 0.3,
+		CloseRate:    0.005,
+	},
+	// satellite is a geostationary link: long but stable round trip and low bandwidth
+	"satellite": {
+		Latency:      600 * time.Millisecond,
+		Jitter:       50 * time.Millisecond,
+		Bandwidth:    32 * 1024,
+		FragmentRate: 0.1,
+		CloseRate:    0.001,
+	},
+	// lossy is a link with broken hardware on the way, the data is damaged but the connection stays
+	"lossy": {
+		Latency:     20 * time.Millisecond,
+		CorruptRate: 0.0001,
+		DropRate:    0.0001,
+	},
+	// flaky is a connection which is lost very often
+	"flaky": {
+		Latency:   20 * time.Millisecond,
+		CloseRate: 0.05,
+	},
+}
+
+// ParseProfile returns predefined profile by its name.
+func ParseProfile(name string) (Profile, error) {
+	profile, ok := Profiles[strings.ToLower(name)]
+	if !ok {
+		return Profile{}, fmt.Errorf("unknown chaos profile %q, available: %s", name, strings.Join(ProfileNames(), ", "))
+	}
+
+	return profile, nil
+}
+
+func ProfileNames() []string {
+	names := make([]string, 0, len(Profiles))
+	for name := range Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
@@ -6,6 +6,10 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"math/rand"
+	"net"
+	"strconv"
+	"sync"
 	"time"
 
 	log "github.com/sirupsen/logrus"
@@ -15,6 +19,7 @@ import (
 )
 
 type BitcoinClient struct {
+	mu   sync.RWMutex
 	conn Connection
 
 	nodeHost string
@@ -23,50 +28,271 @@ type BitcoinClient struct {
 	connectionFn func(host string, port int) (Connection, error)
 
 	isConnected bool
+	lastDial    model.DialTiming
+
+	reconnectPolicy  ReconnectPolicy
+	failedReconnects int
+	nextReconnectAt  time.Time
+	gaveUp           bool
+	giveUpErr        error
+	random           func() float64
+
+	eventHandler func(event model.ConnectionEvent)
+
+	recorder Recorder
+	metrics  Metrics
+	sent     *frameSplitter
+}
+
+type Option func(c *BitcoinClient)
+
+// WithReconnectPolicy overrides DefaultReconnectPolicy.
+func WithReconnectPolicy(policy ReconnectPolicy) Option {
+	return func(c *BitcoinClient) {
+		c.reconnectPolicy = policy
+	}
+}
+
+// WithEventHandler sets the handler of connection state changes. It's called synchronously from the receiving
+// goroutine, so it must not block.
+func WithEventHandler(handler func(event model.ConnectionEvent)) Option {
+	return func(c *BitcoinClient) {
+		c.eventHandler = handler
+	}
+}
+
+// WithRecorder makes the client pass every message sent and received to the recorder.
+func WithRecorder(recorder Recorder) Option {
+	return func(c *BitcoinClient) {
+		c.recorder = recorder
+	}
+}
+
+// WithMetrics makes the client report the message traffic and the errors of received messages to metrics.
+func WithMetrics(metrics Metrics) Option {
+	return func(c *BitcoinClient) {
+		c.metrics = metrics
+	}
 }
 
 func NewBitcoinClient(host string, port int,
-	connectionFn func(host string, port int) (Connection, error)) (*BitcoinClient, error) {
+	connectionFn func(host string, port int) (Connection, error), opts ...Option) (*BitcoinClient, error) {
 	b := &BitcoinClient{
-		nodeHost:     host,
-		nodePort:     port,
-		connectionFn: connectionFn,
+		nodeHost:        host,
+		nodePort:        port,
+		connectionFn:    connectionFn,
+		reconnectPolicy: DefaultReconnectPolicy(),
+		random:          rand.Float64,
+		sent:            &frameSplitter{},
+	}
+
+	for _, opt := range opts {
+		opt(b)
 	}
 
 	if err := b.connect(); err != nil {
 		return nil, err
 	}
+	b.emit(model.ConnectionStateConnected, 0, nil)
 
 	return b, nil
 }
 
 func (c *BitcoinClient) connect() error {
 	log.Debug("connecting to node...")
-	c.isConnected = false
-	conn, err := c.connectionFn(c.nodeHost, c.nodePort)
+	c.setConnected(false)
+	dialStart := time.Now()
+	conn, err := c.connectionFn(c.GetNodeHost(), c.GetNodePort())
 	if err != nil {
 		return fmt.Errorf("failed to connect: %w", err)
 	}
 
+	c.mu.Lock()
 	c.conn = conn
 	c.isConnected = true
+	c.lastDial = model.DialTiming{Start: dialStart, Duration: time.Since(dialStart)}
+	// the message which was written partially to the old connection is lost
+	c.sent.reset()
+
+	// connection function may pick the node by itself, e.g. from DNS seeds, so remember which one we are talking to
+	if c.nodeHost == "" {
+		c.setNodeFromRemoteAddr(conn)
+	}
+	c.mu.Unlock()
 
 	return nil
 }
 
+// reconnect makes the next reconnect attempt if it's time for it according to the reconnect policy.
+func (c *BitcoinClient) reconnect() {
+	now := time.Now()
+	c.mu.RLock()
+	nextReconnectAt := c.nextReconnectAt
+	c.mu.RUnlock()
+	if c.gaveUp || now.Before(nextReconnectAt) {
+		return
+	}
+
+	attempt := c.failedReconnects + 1
+	c.emit(model.ConnectionStateReconnecting, attempt, nil)
+
+	err := c.connect()
+	if c.metrics != nil {
+		c.metrics.Reconnect(err == nil)
+	}
+	if err == nil {
+		log.Infof("reconnected to node after %d attempt(s)", attempt)
+		c.failedReconnects = 0
+		c.emit(model.ConnectionStateConnected, attempt, nil)
+		return
+	}
+	c.failedReconnects++
+
+	// the ban doesn't expire between attempts, so there is no reason to retry
+	if c.reconnectPolicy.exhausted(c.failedReconnects) || errors.Is(err, model.ErrPeerBanned) {
+		err = fmt.Errorf("%w after %d attempt(s): %w", model.ErrReconnectGaveUp, c.failedReconnects, err)
+		log.Error(err)
+		c.mu.Lock()
+		c.giveUpErr = err
+		c.mu.Unlock()
+		c.gaveUp = true
+		c.emit(model.ConnectionStateGaveUp, attempt, err)
+		return
+	}
+
+	delay := c.reconnectPolicy.Delay(c.failedReconnects, c.random)
+	c.mu.Lock()
+	c.nextReconnectAt = now.Add(delay)
+	c.mu.Unlock()
+	log.Warnf("err while reconnecting to node, attempt %d, next attempt in %s: %v", attempt, delay, err)
+}
+
+// Disconnect closes the connection, e.g. to the misbehaving node. The client will reconnect according
+// to the reconnect policy, unless the dial refuses the node.
+func (c *BitcoinClient) Disconnect(reason error) {
+	c.disconnect(reason)
+}
+
+// disconnect closes broken connection, the client will reconnect on the next receive.
+func (c *BitcoinClient) disconnect(reason error) {
+	c.mu.Lock()
+	if !c.isConnected {
+		c.mu.Unlock()
+		return
+	}
+	c.isConnected = false
+	conn := c.conn
+	c.nextReconnectAt = time.Now().Add(c.reconnectPolicy.Delay(0, c.random))
+	c.mu.Unlock()
+
+	if err := conn.Close(); err != nil {
+		log.Debugf("err while closing connection to node: %v", err)
+	}
+
+	log.Warnf("connection to node is lost: %v", reason)
+	c.emit(model.ConnectionStateDisconnected, 0, reason)
+}
+
+func (c *BitcoinClient) emit(state model.ConnectionState, attempt int, err error) {
+	if c.eventHandler == nil {
+		return
+	}
+
+	c.eventHandler(model.ConnectionEvent{
+		State:   state,
+		Attempt: attempt,
+		Err:     err,
+		Time:    time.Now(),
+	})
+}
+
+func (c *BitcoinClient) setConnected(connected bool) {
+	c.mu.Lock()
+	c.isConnected = connected
+	c.mu.Unlock()
+}
+
+func (c *BitcoinClient) connected() (Connection, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.conn, c.isConnected
+}
+
+func (c *BitcoinClient) setNodeFromRemoteAddr(conn Connection) {
+	remoteConn, ok := conn.(interface{ RemoteAddr() net.Addr })
+	if !ok || remoteConn.RemoteAddr() == nil {
+		return
+	}
+
+	host, portStr, err := net.SplitHostPort(remoteConn.RemoteAddr().String())
+	if err != nil {
+		return
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return
+	}
+
+	c.nodeHost, c.nodePort = host, port
+}
+
 func (c *BitcoinClient) GetNodeHost() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
 	return c.nodeHost
 }
 
 func (c *BitcoinClient) GetNodePort() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
 	return c.nodePort
 }
 
+// LastDial returns when the current connection was dialed and how long it took.
+func (c *BitcoinClient) LastDial() model.DialTiming {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.lastDial
+}
+
+// Write sends the message to the node. It fails with model.ErrReconnectGaveUp once the client gave up reconnecting.
 func (c *BitcoinClient) Write(msg []byte) (n int, err error) {
-	if !c.isConnected {
+	conn, ok := c.connected()
+	if !ok {
+		c.mu.RLock()
+		giveUpErr := c.giveUpErr
+		c.mu.RUnlock()
+		if giveUpErr != nil {
+			return 0, giveUpErr
+		}
 		return 0, model.ErrConnectionClosed
 	}
-	return c.conn.Write(msg)
+
+	n, err = conn.Write(msg)
+	if c.recorder != nil || c.metrics != nil {
+		c.sent.feed(msg[:n], c.onSent)
+	}
+
+	return n, err
+}
+
+func (c *BitcoinClient) onSent(header model.MessageHeader, payload []byte) {
+	if c.recorder != nil {
+		c.recorder.RecordSent(header, payload)
+	}
+	if c.metrics != nil {
+		c.metrics.MessageSent(header.Command, headerSize+len(payload))
+	}
+}
+
+func (c *BitcoinClient) decodeError(err error) {
+	if c.metrics != nil {
+		c.metrics.DecodeError(err)
+	}
 }
 
 func (c *BitcoinClient) ReceiveMsg(
@@ -75,16 +301,24 @@ func (c *BitcoinClient) ReceiveMsg(
 	payloadReadFn func(reader *bytes.Reader, header model.MessageHeader) (any, error),
 	receiveCh chan model.MessageFromNode,
 ) {
+	ticker := time.NewTicker(time.Millisecond)
+	defer ticker.Stop()
+
 	for {
 		select {
 		case <-ctx.Done():
 			log.Warn("stopping receiving thread by context done")
-			if err := c.conn.Close(); err != nil {
+			conn, _ := c.connected()
+			if err := conn.Close(); err != nil {
 				log.Warnf("err while closing connection to node: %v", err)
 			}
-			c.isConnected = false
+			c.setConnected(false)
 			return
-		case <-time.NewTicker(time.Millisecond).C:
+		case <-ticker.C:
+			if c.gaveUp {
+				log.Warn("stopping receiving thread as reconnecting to node failed")
+				return
+			}
 			c.receive(headerReadFn, payloadReadFn, receiveCh)
 		}
 	}
@@ -95,53 +329,77 @@ func (c *BitcoinClient) receive(
 	payloadReadFn func(reader *bytes.Reader, header model.MessageHeader) (any, error),
 	receiveCh chan model.MessageFromNode,
 ) {
-	if !c.isConnected {
-		if err := c.connect(); err != nil {
-			log.Errorf("err while reconnectiong to blockchain node: %v", err)
-		}
+	conn, ok := c.connected()
+	if !ok {
+		c.reconnect()
 		return
 	}
 
 	// read header to determine message type and payload size
 	var headerBytes [24]byte
-	_, err := io.ReadFull(c.conn, headerBytes[:])
+	_, err := io.ReadFull(conn, headerBytes[:])
 	if err != nil {
 		if errors.Is(err, io.EOF) {
-			c.isConnected = false
 			log.Debug("got EOF")
 		}
+		c.disconnect(err)
 		return
 	}
 	hr := bytes.NewReader(headerBytes[:])
 	hdr, err := headerReadFn(hr)
 	if err != nil {
 		log.Warnf("err while parsing msg header: %v", err)
+		c.decodeError(err)
 		return
 	}
 
 	// validate magic number
 	if hdr.Magic != model.TestNetMagic {
 		log.Warnf("got mesage with invalid magic number")
+		c.decodeError(model.ErrInvalidMagicNumber)
 		receiveCh <- model.MessageFromNode{
 			Error: &model.ErrInvalidMagicNumber,
 		}
 		return
 	}
 
-	payloadBytes := make([]byte, hdr.Length)
-	_, err = io.ReadFull(c.conn, payloadBytes[:])
-	plr := bytes.NewReader(payloadBytes[:])
+	// the rest of the stream can't be trusted, we don't know where the next message starts
+	if hdr.Length > model.MaxPayloadSize {
+		log.Warnf("got %s message with too large payload: %d", hdr.Command, hdr.Length)
+		c.decodeError(model.ErrPayloadTooLarge)
+		receiveCh <- model.MessageFromNode{
+			Header: hdr,
+			Error:  &model.ErrPayloadTooLarge,
+		}
+		c.disconnect(model.ErrPayloadTooLarge)
+		return
+	}
+
+	payloadBytes, err := readPayload(conn, hdr.Length)
+	receivedAt := time.Now()
+	plr := bytes.NewReader(payloadBytes)
 	if err != nil {
 		log.Warnf("err while reading msg payload from the connection: %v", err)
+		// the rest of the message is lost, so we can't find where the next one starts
+		c.disconnect(err)
 		return
 	}
 
+	if c.recorder != nil {
+		c.recorder.RecordReceived(hdr, payloadBytes)
+	}
+	if c.metrics != nil {
+		c.metrics.MessageReceived(hdr.Command, headerSize+len(payloadBytes))
+	}
+
 	// check if checksum is valid
 	actualChecksum := utils.DoubleHashB(payloadBytes)[0:4]
 	if !bytes.Equal(hdr.Checksum[:], actualChecksum) {
 		log.Warnf("got mesage with invalid checksum")
+		c.decodeError(model.ErrInvalidMessageChecksum)
 		receiveCh <- model.MessageFromNode{
-			Error: &model.ErrInvalidMessageChecksum,
+			Header: hdr,
+			Error:  &model.ErrInvalidMessageChecksum,
 		}
 		return
 	}
@@ -149,12 +407,36 @@ func (c *BitcoinClient) receive(
 	msg, err := payloadReadFn(plr, hdr)
 	if err != nil {
 		log.Warnf("err while parsing msg payload: %v. Skipping", err)
+		c.decodeError(err)
+		// the node may be scored for the malformed message, the unknown ones are fine
+		if !errors.Is(err, model.ErrUnknownCommand) {
+			receiveCh <- model.MessageFromNode{
+				Header: hdr,
+				Error:  &err,
+			}
+		}
 		return
 	}
 
 	log.Debug("sending read message from node to processing")
 	receiveCh <- model.MessageFromNode{
-		Header:  hdr,
-		Payload: msg,
+		Header:     hdr,
+		Payload:    msg,
+		ReceivedAt: receivedAt,
 	}
 }
+
+// readPayload reads the payload growing the buffer while the data comes, so the length from the header
+// can't make us allocate memory for the data which is never sent.
+func readPayload(r io.Reader, length uint32) ([]byte, error) {
+	var buf bytes.Buffer
+	n, err := buf.ReadFrom(io.LimitReader(r, int64(length)))
+	if err != nil {
+		return nil, err
+	}
+	if n < int64(length) {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	return buf.Bytes(), nil
+}
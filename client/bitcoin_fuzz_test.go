@@ -0,0 +1,88 @@
+package client
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/senseyman/bitcoin-handshake/model"
+	"github.com/senseyman/bitcoin-handshake/nodetest"
+	"github.com/senseyman/bitcoin-handshake/service"
+)
+
+// streamConn replays the bytes as if they were received from the node.
+type streamConn struct {
+	*bytes.Reader
+}
+
+func (streamConn) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+func (streamConn) Close() error {
+	return nil
+}
+
+func FuzzBitcoinClient_receive(f *testing.F) {
+	var handshake []byte
+	for _, command := range nodetest.HandshakeSequence {
+		frame, ok := nodetest.CapturedFrame(command)
+		require.True(f, ok)
+		f.Add(frame)
+		handshake = append(handshake, frame...)
+	}
+	f.Add(handshake)
+	// header claiming payload larger than allowed
+	tooLarge := nodetest.EncodeFrame(model.TestNetMagic, "block", nil)
+	binary.LittleEndian.PutUint32(tooLarge[16:20], model.MaxPayloadSize+1)
+	f.Add(tooLarge)
+
+	decoder := service.NewDecodeService()
+	headerReadFn := func(r *bytes.Reader) (model.MessageHeader, error) {
+		var (
+			hdr     model.MessageHeader
+			command [model.CommandSize]byte
+		)
+		err := decoder.DecodeElements(r, &hdr.Magic, &command, &hdr.Length, &hdr.Checksum)
+		hdr.Command = string(bytes.TrimRight(command[:], "\x00"))
+		return hdr, err
+	}
+	payloadReadFn := func(r *bytes.Reader, _ model.MessageHeader) (any, error) {
+		return r.Len(), nil
+	}
+
+	f.Fuzz(func(t *testing.T, stream []byte) {
+		connected := false
+		c, err := NewBitcoinClient("127.0.0.1", 18333, func(string, int) (Connection, error) {
+			if connected {
+				return nil, errors.New("stream is over")
+			}
+			connected = true
+			return streamConn{bytes.NewReader(stream)}, nil
+		}, WithReconnectPolicy(ReconnectPolicy{MaxAttempts: 1}))
+		require.NoError(t, err)
+
+		// every message takes at least a header, so the channel can't overflow
+		receiveCh := make(chan model.MessageFromNode, len(stream)/24+1)
+		for {
+			if _, ok := c.connected(); !ok {
+				break
+			}
+			c.receive(headerReadFn, payloadReadFn, receiveCh)
+		}
+		close(receiveCh)
+
+		for msg := range receiveCh {
+			if msg.Error != nil {
+				continue
+			}
+			assert.Equal(t, uint32(model.TestNetMagic), msg.Header.Magic)
+			assert.LessOrEqual(t, msg.Header.Length, uint32(model.MaxPayloadSize))
+			assert.Equal(t, int(msg.Header.Length), msg.Payload)
+		}
+	})
+}
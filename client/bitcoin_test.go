@@ -1,13 +1,20 @@
 package client
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"net"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"go.uber.org/mock/gomock"
 
 	"github.com/senseyman/bitcoin-handshake/client/mock"
+	"github.com/senseyman/bitcoin-handshake/model"
 )
 
 func TestNewBitcoinClient(t *testing.T) {
@@ -138,3 +145,145 @@ func TestBitcoinClient_Write(t *testing.T) {
 		})
 	}
 }
+
+func TestNewBitcoinClient_NodeFromConnection(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+
+	fn := func(host string, port int) (Connection, error) {
+		return net.Dial("tcp", l.Addr().String())
+	}
+
+	c, err := NewBitcoinClient("", 0, fn)
+	require.NoError(t, err)
+
+	assert.Equal(t, "127.0.0.1", c.GetNodeHost())
+	assert.Equal(t, l.Addr().(*net.TCPAddr).Port, c.GetNodePort())
+}
+
+func TestBitcoinClient_Reconnect(t *testing.T) {
+	testCases := []struct {
+		name       string
+		failDials  int
+		dialErr    error
+		expStates  []model.ConnectionState
+		expAttempt int
+		expGaveUp  bool
+	}{
+		{
+			name:       "success",
+			failDials:  1,
+			expAttempt: 2,
+			expStates: []model.ConnectionState{
+				model.ConnectionStateConnected,
+				model.ConnectionStateDisconnected,
+				model.ConnectionStateReconnecting,
+				model.ConnectionStateReconnecting,
+				model.ConnectionStateConnected,
+			},
+		},
+		{
+			name:      "err/gave_up",
+			failDials: 3,
+			expStates: []model.ConnectionState{
+				model.ConnectionStateConnected,
+				model.ConnectionStateDisconnected,
+				model.ConnectionStateReconnecting,
+				model.ConnectionStateReconnecting,
+				model.ConnectionStateReconnecting,
+				model.ConnectionStateGaveUp,
+			},
+			expAttempt: 3,
+			expGaveUp:  true,
+		},
+		{
+			name:      "err/banned",
+			failDials: 3,
+			dialErr:   fmt.Errorf("%w: 127.0.0.1", model.ErrPeerBanned),
+			expStates: []model.ConnectionState{
+				model.ConnectionStateConnected,
+				model.ConnectionStateDisconnected,
+				model.ConnectionStateReconnecting,
+				model.ConnectionStateGaveUp,
+			},
+			expAttempt: 1,
+			expGaveUp:  true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			var (
+				mu      sync.Mutex
+				events  []model.ConnectionEvent
+				dials   int
+				remotes []net.Conn
+			)
+
+			fn := func(host string, port int) (Connection, error) {
+				dials++
+				// the first connection is dropped by the node, then it's unreachable for some time
+				if dials > 1 && dials <= tc.failDials+1 {
+					if tc.dialErr != nil {
+						return nil, tc.dialErr
+					}
+					return nil, errors.New("connection refused")
+				}
+				local, remote := net.Pipe()
+				if dials == 1 {
+					_ = remote.Close()
+				}
+				remotes = append(remotes, remote)
+				return local, nil
+			}
+			policy := ReconnectPolicy{
+				InitialDelay: time.Millisecond,
+				MaxDelay:     10 * time.Millisecond,
+				Multiplier:   2,
+				MaxAttempts:  3,
+			}
+			handler := func(event model.ConnectionEvent) {
+				mu.Lock()
+				events = append(events, event)
+				mu.Unlock()
+			}
+
+			c, err := NewBitcoinClient("127.0.0.1", 8333, fn, WithReconnectPolicy(policy), WithEventHandler(handler))
+			require.NoError(t, err)
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			go c.ReceiveMsg(ctx, nil, nil, nil)
+
+			assert.Eventually(t, func() bool {
+				mu.Lock()
+				defer mu.Unlock()
+				return len(events) == len(tc.expStates)
+			}, time.Second, time.Millisecond)
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			var states []model.ConnectionState
+			for _, event := range events {
+				states = append(states, event.State)
+			}
+			assert.Equal(t, tc.expStates, states)
+			assert.Equal(t, tc.expAttempt, events[len(events)-1].Attempt)
+			if tc.expGaveUp {
+				require.ErrorIs(t, events[len(events)-1].Err, model.ErrReconnectGaveUp)
+				if tc.dialErr != nil {
+					require.ErrorIs(t, events[len(events)-1].Err, tc.dialErr)
+				}
+				_, err := c.Write([]byte{0})
+				assert.ErrorIs(t, err, model.ErrReconnectGaveUp)
+			}
+			for _, remote := range remotes {
+				_ = remote.Close()
+			}
+		})
+	}
+}
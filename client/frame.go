@@ -0,0 +1,51 @@
+package client
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sync"
+
+	"github.com/senseyman/bitcoin-handshake/model"
+)
+
+const (
+	headerSize = model.MagicSize + model.CommandSize + model.LengthSize + model.ChecksumSize
+)
+
+// frameSplitter collects written bytes into messages, as a message may be written by several writes.
+type frameSplitter struct {
+	mu  sync.Mutex
+	buf []byte
+}
+
+// feed adds written bytes and calls fn for every completed message.
+func (s *frameSplitter) feed(b []byte, fn func(header model.MessageHeader, payload []byte)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.buf = append(s.buf, b...)
+	for len(s.buf) >= headerSize {
+		hdr := model.MessageHeader{
+			Magic:   binary.LittleEndian.Uint32(s.buf[:4]),
+			Command: string(bytes.TrimRight(s.buf[4:16], "\x00")),
+			Length:  binary.LittleEndian.Uint32(s.buf[16:20]),
+		}
+		copy(hdr.Checksum[:], s.buf[20:headerSize])
+
+		frameSize := headerSize + int(hdr.Length)
+		if len(s.buf) < frameSize {
+			return
+		}
+
+		payload := append([]byte(nil), s.buf[headerSize:frameSize]...)
+		s.buf = s.buf[frameSize:]
+		fn(hdr, payload)
+	}
+}
+
+func (s *frameSplitter) reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.buf = nil
+}
@@ -0,0 +1,59 @@
+package client
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/senseyman/bitcoin-handshake/model"
+	"github.com/senseyman/bitcoin-handshake/nodetest"
+)
+
+func TestFrameSplitter_Feed(t *testing.T) {
+	version := nodetest.EncodeFrame(model.TestNetMagic, model.VersionCMD, []byte{1, 2, 3})
+	verack := nodetest.EncodeFrame(model.TestNetMagic, model.VerackCMD, nil)
+
+	testCases := []struct {
+		name        string
+		writes      [][]byte
+		expCommands []string
+	}{
+		{
+			name:        "success/header_and_payload",
+			writes:      [][]byte{version[:24], version[24:]},
+			expCommands: []string{model.VersionCMD},
+		},
+		{
+			name:        "success/several_in_one_write",
+			writes:      [][]byte{append(append([]byte(nil), version...), verack...)},
+			expCommands: []string{model.VersionCMD, model.VerackCMD},
+		},
+		{
+			name:        "success/split_header",
+			writes:      [][]byte{verack[:10], verack[10:], version[:25]},
+			expCommands: []string{model.VerackCMD},
+		},
+		{
+			name:   "success/incomplete",
+			writes: [][]byte{version[:26]},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			var (
+				s        frameSplitter
+				commands []string
+			)
+			for _, w := range tc.writes {
+				s.feed(w, func(header model.MessageHeader, payload []byte) {
+					assert.Equal(t, int(header.Length), len(payload))
+					commands = append(commands, header.Command)
+				})
+			}
+			assert.Equal(t, tc.expCommands, commands)
+		})
+	}
+}
@@ -2,6 +2,8 @@ package client
 
 import (
 	"io"
+
+	"github.com/senseyman/bitcoin-handshake/model"
 )
 
 type Connection interface {
@@ -9,3 +11,17 @@ type Connection interface {
 	io.Writer
 	io.Closer
 }
+
+// Recorder gets every message sent to and received from the node, e.g. to capture the session into a file.
+type Recorder interface {
+	RecordReceived(header model.MessageHeader, payload []byte)
+	RecordSent(header model.MessageHeader, payload []byte)
+}
+
+// Metrics gets the message traffic of the connection and the errors of received messages.
+type Metrics interface {
+	MessageReceived(command string, size int)
+	MessageSent(command string, size int)
+	DecodeError(err error)
+	Reconnect(success bool)
+}
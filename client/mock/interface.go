@@ -12,6 +12,7 @@ package mock
 import (
 	reflect "reflect"
 
+	model "github.com/senseyman/bitcoin-handshake/model"
 	gomock "go.uber.org/mock/gomock"
 )
 
@@ -81,3 +82,121 @@ func (mr *MockConnectionMockRecorder) Write(p any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Write", reflect.TypeOf((*MockConnection)(nil).Write), p)
 }
+
+// MockRecorder is a mock of Recorder interface.
+type MockRecorder struct {
+	ctrl     *gomock.Controller
+	recorder *MockRecorderMockRecorder
+}
+
+// MockRecorderMockRecorder is the mock recorder for MockRecorder.
+type MockRecorderMockRecorder struct {
+	mock *MockRecorder
+}
+
+// NewMockRecorder creates a new mock instance.
+func NewMockRecorder(ctrl *gomock.Controller) *MockRecorder {
+	mock := &MockRecorder{ctrl: ctrl}
+	mock.recorder = &MockRecorderMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockRecorder) EXPECT() *MockRecorderMockRecorder {
+	return m.recorder
+}
+
+// RecordReceived mocks base method.
+func (m *MockRecorder) RecordReceived(header model.MessageHeader, payload []byte) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "RecordReceived", header, payload)
+}
+
+// RecordReceived indicates an expected call of RecordReceived.
+func (mr *MockRecorderMockRecorder) RecordReceived(header, payload any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RecordReceived", reflect.TypeOf((*MockRecorder)(nil).RecordReceived), header, payload)
+}
+
+// RecordSent mocks base method.
+func (m *MockRecorder) RecordSent(header model.MessageHeader, payload []byte) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "RecordSent", header, payload)
+}
+
+// RecordSent indicates an expected call of RecordSent.
+func (mr *MockRecorderMockRecorder) RecordSent(header, payload any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RecordSent", reflect.TypeOf((*MockRecorder)(nil).RecordSent), header, payload)
+}
+
+// MockMetrics is a mock of Metrics interface.
+type MockMetrics struct {
+	ctrl     *gomock.Controller
+	recorder *MockMetricsMockRecorder
+}
+
+// MockMetricsMockRecorder is the mock recorder for MockMetrics.
+type MockMetricsMockRecorder struct {
+	mock *MockMetrics
+}
+
+// NewMockMetrics creates a new mock instance.
+func NewMockMetrics(ctrl *gomock.Controller) *MockMetrics {
+	mock := &MockMetrics{ctrl: ctrl}
+	mock.recorder = &MockMetricsMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockMetrics) EXPECT() *MockMetricsMockRecorder {
+	return m.recorder
+}
+
+// DecodeError mocks base method.
+func (m *MockMetrics) DecodeError(err error) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "DecodeError", err)
+}
+
+// DecodeError indicates an expected call of DecodeError.
+func (mr *MockMetricsMockRecorder) DecodeError(err any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DecodeError", reflect.TypeOf((*MockMetrics)(nil).DecodeError), err)
+}
+
+// MessageReceived mocks base method.
+func (m *MockMetrics) MessageReceived(command string, size int) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "MessageReceived", command, size)
+}
+
+// MessageReceived indicates an expected call of MessageReceived.
+func (mr *MockMetricsMockRecorder) MessageReceived(command, size any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "MessageReceived", reflect.TypeOf((*MockMetrics)(nil).MessageReceived), command, size)
+}
+
+// MessageSent mocks base method.
+func (m *MockMetrics) MessageSent(command string, size int) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "MessageSent", command, size)
+}
+
+// MessageSent indicates an expected call of MessageSent.
+func (mr *MockMetricsMockRecorder) MessageSent(command, size any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "MessageSent", reflect.TypeOf((*MockMetrics)(nil).MessageSent), command, size)
+}
+
+// Reconnect mocks base method.
+func (m *MockMetrics) Reconnect(success bool) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "Reconnect", success)
+}
+
+// Reconnect indicates an expected call of Reconnect.
+func (mr *MockMetricsMockRecorder) Reconnect(success any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Reconnect", reflect.TypeOf((*MockMetrics)(nil).Reconnect), success)
+}
@@ -0,0 +1,49 @@
+package client
+
+import (
+	"math"
+	"time"
+)
+
+// ReconnectPolicy defines how the client reconnects to the node after the connection is lost.
+// Delays grow exponentially from InitialDelay up to MaxDelay, and every delay is randomized by Jitter,
+// so many clients don't reconnect to the same node at the same moment.
+type ReconnectPolicy struct {
+	InitialDelay time.Duration
+	MaxDelay     time.Duration
+	Multiplier   float64
+	// Jitter is a fraction of the delay, the actual delay is in range [delay*(1-Jitter), delay*(1+Jitter)]
+	Jitter float64
+	// MaxAttempts is the number of failed reconnects after which the client gives up, zero means no limit. Then
+	// ConnectionStateGaveUp is emitted with the error wrapping model.ErrReconnectGaveUp.
+	MaxAttempts int
+}
+
+func DefaultReconnectPolicy() ReconnectPolicy {
+	return ReconnectPolicy{
+		InitialDelay: 500 * time.Millisecond,
+		MaxDelay:     time.Minute,
+		Multiplier:   2,
+		Jitter:       0.2,
+		MaxAttempts:  10,
+	}
+}
+
+// Delay returns the delay before the next attempt after given number of failed attempts.
+// random has to return values in range [0, 1).
+func (p ReconnectPolicy) Delay(failedAttempts int, random func() float64) time.Duration {
+	delay := float64(p.InitialDelay) * math.Pow(p.Multiplier, float64(failedAttempts))
+	if p.MaxDelay > 0 && delay > float64(p.MaxDelay) {
+		delay = float64(p.MaxDelay)
+	}
+
+	if p.Jitter > 0 {
+		delay *= 1 - p.Jitter + 2*p.Jitter*random()
+	}
+
+	return time.Duration(delay)
+}
+
+func (p ReconnectPolicy) exhausted(failedAttempts int) bool {
+	return p.MaxAttempts > 0 && failedAttempts >= p.MaxAttempts
+}
@@ -0,0 +1,70 @@
+package client
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReconnectPolicy_Delay(t *testing.T) {
+	policy := ReconnectPolicy{
+		InitialDelay: 100 * time.Millisecond,
+		MaxDelay:     time.Second,
+		Multiplier:   2,
+		Jitter:       0.5,
+	}
+
+	testCases := []struct {
+		name     string
+		attempts int
+		random   float64
+		expDelay time.Duration
+	}{
+		{
+			name:     "first/no_jitter",
+			attempts: 0,
+			random:   0.5,
+			expDelay: 100 * time.Millisecond,
+		},
+		{
+			name:     "third/no_jitter",
+			attempts: 2,
+			random:   0.5,
+			expDelay: 400 * time.Millisecond,
+		},
+		{
+			name:     "third/min_jitter",
+			attempts: 2,
+			random:   0,
+			expDelay: 200 * time.Millisecond,
+		},
+		{
+			name:     "third/max_jitter",
+			attempts: 2,
+			random:   1,
+			expDelay: 600 * time.Millisecond,
+		},
+		{
+			name:     "capped",
+			attempts: 10,
+			random:   0.5,
+			expDelay: time.Second,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			delay := policy.Delay(tc.attempts, func() float64 { return tc.random })
+			assert.InDelta(t, float64(tc.expDelay), float64(delay), float64(time.Microsecond))
+		})
+	}
+}
+
+func TestReconnectPolicy_Exhausted(t *testing.T) {
+	assert.False(t, ReconnectPolicy{}.exhausted(100))
+	assert.False(t, ReconnectPolicy{MaxAttempts: 3}.exhausted(2))
+	assert.True(t, ReconnectPolicy{MaxAttempts: 3}.exhausted(3))
+}
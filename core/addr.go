@@ -0,0 +1,158 @@
+package core
+
+import (
+	"context"
+	"errors"
+	mrand "math/rand"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/senseyman/bitcoin-handshake/model"
+)
+
+// DefaultAdvertiseInterval is the average interval of the advertisement of our address, the same as in Bitcoin Core.
+const DefaultAdvertiseInterval = 24 * time.Hour
+
+// WithAddrSource makes core answer getaddr of the node with the addresses of the source, once per connection as
+// Bitcoin Core does. Without the source or the listening address of WithSelfAdvertisement getaddr is ignored.
+func WithAddrSource(source AddrSource) Option {
+	return func(c *Core) {
+		c.addrSource = source
+	}
+}
+
+// WithSelfAdvertisement makes core advertise our listening address to the node after every handshake and then
+// once per interval on average, zero interval advertises it after handshakes only. The intervals are random like
+// in Bitcoin Core, so they can't be used to link our connections.
+func WithSelfAdvertisement(addr model.NetAddressV2, interval time.Duration) Option {
+	return func(c *Core) {
+		c.localAddr = &addr
+		c.advertiseInterval = interval
+	}
+}
+
+func (c *Core) storeAddresses(msg model.MessageFromNode) {
+	if c.addrBook == nil {
+		return
+	}
+
+	var addrs []model.NetAddressV2
+	switch payload := msg.Payload.(type) {
+	case model.AddrMessage:
+		addrs = make([]model.NetAddressV2, 0, len(payload.AddrList))
+		for _, na := range payload.AddrList {
+			addrs = append(addrs, na.ToV2())
+		}
+	case model.AddrV2Message:
+		addrs = payload.AddrList
+	default:
+		return
+	}
+
+	// the node which relayed addresses is used as a source for bucketing, the node dialed by a hostname has no
+	// address, so its addresses share the group of unknown sources
+	source, err := model.NewNetAddressV2FromHost(
+		c.client.GetNodeHost(), uint16(c.client.GetNodePort()), 0, time.Now().Unix(),
+	)
+	if err != nil {
+		log.Debugf("storing addresses without source, node host isn't an address: %v", err)
+		source = model.NetAddressV2{}
+	}
+	added := c.addrBook.Add(addrs, source)
+	log.Infof("got %d addresses from node, %d of them are new", len(addrs), added)
+}
+
+// requestAddresses asks the node for addresses after the handshake like Bitcoin Core does, otherwise the address
+// book would get only the addresses the node relays on its own.
+func (c *Core) requestAddresses() {
+	if c.addrBook == nil {
+		return
+	}
+	if err := c.sendMessage(model.GetAddrCMD, nil); err != nil {
+		log.Errorf("err requesting addresses from node: %v", err)
+	}
+}
+
+// answerGetAddr sends the addresses to the node, the repeated getaddr of the same connection is ignored, so the
+// node can't scrape the address book. Like Bitcoin Core, which ignores getaddr on the outbound connections, it's
+// answered in listener mode only, otherwise the node could fingerprint us by our address book.
+func (c *Core) answerGetAddr() {
+	if c.addrSource == nil {
+		log.Debug("ignoring getaddr message, there is no address source")
+		return
+	}
+	if c.localAddr == nil {
+		log.Debug("ignoring getaddr message, not in listener mode")
+		return
+	}
+	if c.receivedTwice(&c.getAddrReceived) {
+		log.Info("ignoring repeated getaddr message")
+		return
+	}
+	network, ok := c.peerNetwork()
+	if !ok {
+		log.Info("ignoring getaddr message, the network of the node is unknown")
+		return
+	}
+
+	addrs := c.addrSource.Addresses(network)
+	if len(addrs) == 0 {
+		return
+	}
+	if err := c.SendAddresses(addrs); err != nil {
+		if errors.Is(err, errNoIPAddresses) {
+			log.Info("no addresses to answer getaddr with")
+			return
+		}
+		log.Errorf("err answering getaddr message: %v", err)
+		return
+	}
+	log.Infof("answered getaddr with %d addresses", len(addrs))
+}
+
+// peerNetwork is the network the node is connected over. It's unknown for the host which isn't an address, as
+// the name can be resolved by the proxy to any network, and the responses cached per network mustn't be mixed.
+func (c *Core) peerNetwork() (model.NetworkID, bool) {
+	addr, err := model.NewNetAddressV2FromHost(c.client.GetNodeHost(), 0, 0, 0)
+	if err != nil {
+		return 0, false
+	}
+
+	return addr.NetworkID, true
+}
+
+// advertiseSelf sends our listening address to the node with the services we currently provide.
+func (c *Core) advertiseSelf() {
+	if c.localAddr == nil {
+		return
+	}
+
+	addr := *c.localAddr
+	addr.Timestamp = time.Now().Unix()
+	addr.Services, _ = c.localServices()
+	if err := c.SendAddresses([]model.NetAddressV2{addr}); err != nil {
+		log.Warnf("err advertising our address %s: %v", addr, err)
+		return
+	}
+	log.Infof("advertised our address %s", addr)
+}
+
+// advertiseSelfLoop advertises our address at random intervals while the handshake is done.
+func (c *Core) advertiseSelfLoop(ctx context.Context) {
+	for {
+		timer := time.NewTimer(time.Duration(mrand.ExpFloat64() * float64(c.advertiseInterval))) //nolint:gosec // not used for security
+		select {
+		case <-timer.C:
+			c.mu.Lock()
+			done := c.handshakeDone
+			c.mu.Unlock()
+			if done {
+				c.advertiseSelf()
+			}
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		}
+	}
+}
@@ -0,0 +1,301 @@
+package core
+
+import (
+	"bytes"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/senseyman/bitcoin-handshake/model"
+	"github.com/senseyman/bitcoin-handshake/nodetest"
+	"github.com/senseyman/bitcoin-handshake/service"
+)
+
+type addrSourceStub struct {
+	addrs []model.NetAddressV2
+
+	mu       sync.Mutex
+	networks []model.NetworkID
+}
+
+func (s *addrSourceStub) Addresses(network model.NetworkID) []model.NetAddressV2 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.networks = append(s.networks, network)
+
+	return s.addrs
+}
+
+func (s *addrSourceStub) Networks() []model.NetworkID {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.networks
+}
+
+type addrBookStub struct {
+	mu      sync.Mutex
+	addrs   []model.NetAddressV2
+	sources []model.NetAddressV2
+}
+
+func (b *addrBookStub) Add(addrs []model.NetAddressV2, source model.NetAddressV2) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.addrs = append(b.addrs, addrs...)
+	b.sources = append(b.sources, source)
+
+	return len(addrs)
+}
+
+func (b *addrBookStub) Sources() []model.NetAddressV2 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.sources
+}
+
+func TestCore_AddrBook(t *testing.T) {
+	onionHost := "pg6mmjiyjmcrsslvykfwnntlaru7p5svn6y2ymmju6nubxndf4pscryd.onion"
+	onion, err := model.NewNetAddressV2FromHost(onionHost, 18333, 0, 0)
+	require.NoError(t, err)
+	payload, _ := nodetest.CapturedPayload(model.AddrCMD)
+
+	// the addresses are requested after the handshake and stored with the node as a source
+	requested := append(nodetest.Handshake(),
+		nodetest.Expect(model.GetAddrCMD),
+		nodetest.SendMessage(model.AddrCMD, payload),
+	)
+
+	testCases := []struct {
+		name      string
+		host      string
+		noBook    bool
+		steps     []nodetest.Step
+		expSource model.NetAddressV2
+	}{
+		{
+			name:      "ip",
+			host:      "8.8.4.4",
+			steps:     requested,
+			expSource: model.NewNetAddressV2FromIP(net.ParseIP("8.8.4.4"), 18333, 0, 0),
+		},
+		{
+			name:      "onion",
+			host:      onionHost,
+			steps:     requested,
+			expSource: onion,
+		},
+		{
+			// the name has no address, so there is no source
+			name:  "hostname",
+			host:  "node.example.com",
+			steps: requested,
+		},
+		{
+			name:   "no_book",
+			host:   "8.8.4.4",
+			noBook: true,
+			steps: append(nodetest.Handshake(),
+				nodetest.ExpectNothing(50*time.Millisecond),
+			),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			book := &addrBookStub{}
+			var opts []Option
+			if !tc.noBook {
+				opts = append(opts, WithAddrBook(book))
+			}
+			handshakeWithHost(t, tc.host, opts, tc.steps...)
+			if tc.noBook {
+				return
+			}
+
+			require.Eventually(t, func() bool { return len(book.Sources()) == 1 }, time.Second, time.Millisecond)
+			source := book.Sources()[0]
+			assert.Equal(t, tc.expSource.NetworkID, source.NetworkID)
+			assert.Equal(t, tc.expSource.Addr, source.Addr)
+			assert.Equal(t, tc.expSource.Port, source.Port)
+		})
+	}
+}
+
+func TestCore_GetAddr(t *testing.T) {
+	ipv4 := model.NewNetAddressV2FromIP(net.ParseIP("1.2.3.4"), 18333, model.ServiceNodeNetwork, 1700000000)
+	onion := model.NetAddressV2{
+		Timestamp: 1700000000, NetworkID: model.NetTorV3, Addr: bytes.Repeat([]byte{0x01}, 32), Port: 18333,
+	}
+	addrs := []model.NetAddressV2{ipv4, onion}
+	local := model.NewNetAddressV2FromIP(net.ParseIP("5.6.7.8"), 18333, 0, 0)
+
+	getAddrTwice := func(command string) []nodetest.Step {
+		return []nodetest.Step{
+			// our address is advertised after the handshake
+			nodetest.Expect(command),
+			nodetest.SendMessage(model.GetAddrCMD, nil),
+			nodetest.Expect(command),
+			// one response per connection
+			nodetest.SendMessage(model.GetAddrCMD, nil),
+			nodetest.ExpectNothing(50 * time.Millisecond),
+		}
+	}
+
+	testCases := []struct {
+		name        string
+		host        string
+		noSource    bool
+		noListener  bool
+		features    Features
+		steps       []nodetest.Step
+		expCommand  string
+		expAddrs    []model.NetAddressV2
+		expNetworks []model.NetworkID
+	}{
+		{
+			name:        "addr",
+			steps:       append(nodetest.Handshake(), getAddrTwice(model.AddrCMD)...),
+			expCommand:  model.AddrCMD,
+			expAddrs:    []model.NetAddressV2{ipv4},
+			expNetworks: []model.NetworkID{model.NetIPv4},
+		},
+		{
+			name:     "addrv2",
+			features: Features{AddrV2: true},
+			steps: append([]nodetest.Step{
+				nodetest.Expect(model.VersionCMD),
+				nodetest.SendVersion(nodetest.DefaultVersion()),
+				nodetest.SendMessage(model.SendAddrV2CMD, nil),
+				nodetest.SendVerack(),
+				nodetest.SkipUntil(model.VerackCMD),
+			}, getAddrTwice(model.AddrV2CMD)...),
+			expCommand:  model.AddrV2CMD,
+			expAddrs:    addrs,
+			expNetworks: []model.NetworkID{model.NetIPv4},
+		},
+		{
+			name:     "no_source",
+			noSource: true,
+			steps: append(nodetest.Handshake(),
+				nodetest.Expect(model.AddrCMD),
+				nodetest.SendMessage(model.GetAddrCMD, nil),
+				nodetest.ExpectNothing(50*time.Millisecond),
+			),
+		},
+		{
+			name:       "no_listener",
+			noListener: true,
+			steps: append(nodetest.Handshake(),
+				nodetest.SendMessage(model.GetAddrCMD, nil),
+				nodetest.ExpectNothing(50*time.Millisecond),
+			),
+		},
+		{
+			// the name can be resolved by the proxy to any network
+			name: "hostname",
+			host: "node.example.com",
+			steps: append(nodetest.Handshake(),
+				nodetest.Expect(model.AddrCMD),
+				nodetest.SendMessage(model.GetAddrCMD, nil),
+				nodetest.ExpectNothing(50*time.Millisecond),
+			),
+		},
+		{
+			name:     "onion",
+			host:     "pg6mmjiyjmcrsslvykfwnntlaru7p5svn6y2ymmju6nubxndf4pscryd.onion",
+			features: Features{AddrV2: true},
+			steps: append([]nodetest.Step{
+				nodetest.Expect(model.VersionCMD),
+				nodetest.SendVersion(nodetest.DefaultVersion()),
+				nodetest.SendMessage(model.SendAddrV2CMD, nil),
+				nodetest.SendVerack(),
+				nodetest.SkipUntil(model.VerackCMD),
+			}, getAddrTwice(model.AddrV2CMD)...),
+			expCommand:  model.AddrV2CMD,
+			expAddrs:    addrs,
+			expNetworks: []model.NetworkID{model.NetTorV3},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			source := &addrSourceStub{addrs: addrs}
+			opts := []Option{WithFeatures(tc.features)}
+			if !tc.noSource {
+				opts = append(opts, WithAddrSource(source))
+			}
+			if !tc.noListener {
+				opts = append(opts, WithSelfAdvertisement(local, 0))
+			}
+			host := tc.host
+			if host == "" {
+				host = "127.0.0.1"
+			}
+			_, peer := handshakeWithHost(t, host, opts, tc.steps...)
+
+			assert.Equal(t, tc.expNetworks, source.Networks())
+			if tc.expCommand == "" {
+				return
+			}
+
+			frame := lastFrame(t, peer, tc.expCommand)
+			decoder := service.NewDecodeService()
+			var got []model.NetAddressV2
+			if tc.expCommand == model.AddrV2CMD {
+				msg, err := decoder.DecodeAddrV2Message(bytes.NewReader(frame.Payload))
+				require.NoError(t, err)
+				got = msg.AddrList
+			} else {
+				msg, err := decoder.DecodeAddrMessage(bytes.NewReader(frame.Payload))
+				require.NoError(t, err)
+				for _, na := range msg.AddrList {
+					got = append(got, na.ToV2())
+				}
+			}
+			assert.Equal(t, tc.expAddrs, got)
+		})
+	}
+}
+
+func TestCore_SelfAdvertisement(t *testing.T) {
+	local := model.NewNetAddressV2FromIP(net.ParseIP("1.2.3.4"), 18333, 0, 0)
+	store, _ := servingStore(t)
+
+	_, peer := handshakeWithOptions(t, []Option{
+		WithSelfAdvertisement(local, 10*time.Millisecond),
+		WithBlockStore(store),
+	}, append(nodetest.Handshake(), nodetest.Expect(model.AddrCMD))...)
+
+	// after the handshake and then periodically
+	require.Eventually(t, func() bool {
+		count := 0
+		for _, cmd := range peer.Commands() {
+			if cmd == model.AddrCMD {
+				count++
+			}
+		}
+		return count >= 3
+	}, time.Second, time.Millisecond, "%v", peer.Commands())
+
+	msg, err := service.NewDecodeService().DecodeAddrMessage(bytes.NewReader(lastFrame(t, peer, model.AddrCMD).Payload))
+	require.NoError(t, err)
+	require.Len(t, msg.AddrList, 1)
+	got := msg.AddrList[0]
+	assert.Equal(t, "1.2.3.4", got.IP.String())
+	assert.Equal(t, uint16(18333), got.Port)
+	// the services we provide at the moment
+	assert.Equal(t, store.Services(), got.Services)
+	assert.InDelta(t, time.Now().Unix(), got.Timestamp, 5)
+}
@@ -0,0 +1,46 @@
+package core
+
+import (
+	"time"
+
+	"github.com/senseyman/bitcoin-handshake/model"
+)
+
+// maxAnnouncedHeaders is the most headers the node announces new blocks with, as in Bitcoin Core. The longer
+// headers messages are the responses to getheaders.
+const maxAnnouncedHeaders = 8
+
+// WithAnnouncementRecorder makes core pass the blocks and txs announced by the node with inv, headers and cmpctblock
+// messages to the recorder, with the time the messages were received.
+func WithAnnouncementRecorder(recorder AnnouncementRecorder) Option {
+	return func(c *Core) {
+		c.announcements = recorder
+	}
+}
+
+func (c *Core) recordAnnouncements(msg model.MessageFromNode) {
+	if c.announcements == nil {
+		return
+	}
+	at := msg.ReceivedAt
+	if at.IsZero() {
+		at = time.Now()
+	}
+	peer := c.peerAddress()
+
+	switch payload := msg.Payload.(type) {
+	case model.InvMessage:
+		for _, item := range payload.Inventory {
+			c.announcements.Announced(peer, item, at)
+		}
+	case model.HeadersMessage:
+		if len(payload.Headers) > maxAnnouncedHeaders {
+			return
+		}
+		for _, h := range payload.Headers {
+			c.announcements.Announced(peer, model.InvVect{Type: model.InvTypeBlock, Hash: h.Hash()}, at)
+		}
+	case model.CmpctBlockMessage:
+		c.announcements.Announced(peer, model.InvVect{Type: model.InvTypeBlock, Hash: payload.Header.Hash()}, at)
+	}
+}
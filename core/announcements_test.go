@@ -0,0 +1,104 @@
+package core
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/senseyman/bitcoin-handshake/model"
+	"github.com/senseyman/bitcoin-handshake/nodetest"
+	"github.com/senseyman/bitcoin-handshake/propagation"
+	"github.com/senseyman/bitcoin-handshake/service"
+)
+
+type announcementRecorderStub struct {
+	mu    sync.Mutex
+	peers []string
+	items []model.InvVect
+	times []time.Time
+}
+
+func (r *announcementRecorderStub) Announced(peer string, inv model.InvVect, at time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.peers = append(r.peers, peer)
+	r.items = append(r.items, inv)
+	r.times = append(r.times, at)
+}
+
+func TestCore_RecordAnnouncements(t *testing.T) {
+	var chain []model.BlockHeader
+	var prev [32]byte
+	for i := 0; i <= maxAnnouncedHeaders; i++ {
+		h := model.BlockHeader{Version: 4, PrevBlock: prev, Timestamp: 1700000000 + uint32(i)*600, Bits: 0x207fffff}
+		for !h.CheckProofOfWork() {
+			h.Nonce++
+		}
+		chain = append(chain, h)
+		prev = h.Hash()
+	}
+	headersPayload := func(headers ...model.BlockHeader) []byte {
+		var payload bytes.Buffer
+		require.NoError(t, service.NewEncodeService().EncodeHeadersMessage(&payload, model.HeadersMessage{Headers: headers}))
+		return payload.Bytes()
+	}
+	blockInv := func(h model.BlockHeader) model.InvVect {
+		return model.InvVect{Type: model.InvTypeBlock, Hash: h.Hash()}
+	}
+	tx := model.InvVect{Type: model.InvTypeWtx, Hash: [32]byte{1}}
+
+	recorder := &announcementRecorderStub{}
+	start := time.Now()
+	handshakeWithOptions(t, []Option{WithAnnouncementRecorder(recorder)}, append(nodetest.Handshake(),
+		nodetest.SendMessage(model.InvCMD, invPayload(t, blockInv(chain[1]), tx)),
+		nodetest.SendMessage(model.HeadersCMD, headersPayload(chain[2:4]...)),
+		// the response to getheaders isn't an announcement
+		nodetest.SendMessage(model.HeadersCMD, headersPayload(chain...)),
+		// the header is followed by the rest of the compact block
+		nodetest.SendMessage(model.CmpctBlockCMD, append(chain[4].Bytes(), 0x01, 0x02)),
+		nodetest.ExpectNothing(50*time.Millisecond),
+	)...)
+
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	assert.Equal(t, []model.InvVect{blockInv(chain[1]), tx, blockInv(chain[2]), blockInv(chain[3]), blockInv(chain[4])}, recorder.items)
+	for i, peer := range recorder.peers {
+		assert.Equal(t, "127.0.0.1:18333", peer)
+		assert.WithinDuration(t, start, recorder.times[i], time.Second)
+	}
+}
+
+func TestCore_RecordAnnouncements_CompactBlocks(t *testing.T) {
+	h := model.BlockHeader{Version: 4, Timestamp: 1700000000, Bits: 0x207fffff}
+	for !h.CheckProofOfWork() {
+		h.Nonce++
+	}
+	// the compact block of the header, the nonce, one short tx id and the prefilled coinbase
+	cmpctBlock := append(h.Bytes(), 1, 2, 3, 4, 5, 6, 7, 8)
+	cmpctBlock = append(cmpctBlock, 0x01, 0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff)
+	cmpctBlock = append(cmpctBlock, 0x01, 0x00, 0x02, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00)
+
+	recorder := propagation.New()
+	_, peer := handshakeWithOptions(t, []Option{
+		WithFeatures(Features{CompactBlocks: true}),
+		WithAnnouncementRecorder(recorder),
+	}, append(nodetest.Handshake(),
+		nodetest.Expect(model.SendCmpctCMD),
+		nodetest.SendMessage(model.CmpctBlockCMD, cmpctBlock),
+		nodetest.ExpectNothing(50*time.Millisecond),
+	)...)
+
+	// the high-bandwidth mode with the segwit version of compact blocks is asked for
+	assert.Equal(t, []byte{1, 2, 0, 0, 0, 0, 0, 0, 0}, lastFrame(t, peer, model.SendCmpctCMD).Payload)
+
+	report := recorder.Report()
+	assert.Equal(t, 1, report.Blocks.Items)
+	require.Len(t, report.Peers, 1)
+	assert.Equal(t, "127.0.0.1:18333", report.Peers[0].Peer)
+	assert.Equal(t, 1, report.Peers[0].Blocks.First)
+}
@@ -0,0 +1,87 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/senseyman/bitcoin-handshake/chaos"
+	"github.com/senseyman/bitcoin-handshake/client"
+	"github.com/senseyman/bitcoin-handshake/model"
+	"github.com/senseyman/bitcoin-handshake/nodetest"
+	"github.com/senseyman/bitcoin-handshake/service"
+)
+
+// TestCore_Handshake_Chaos checks that the handshake either succeeds or fails in time on broken links,
+// but never hangs or panics.
+func TestCore_Handshake_Chaos(t *testing.T) {
+	testCases := []struct {
+		name    string
+		profile chaos.Profile
+		// mustSucceed is set for the links which are slow but don't lose data
+		mustSucceed bool
+	}{
+		{
+			name: "slow",
+			profile: chaos.Profile{
+				Latency:      time.Millisecond,
+				Jitter:       5 * time.Millisecond,
+				Bandwidth:    64 * 1024,
+				FragmentRate: 1,
+			},
+			mustSucceed: true,
+		},
+		{
+			name:    "lossy",
+			profile: chaos.Profile{CorruptRate: 0.01, DropRate: 0.01},
+		},
+		{
+			name:    "flaky",
+			profile: chaos.Profile{FragmentRate: 0.5, CloseRate: 0.2},
+		},
+	}
+
+	for _, tc := range testCases {
+		for seed := int64(1); seed <= 5; seed++ {
+			t.Run(fmt.Sprintf("%s/seed_%d", tc.name, seed), func(t *testing.T) {
+				t.Parallel()
+
+				ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+				defer cancel()
+
+				peer := nodetest.NewPeer(nodetest.Handshake()...).SetExpectTimeout(time.Second)
+				connected := false
+				connectionFn := chaos.ConnectionFn(func(string, int) (client.Connection, error) {
+					if connected {
+						return nil, model.ErrConnectionClosed
+					}
+					connected = true
+					return peer.Pipe(), nil
+				}, tc.profile, seed)
+
+				cli, err := client.NewBitcoinClient("127.0.0.1", 18333, connectionFn,
+					client.WithReconnectPolicy(client.ReconnectPolicy{MaxAttempts: 1}))
+				require.NoError(t, err)
+				c := New(service.NewDecodeService(), service.NewEncodeService(), service.NewMessageGenerator(), cli)
+				c.ReceiveMessages(ctx)
+
+				start := time.Now()
+				_, err = c.Handshake(ctx)
+				assert.Less(t, time.Since(start), time.Second, "seed %d", seed)
+				if tc.mustSucceed {
+					assert.NoError(t, err, "seed %d", seed)
+				} else if err != nil {
+					// the handshake is stopped either by timeout or by broken connection on sending
+					assert.True(t, errors.Is(err, model.ErrContextTimeout) ||
+						errors.Is(err, chaos.ErrInjectedClose) ||
+						errors.Is(err, model.ErrConnectionClosed), "seed %d: %v", seed, err)
+				}
+			})
+		}
+	}
+}
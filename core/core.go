@@ -1,9 +1,14 @@
 package core
 
 import (
+	"context"
+	"net"
+	"strconv"
 	"sync"
+	"time"
 
 	"github.com/senseyman/bitcoin-handshake/model"
+	"github.com/senseyman/bitcoin-handshake/tracing"
 )
 
 const (
@@ -13,15 +18,86 @@ const (
 type Core struct {
 	messageReceiveOnce sync.Once
 	connectOnce        sync.Once
+	listenOnce         sync.Once
 	decoder            Decoder
 	encoder            Encoder
 	generator          Generator
 	client             Client
+	addrBook           AddrBook
+	addrSource         AddrSource
+	metrics            Metrics
+	tracer             *tracing.Tracer
+	timeData           TimeData
+	features           Features
+	banList            BanList
+	banPolicy          BanPolicy
+	blockStore         BlockStore
+	uploadTarget       UploadTarget
+	chainTracker       ChainTracker
+	announcements      AnnouncementRecorder
+	// localAddr is our listening address which is advertised to the node once per advertiseInterval on average
+	localAddr         *model.NetAddressV2
+	advertiseInterval time.Duration
 
 	receiveCh chan model.MessageFromNode
+
+	mu            sync.Mutex
+	waiter        *handshakeWaiter
+	handshakeDone bool
+	subscribers   []chan model.MessageFromNode
+	remoteVersion model.VersionMessage
+	// remoteTimeOffset is the clock offset of the node, computed when its version is received
+	remoteTimeOffset time.Duration
+	lastHandshake    model.HandshakeStats
+	// peerFeatures are negotiated during the current connection, wtxidrelay is negotiated when it's both sent
+	// and received
+	peerFeatures       model.PeerFeatures
+	wtxidRelaySent     bool
+	wtxidRelayReceived bool
+	versionReceived    bool
+	verackReceived     bool
+	getAddrReceived    bool
+	// misbehaviorScore is kept across reconnects, the node is the same
+	misbehaviorScore int
+	banned           bool
+	// sessionCtx is the context of the first handshake, it's used to make handshake again after reconnect
+	sessionCtx context.Context
+	// tracedDial is the start of the dial which is already a part of a handshake trace
+	tracedDial time.Time
+}
+
+type Option func(c *Core)
+
+// WithAddrBook sets the address book which stores addresses received from the node.
+func WithAddrBook(addrBook AddrBook) Option {
+	return func(c *Core) {
+		c.addrBook = addrBook
+	}
+}
+
+// WithMetrics makes core report the results of handshakes to metrics.
+func WithMetrics(metrics Metrics) Option {
+	return func(c *Core) {
+		c.metrics = metrics
+	}
+}
+
+// WithTracer makes core trace every handshake, from dial to the received verack.
+func WithTracer(tracer *tracing.Tracer) Option {
+	return func(c *Core) {
+		c.tracer = tracer
+	}
+}
+
+// WithTimeData makes core report the clock offset of the node after every handshake, so the median
+// offset over peers is computed.
+func WithTimeData(timeData TimeData) Option {
+	return func(c *Core) {
+		c.timeData = timeData
+	}
 }
 
-func New(decoder Decoder, encoder Encoder, generator Generator, client Client) *Core {
+func New(decoder Decoder, encoder Encoder, generator Generator, client Client, opts ...Option) *Core {
 	c := &Core{
 		messageReceiveOnce: sync.Once{},
 		connectOnce:        sync.Once{},
@@ -32,9 +108,18 @@ func New(decoder Decoder, encoder Encoder, generator Generator, client Client) *
 		receiveCh:          make(chan model.MessageFromNode, receiveChannelSize),
 	}
 
+	for _, opt := range opts {
+		opt(c)
+	}
+
 	return c
 }
 
 func (c *Core) GetReceiveChannel() chan model.MessageFromNode {
 	return c.receiveCh
 }
+
+// peerAddress is host:port of the node, it identifies the peer in traces and time data.
+func (c *Core) peerAddress() string {
+	return net.JoinHostPort(c.client.GetNodeHost(), strconv.Itoa(c.client.GetNodePort()))
+}
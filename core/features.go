@@ -0,0 +1,233 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/senseyman/bitcoin-handshake/model"
+)
+
+var (
+	errNoIPAddresses = errors.New("peer doesn't support addrv2 and there are no IP addresses to send")
+)
+
+// Features are the optional features of the protocol which core negotiates with the node. The zero value
+// negotiates nothing, as a client before BIP130.
+type Features struct {
+	// SendHeaders asks the node to announce new blocks with headers instead of inv, BIP130
+	SendHeaders bool
+	// FeeFilter is the fee rate in satoshis per kilobyte below which the node shouldn't announce txs, zero
+	// doesn't send feefilter, BIP133
+	FeeFilter int64
+	// WtxidRelay asks to announce txs by wtxid, it's used only if the node asks for it too, BIP339
+	WtxidRelay bool
+	// AddrV2 asks the node to relay addresses in addrv2 messages, BIP155
+	AddrV2 bool
+	// CompactBlocks asks the node to announce new blocks with cmpctblock, the high-bandwidth mode of BIP152. Bitcoin
+	// Core asks it of three peers only, so it's not among the default features
+	CompactBlocks bool
+}
+
+// DefaultFeatures negotiates all features as Bitcoin Core does, with its default minimal relay fee.
+func DefaultFeatures() Features {
+	return Features{
+		SendHeaders: true,
+		FeeFilter:   1000,
+		WtxidRelay:  true,
+		AddrV2:      true,
+	}
+}
+
+// WithFeatures sets the features which are negotiated during every handshake.
+func WithFeatures(features Features) Option {
+	return func(c *Core) {
+		c.features = features
+	}
+}
+
+// PeerFeatures returns the features negotiated with the node during the current connection.
+func (c *Core) PeerFeatures() model.PeerFeatures {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.peerFeatures
+}
+
+// sendPreVerackFeatures sends the messages which are allowed only between version and verack. wtxidrelay
+// is sent only if the node's version supports it, otherwise the node would disconnect.
+func (c *Core) sendPreVerackFeatures() error {
+	c.mu.Lock()
+	remoteVersion := c.remoteVersion.Version
+	c.mu.Unlock()
+
+	if c.features.WtxidRelay && remoteVersion >= model.WtxidRelayVersion {
+		if err := c.sendMessage(model.WtxidRelayCMD, nil); err != nil {
+			return err
+		}
+		c.mu.Lock()
+		c.wtxidRelaySent = true
+		c.peerFeatures.WtxidRelay = c.wtxidRelayReceived
+		c.mu.Unlock()
+	}
+	if c.features.AddrV2 {
+		if err := c.sendMessage(model.SendAddrV2CMD, nil); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// sendPostVerackFeatures sends the messages which are allowed after the handshake.
+func (c *Core) sendPostVerackFeatures() error {
+	c.mu.Lock()
+	remoteVersion := c.remoteVersion.Version
+	c.mu.Unlock()
+
+	if c.features.SendHeaders && remoteVersion >= model.SendHeadersVersion {
+		if err := c.sendMessage(model.SendHeadersCMD, nil); err != nil {
+			return err
+		}
+	}
+	if c.features.FeeFilter > 0 && remoteVersion >= model.FeeFilterVersion {
+		var payload bytes.Buffer
+		if err := c.encoder.EncodeElements(&payload, c.features.FeeFilter); err != nil {
+			return err
+		}
+		if err := c.sendMessage(model.FeeFilterCMD, payload.Bytes()); err != nil {
+			return err
+		}
+	}
+	if c.features.CompactBlocks && remoteVersion >= model.CompactBlocksVersion {
+		var payload bytes.Buffer
+		if err := c.encoder.EncodeElements(&payload, true, uint64(model.CompactBlocksEncoding)); err != nil {
+			return err
+		}
+		if err := c.sendMessage(model.SendCmpctCMD, payload.Bytes()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// handleFeature stores the feature which the node asked for. Like Bitcoin Core, wtxidrelay and sendaddrv2
+// are ignored after verack, false is returned for them.
+func (c *Core) handleFeature(msg model.MessageFromNode) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch msg.Header.Command {
+	case model.WtxidRelayCMD:
+		if c.verackReceived {
+			log.Warn("ignoring wtxidrelay message received after verack")
+			return false
+		}
+		c.wtxidRelayReceived = true
+		c.peerFeatures.WtxidRelay = c.wtxidRelaySent
+	case model.SendAddrV2CMD:
+		if c.verackReceived {
+			log.Warn("ignoring sendaddrv2 message received after verack")
+			return false
+		}
+		c.peerFeatures.AddrV2 = true
+	case model.SendHeadersCMD:
+		c.peerFeatures.SendHeaders = true
+	case model.FeeFilterCMD:
+		if filter, ok := msg.Payload.(model.FeeFilterMessage); ok {
+			c.peerFeatures.FeeFilter = filter.FeeRate
+		}
+	}
+
+	return true
+}
+
+// SendAddresses relays the addresses to the node in addrv2 message if it asked for it, otherwise in addr
+// message without the addresses which aren't IP based.
+func (c *Core) SendAddresses(addrs []model.NetAddressV2) error {
+	var payload bytes.Buffer
+	if c.PeerFeatures().AddrV2 {
+		if err := c.encoder.EncodeAddrV2Message(&payload, model.AddrV2Message{AddrList: addrs}); err != nil {
+			return err
+		}
+		return c.sendMessage(model.AddrV2CMD, payload.Bytes())
+	}
+
+	msg := model.AddrMessage{AddrList: make([]model.NetAddress, 0, len(addrs))}
+	for _, na := range addrs {
+		if v1, ok := na.ToV1(); ok {
+			msg.AddrList = append(msg.AddrList, v1)
+		}
+	}
+	if len(msg.AddrList) == 0 {
+		return errNoIPAddresses
+	}
+	if err := c.encoder.EncodeAddrMessage(&payload, msg); err != nil {
+		return err
+	}
+
+	return c.sendMessage(model.AddrCMD, payload.Bytes())
+}
+
+// AnnounceTxs announces the txs to the node in inv message. The txs below the fee filter of the node are
+// skipped, they are identified by wtxid if it's negotiated. Nothing is sent if the node doesn't want txs
+// at all, i.e. relay is false in its version. The number of announced txs is returned.
+func (c *Core) AnnounceTxs(txs []model.TxAnnouncement) (int, error) {
+	c.mu.Lock()
+	features, relay := c.peerFeatures, c.remoteVersion.Relay
+	c.mu.Unlock()
+
+	if !relay {
+		return 0, nil
+	}
+
+	msg := model.InvMessage{Inventory: make([]model.InvVect, 0, len(txs))}
+	for _, tx := range txs {
+		if tx.FeeRate < features.FeeFilter {
+			continue
+		}
+		inv := model.InvVect{Type: model.InvTypeTx, Hash: tx.TxID}
+		if features.WtxidRelay {
+			inv = model.InvVect{Type: model.InvTypeWtx, Hash: tx.WTxID}
+		}
+		msg.Inventory = append(msg.Inventory, inv)
+	}
+	if len(msg.Inventory) == 0 {
+		return 0, nil
+	}
+
+	var payload bytes.Buffer
+	if err := c.encoder.EncodeInvMessage(&payload, msg); err != nil {
+		return 0, err
+	}
+
+	return len(msg.Inventory), c.sendMessage(model.InvCMD, payload.Bytes())
+}
+
+// AnnounceBlocks announces new blocks to the node with headers message if it asked for it, otherwise
+// with inv message of their hashes.
+func (c *Core) AnnounceBlocks(headers []model.BlockHeader) error {
+	if len(headers) == 0 {
+		return nil
+	}
+
+	var payload bytes.Buffer
+	if c.PeerFeatures().SendHeaders {
+		if err := c.encoder.EncodeHeadersMessage(&payload, model.HeadersMessage{Headers: headers}); err != nil {
+			return err
+		}
+		return c.sendMessage(model.HeadersCMD, payload.Bytes())
+	}
+
+	msg := model.InvMessage{Inventory: make([]model.InvVect, 0, len(headers))}
+	for _, h := range headers {
+		msg.Inventory = append(msg.Inventory, model.InvVect{Type: model.InvTypeBlock, Hash: h.Hash()})
+	}
+	if err := c.encoder.EncodeInvMessage(&payload, msg); err != nil {
+		return err
+	}
+
+	return c.sendMessage(model.InvCMD, payload.Bytes())
+}
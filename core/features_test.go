@@ -0,0 +1,385 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/senseyman/bitcoin-handshake/client"
+	"github.com/senseyman/bitcoin-handshake/model"
+	"github.com/senseyman/bitcoin-handshake/nodetest"
+	"github.com/senseyman/bitcoin-handshake/service"
+)
+
+func feeFilterPayload(feeRate int64) []byte {
+	return binary.LittleEndian.AppendUint64(nil, uint64(feeRate))
+}
+
+// handshakeWithFeatures makes the handshake with the fake peer and returns core and the peer.
+func handshakeWithFeatures(t *testing.T, features Features, steps ...nodetest.Step) (*Core, *nodetest.Peer) {
+	return handshakeWithOptions(t, []Option{WithFeatures(features)}, steps...)
+}
+
+// handshakeWithOptions makes the handshake with the fake peer and core created with the options.
+func handshakeWithOptions(t *testing.T, opts []Option, steps ...nodetest.Step) (*Core, *nodetest.Peer) {
+	return handshakeWithHost(t, "127.0.0.1", opts, steps...)
+}
+
+// handshakeWithHost is handshakeWithOptions with the fake peer dialed by the host.
+func handshakeWithHost(t *testing.T, host string, opts []Option, steps ...nodetest.Step) (*Core, *nodetest.Peer) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	t.Cleanup(cancel)
+
+	peer := nodetest.NewPeer(steps...).SetExpectTimeout(time.Second)
+	cli, err := client.NewBitcoinClient(host, 18333, func(string, int) (client.Connection, error) {
+		return peer.Pipe(), nil
+	}, client.WithReconnectPolicy(client.ReconnectPolicy{MaxAttempts: 1}))
+	require.NoError(t, err)
+
+	c := New(service.NewDecodeService(), service.NewEncodeService(), service.NewMessageGenerator(), cli, opts...)
+	c.ReceiveMessages(ctx)
+	_, err = c.Handshake(ctx)
+	require.NoError(t, err)
+	require.NoError(t, peer.Wait(ctx))
+
+	return c, peer
+}
+
+func TestCore_Handshake_Features(t *testing.T) {
+	oldVersion := nodetest.DefaultVersion()
+	oldVersion.Version = 70015
+
+	testCases := []struct {
+		name        string
+		features    Features
+		steps       []nodetest.Step
+		expSent     []string
+		expFeatures model.PeerFeatures
+	}{
+		{
+			name:     "all",
+			features: DefaultFeatures(),
+			steps: []nodetest.Step{
+				nodetest.Expect(model.VersionCMD),
+				nodetest.SendVersion(nodetest.DefaultVersion()),
+				nodetest.SendMessage(model.WtxidRelayCMD, nil),
+				nodetest.SendMessage(model.SendAddrV2CMD, nil),
+				nodetest.SendVerack(),
+				nodetest.Expect(model.WtxidRelayCMD),
+				nodetest.Expect(model.SendAddrV2CMD),
+				nodetest.Expect(model.VerackCMD),
+				nodetest.SendMessage(model.SendHeadersCMD, nil),
+				nodetest.SendMessage(model.FeeFilterCMD, feeFilterPayload(2000)),
+				nodetest.Expect(model.SendHeadersCMD),
+				nodetest.Expect(model.FeeFilterCMD),
+			},
+			expSent: []string{
+				model.VersionCMD, model.WtxidRelayCMD, model.SendAddrV2CMD, model.VerackCMD,
+				model.SendHeadersCMD, model.FeeFilterCMD,
+			},
+			expFeatures: model.PeerFeatures{SendHeaders: true, FeeFilter: 2000, WtxidRelay: true, AddrV2: true},
+		},
+		{
+			name:     "none",
+			features: Features{},
+			steps: []nodetest.Step{
+				nodetest.Expect(model.VersionCMD),
+				nodetest.SendVersion(nodetest.DefaultVersion()),
+				nodetest.SendMessage(model.WtxidRelayCMD, nil),
+				nodetest.SendMessage(model.SendAddrV2CMD, nil),
+				nodetest.SendVerack(),
+				nodetest.Expect(model.VerackCMD),
+				nodetest.SendMessage(model.SendHeadersCMD, nil),
+				nodetest.ExpectNothing(50 * time.Millisecond),
+			},
+			expSent: []string{model.VersionCMD, model.VerackCMD},
+			// the peer wants addrv2 and headers, but txs are announced by wtxid only if both sides agreed
+			expFeatures: model.PeerFeatures{SendHeaders: true, AddrV2: true},
+		},
+		{
+			name:     "old_version",
+			features: DefaultFeatures(),
+			steps: []nodetest.Step{
+				nodetest.Expect(model.VersionCMD),
+				nodetest.SendVersion(oldVersion),
+				nodetest.SendVerack(),
+				nodetest.Expect(model.SendAddrV2CMD),
+				nodetest.Expect(model.VerackCMD),
+				nodetest.Expect(model.SendHeadersCMD),
+				nodetest.Expect(model.FeeFilterCMD),
+			},
+			expSent: []string{
+				model.VersionCMD, model.SendAddrV2CMD, model.VerackCMD, model.SendHeadersCMD, model.FeeFilterCMD,
+			},
+		},
+		{
+			name:     "compact_blocks",
+			features: Features{CompactBlocks: true},
+			steps: []nodetest.Step{
+				nodetest.Expect(model.VersionCMD),
+				nodetest.SendVersion(nodetest.DefaultVersion()),
+				nodetest.SendVerack(),
+				nodetest.Expect(model.VerackCMD),
+				nodetest.Expect(model.SendCmpctCMD),
+			},
+			expSent: []string{model.VersionCMD, model.VerackCMD, model.SendCmpctCMD},
+		},
+		{
+			name:     "compact_blocks/old_version",
+			features: Features{CompactBlocks: true},
+			steps: []nodetest.Step{
+				nodetest.Expect(model.VersionCMD),
+				nodetest.SendVersion(model.VersionMessage{Version: model.FeeFilterVersion, UserAgent: "/old/"}),
+				nodetest.SendVerack(),
+				nodetest.Expect(model.VerackCMD),
+				nodetest.ExpectNothing(50 * time.Millisecond),
+			},
+			expSent: []string{model.VersionCMD, model.VerackCMD},
+		},
+		{
+			name:     "after_verack",
+			features: DefaultFeatures(),
+			steps: []nodetest.Step{
+				nodetest.Expect(model.VersionCMD),
+				nodetest.SendVersion(nodetest.DefaultVersion()),
+				nodetest.SendVerack(),
+				nodetest.SkipUntil(model.FeeFilterCMD),
+				nodetest.SendMessage(model.WtxidRelayCMD, nil),
+				nodetest.SendMessage(model.SendAddrV2CMD, nil),
+				nodetest.SendMessage(model.FeeFilterCMD, feeFilterPayload(1000)),
+			},
+			expSent: []string{
+				model.VersionCMD, model.WtxidRelayCMD, model.SendAddrV2CMD, model.VerackCMD,
+				model.SendHeadersCMD, model.FeeFilterCMD,
+			},
+			expFeatures: model.PeerFeatures{FeeFilter: 1000},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			c, peer := handshakeWithFeatures(t, tc.features, tc.steps...)
+
+			assert.Equal(t, tc.expSent, peer.Commands())
+			// the features sent by the peer at the end of the script may be still on the way
+			assert.Eventually(t, func() bool {
+				return c.PeerFeatures() == tc.expFeatures
+			}, time.Second, time.Millisecond, "%+v", c.PeerFeatures())
+		})
+	}
+}
+
+func TestCore_Handshake_Features_Reconnect(t *testing.T) {
+	c, _ := handshakeWithFeatures(t, DefaultFeatures(),
+		nodetest.Expect(model.VersionCMD),
+		nodetest.SendVersion(nodetest.DefaultVersion()),
+		nodetest.SendMessage(model.SendAddrV2CMD, nil),
+		nodetest.SendVerack(),
+		nodetest.SkipUntil(model.FeeFilterCMD),
+	)
+	require.Eventually(t, func() bool { return c.PeerFeatures().AddrV2 }, time.Second, time.Millisecond)
+
+	// the node of the new connection has to ask for the features again
+	c.startHandshake()
+	assert.Equal(t, model.PeerFeatures{}, c.PeerFeatures())
+}
+
+// featuresPeer is the peer which makes the handshake with all features of core, the steps are made before
+// its verack, so the features they announce are negotiated when the handshake is done.
+func featuresPeer(t *testing.T, relay bool, steps ...nodetest.Step) (*Core, *nodetest.Peer) {
+	version := nodetest.DefaultVersion()
+	version.Relay = relay
+
+	return handshakeWithFeatures(t, DefaultFeatures(), append([]nodetest.Step{
+		nodetest.Expect(model.VersionCMD),
+		nodetest.SendVersion(version),
+	}, append(steps, nodetest.SendVerack(), nodetest.SkipUntil(model.FeeFilterCMD))...)...)
+}
+
+// lastFrame waits for the message which is sent after the handshake.
+func lastFrame(t *testing.T, peer *nodetest.Peer, command string) nodetest.Frame {
+	var frame nodetest.Frame
+	require.Eventually(t, func() bool {
+		frames := peer.Received()
+		frame = frames[len(frames)-1]
+		return frame.Header.Command == command
+	}, time.Second, time.Millisecond, "%v", peer.Commands())
+
+	return frame
+}
+
+func TestCore_AnnounceTxs(t *testing.T) {
+	hash := func(b byte) [32]byte { return [32]byte{b} }
+	txs := []model.TxAnnouncement{
+		{TxID: hash(1), WTxID: hash(2), FeeRate: 500},
+		{TxID: hash(3), WTxID: hash(4), FeeRate: 5000},
+	}
+
+	testCases := []struct {
+		name     string
+		relay    bool
+		steps    []nodetest.Step
+		expInv   []model.InvVect
+		expCount int
+	}{
+		{
+			name:     "txid",
+			relay:    true,
+			expInv:   []model.InvVect{{Type: model.InvTypeTx, Hash: hash(1)}, {Type: model.InvTypeTx, Hash: hash(3)}},
+			expCount: 2,
+		},
+		{
+			name:  "wtxid_fee_filter",
+			relay: true,
+			steps: []nodetest.Step{
+				nodetest.SendMessage(model.WtxidRelayCMD, nil),
+				nodetest.SendMessage(model.FeeFilterCMD, feeFilterPayload(1000)),
+			},
+			expInv:   []model.InvVect{{Type: model.InvTypeWtx, Hash: hash(4)}},
+			expCount: 1,
+		},
+		{
+			name: "no_relay",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			c, peer := featuresPeer(t, tc.relay, tc.steps...)
+
+			n, err := c.AnnounceTxs(txs)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expCount, n)
+			if tc.expInv == nil {
+				assert.Never(t, func() bool {
+					return peer.Commands()[len(peer.Commands())-1] == model.InvCMD
+				}, 50*time.Millisecond, 5*time.Millisecond)
+				return
+			}
+
+			var expPayload bytes.Buffer
+			require.NoError(t, service.NewEncodeService().EncodeInvMessage(&expPayload, model.InvMessage{Inventory: tc.expInv}))
+			assert.Equal(t, expPayload.Bytes(), lastFrame(t, peer, model.InvCMD).Payload)
+		})
+	}
+}
+
+func TestCore_AnnounceBlocks(t *testing.T) {
+	header := model.BlockHeader{Version: 4, Timestamp: 1700000000, Bits: 0x1d00ffff, Nonce: 42}
+
+	testCases := []struct {
+		name       string
+		steps      []nodetest.Step
+		expCommand string
+		expMessage func(w *bytes.Buffer) error
+	}{
+		{
+			name:       "inv",
+			expCommand: model.InvCMD,
+			expMessage: func(w *bytes.Buffer) error {
+				return service.NewEncodeService().EncodeInvMessage(w, model.InvMessage{
+					Inventory: []model.InvVect{{Type: model.InvTypeBlock, Hash: header.Hash()}},
+				})
+			},
+		},
+		{
+			name:       "headers",
+			steps:      []nodetest.Step{nodetest.SendMessage(model.SendHeadersCMD, nil)},
+			expCommand: model.HeadersCMD,
+			expMessage: func(w *bytes.Buffer) error {
+				return service.NewEncodeService().EncodeHeadersMessage(w, model.HeadersMessage{
+					Headers: []model.BlockHeader{header},
+				})
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			c, peer := featuresPeer(t, true, tc.steps...)
+			require.Equal(t, len(tc.steps) > 0, c.PeerFeatures().SendHeaders)
+
+			require.NoError(t, c.AnnounceBlocks([]model.BlockHeader{header}))
+
+			var expPayload bytes.Buffer
+			require.NoError(t, tc.expMessage(&expPayload))
+			assert.Equal(t, expPayload.Bytes(), lastFrame(t, peer, tc.expCommand).Payload)
+		})
+	}
+}
+
+func TestCore_SendAddresses(t *testing.T) {
+	ipv4 := model.NewNetAddressV2FromIP(net.ParseIP("10.0.0.1"), 18333, model.ServiceNodeNetwork, 1700000000)
+	onion, err := model.NewNetAddressV2FromHost(
+		"pg6mmjiyjmcrsslvykfwnntlaru7p5svn6y2ymmju6nubxndf4pscryd.onion", 18333, 0, 1700000000)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name       string
+		steps      []nodetest.Step
+		addrs      []model.NetAddressV2
+		expCommand string
+		expMessage func(w *bytes.Buffer) error
+		expErr     error
+	}{
+		{
+			name:       "addrv2",
+			steps:      []nodetest.Step{nodetest.SendMessage(model.SendAddrV2CMD, nil)},
+			addrs:      []model.NetAddressV2{ipv4, onion},
+			expCommand: model.AddrV2CMD,
+			expMessage: func(w *bytes.Buffer) error {
+				return service.NewEncodeService().EncodeAddrV2Message(w, model.AddrV2Message{
+					AddrList: []model.NetAddressV2{ipv4, onion},
+				})
+			},
+		},
+		{
+			name:       "addr",
+			addrs:      []model.NetAddressV2{ipv4, onion},
+			expCommand: model.AddrCMD,
+			expMessage: func(w *bytes.Buffer) error {
+				v1, _ := ipv4.ToV1()
+				return service.NewEncodeService().EncodeAddrMessage(w, model.AddrMessage{
+					AddrList: []model.NetAddress{v1},
+				})
+			},
+		},
+		{
+			name:   "err/addr_no_ip",
+			addrs:  []model.NetAddressV2{onion},
+			expErr: errNoIPAddresses,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			c, peer := featuresPeer(t, true, tc.steps...)
+			require.Equal(t, len(tc.steps) > 0, c.PeerFeatures().AddrV2)
+
+			err := c.SendAddresses(tc.addrs)
+			if tc.expErr != nil {
+				assert.ErrorIs(t, err, tc.expErr)
+				return
+			}
+			require.NoError(t, err)
+
+			var expPayload bytes.Buffer
+			require.NoError(t, tc.expMessage(&expPayload))
+			assert.Equal(t, expPayload.Bytes(), lastFrame(t, peer, tc.expCommand).Payload)
+		})
+	}
+}
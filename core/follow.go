@@ -0,0 +1,57 @@
+package core
+
+import (
+	log "github.com/sirupsen/logrus"
+
+	"github.com/senseyman/bitcoin-handshake/model"
+)
+
+// WithChainTracker makes core follow the headers chain of the node: its headers are requested after every
+// handshake and whenever it announces an unknown block, and all received headers are passed to the tracker.
+func WithChainTracker(tracker ChainTracker) Option {
+	return func(c *Core) {
+		c.chainTracker = tracker
+	}
+}
+
+// requestTipHeaders asks the node for the headers on top of the tracked chain.
+func (c *Core) requestTipHeaders() {
+	if c.chainTracker == nil {
+		return
+	}
+	if err := c.RequestHeaders(c.chainTracker.Locator()); err != nil {
+		log.Errorf("err requesting headers from node: %v", err)
+	}
+}
+
+// followHeaders passes the headers to the tracker, the rest of them is requested if the tracker needs it.
+func (c *Core) followHeaders(msg model.MessageFromNode) {
+	headers, ok := msg.Payload.(model.HeadersMessage)
+	if c.chainTracker == nil || !ok {
+		return
+	}
+
+	more, err := c.chainTracker.AddHeaders(c.peerAddress(), headers.Headers)
+	if err != nil {
+		log.Warnf("err following headers of node: %v", err)
+	}
+	if more {
+		c.requestTipHeaders()
+	}
+}
+
+// followInv requests the headers when the node announces a block the tracker doesn't know, the nodes which
+// didn't ask for headers announcements announce blocks by inv.
+func (c *Core) followInv(msg model.MessageFromNode) {
+	inv, ok := msg.Payload.(model.InvMessage)
+	if c.chainTracker == nil || !ok {
+		return
+	}
+
+	for _, item := range inv.Inventory {
+		if item.Type == model.InvTypeBlock && !c.chainTracker.Has(item.Hash) {
+			c.requestTipHeaders()
+			return
+		}
+	}
+}
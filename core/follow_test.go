@@ -0,0 +1,58 @@
+package core
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/senseyman/bitcoin-handshake/chaintip"
+	"github.com/senseyman/bitcoin-handshake/model"
+	"github.com/senseyman/bitcoin-handshake/nodetest"
+	"github.com/senseyman/bitcoin-handshake/service"
+)
+
+func TestCore_ChainTracker(t *testing.T) {
+	_, chain := servingStore(t)
+	tracker := chaintip.New(chain[0], 0)
+
+	headersPayload := func(headers ...model.BlockHeader) []byte {
+		var payload bytes.Buffer
+		require.NoError(t, service.NewEncodeService().EncodeHeadersMessage(&payload, model.HeadersMessage{Headers: headers}))
+		return payload.Bytes()
+	}
+	blockInv := func(h model.BlockHeader) []byte {
+		return invPayload(t, model.InvVect{Type: model.InvTypeBlock, Hash: h.Hash()})
+	}
+
+	_, peer := handshakeWithOptions(t, []Option{WithChainTracker(tracker)}, append(nodetest.Handshake(),
+		// the headers are requested after the handshake
+		nodetest.Expect(model.GetHeadersCMD),
+		nodetest.SendMessage(model.HeadersCMD, headersPayload(chain[1:3]...)),
+		// the known block isn't requested
+		nodetest.SendMessage(model.InvCMD, blockInv(chain[2])),
+		nodetest.ExpectNothing(50*time.Millisecond),
+		nodetest.SendMessage(model.InvCMD, blockInv(chain[4])),
+		nodetest.Expect(model.GetHeadersCMD),
+		nodetest.SendMessage(model.HeadersCMD, headersPayload(chain[3:]...)),
+		nodetest.ExpectNothing(50*time.Millisecond),
+	)...)
+
+	assert.Equal(t, 5, tracker.Tip().Height)
+
+	var locators [][][32]byte
+	for _, frame := range peer.Received() {
+		if frame.Header.Command != model.GetHeadersCMD {
+			continue
+		}
+		msg, err := service.NewDecodeService().DecodeBlockLocatorMessage(bytes.NewReader(frame.Payload))
+		require.NoError(t, err)
+		locators = append(locators, msg.Locator)
+	}
+	assert.Equal(t, [][][32]byte{
+		{chain[0].Hash()},
+		{chain[2].Hash(), chain[1].Hash(), chain[0].Hash()},
+	}, locators)
+}
@@ -0,0 +1,366 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/senseyman/bitcoin-handshake/client"
+	"github.com/senseyman/bitcoin-handshake/metrics"
+	"github.com/senseyman/bitcoin-handshake/model"
+	"github.com/senseyman/bitcoin-handshake/nodetest"
+	"github.com/senseyman/bitcoin-handshake/service"
+	"github.com/senseyman/bitcoin-handshake/timedata"
+	"github.com/senseyman/bitcoin-handshake/tracing"
+)
+
+func newTestCore(t *testing.T, peer *nodetest.Peer) *Core {
+	connected := false
+	connectionFn := func(string, int) (client.Connection, error) {
+		// the fake peer serves only one connection
+		if connected {
+			return nil, errors.New("fake peer is gone")
+		}
+		connected = true
+		return peer.Pipe(), nil
+	}
+
+	cli, err := client.NewBitcoinClient("127.0.0.1", 18333, connectionFn,
+		client.WithReconnectPolicy(client.ReconnectPolicy{MaxAttempts: 1}))
+	require.NoError(t, err)
+
+	return New(service.NewDecodeService(), service.NewEncodeService(), service.NewMessageGenerator(), cli)
+}
+
+func TestCore_Handshake_FakeNode(t *testing.T) {
+	versionPayload := func() []byte {
+		var b bytes.Buffer
+		require.NoError(t, service.NewEncodeService().EncodeVersionMessage(&b, nodetest.DefaultVersion()))
+		return b.Bytes()
+	}()
+
+	testCases := []struct {
+		name        string
+		steps       []nodetest.Step
+		expCommands []string
+		hasErr      bool
+		expErr      error
+	}{
+		{
+			name:        "success",
+			steps:       nodetest.Handshake(),
+			expCommands: []string{model.VersionCMD, model.VerackCMD},
+		},
+		{
+			name: "success/version_before_ours",
+			steps: []nodetest.Step{
+				nodetest.SendVersion(nodetest.DefaultVersion()),
+				nodetest.Expect(model.VersionCMD),
+				nodetest.Expect(model.VerackCMD),
+				nodetest.SendVerack(),
+			},
+			expCommands: []string{model.VersionCMD, model.VerackCMD},
+		},
+		{
+			name: "success/delayed_verack",
+			steps: []nodetest.Step{
+				nodetest.Expect(model.VersionCMD),
+				nodetest.SendVersion(nodetest.DefaultVersion()),
+				nodetest.Expect(model.VerackCMD),
+				nodetest.Delay(100 * time.Millisecond),
+				nodetest.SendVerack(),
+			},
+			expCommands: []string{model.VersionCMD, model.VerackCMD},
+		},
+		{
+			name: "success/garbage_header_skipped",
+			steps: append([]nodetest.Step{
+				nodetest.SendGarbage(24),
+			}, nodetest.Handshake()...),
+			expCommands: []string{model.VersionCMD, model.VerackCMD},
+		},
+		{
+			name: "success/unknown_message_ignored",
+			steps: []nodetest.Step{
+				nodetest.Expect(model.VersionCMD),
+				nodetest.SendMessage("sendcmpct", []byte{0, 2, 0, 0, 0, 0, 0, 0, 0}),
+				nodetest.SendVersion(nodetest.DefaultVersion()),
+				nodetest.SendVerack(),
+				nodetest.Expect(model.VerackCMD),
+			},
+			expCommands: []string{model.VersionCMD, model.VerackCMD},
+		},
+		{
+			name: "err/no_verack",
+			steps: []nodetest.Step{
+				nodetest.Expect(model.VersionCMD),
+				nodetest.SendVersion(nodetest.DefaultVersion()),
+				nodetest.Expect(model.VerackCMD),
+			},
+			expCommands: []string{model.VersionCMD, model.VerackCMD},
+			hasErr:      true,
+			expErr:      model.ErrContextTimeout,
+		},
+		{
+			name: "err/no_version",
+			steps: []nodetest.Step{
+				nodetest.Expect(model.VersionCMD),
+				nodetest.ExpectNothing(100 * time.Millisecond),
+			},
+			expCommands: []string{model.VersionCMD},
+			hasErr:      true,
+			expErr:      model.ErrContextTimeout,
+		},
+		{
+			name: "err/closed_mid_message",
+			steps: []nodetest.Step{
+				nodetest.Expect(model.VersionCMD),
+				nodetest.CloseMidMessage(model.VersionCMD, versionPayload),
+			},
+			expCommands: []string{model.VersionCMD},
+			hasErr:      true,
+			expErr:      model.ErrContextTimeout,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+			defer cancel()
+
+			peer := nodetest.NewPeer(tc.steps...).SetExpectTimeout(time.Second)
+			c := newTestCore(t, peer)
+			c.ReceiveMessages(ctx)
+
+			_, err := c.Handshake(ctx)
+			if tc.hasErr {
+				assert.ErrorIs(t, err, tc.expErr)
+			} else {
+				require.NoError(t, err)
+				require.NoError(t, peer.Wait(ctx))
+
+				stats := c.LastHandshake()
+				assert.Equal(t, nodetest.DefaultVersion().UserAgent, stats.RemoteVersion.UserAgent)
+				assert.Positive(t, stats.VersionRTT)
+				assert.Positive(t, stats.VerackRTT)
+				assert.GreaterOrEqual(t, stats.Total, stats.VersionRTT+stats.VerackRTT)
+			}
+
+			require.Eventually(t, func() bool {
+				return len(peer.Commands()) >= len(tc.expCommands)
+			}, time.Second, time.Millisecond)
+			assert.Equal(t, tc.expCommands, peer.Commands())
+
+			var version int32
+			require.NoError(t, service.NewDecodeService().DecodeElements(bytes.NewReader(peer.Received()[0].Payload), &version))
+			assert.Equal(t, int32(model.ProtocolVersion), version)
+		})
+	}
+}
+
+func TestCore_Handshake_Metrics(t *testing.T) {
+	badChecksum := nodetest.EncodeFrame(model.TestNetMagic, "ping", make([]byte, 8))
+	badChecksum[len(badChecksum)-1] = 1
+
+	peer := nodetest.NewPeer(
+		nodetest.SendGarbage(24),
+		nodetest.Expect(model.VersionCMD),
+		nodetest.SendMessage("sendcmpct", []byte{0, 2, 0, 0, 0, 0, 0, 0, 0}),
+		nodetest.SendRaw(badChecksum),
+		nodetest.SendVersion(nodetest.DefaultVersion()),
+		nodetest.SendVerack(),
+		nodetest.Expect(model.VerackCMD),
+	).SetExpectTimeout(time.Second)
+
+	m := metrics.New()
+	cli, err := client.NewBitcoinClient("127.0.0.1", 18333, func(string, int) (client.Connection, error) {
+		return peer.Pipe(), nil
+	}, client.WithReconnectPolicy(client.ReconnectPolicy{MaxAttempts: 1}), client.WithMetrics(m))
+	require.NoError(t, err)
+	c := New(service.NewDecodeService(), service.NewEncodeService(), service.NewMessageGenerator(), cli, WithMetrics(m))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	c.ReceiveMessages(ctx)
+
+	_, err = c.Handshake(ctx)
+	require.NoError(t, err)
+	require.NoError(t, peer.Wait(ctx))
+
+	var b bytes.Buffer
+	require.NoError(t, m.WriteText(&b))
+	text := b.String()
+	for _, line := range []string{
+		"bitcoin_handshake_attempts_total 1\n",
+		"bitcoin_handshake_successes_total 1\n",
+		`bitcoin_handshake_duration_seconds_count{phase="total"} 1` + "\n",
+		`bitcoin_handshake_duration_seconds_count{phase="version"} 1` + "\n",
+		`bitcoin_handshake_duration_seconds_count{phase="verack"} 1` + "\n",
+		`bitcoin_messages_total{command="version",direction="in"} 1` + "\n",
+		`bitcoin_messages_total{command="verack",direction="in"} 1` + "\n",
+		`bitcoin_messages_total{command="version",direction="out"} 1` + "\n",
+		`bitcoin_messages_total{command="verack",direction="out"} 1` + "\n",
+		`bitcoin_message_bytes_total{command="verack",direction="out"} 24` + "\n",
+		`bitcoin_decode_errors_total{reason="bad_magic"} 1` + "\n",
+		`bitcoin_decode_errors_total{reason="bad_checksum"} 1` + "\n",
+		`bitcoin_decode_errors_total{reason="unknown_command"} 1` + "\n",
+	} {
+		assert.Contains(t, text, line)
+	}
+	assert.NotContains(t, text, "bitcoin_handshake_failures_total{")
+}
+
+func TestCore_Handshake_Tracing(t *testing.T) {
+	testCases := []struct {
+		name     string
+		steps    []nodetest.Step
+		expSpans []string
+		expErr   string
+	}{
+		{
+			name:  "success",
+			steps: nodetest.Handshake(),
+			expSpans: []string{
+				SpanDial, SpanVersionSend, SpanVersionReceive, SpanVerackSend, SpanVerackReceive, SpanHandshake,
+			},
+		},
+		{
+			name: "err/no_version",
+			steps: []nodetest.Step{
+				nodetest.Expect(model.VersionCMD),
+				nodetest.ExpectNothing(100 * time.Millisecond),
+			},
+			expSpans: []string{SpanDial, SpanVersionSend, SpanVersionReceive, SpanHandshake},
+			expErr:   model.ErrContextTimeout.Error(),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+			defer cancel()
+
+			peer := nodetest.NewPeer(tc.steps...).SetExpectTimeout(time.Second)
+			cli, err := client.NewBitcoinClient("127.0.0.1", 18333, func(string, int) (client.Connection, error) {
+				return peer.Pipe(), nil
+			}, client.WithReconnectPolicy(client.ReconnectPolicy{MaxAttempts: 1}))
+			require.NoError(t, err)
+
+			exporter := tracing.NewInMemoryExporter()
+			c := New(service.NewDecodeService(), service.NewEncodeService(), service.NewMessageGenerator(), cli,
+				WithTracer(tracing.NewTracer(exporter)))
+			c.ReceiveMessages(ctx)
+			_, _ = c.Handshake(ctx)
+
+			spans := exporter.Spans()
+			names := make([]string, 0, len(spans))
+			for _, span := range spans {
+				names = append(names, span.Name)
+			}
+			require.Equal(t, tc.expSpans, names)
+
+			root := spans[len(spans)-1]
+			assert.False(t, root.Parent.IsValid())
+			assert.Equal(t, tc.expErr, root.Error)
+			assert.Equal(t, tc.expErr, spans[len(spans)-2].Error)
+			peerAddress, _ := root.Attribute(AttrPeerAddress)
+			assert.Equal(t, "127.0.0.1:18333", peerAddress)
+			for _, span := range spans[:len(spans)-1] {
+				assert.Equal(t, root.Context.TraceID, span.Context.TraceID, span.Name)
+				assert.Equal(t, root.Context.SpanID, span.Parent, span.Name)
+				assert.False(t, span.Start.Before(root.Start), span.Name)
+				assert.False(t, span.End.After(root.End), span.Name)
+			}
+			// the root starts with the dial, so the connecting time isn't an opaque gap
+			assert.Equal(t, root.Start, spans[0].Start)
+
+			if tc.expErr != "" {
+				return
+			}
+			userAgent, _ := root.Attribute(AttrUserAgent)
+			assert.Equal(t, nodetest.DefaultVersion().UserAgent, userAgent)
+			protocolVersion, _ := root.Attribute(AttrProtocolVersion)
+			assert.Equal(t, int64(nodetest.DefaultVersion().Version), protocolVersion)
+			services, _ := root.Attribute(AttrServices)
+			assert.Equal(t, "NETWORK|WITNESS|NETWORK_LIMITED", services)
+			protocolVersion, _ = spans[2].Attribute(AttrProtocolVersion)
+			assert.Equal(t, int64(nodetest.DefaultVersion().Version), protocolVersion)
+
+			// the next handshake on the same connection doesn't trace the dial again
+			exporter.Reset()
+			_, _ = c.Handshake(ctx)
+			for _, span := range exporter.Spans() {
+				assert.NotEqual(t, SpanDial, span.Name)
+			}
+		})
+	}
+}
+
+func TestCore_Handshake_TimeOffset(t *testing.T) {
+	testCases := []struct {
+		name      string
+		offset    time.Duration
+		expSkewed bool
+	}{
+		{
+			name:   "in_sync",
+			offset: 0,
+		},
+		{
+			name:      "node_ahead",
+			offset:    time.Hour,
+			expSkewed: true,
+		},
+		{
+			name:      "node_behind",
+			offset:    -20 * time.Minute,
+			expSkewed: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+
+			version := nodetest.DefaultVersion()
+			version.Timestamp = time.Now().Add(tc.offset).Unix()
+			peer := nodetest.NewPeer(
+				nodetest.Expect(model.VersionCMD),
+				nodetest.SendVersion(version),
+				nodetest.SendVerack(),
+				nodetest.Expect(model.VerackCMD),
+			).SetExpectTimeout(time.Second)
+			cli, err := client.NewBitcoinClient("127.0.0.1", 18333, func(string, int) (client.Connection, error) {
+				return peer.Pipe(), nil
+			}, client.WithReconnectPolicy(client.ReconnectPolicy{MaxAttempts: 1}))
+			require.NoError(t, err)
+
+			sampler := timedata.New(timedata.Config{MaxSamples: 1, MinSamples: 1, WarnThreshold: timedata.DefaultWarnThreshold})
+			c := New(service.NewDecodeService(), service.NewEncodeService(), service.NewMessageGenerator(), cli,
+				WithTimeData(sampler))
+			c.ReceiveMessages(ctx)
+			_, err = c.Handshake(ctx)
+			require.NoError(t, err)
+
+			// the timestamp is in seconds, so the offset may be a second off
+			assert.InDelta(t, tc.offset.Seconds(), c.LastHandshake().TimeOffset.Seconds(), 1)
+			samples := sampler.Samples()
+			require.Len(t, samples, 1)
+			assert.Equal(t, "127.0.0.1:18333", samples[0].Peer)
+			assert.Equal(t, c.LastHandshake().TimeOffset, samples[0].Offset)
+			assert.Equal(t, tc.expSkewed, sampler.Skewed())
+		})
+	}
+}
@@ -4,16 +4,31 @@ import (
 	"bytes"
 	"context"
 	"io"
+	"net"
+	"time"
 
 	"github.com/senseyman/bitcoin-handshake/model"
 )
 
 type Decoder interface {
 	DecodeElements(r io.Reader, elements ...any) error
+	DecodeVersionMessage(r io.Reader) (model.VersionMessage, error)
+	DecodeAddrMessage(r io.Reader) (model.AddrMessage, error)
+	DecodeAddrV2Message(r io.Reader) (model.AddrV2Message, error)
+	DecodeFeeFilterMessage(r io.Reader) (model.FeeFilterMessage, error)
+	DecodeHeadersMessage(r io.Reader) (model.HeadersMessage, error)
+	DecodeInvMessage(r io.Reader) (model.InvMessage, error)
+	DecodeCmpctBlockMessage(r io.Reader) (model.CmpctBlockMessage, error)
+	DecodeBlockLocatorMessage(r io.Reader) (model.BlockLocatorMessage, error)
 }
 
 type Encoder interface {
 	EncodeVersionMessage(w io.Writer, msg model.VersionMessage) error
+	EncodeAddrMessage(w io.Writer, msg model.AddrMessage) error
+	EncodeAddrV2Message(w io.Writer, msg model.AddrV2Message) error
+	EncodeInvMessage(w io.Writer, msg model.InvMessage) error
+	EncodeHeadersMessage(w io.Writer, msg model.HeadersMessage) error
+	EncodeBlockLocatorMessage(w io.Writer, msg model.BlockLocatorMessage) error
 	EncodeElements(w io.Writer, elements ...any) error
 }
 
@@ -31,4 +46,61 @@ type Client interface {
 	)
 	GetNodeHost() string
 	GetNodePort() int
+	LastDial() model.DialTiming
+	Disconnect(reason error)
+}
+
+type AddrBook interface {
+	Add(addrs []model.NetAddressV2, source model.NetAddressV2) int
+}
+
+// AddrSource provides the addresses which are sent to the node in response to getaddr, the node is connected
+// over the network.
+type AddrSource interface {
+	Addresses(network model.NetworkID) []model.NetAddressV2
+}
+
+// AnnouncementRecorder gets the blocks and txs announced by the node and when their announcements were received.
+type AnnouncementRecorder interface {
+	Announced(peer string, inv model.InvVect, at time.Time)
+}
+
+// ChainTracker follows the headers announced by the nodes, it reports if more headers should be requested
+// from the node.
+type ChainTracker interface {
+	AddHeaders(peer string, headers []model.BlockHeader) (bool, error)
+	Has(hash [32]byte) bool
+	Locator() [][32]byte
+}
+
+// Metrics gets the results of handshakes.
+type Metrics interface {
+	HandshakeStarted()
+	HandshakeSucceeded(stats model.HandshakeStats)
+	HandshakeFailed(err error)
+}
+
+// TimeData gets the clock offsets of peers.
+type TimeData interface {
+	Add(peer string, offset time.Duration)
+}
+
+// BanList keeps the subnets of misbehaving nodes.
+type BanList interface {
+	Ban(subnet *net.IPNet, duration time.Duration, reason string)
+}
+
+// BlockStore has the headers and blocks which are served to the node.
+type BlockStore interface {
+	Tip() (model.BlockHeader, int)
+	Headers(locator [][32]byte, stop [32]byte, max int) []model.BlockHeader
+	Hashes(locator [][32]byte, stop [32]byte, max int) [][32]byte
+	Block(hash [32]byte, witness bool) ([]byte, bool)
+	IsHistorical(hash [32]byte) bool
+	Services() model.ServiceFlag
+}
+
+// UploadTarget says if there is enough room in the upload target to serve historical blocks.
+type UploadTarget interface {
+	ServeHistoricalBlocks() bool
 }
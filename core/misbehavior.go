@@ -0,0 +1,167 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/senseyman/bitcoin-handshake/model"
+)
+
+// Violation is the protocol violation of the node which adds to its misbehaviour score.
+type Violation string
+
+const (
+	ViolationBadMagic        Violation = "bad magic"
+	ViolationBadChecksum     Violation = "bad checksum"
+	ViolationPayloadTooLarge Violation = "payload too large"
+	ViolationMalformed       Violation = "malformed message"
+	ViolationUnsolicited     Violation = "unsolicited message"
+	ViolationInvalidHeaders  Violation = "invalid headers"
+	ViolationAddrFlood       Violation = "address flood"
+	ViolationInvFlood        Violation = "inventory flood"
+	ViolationLocatorTooLarge Violation = "locator too large"
+)
+
+// violationScores are close to the ones of Bitcoin Core, the violations which break the stream or can't
+// be made by an honest node are enough for the ban at once.
+var violationScores = map[Violation]int{
+	ViolationBadMagic:        100,
+	ViolationBadChecksum:     10,
+	ViolationPayloadTooLarge: 100,
+	ViolationMalformed:       10,
+	ViolationUnsolicited:     10,
+	ViolationInvalidHeaders:  100,
+	ViolationAddrFlood:       20,
+	ViolationInvFlood:        20,
+	ViolationLocatorTooLarge: 100,
+}
+
+// Score is the number of points the violation adds to the misbehaviour score.
+func (v Violation) Score() int {
+	return violationScores[v]
+}
+
+// BanPolicy says when and how the misbehaving node is banned.
+type BanPolicy struct {
+	// Threshold is the misbehaviour score at which the node is banned
+	Threshold int
+	// Duration is how long the node stays banned
+	Duration time.Duration
+	// IPv4Prefix and IPv6Prefix are the sizes of the banned subnet around the address of the node
+	IPv4Prefix int
+	IPv6Prefix int
+}
+
+// DefaultBanPolicy bans the single address of the node for a day once its score reaches 100.
+func DefaultBanPolicy() BanPolicy {
+	return BanPolicy{
+		Threshold:  100,
+		Duration:   24 * time.Hour,
+		IPv4Prefix: 32,
+		IPv6Prefix: 128,
+	}
+}
+
+// subnet returns the subnet of the address which is banned according to the policy.
+func (p BanPolicy) subnet(ip net.IP) *net.IPNet {
+	if ip4 := ip.To4(); ip4 != nil {
+		mask := net.CIDRMask(p.IPv4Prefix, 32)
+		return &net.IPNet{IP: ip4.Mask(mask), Mask: mask}
+	}
+
+	mask := net.CIDRMask(p.IPv6Prefix, 128)
+	return &net.IPNet{IP: ip.Mask(mask), Mask: mask}
+}
+
+// WithBanList makes core disconnect and ban the node once its misbehaviour score crosses the threshold of
+// the policy. Without the ban list violations are only scored and logged.
+func WithBanList(banList BanList, policy BanPolicy) Option {
+	return func(c *Core) {
+		c.banList = banList
+		c.banPolicy = policy
+	}
+}
+
+// MisbehaviorScore returns the misbehaviour score of the node, it's kept across reconnects.
+func (c *Core) MisbehaviorScore() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.misbehaviorScore
+}
+
+// Misbehaving adds the violation to the score of the node. The node is disconnected and banned when the score
+// reaches the threshold, true is returned then.
+func (c *Core) Misbehaving(violation Violation, reason string) bool {
+	c.mu.Lock()
+	c.misbehaviorScore += violation.Score()
+	score := c.misbehaviorScore
+	ban := c.banList != nil && !c.banned && score >= c.banPolicy.Threshold
+	if ban {
+		c.banned = true
+	}
+	c.mu.Unlock()
+
+	peer := c.peerAddress()
+	log.Warnf("peer %s misbehaving (%s): %s, score %d", peer, violation, reason, score)
+	if !ban {
+		return false
+	}
+
+	ip := net.ParseIP(c.client.GetNodeHost())
+	if ip == nil {
+		log.Errorf("can't ban peer %s, its address isn't IP", peer)
+	} else {
+		subnet := c.banPolicy.subnet(ip)
+		c.banList.Ban(subnet, c.banPolicy.Duration, fmt.Sprintf("%s: %s", violation, reason))
+		log.Warnf("banned %s for %s, misbehaviour score %d", subnet, c.banPolicy.Duration, score)
+	}
+	c.client.Disconnect(fmt.Errorf("%w: misbehaviour score %d", model.ErrPeerBanned, score))
+
+	return true
+}
+
+// handleInvalidMessage scores the message which the client failed to read.
+func (c *Core) handleInvalidMessage(msg model.MessageFromNode) {
+	err := *msg.Error
+	log.Errorf("got invalid %s message: %v", msg.Header.Command, err)
+
+	switch {
+	case errors.Is(err, model.ErrInvalidMagicNumber):
+		c.Misbehaving(ViolationBadMagic, err.Error())
+	case errors.Is(err, model.ErrInvalidMessageChecksum):
+		c.Misbehaving(ViolationBadChecksum, err.Error())
+	case errors.Is(err, model.ErrPayloadTooLarge):
+		c.Misbehaving(ViolationPayloadTooLarge, err.Error())
+	case errors.Is(err, model.ErrTooManyAddresses):
+		c.Misbehaving(ViolationAddrFlood, err.Error())
+	case errors.Is(err, model.ErrTooManyInventory):
+		c.Misbehaving(ViolationInvFlood, err.Error())
+	case errors.Is(err, model.ErrLocatorTooLarge):
+		c.Misbehaving(ViolationLocatorTooLarge, err.Error())
+	case errors.Is(err, model.ErrInvalidHeaders):
+		c.Misbehaving(ViolationInvalidHeaders, err.Error())
+	case errors.Is(err, model.ErrUnknownCommand):
+		// unknown commands are fine, the node may support newer protocol
+	default:
+		c.Misbehaving(ViolationMalformed, fmt.Sprintf("%s: %v", msg.Header.Command, err))
+	}
+}
+
+// checkHeaders scores the headers which don't connect or have invalid proof of work, false is returned for them.
+func (c *Core) checkHeaders(msg model.MessageFromNode) bool {
+	headers, ok := msg.Payload.(model.HeadersMessage)
+	if !ok {
+		return false
+	}
+	if err := model.ValidateHeaders(headers.Headers); err != nil {
+		c.Misbehaving(ViolationInvalidHeaders, err.Error())
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,210 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/senseyman/bitcoin-handshake/client"
+	"github.com/senseyman/bitcoin-handshake/model"
+	"github.com/senseyman/bitcoin-handshake/nodetest"
+	"github.com/senseyman/bitcoin-handshake/service"
+)
+
+type banListStub struct {
+	mu   sync.Mutex
+	bans []string
+}
+
+func (b *banListStub) Ban(subnet *net.IPNet, _ time.Duration, _ string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.bans = append(b.bans, subnet.String())
+}
+
+func (b *banListStub) Bans() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return append([]string(nil), b.bans...)
+}
+
+func badChecksumFrame(command string, payload []byte) []byte {
+	frame := nodetest.EncodeFrame(model.TestNetMagic, command, payload)
+	frame[20] ^= 0xff
+
+	return frame
+}
+
+func oversizedFrame() []byte {
+	frame := nodetest.EncodeFrame(model.TestNetMagic, model.AddrCMD, nil)
+	binary.LittleEndian.PutUint32(frame[16:20], model.MaxPayloadSize+1)
+
+	return frame
+}
+
+func TestCore_Misbehaving(t *testing.T) {
+	invalidHeader := model.BlockHeader{Version: 4, Timestamp: 1700000000, Bits: 0x1d00ffff}
+	var invalidHeaders bytes.Buffer
+	require.NoError(t, service.NewEncodeService().EncodeHeadersMessage(&invalidHeaders,
+		model.HeadersMessage{Headers: []model.BlockHeader{invalidHeader}}))
+
+	// 0xfd 0xe9 0x03 is 1001 addresses
+	addrFlood := []byte{0xfd, 0xe9, 0x03}
+	// 0xfe 0x51 0xc3 0x00 0x00 is 50001 items
+	invFlood := []byte{0xfe, 0x51, 0xc3, 0x00, 0x00}
+	// the version and 102 locator hashes
+	largeLocator := []byte{0x80, 0x11, 0x01, 0x00, 0x66}
+
+	testCases := []struct {
+		name     string
+		noBans   bool
+		policy   BanPolicy
+		steps    []nodetest.Step
+		expScore int
+		expBans  []string
+	}{
+		{
+			name:     "bad_checksum",
+			steps:    []nodetest.Step{nodetest.SendRaw(badChecksumFrame(model.SendHeadersCMD, nil))},
+			expScore: 10,
+		},
+		{
+			name:     "malformed",
+			steps:    []nodetest.Step{nodetest.SendMessage(model.FeeFilterCMD, []byte{0x01})},
+			expScore: 10,
+		},
+		{
+			name:     "unknown_command",
+			steps:    []nodetest.Step{nodetest.SendMessage("mempool", nil)},
+			expScore: 0,
+		},
+		{
+			name: "unsolicited",
+			steps: []nodetest.Step{
+				nodetest.SendVersion(nodetest.DefaultVersion()),
+				nodetest.SendVerack(),
+				nodetest.SendMessage(model.WtxidRelayCMD, nil),
+			},
+			expScore: 30,
+		},
+		{
+			name:     "addr_flood",
+			steps:    []nodetest.Step{nodetest.SendMessage(model.AddrCMD, addrFlood)},
+			expScore: 20,
+		},
+		{
+			name:     "inv_flood",
+			steps:    []nodetest.Step{nodetest.SendMessage(model.GetDataCMD, invFlood)},
+			expScore: 20,
+		},
+		{
+			name:     "locator_too_large",
+			steps:    []nodetest.Step{nodetest.SendMessage(model.GetHeadersCMD, largeLocator)},
+			expScore: 100,
+			expBans:  []string{"127.0.0.1/32"},
+		},
+		{
+			name:     "bad_magic",
+			steps:    []nodetest.Step{nodetest.SendRaw(nodetest.EncodeFrame(0xdeadbeef, model.SendHeadersCMD, nil))},
+			expScore: 100,
+			expBans:  []string{"127.0.0.1/32"},
+		},
+		{
+			name:     "payload_too_large",
+			steps:    []nodetest.Step{nodetest.SendRaw(oversizedFrame())},
+			expScore: 100,
+			expBans:  []string{"127.0.0.1/32"},
+		},
+		{
+			name:     "invalid_headers",
+			steps:    []nodetest.Step{nodetest.SendMessage(model.HeadersCMD, invalidHeaders.Bytes())},
+			expScore: 100,
+			expBans:  []string{"127.0.0.1/32"},
+		},
+		{
+			name:   "subnet",
+			policy: BanPolicy{Threshold: 20, Duration: time.Hour, IPv4Prefix: 24, IPv6Prefix: 64},
+			steps: []nodetest.Step{
+				nodetest.SendRaw(badChecksumFrame(model.SendHeadersCMD, nil)),
+				nodetest.SendRaw(badChecksumFrame(model.SendHeadersCMD, nil)),
+			},
+			expScore: 20,
+			expBans:  []string{"127.0.0.0/24"},
+		},
+		{
+			name:     "no_ban_list",
+			noBans:   true,
+			steps:    []nodetest.Step{nodetest.SendRaw(nodetest.EncodeFrame(0xdeadbeef, model.SendHeadersCMD, nil))},
+			expScore: 100,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			defer cancel()
+
+			// the fee filter is sent at the end, so once it's stored all violations are handled
+			steps := append(nodetest.Handshake(), tc.steps...)
+			steps = append(steps, nodetest.SendMessage(model.FeeFilterCMD, feeFilterPayload(4242)))
+			peer := nodetest.NewPeer(steps...).SetExpectTimeout(time.Second)
+
+			var (
+				mu           sync.Mutex
+				disconnected bool
+			)
+			cli, err := client.NewBitcoinClient("127.0.0.1", 18333, func(string, int) (client.Connection, error) {
+				return peer.Pipe(), nil
+			},
+				client.WithReconnectPolicy(client.ReconnectPolicy{MaxAttempts: 1, InitialDelay: time.Hour}),
+				client.WithEventHandler(func(event model.ConnectionEvent) {
+					mu.Lock()
+					defer mu.Unlock()
+					disconnected = disconnected || event.State == model.ConnectionStateDisconnected
+				}),
+			)
+			require.NoError(t, err)
+
+			policy := tc.policy
+			if policy.Threshold == 0 {
+				policy = DefaultBanPolicy()
+			}
+			banList := &banListStub{}
+			var opts []Option
+			if !tc.noBans {
+				opts = append(opts, WithBanList(banList, policy))
+			}
+			c := New(service.NewDecodeService(), service.NewEncodeService(), service.NewMessageGenerator(), cli, opts...)
+			c.ReceiveMessages(ctx)
+			_, err = c.Handshake(ctx)
+			require.NoError(t, err)
+
+			if len(tc.expBans) > 0 {
+				assert.Eventually(t, func() bool { return len(banList.Bans()) > 0 }, time.Second, time.Millisecond)
+				assert.Equal(t, tc.expBans, banList.Bans())
+				assert.Equal(t, tc.expScore, c.MisbehaviorScore())
+				assert.Eventually(t, func() bool {
+					mu.Lock()
+					defer mu.Unlock()
+					return disconnected
+				}, time.Second, time.Millisecond)
+				return
+			}
+
+			assert.Eventually(t, func() bool { return c.PeerFeatures().FeeFilter == 4242 }, time.Second, time.Millisecond)
+			assert.Equal(t, tc.expScore, c.MisbehaviorScore())
+			assert.Empty(t, banList.Bans())
+		})
+	}
+}
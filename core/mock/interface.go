@@ -13,7 +13,9 @@ import (
 	bytes "bytes"
 	context "context"
 	io "io"
+	net "net"
 	reflect "reflect"
+	time "time"
 
 	model "github.com/senseyman/bitcoin-handshake/model"
 	gomock "go.uber.org/mock/gomock"
@@ -42,6 +44,66 @@ func (m *MockDecoder) EXPECT() *MockDecoderMockRecorder {
 	return m.recorder
 }
 
+// DecodeAddrMessage mocks base method.
+func (m *MockDecoder) DecodeAddrMessage(r io.Reader) (model.AddrMessage, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DecodeAddrMessage", r)
+	ret0, _ := ret[0].(model.AddrMessage)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// DecodeAddrMessage indicates an expected call of DecodeAddrMessage.
+func (mr *MockDecoderMockRecorder) DecodeAddrMessage(r any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DecodeAddrMessage", reflect.TypeOf((*MockDecoder)(nil).DecodeAddrMessage), r)
+}
+
+// DecodeAddrV2Message mocks base method.
+func (m *MockDecoder) DecodeAddrV2Message(r io.Reader) (model.AddrV2Message, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DecodeAddrV2Message", r)
+	ret0, _ := ret[0].(model.AddrV2Message)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// DecodeAddrV2Message indicates an expected call of DecodeAddrV2Message.
+func (mr *MockDecoderMockRecorder) DecodeAddrV2Message(r any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DecodeAddrV2Message", reflect.TypeOf((*MockDecoder)(nil).DecodeAddrV2Message), r)
+}
+
+// DecodeBlockLocatorMessage mocks base method.
+func (m *MockDecoder) DecodeBlockLocatorMessage(r io.Reader) (model.BlockLocatorMessage, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DecodeBlockLocatorMessage", r)
+	ret0, _ := ret[0].(model.BlockLocatorMessage)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// DecodeBlockLocatorMessage indicates an expected call of DecodeBlockLocatorMessage.
+func (mr *MockDecoderMockRecorder) DecodeBlockLocatorMessage(r any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DecodeBlockLocatorMessage", reflect.TypeOf((*MockDecoder)(nil).DecodeBlockLocatorMessage), r)
+}
+
+// DecodeCmpctBlockMessage mocks base method.
+func (m *MockDecoder) DecodeCmpctBlockMessage(r io.Reader) (model.CmpctBlockMessage, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DecodeCmpctBlockMessage", r)
+	ret0, _ := ret[0].(model.CmpctBlockMessage)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// DecodeCmpctBlockMessage indicates an expected call of DecodeCmpctBlockMessage.
+func (mr *MockDecoderMockRecorder) DecodeCmpctBlockMessage(r any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DecodeCmpctBlockMessage", reflect.TypeOf((*MockDecoder)(nil).DecodeCmpctBlockMessage), r)
+}
+
 // DecodeElements mocks base method.
 func (m *MockDecoder) DecodeElements(r io.Reader, elements ...any) error {
 	m.ctrl.T.Helper()
@@ -61,6 +123,66 @@ func (mr *MockDecoderMockRecorder) DecodeElements(r any, elements ...any) *gomoc
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DecodeElements", reflect.TypeOf((*MockDecoder)(nil).DecodeElements), varargs...)
 }
 
+// DecodeFeeFilterMessage mocks base method.
+func (m *MockDecoder) DecodeFeeFilterMessage(r io.Reader) (model.FeeFilterMessage, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DecodeFeeFilterMessage", r)
+	ret0, _ := ret[0].(model.FeeFilterMessage)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// DecodeFeeFilterMessage indicates an expected call of DecodeFeeFilterMessage.
+func (mr *MockDecoderMockRecorder) DecodeFeeFilterMessage(r any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DecodeFeeFilterMessage", reflect.TypeOf((*MockDecoder)(nil).DecodeFeeFilterMessage), r)
+}
+
+// DecodeHeadersMessage mocks base method.
+func (m *MockDecoder) DecodeHeadersMessage(r io.Reader) (model.HeadersMessage, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DecodeHeadersMessage", r)
+	ret0, _ := ret[0].(model.HeadersMessage)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// DecodeHeadersMessage indicates an expected call of DecodeHeadersMessage.
+func (mr *MockDecoderMockRecorder) DecodeHeadersMessage(r any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DecodeHeadersMessage", reflect.TypeOf((*MockDecoder)(nil).DecodeHeadersMessage), r)
+}
+
+// DecodeInvMessage mocks base method.
+func (m *MockDecoder) DecodeInvMessage(r io.Reader) (model.InvMessage, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DecodeInvMessage", r)
+	ret0, _ := ret[0].(model.InvMessage)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// DecodeInvMessage indicates an expected call of DecodeInvMessage.
+func (mr *MockDecoderMockRecorder) DecodeInvMessage(r any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DecodeInvMessage", reflect.TypeOf((*MockDecoder)(nil).DecodeInvMessage), r)
+}
+
+// DecodeVersionMessage mocks base method.
+func (m *MockDecoder) DecodeVersionMessage(r io.Reader) (model.VersionMessage, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DecodeVersionMessage", r)
+	ret0, _ := ret[0].(model.VersionMessage)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// DecodeVersionMessage indicates an expected call of DecodeVersionMessage.
+func (mr *MockDecoderMockRecorder) DecodeVersionMessage(r any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DecodeVersionMessage", reflect.TypeOf((*MockDecoder)(nil).DecodeVersionMessage), r)
+}
+
 // MockEncoder is a mock of Encoder interface.
 type MockEncoder struct {
 	ctrl     *gomock.Controller
@@ -84,6 +206,48 @@ func (m *MockEncoder) EXPECT() *MockEncoderMockRecorder {
 	return m.recorder
 }
 
+// EncodeAddrMessage mocks base method.
+func (m *MockEncoder) EncodeAddrMessage(w io.Writer, msg model.AddrMessage) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "EncodeAddrMessage", w, msg)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// EncodeAddrMessage indicates an expected call of EncodeAddrMessage.
+func (mr *MockEncoderMockRecorder) EncodeAddrMessage(w, msg any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "EncodeAddrMessage", reflect.TypeOf((*MockEncoder)(nil).EncodeAddrMessage), w, msg)
+}
+
+// EncodeAddrV2Message mocks base method.
+func (m *MockEncoder) EncodeAddrV2Message(w io.Writer, msg model.AddrV2Message) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "EncodeAddrV2Message", w, msg)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// EncodeAddrV2Message indicates an expected call of EncodeAddrV2Message.
+func (mr *MockEncoderMockRecorder) EncodeAddrV2Message(w, msg any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "EncodeAddrV2Message", reflect.TypeOf((*MockEncoder)(nil).EncodeAddrV2Message), w, msg)
+}
+
+// EncodeBlockLocatorMessage mocks base method.
+func (m *MockEncoder) EncodeBlockLocatorMessage(w io.Writer, msg model.BlockLocatorMessage) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "EncodeBlockLocatorMessage", w, msg)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// EncodeBlockLocatorMessage indicates an expected call of EncodeBlockLocatorMessage.
+func (mr *MockEncoderMockRecorder) EncodeBlockLocatorMessage(w, msg any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "EncodeBlockLocatorMessage", reflect.TypeOf((*MockEncoder)(nil).EncodeBlockLocatorMessage), w, msg)
+}
+
 // EncodeElements mocks base method.
 func (m *MockEncoder) EncodeElements(w io.Writer, elements ...any) error {
 	m.ctrl.T.Helper()
@@ -103,6 +267,34 @@ func (mr *MockEncoderMockRecorder) EncodeElements(w any, elements ...any) *gomoc
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "EncodeElements", reflect.TypeOf((*MockEncoder)(nil).EncodeElements), varargs...)
 }
 
+// EncodeHeadersMessage mocks base method.
+func (m *MockEncoder) EncodeHeadersMessage(w io.Writer, msg model.HeadersMessage) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "EncodeHeadersMessage", w, msg)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// EncodeHeadersMessage indicates an expected call of EncodeHeadersMessage.
+func (mr *MockEncoderMockRecorder) EncodeHeadersMessage(w, msg any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "EncodeHeadersMessage", reflect.TypeOf((*MockEncoder)(nil).EncodeHeadersMessage), w, msg)
+}
+
+// EncodeInvMessage mocks base method.
+func (m *MockEncoder) EncodeInvMessage(w io.Writer, msg model.InvMessage) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "EncodeInvMessage", w, msg)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// EncodeInvMessage indicates an expected call of EncodeInvMessage.
+func (mr *MockEncoderMockRecorder) EncodeInvMessage(w, msg any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "EncodeInvMessage", reflect.TypeOf((*MockEncoder)(nil).EncodeInvMessage), w, msg)
+}
+
 // EncodeVersionMessage mocks base method.
 func (m *MockEncoder) EncodeVersionMessage(w io.Writer, msg model.VersionMessage) error {
 	m.ctrl.T.Helper()
@@ -177,6 +369,18 @@ func (m *MockClient) EXPECT() *MockClientMockRecorder {
 	return m.recorder
 }
 
+// Disconnect mocks base method.
+func (m *MockClient) Disconnect(reason error) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "Disconnect", reason)
+}
+
+// Disconnect indicates an expected call of Disconnect.
+func (mr *MockClientMockRecorder) Disconnect(reason any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Disconnect", reflect.TypeOf((*MockClient)(nil).Disconnect), reason)
+}
+
 // GetNodeHost mocks base method.
 func (m *MockClient) GetNodeHost() string {
 	m.ctrl.T.Helper()
@@ -205,6 +409,20 @@ func (mr *MockClientMockRecorder) GetNodePort() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetNodePort", reflect.TypeOf((*MockClient)(nil).GetNodePort))
 }
 
+// LastDial mocks base method.
+func (m *MockClient) LastDial() model.DialTiming {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "LastDial")
+	ret0, _ := ret[0].(model.DialTiming)
+	return ret0
+}
+
+// LastDial indicates an expected call of LastDial.
+func (mr *MockClientMockRecorder) LastDial() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LastDial", reflect.TypeOf((*MockClient)(nil).LastDial))
+}
+
 // ReceiveMsg mocks base method.
 func (m *MockClient) ReceiveMsg(ctx context.Context, headerReadFn func(*bytes.Reader) (model.MessageHeader, error), payloadReadFn func(*bytes.Reader, model.MessageHeader) (any, error), receiveCh chan model.MessageFromNode) {
 	m.ctrl.T.Helper()
@@ -231,3 +449,453 @@ func (mr *MockClientMockRecorder) Write(p any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Write", reflect.TypeOf((*MockClient)(nil).Write), p)
 }
+
+// MockAddrBook is a mock of AddrBook interface.
+type MockAddrBook struct {
+	ctrl     *gomock.Controller
+	recorder *MockAddrBookMockRecorder
+}
+
+// MockAddrBookMockRecorder is the mock recorder for MockAddrBook.
+type MockAddrBookMockRecorder struct {
+	mock *MockAddrBook
+}
+
+// NewMockAddrBook creates a new mock instance.
+func NewMockAddrBook(ctrl *gomock.Controller) *MockAddrBook {
+	mock := &MockAddrBook{ctrl: ctrl}
+	mock.recorder = &MockAddrBookMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockAddrBook) EXPECT() *MockAddrBookMockRecorder {
+	return m.recorder
+}
+
+// Add mocks base method.
+func (m *MockAddrBook) Add(addrs []model.NetAddressV2, source model.NetAddressV2) int {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Add", addrs, source)
+	ret0, _ := ret[0].(int)
+	return ret0
+}
+
+// Add indicates an expected call of Add.
+func (mr *MockAddrBookMockRecorder) Add(addrs, source any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Add", reflect.TypeOf((*MockAddrBook)(nil).Add), addrs, source)
+}
+
+// MockAddrSource is a mock of AddrSource interface.
+type MockAddrSource struct {
+	ctrl     *gomock.Controller
+	recorder *MockAddrSourceMockRecorder
+}
+
+// MockAddrSourceMockRecorder is the mock recorder for MockAddrSource.
+type MockAddrSourceMockRecorder struct {
+	mock *MockAddrSource
+}
+
+// NewMockAddrSource creates a new mock instance.
+func NewMockAddrSource(ctrl *gomock.Controller) *MockAddrSource {
+	mock := &MockAddrSource{ctrl: ctrl}
+	mock.recorder = &MockAddrSourceMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockAddrSource) EXPECT() *MockAddrSourceMockRecorder {
+	return m.recorder
+}
+
+// Addresses mocks base method.
+func (m *MockAddrSource) Addresses(network model.NetworkID) []model.NetAddressV2 {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Addresses", network)
+	ret0, _ := ret[0].([]model.NetAddressV2)
+	return ret0
+}
+
+// Addresses indicates an expected call of Addresses.
+func (mr *MockAddrSourceMockRecorder) Addresses(network any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Addresses", reflect.TypeOf((*MockAddrSource)(nil).Addresses), network)
+}
+
+// MockAnnouncementRecorder is a mock of AnnouncementRecorder interface.
+type MockAnnouncementRecorder struct {
+	ctrl     *gomock.Controller
+	recorder *MockAnnouncementRecorderMockRecorder
+}
+
+// MockAnnouncementRecorderMockRecorder is the mock recorder for MockAnnouncementRecorder.
+type MockAnnouncementRecorderMockRecorder struct {
+	mock *MockAnnouncementRecorder
+}
+
+// NewMockAnnouncementRecorder creates a new mock instance.
+func NewMockAnnouncementRecorder(ctrl *gomock.Controller) *MockAnnouncementRecorder {
+	mock := &MockAnnouncementRecorder{ctrl: ctrl}
+	mock.recorder = &MockAnnouncementRecorderMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockAnnouncementRecorder) EXPECT() *MockAnnouncementRecorderMockRecorder {
+	return m.recorder
+}
+
+// Announced mocks base method.
+func (m *MockAnnouncementRecorder) Announced(peer string, inv model.InvVect, at time.Time) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "Announced", peer, inv, at)
+}
+
+// Announced indicates an expected call of Announced.
+func (mr *MockAnnouncementRecorderMockRecorder) Announced(peer, inv, at any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Announced", reflect.TypeOf((*MockAnnouncementRecorder)(nil).Announced), peer, inv, at)
+}
+
+// MockChainTracker is a mock of ChainTracker interface.
+type MockChainTracker struct {
+	ctrl     *gomock.Controller
+	recorder *MockChainTrackerMockRecorder
+}
+
+// MockChainTrackerMockRecorder is the mock recorder for MockChainTracker.
+type MockChainTrackerMockRecorder struct {
+	mock *MockChainTracker
+}
+
+// NewMockChainTracker creates a new mock instance.
+func NewMockChainTracker(ctrl *gomock.Controller) *MockChainTracker {
+	mock := &MockChainTracker{ctrl: ctrl}
+	mock.recorder = &MockChainTrackerMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockChainTracker) EXPECT() *MockChainTrackerMockRecorder {
+	return m.recorder
+}
+
+// AddHeaders mocks base method.
+func (m *MockChainTracker) AddHeaders(peer string, headers []model.BlockHeader) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AddHeaders", peer, headers)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// AddHeaders indicates an expected call of AddHeaders.
+func (mr *MockChainTrackerMockRecorder) AddHeaders(peer, headers any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddHeaders", reflect.TypeOf((*MockChainTracker)(nil).AddHeaders), peer, headers)
+}
+
+// Has mocks base method.
+func (m *MockChainTracker) Has(hash [32]byte) bool {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Has", hash)
+	ret0, _ := ret[0].(bool)
+	return ret0
+}
+
+// Has indicates an expected call of Has.
+func (mr *MockChainTrackerMockRecorder) Has(hash any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Has", reflect.TypeOf((*MockChainTracker)(nil).Has), hash)
+}
+
+// Locator mocks base method.
+func (m *MockChainTracker) Locator() [][32]byte {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Locator")
+	ret0, _ := ret[0].([][32]byte)
+	return ret0
+}
+
+// Locator indicates an expected call of Locator.
+func (mr *MockChainTrackerMockRecorder) Locator() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Locator", reflect.TypeOf((*MockChainTracker)(nil).Locator))
+}
+
+// MockMetrics is a mock of Metrics interface.
+type MockMetrics struct {
+	ctrl     *gomock.Controller
+	recorder *MockMetricsMockRecorder
+}
+
+// MockMetricsMockRecorder is the mock recorder for MockMetrics.
+type MockMetricsMockRecorder struct {
+	mock *MockMetrics
+}
+
+// NewMockMetrics creates a new mock instance.
+func NewMockMetrics(ctrl *gomock.Controller) *MockMetrics {
+	mock := &MockMetrics{ctrl: ctrl}
+	mock.recorder = &MockMetricsMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockMetrics) EXPECT() *MockMetricsMockRecorder {
+	return m.recorder
+}
+
+// HandshakeFailed mocks base method.
+func (m *MockMetrics) HandshakeFailed(err error) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "HandshakeFailed", err)
+}
+
+// HandshakeFailed indicates an expected call of HandshakeFailed.
+func (mr *MockMetricsMockRecorder) HandshakeFailed(err any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "HandshakeFailed", reflect.TypeOf((*MockMetrics)(nil).HandshakeFailed), err)
+}
+
+// HandshakeStarted mocks base method.
+func (m *MockMetrics) HandshakeStarted() {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "HandshakeStarted")
+}
+
+// HandshakeStarted indicates an expected call of HandshakeStarted.
+func (mr *MockMetricsMockRecorder) HandshakeStarted() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "HandshakeStarted", reflect.TypeOf((*MockMetrics)(nil).HandshakeStarted))
+}
+
+// HandshakeSucceeded mocks base method.
+func (m *MockMetrics) HandshakeSucceeded(stats model.HandshakeStats) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "HandshakeSucceeded", stats)
+}
+
+// HandshakeSucceeded indicates an expected call of HandshakeSucceeded.
+func (mr *MockMetricsMockRecorder) HandshakeSucceeded(stats any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "HandshakeSucceeded", reflect.TypeOf((*MockMetrics)(nil).HandshakeSucceeded), stats)
+}
+
+// MockTimeData is a mock of TimeData interface.
+type MockTimeData struct {
+	ctrl     *gomock.Controller
+	recorder *MockTimeDataMockRecorder
+}
+
+// MockTimeDataMockRecorder is the mock recorder for MockTimeData.
+type MockTimeDataMockRecorder struct {
+	mock *MockTimeData
+}
+
+// NewMockTimeData creates a new mock instance.
+func NewMockTimeData(ctrl *gomock.Controller) *MockTimeData {
+	mock := &MockTimeData{ctrl: ctrl}
+	mock.recorder = &MockTimeDataMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockTimeData) EXPECT() *MockTimeDataMockRecorder {
+	return m.recorder
+}
+
+// Add mocks base method.
+func (m *MockTimeData) Add(peer string, offset time.Duration) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "Add", peer, offset)
+}
+
+// Add indicates an expected call of Add.
+func (mr *MockTimeDataMockRecorder) Add(peer, offset any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Add", reflect.TypeOf((*MockTimeData)(nil).Add), peer, offset)
+}
+
+// MockBanList is a mock of BanList interface.
+type MockBanList struct {
+	ctrl     *gomock.Controller
+	recorder *MockBanListMockRecorder
+}
+
+// MockBanListMockRecorder is the mock recorder for MockBanList.
+type MockBanListMockRecorder struct {
+	mock *MockBanList
+}
+
+// NewMockBanList creates a new mock instance.
+func NewMockBanList(ctrl *gomock.Controller) *MockBanList {
+	mock := &MockBanList{ctrl: ctrl}
+	mock.recorder = &MockBanListMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockBanList) EXPECT() *MockBanListMockRecorder {
+	return m.recorder
+}
+
+// Ban mocks base method.
+func (m *MockBanList) Ban(subnet *net.IPNet, duration time.Duration, reason string) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "Ban", subnet, duration, reason)
+}
+
+// Ban indicates an expected call of Ban.
+func (mr *MockBanListMockRecorder) Ban(subnet, duration, reason any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Ban", reflect.TypeOf((*MockBanList)(nil).Ban), subnet, duration, reason)
+}
+
+// MockBlockStore is a mock of BlockStore interface.
+type MockBlockStore struct {
+	ctrl     *gomock.Controller
+	recorder *MockBlockStoreMockRecorder
+}
+
+// MockBlockStoreMockRecorder is the mock recorder for MockBlockStore.
+type MockBlockStoreMockRecorder struct {
+	mock *MockBlockStore
+}
+
+// NewMockBlockStore creates a new mock instance.
+func NewMockBlockStore(ctrl *gomock.Controller) *MockBlockStore {
+	mock := &MockBlockStore{ctrl: ctrl}
+	mock.recorder = &MockBlockStoreMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockBlockStore) EXPECT() *MockBlockStoreMockRecorder {
+	return m.recorder
+}
+
+// Block mocks base method.
+func (m *MockBlockStore) Block(hash [32]byte, witness bool) ([]byte, bool) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Block", hash, witness)
+	ret0, _ := ret[0].([]byte)
+	ret1, _ := ret[1].(bool)
+	return ret0, ret1
+}
+
+// Block indicates an expected call of Block.
+func (mr *MockBlockStoreMockRecorder) Block(hash, witness any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Block", reflect.TypeOf((*MockBlockStore)(nil).Block), hash, witness)
+}
+
+// Hashes mocks base method.
+func (m *MockBlockStore) Hashes(locator [][32]byte, stop [32]byte, max int) [][32]byte {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Hashes", locator, stop, max)
+	ret0, _ := ret[0].([][32]byte)
+	return ret0
+}
+
+// Hashes indicates an expected call of Hashes.
+func (mr *MockBlockStoreMockRecorder) Hashes(locator, stop, max any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Hashes", reflect.TypeOf((*MockBlockStore)(nil).Hashes), locator, stop, max)
+}
+
+// Headers mocks base method.
+func (m *MockBlockStore) Headers(locator [][32]byte, stop [32]byte, max int) []model.BlockHeader {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Headers", locator, stop, max)
+	ret0, _ := ret[0].([]model.BlockHeader)
+	return ret0
+}
+
+// Headers indicates an expected call of Headers.
+func (mr *MockBlockStoreMockRecorder) Headers(locator, stop, max any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Headers", reflect.TypeOf((*MockBlockStore)(nil).Headers), locator, stop, max)
+}
+
+// IsHistorical mocks base method.
+func (m *MockBlockStore) IsHistorical(hash [32]byte) bool {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "IsHistorical", hash)
+	ret0, _ := ret[0].(bool)
+	return ret0
+}
+
+// IsHistorical indicates an expected call of IsHistorical.
+func (mr *MockBlockStoreMockRecorder) IsHistorical(hash any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "IsHistorical", reflect.TypeOf((*MockBlockStore)(nil).IsHistorical), hash)
+}
+
+// Services mocks base method.
+func (m *MockBlockStore) Services() model.ServiceFlag {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Services")
+	ret0, _ := ret[0].(model.ServiceFlag)
+	return ret0
+}
+
+// Services indicates an expected call of Services.
+func (mr *MockBlockStoreMockRecorder) Services() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Services", reflect.TypeOf((*MockBlockStore)(nil).Services))
+}
+
+// Tip mocks base method.
+func (m *MockBlockStore) Tip() (model.BlockHeader, int) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Tip")
+	ret0, _ := ret[0].(model.BlockHeader)
+	ret1, _ := ret[1].(int)
+	return ret0, ret1
+}
+
+// Tip indicates an expected call of Tip.
+func (mr *MockBlockStoreMockRecorder) Tip() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Tip", reflect.TypeOf((*MockBlockStore)(nil).Tip))
+}
+
+// MockUploadTarget is a mock of UploadTarget interface.
+type MockUploadTarget struct {
+	ctrl     *gomock.Controller
+	recorder *MockUploadTargetMockRecorder
+}
+
+// MockUploadTargetMockRecorder is the mock recorder for MockUploadTarget.
+type MockUploadTargetMockRecorder struct {
+	mock *MockUploadTarget
+}
+
+// NewMockUploadTarget creates a new mock instance.
+func NewMockUploadTarget(ctrl *gomock.Controller) *MockUploadTarget {
+	mock := &MockUploadTarget{ctrl: ctrl}
+	mock.recorder = &MockUploadTargetMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockUploadTarget) EXPECT() *MockUploadTargetMockRecorder {
+	return m.recorder
+}
+
+// ServeHistoricalBlocks mocks base method.
+func (m *MockUploadTarget) ServeHistoricalBlocks() bool {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ServeHistoricalBlocks")
+	ret0, _ := ret[0].(bool)
+	return ret0
+}
+
+// ServeHistoricalBlocks indicates an expected call of ServeHistoricalBlocks.
+func (mr *MockUploadTargetMockRecorder) ServeHistoricalBlocks() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ServeHistoricalBlocks", reflect.TypeOf((*MockUploadTarget)(nil).ServeHistoricalBlocks))
+}
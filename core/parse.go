@@ -0,0 +1,18 @@
+package core
+
+import (
+	"bytes"
+
+	"github.com/senseyman/bitcoin-handshake/model"
+)
+
+// ReadHeader parses message header the same way as messages received from the node are parsed.
+// It's meant for tools which work with messages outside of a session, e.g. to inspect captured messages.
+func (c *Core) ReadHeader(reader *bytes.Reader) (model.MessageHeader, error) {
+	return c.readHeader(reader)
+}
+
+// ReadPayload parses message payload the same way as messages received from the node are parsed.
+func (c *Core) ReadPayload(reader *bytes.Reader, header model.MessageHeader) (any, error) {
+	return c.payloadRead(reader, header)
+}
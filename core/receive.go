@@ -9,6 +9,7 @@ import (
 	log "github.com/sirupsen/logrus"
 
 	"github.com/senseyman/bitcoin-handshake/model"
+	"github.com/senseyman/bitcoin-handshake/tracing"
 )
 
 func (c *Core) ReceiveMessages(ctx context.Context) {
@@ -30,49 +31,38 @@ func (c *Core) readHeader(reader *bytes.Reader) (model.MessageHeader, error) {
 func (c *Core) payloadRead(reader *bytes.Reader, header model.MessageHeader) (any, error) {
 	switch header.Command {
 	case model.VersionCMD:
-		versionMsg := model.VersionMessage{}
-		err := c.decoder.DecodeElements(
-			reader,
-			&versionMsg.Version,
-			&versionMsg.Services,
-			&versionMsg.Timestamp,
-			&versionMsg.AddrFrom,
-			&versionMsg.AddrRecv,
-			&versionMsg.Nonce,
-			&versionMsg.UserAgent,
-			&versionMsg.StartHeight,
-			&versionMsg.Relay,
-		)
-		return versionMsg, err
+		return c.decoder.DecodeVersionMessage(reader)
 	case model.VerackCMD:
 		return model.EmptyMessage{}, nil
+	case model.AddrCMD:
+		return c.decoder.DecodeAddrMessage(reader)
+	case model.AddrV2CMD:
+		return c.decoder.DecodeAddrV2Message(reader)
+	case model.SendHeadersCMD, model.WtxidRelayCMD, model.SendAddrV2CMD, model.GetAddrCMD:
+		return model.EmptyMessage{}, nil
+	case model.FeeFilterCMD:
+		return c.decoder.DecodeFeeFilterMessage(reader)
+	case model.HeadersCMD:
+		return c.decoder.DecodeHeadersMessage(reader)
+	case model.GetHeadersCMD, model.GetBlocksCMD:
+		return c.decoder.DecodeBlockLocatorMessage(reader)
+	case model.InvCMD, model.GetDataCMD, model.NotFoundCMD:
+		return c.decoder.DecodeInvMessage(reader)
+	case model.CmpctBlockCMD:
+		return c.decoder.DecodeCmpctBlockMessage(reader)
 	}
 
-	return nil, fmt.Errorf("unknown command, can't parse payload: %s", header.Command)
+	return nil, fmt.Errorf("%w, can't parse payload: %s", model.ErrUnknownCommand, header.Command)
 }
 
-func (c *Core) listenReceiveChannel(ctx context.Context, versionMsgLockCh, verackMsgLockCh chan struct{}) {
+func (c *Core) listenReceiveChannel(ctx context.Context) {
 	receiveCh := c.GetReceiveChannel()
 
 	log.Info("Starting listening incoming messages from node...")
 	for {
 		select {
 		case msg := <-receiveCh:
-			if msg.Error != nil {
-				log.Errorf("got invalid message: %v", *msg.Error)
-			}
-			if msg.Header.Command == model.VersionCMD {
-				log.Info("got version message")
-				log.Infof("%+v\n", msg)
-				versionMsgLockCh <- struct{}{}
-			}
-			if msg.Header.Command == model.VerackCMD {
-				log.Info("got verack message")
-				log.Infof("%+v\n", msg)
-				verackMsgLockCh <- struct{}{}
-				return
-			}
-			log.Info(msg)
+			c.handleMessage(msg)
 		case <-ctx.Done():
 			log.Warn("stopping listening messages from node by timeout")
 			return
@@ -80,51 +70,194 @@ func (c *Core) listenReceiveChannel(ctx context.Context, versionMsgLockCh, verac
 	}
 }
 
+func (c *Core) handleMessage(msg model.MessageFromNode) {
+	if msg.Error != nil {
+		c.handleInvalidMessage(msg)
+		return
+	}
+
+	switch msg.Header.Command {
+	case model.VersionCMD:
+		log.Info("got version message")
+		log.Infof("%+v\n", msg)
+		if c.receivedTwice(&c.versionReceived) {
+			c.Misbehaving(ViolationUnsolicited, "duplicated version message")
+			return
+		}
+		c.setRemoteVersion(msg)
+		if !c.notifyHandshake(func(w *handshakeWaiter) chan struct{} { return w.versionCh }) {
+			c.Misbehaving(ViolationUnsolicited, "version message outside of handshake")
+		}
+		return
+	case model.VerackCMD:
+		log.Info("got verack message")
+		log.Infof("%+v\n", msg)
+		if c.receivedTwice(&c.verackReceived) {
+			c.Misbehaving(ViolationUnsolicited, "duplicated verack message")
+			return
+		}
+		if !c.notifyHandshake(func(w *handshakeWaiter) chan struct{} { return w.verackCh }) {
+			c.Misbehaving(ViolationUnsolicited, "verack message outside of handshake")
+		}
+		return
+	case model.AddrCMD, model.AddrV2CMD:
+		c.storeAddresses(msg)
+	case model.SendHeadersCMD, model.FeeFilterCMD, model.WtxidRelayCMD, model.SendAddrV2CMD:
+		if !c.handleFeature(msg) {
+			c.Misbehaving(ViolationUnsolicited, fmt.Sprintf("%s message after verack", msg.Header.Command))
+			return
+		}
+	case model.HeadersCMD:
+		if !c.checkHeaders(msg) {
+			return
+		}
+		c.recordAnnouncements(msg)
+		c.followHeaders(msg)
+	case model.InvCMD:
+		c.recordAnnouncements(msg)
+		c.followInv(msg)
+	case model.CmpctBlockCMD:
+		c.recordAnnouncements(msg)
+	case model.GetAddrCMD:
+		c.answerGetAddr()
+	case model.GetHeadersCMD, model.GetBlocksCMD, model.GetDataCMD:
+		if err := c.serve(msg); err != nil {
+			log.Errorf("err serving %s message: %v", msg.Header.Command, err)
+		}
+	}
+	log.Info(msg)
+
+	c.deliver(msg)
+}
+
+// Listen starts processing the messages from the node until ctx is done, ctx is the context of the whole session,
+// it's used for handshakes after reconnects too. If it isn't called, the session is bound to the context of the
+// first handshake.
+func (c *Core) Listen(ctx context.Context) {
+	c.listenOnce.Do(func() {
+		c.mu.Lock()
+		c.sessionCtx = ctx
+		c.mu.Unlock()
+		go c.listenReceiveChannel(ctx)
+		if c.localAddr != nil && c.advertiseInterval > 0 {
+			go c.advertiseSelfLoop(ctx)
+		}
+	})
+}
+
 func (c *Core) Handshake(ctx context.Context) (int64, error) {
-	versionMsgLockCh := make(chan struct{})
-	verackMsgLockCh := make(chan struct{})
+	waiter := c.startHandshake()
 
-	// go routing for processing messages from node
-	go c.listenReceiveChannel(ctx, versionMsgLockCh, verackMsgLockCh)
+	// go routing for processing messages from node, unless the session is already started
+	c.Listen(ctx)
+
+	if c.metrics != nil {
+		c.metrics.HandshakeStarted()
+	}
 
 	handshakeStartTime := time.Now()
-	err := c.sendHandshakeMessages(ctx, versionMsgLockCh, verackMsgLockCh)
+	spanCtx, span := c.startHandshakeSpan(ctx)
+	defer span.End()
+
+	stats, err := c.sendHandshakeMessages(spanCtx, waiter)
 	if err != nil {
+		span.RecordError(err)
+		if c.metrics != nil {
+			c.metrics.HandshakeFailed(err)
+		}
 		return 0, err
 	}
+	stats.Total = time.Since(handshakeStartTime)
+	stats = c.finishHandshake(stats)
+	span.SetAttributes(c.remoteVersionAttributes()...)
+	if c.timeData != nil {
+		c.timeData.Add(c.peerAddress(), stats.TimeOffset)
+	}
+	c.advertiseSelf()
+	c.requestAddresses()
+	c.requestTipHeaders()
+	if c.metrics != nil {
+		c.metrics.HandshakeSucceeded(stats)
+	}
 
-	return time.Since(handshakeStartTime).Milliseconds(), nil
+	return stats.Total.Milliseconds(), nil
 }
 
-func (c *Core) sendHandshakeMessages(ctx context.Context, versionMsgLockCh, verackMsgLockCh chan struct{}) error {
+func (c *Core) sendHandshakeMessages(
+	ctx context.Context,
+	waiter *handshakeWaiter,
+) (model.HandshakeStats, error) {
+	var stats model.HandshakeStats
+
 	// sending version message to node. This it the first mandatory message we need to send to start our handshake process
-	if err := c.SendVersionMessage(); err != nil {
+	versionSentAt := time.Now()
+	_, span := c.tracer.Start(ctx, SpanVersionSend, tracing.Int(AttrProtocolVersion, model.ProtocolVersion))
+	err := c.SendVersionMessage()
+	span.RecordError(err)
+	span.End()
+	if err != nil {
 		log.Errorf("err sending version message to node: %v", err)
-		return err
+		return stats, err
 	}
+
+	_, span = c.tracer.Start(ctx, SpanVersionReceive)
 	select {
 	// if we receive version message from node after our one, we can continue with sending verack message
-	case <-versionMsgLockCh:
+	case <-waiter.versionCh:
+		stats.VersionRTT = time.Since(versionSentAt)
+		span.SetAttributes(c.remoteVersionAttributes()...)
+		span.End()
 		log.Info("version message received successfully, trying to send verack message")
 	case <-ctx.Done():
+		span.RecordError(model.ErrContextTimeout)
+		span.End()
 		log.Warn("stopping sending version message by context cancel")
-		return model.ErrContextTimeout
+		return stats, model.ErrContextTimeout
+	case err := <-waiter.gaveUpCh:
+		span.RecordError(err)
+		span.End()
+		return stats, err
+	}
+
+	// features negotiated before verack have to be sent between version and verack
+	if err := c.sendPreVerackFeatures(); err != nil {
+		log.Errorf("err sending features to node: %v", err)
+		return stats, err
 	}
 
 	// sending verack message to node. This it the second mandatory message we need to send to start our handshake process
-	if err := c.SendVerackMessage(); err != nil {
+	verackSentAt := time.Now()
+	_, span = c.tracer.Start(ctx, SpanVerackSend)
+	err = c.SendVerackMessage()
+	span.RecordError(err)
+	span.End()
+	if err != nil {
 		log.Errorf("err sending verack message to node: %v", err)
-		return err
+		return stats, err
 	}
 
+	_, span = c.tracer.Start(ctx, SpanVerackReceive)
 	select {
 	// if we receive verack message from node after our one, our handshake is finished
-	case <-verackMsgLockCh:
+	case <-waiter.verackCh:
+		stats.VerackRTT = time.Since(verackSentAt)
+		span.End()
 		log.Info("verack message received successfully")
 	case <-ctx.Done():
+		span.RecordError(model.ErrContextTimeout)
+		span.End()
 		log.Warn("stopping sending verack message by context cancel")
-		return model.ErrContextTimeout
+		return stats, model.ErrContextTimeout
+	case err := <-waiter.gaveUpCh:
+		span.RecordError(err)
+		span.End()
+		return stats, err
+	}
+
+	if err := c.sendPostVerackFeatures(); err != nil {
+		log.Errorf("err sending features to node: %v", err)
+		return stats, err
 	}
 
-	return nil
+	return stats, nil
 }
@@ -0,0 +1,113 @@
+package core
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/senseyman/bitcoin-handshake/model"
+	"github.com/senseyman/bitcoin-handshake/nodetest"
+	"github.com/senseyman/bitcoin-handshake/service"
+)
+
+func FuzzCore_readHeader(f *testing.F) {
+	for _, command := range nodetest.CapturedCommands() {
+		frame, _ := nodetest.CapturedFrame(command)
+		f.Add(frame[:24])
+	}
+
+	c := New(service.NewDecodeService(), nil, nil, nil)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		hdr, err := c.readHeader(bytes.NewReader(data))
+		if err != nil {
+			return
+		}
+
+		frame := nodetest.EncodeFrame(hdr.Magic, hdr.Command, nil)
+		decoded, err := c.readHeader(bytes.NewReader(frame))
+		require.NoError(t, err)
+		assert.Equal(t, hdr.Magic, decoded.Magic)
+		assert.Equal(t, hdr.Command, decoded.Command)
+	})
+}
+
+func FuzzCore_payloadRead(f *testing.F) {
+	for _, command := range nodetest.CapturedCommands() {
+		payload, _ := nodetest.CapturedPayload(command)
+		f.Add(command, payload)
+	}
+
+	c := New(service.NewDecodeService(), nil, nil, nil)
+	encoder := service.NewEncodeService()
+
+	var headers bytes.Buffer
+	require.NoError(f, encoder.EncodeHeadersMessage(&headers, model.HeadersMessage{
+		Headers: []model.BlockHeader{model.TestNetGenesis()},
+	}))
+	f.Add(model.HeadersCMD, headers.Bytes())
+	f.Add(model.HeadersCMD, []byte{0x00})
+
+	var locator bytes.Buffer
+	require.NoError(f, encoder.EncodeBlockLocatorMessage(&locator, model.BlockLocatorMessage{
+		Version: model.ProtocolVersion,
+		Locator: [][32]byte{model.TestNetGenesis().Hash()},
+	}))
+	var inv bytes.Buffer
+	require.NoError(f, encoder.EncodeInvMessage(&inv, model.InvMessage{
+		Inventory: []model.InvVect{{Type: model.InvTypeBlock, Hash: model.TestNetGenesis().Hash()}},
+	}))
+	for _, command := range []string{model.GetHeadersCMD, model.GetBlocksCMD} {
+		f.Add(command, locator.Bytes())
+	}
+	for _, command := range []string{model.InvCMD, model.GetDataCMD, model.NotFoundCMD} {
+		f.Add(command, inv.Bytes())
+		f.Add(command, []byte{0x00})
+	}
+
+	// header, nonce and empty short ids and prefilled txs
+	genesis := model.TestNetGenesis()
+	var cmpctBlock bytes.Buffer
+	require.NoError(f, encoder.EncodeElements(&cmpctBlock, genesis.Version, genesis.PrevBlock, genesis.MerkleRoot,
+		genesis.Timestamp, genesis.Bits, genesis.Nonce, uint64(0), uint8(0), uint8(0)))
+	f.Add(model.CmpctBlockCMD, cmpctBlock.Bytes())
+
+	f.Fuzz(func(t *testing.T, command string, payload []byte) {
+		hdr := model.MessageHeader{Command: command, Length: uint32(len(payload))}
+		msg, err := c.payloadRead(bytes.NewReader(payload), hdr)
+		if err != nil {
+			return
+		}
+
+		// decoded message must be encoded back to the payload with the same content
+		var encoded bytes.Buffer
+		switch m := msg.(type) {
+		case model.VersionMessage:
+			require.NoError(t, encoder.EncodeVersionMessage(&encoded, m))
+		case model.AddrMessage:
+			require.NoError(t, encoder.EncodeAddrMessage(&encoded, m))
+		case model.AddrV2Message:
+			require.NoError(t, encoder.EncodeAddrV2Message(&encoded, m))
+		case model.HeadersMessage:
+			require.NoError(t, encoder.EncodeHeadersMessage(&encoded, m))
+		case model.BlockLocatorMessage:
+			require.NoError(t, encoder.EncodeBlockLocatorMessage(&encoded, m))
+		case model.InvMessage:
+			require.NoError(t, encoder.EncodeInvMessage(&encoded, m))
+		case model.CmpctBlockMessage:
+			h := m.Header
+			require.NoError(t, encoder.EncodeElements(&encoded, h.Version, h.PrevBlock, h.MerkleRoot, h.Timestamp,
+				h.Bits, h.Nonce))
+		case model.FeeFilterMessage:
+			require.NoError(t, encoder.EncodeElements(&encoded, m.FeeRate))
+		case model.EmptyMessage:
+		default:
+			t.Fatalf("unexpected message type %T", msg)
+		}
+
+		decoded, err := c.payloadRead(bytes.NewReader(encoded.Bytes()), hdr)
+		require.NoError(t, err)
+		assert.Equal(t, msg, decoded)
+	})
+}
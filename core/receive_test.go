@@ -114,7 +114,7 @@ func TestCore_Handshake(t *testing.T) {
 		t.Run(tc.name, func(t *testing.T) {
 			t.Parallel()
 
-			ctx, cancel := context.WithTimeout(context.Background(), time.Second*6)
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
 			defer cancel()
 
 			c := tc.init(t, tc.fail, remoteHost, remotePort, localHost, locaPort, versionMsg)
@@ -149,13 +149,13 @@ func prepareMocks(t *testing.T, fail failType,
 		mockSendVersionMessage(client, encoder, generator, remoteHost, remotePort, localHost, locaPort, versionMsg, fail)
 		mockSendVerackMessage(client, encoder, fail)
 		go func() {
-			time.Sleep(time.Second * 2)
+			time.Sleep(20 * time.Millisecond)
 			recCh <- model.MessageFromNode{
 				Header: model.MessageHeader{Command: model.VersionCMD},
 			}
 		}()
 		go func() {
-			time.Sleep(time.Second * 4)
+			time.Sleep(40 * time.Millisecond)
 			recCh <- model.MessageFromNode{
 				Header: model.MessageHeader{Command: model.VerackCMD},
 			}
@@ -164,7 +164,7 @@ func prepareMocks(t *testing.T, fail failType,
 		mockSendVersionMessage(client, encoder, generator, remoteHost, remotePort, localHost, locaPort, versionMsg, fail)
 		mockSendVerackMessage(client, encoder, fail)
 		go func() {
-			time.Sleep(time.Second * 2)
+			time.Sleep(20 * time.Millisecond)
 			recCh <- model.MessageFromNode{
 				Header: model.MessageHeader{Command: model.VersionCMD},
 			}
@@ -18,6 +18,9 @@ func (c *Core) SendVersionMessage() error {
 		c.client.GetNodeHost(), uint16(c.client.GetNodePort()),
 		"127.0.0.1", 0, // ignore it as node will respond us with our correct white IP
 	)
+	// the services are advertised according to the blocks we can serve, peers request the data we claim to have
+	msg.Services, msg.StartHeight = c.localServices()
+	msg.AddrFrom.Services = msg.Services
 	var bw bytes.Buffer
 	if err := c.encoder.EncodeVersionMessage(&bw, msg); err != nil {
 		return err
@@ -54,15 +57,24 @@ func (c *Core) SendVersionMessage() error {
 
 func (c *Core) SendVerackMessage() error {
 	log.Info("sending verack message")
-	var command [model.CommandSize]byte
-	copy(command[:], model.VerackCMD)
+
+	return c.sendMessage(model.VerackCMD, nil)
+}
+
+// sendMessage sends the message with the header, the header is written separately as for version message.
+func (c *Core) sendMessage(command string, payload []byte) error {
+	var cmd [model.CommandSize]byte
+	copy(cmd[:], command)
 
 	hdr := model.MessageHeader{}
 	hdr.Magic = model.TestNetMagic
-	hdr.Command = model.VerackCMD
+	hdr.Command = command
+	hdr.Length = uint32(len(payload))
+	// checksum of empty payload is not zero, nodes drop the message if it's not set
+	copy(hdr.Checksum[:], utils.DoubleHashB(payload)[0:4])
 
 	hw := bytes.NewBuffer(make([]byte, 0, 24))
-	if err := c.encoder.EncodeElements(hw, hdr.Magic, command, hdr.Length, hdr.Checksum); err != nil {
+	if err := c.encoder.EncodeElements(hw, hdr.Magic, cmd, hdr.Length, hdr.Checksum); err != nil {
 		return err
 	}
 
@@ -70,7 +82,16 @@ func (c *Core) SendVerackMessage() error {
 	if err != nil {
 		return err
 	}
-	log.Debugf("sent header, number of byte %d", n)
+	log.Debugf("sent %s header, number of byte %d", command, n)
+
+	if len(payload) == 0 {
+		return nil
+	}
+	n, err = c.client.Write(payload)
+	if err != nil {
+		return err
+	}
+	log.Debugf("sent %s payload, number of byte %d", command, n)
 
 	return nil
 }
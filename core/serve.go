@@ -0,0 +1,157 @@
+package core
+
+import (
+	"bytes"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/senseyman/bitcoin-handshake/model"
+)
+
+// WithBlockStore makes core serve the headers and blocks of the store to the node and advertise the services
+// the store allows. Without the store core advertises no services and every getdata item is not found.
+func WithBlockStore(store BlockStore) Option {
+	return func(c *Core) {
+		c.blockStore = store
+	}
+}
+
+// WithUploadTarget makes core stop serving historical blocks once the upload target is nearly reached, the
+// blocks near the tip are served regardless, as in Bitcoin Core.
+func WithUploadTarget(target UploadTarget) Option {
+	return func(c *Core) {
+		c.uploadTarget = target
+	}
+}
+
+// localServices returns the services and the height which are advertised in the version message.
+func (c *Core) localServices() (model.ServiceFlag, int32) {
+	if c.blockStore == nil {
+		return 0, 0
+	}
+	_, height := c.blockStore.Tip()
+
+	return c.blockStore.Services(), int32(height)
+}
+
+// serve answers getheaders, getblocks and getdata messages of the node from the block store.
+func (c *Core) serve(msg model.MessageFromNode) error {
+	switch msg.Header.Command {
+	case model.GetHeadersCMD:
+		if locator, ok := msg.Payload.(model.BlockLocatorMessage); ok {
+			return c.serveHeaders(locator)
+		}
+	case model.GetBlocksCMD:
+		if locator, ok := msg.Payload.(model.BlockLocatorMessage); ok {
+			return c.serveBlockHashes(locator)
+		}
+	case model.GetDataCMD:
+		if inv, ok := msg.Payload.(model.InvMessage); ok {
+			return c.serveData(inv)
+		}
+	}
+
+	return nil
+}
+
+// serveHeaders replies with headers message, it's sent even if it's empty, so the node knows it's synced with us.
+func (c *Core) serveHeaders(locator model.BlockLocatorMessage) error {
+	if c.blockStore == nil {
+		log.Debug("ignoring getheaders message, there is no block store")
+		return nil
+	}
+
+	headers := c.blockStore.Headers(locator.Locator, locator.HashStop, model.MaxHeadersPerMessage)
+	var payload bytes.Buffer
+	if err := c.encoder.EncodeHeadersMessage(&payload, model.HeadersMessage{Headers: headers}); err != nil {
+		return err
+	}
+	log.Infof("serving %d headers", len(headers))
+
+	return c.sendMessage(model.HeadersCMD, payload.Bytes())
+}
+
+// serveBlockHashes replies with inv message of the stored blocks, nothing is sent if there are none.
+func (c *Core) serveBlockHashes(locator model.BlockLocatorMessage) error {
+	if c.blockStore == nil {
+		log.Debug("ignoring getblocks message, there is no block store")
+		return nil
+	}
+
+	hashes := c.blockStore.Hashes(locator.Locator, locator.HashStop, model.MaxBlocksPerInv)
+	if len(hashes) == 0 {
+		return nil
+	}
+	msg := model.InvMessage{Inventory: make([]model.InvVect, 0, len(hashes))}
+	for _, hash := range hashes {
+		msg.Inventory = append(msg.Inventory, model.InvVect{Type: model.InvTypeBlock, Hash: hash})
+	}
+
+	var payload bytes.Buffer
+	if err := c.encoder.EncodeInvMessage(&payload, msg); err != nil {
+		return err
+	}
+	log.Infof("serving %d block hashes", len(hashes))
+
+	return c.sendMessage(model.InvCMD, payload.Bytes())
+}
+
+// serveData sends the requested blocks, MSG_WITNESS_BLOCK gets the block as it's stored and MSG_BLOCK gets it
+// without the witness data, BIP144. The items which can't be served are listed in a single notfound message, txs
+// are never served.
+func (c *Core) serveData(inv model.InvMessage) error {
+	var notFound []model.InvVect
+	served := 0
+	for _, item := range inv.Inventory {
+		raw, ok := c.block(item)
+		if !ok {
+			notFound = append(notFound, item)
+			continue
+		}
+		if err := c.sendMessage(model.BlockCMD, raw); err != nil {
+			return err
+		}
+		served++
+	}
+	if served > 0 {
+		log.Infof("served %d blocks", served)
+	}
+	if len(notFound) == 0 {
+		return nil
+	}
+
+	var payload bytes.Buffer
+	if err := c.encoder.EncodeInvMessage(&payload, model.InvMessage{Inventory: notFound}); err != nil {
+		return err
+	}
+
+	return c.sendMessage(model.NotFoundCMD, payload.Bytes())
+}
+
+// block returns the requested block if it's stored and the upload target allows to serve it.
+func (c *Core) block(item model.InvVect) ([]byte, bool) {
+	if c.blockStore == nil || (item.Type != model.InvTypeBlock && item.Type != model.InvTypeWitnessBlock) {
+		return nil, false
+	}
+	raw, ok := c.blockStore.Block(item.Hash, item.Type == model.InvTypeWitnessBlock)
+	if !ok {
+		return nil, false
+	}
+	if c.uploadTarget != nil && !c.uploadTarget.ServeHistoricalBlocks() && c.blockStore.IsHistorical(item.Hash) {
+		log.Warnf("not serving historical block %s, upload target is reached", model.HashString(item.Hash))
+		return nil, false
+	}
+
+	return raw, true
+}
+
+// RequestHeaders asks the node for the headers after the first locator hash it knows, up to 2000 of them.
+func (c *Core) RequestHeaders(locator [][32]byte) error {
+	var payload bytes.Buffer
+	msg := model.BlockLocatorMessage{Version: model.ProtocolVersion, Locator: locator}
+	if err := c.encoder.EncodeBlockLocatorMessage(&payload, msg); err != nil {
+		return err
+	}
+
+	return c.sendMessage(model.GetHeadersCMD, payload.Bytes())
+}
@@ -0,0 +1,221 @@
+package core
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/senseyman/bitcoin-handshake/blockstore"
+	"github.com/senseyman/bitcoin-handshake/model"
+	"github.com/senseyman/bitcoin-handshake/nodetest"
+	"github.com/senseyman/bitcoin-handshake/service"
+)
+
+type uploadTargetStub bool
+
+func (t uploadTargetStub) ServeHistoricalBlocks() bool { return bool(t) }
+
+// servingStore returns the store with the chain of 5 blocks three days apart, the blocks from height 2 are stored.
+func servingStore(t *testing.T) (*blockstore.Store, []model.BlockHeader) {
+	var chain []model.BlockHeader
+	var prev [32]byte
+	for i := 0; i <= 5; i++ {
+		h := model.BlockHeader{Version: 4, PrevBlock: prev, Timestamp: 1700000000 + uint32(i)*3*86400, Bits: 0x207fffff}
+		for !h.CheckProofOfWork() {
+			h.Nonce++
+		}
+		chain = append(chain, h)
+		prev = h.Hash()
+	}
+
+	store := blockstore.New(chain[0])
+	_, err := store.AddHeaders(chain[1:])
+	require.NoError(t, err)
+	for _, h := range chain[2:] {
+		require.NoError(t, store.AddBlock(rawServedBlock(h, true)))
+	}
+
+	return store, chain
+}
+
+// rawServedBlock is the block of one segwit tx with one input and output, with the witness data or without it.
+func rawServedBlock(h model.BlockHeader, witness bool) []byte {
+	raw := append(h.Bytes(), 0x01, 0x02, 0x00, 0x00, 0x00)
+	if witness {
+		raw = append(raw, 0x00, 0x01)
+	}
+	raw = append(raw, 0x01)
+	raw = append(raw, bytes.Repeat([]byte{0xaa}, 36)...)
+	raw = append(raw, 0x00, 0xff, 0xff, 0xff, 0xff, 0x01, 0x10, 0x27, 0, 0, 0, 0, 0, 0, 0x00)
+	if witness {
+		raw = append(raw, 0x01, 0x02, 0xbb, 0xbb)
+	}
+
+	return append(raw, 0x00, 0x00, 0x00, 0x00)
+}
+
+func locatorPayload(t *testing.T, locator ...[32]byte) []byte {
+	var payload bytes.Buffer
+	require.NoError(t, service.NewEncodeService().EncodeBlockLocatorMessage(&payload, model.BlockLocatorMessage{
+		Version: model.ProtocolVersion,
+		Locator: locator,
+	}))
+
+	return payload.Bytes()
+}
+
+func invPayload(t *testing.T, inv ...model.InvVect) []byte {
+	var payload bytes.Buffer
+	require.NoError(t, service.NewEncodeService().EncodeInvMessage(&payload, model.InvMessage{Inventory: inv}))
+
+	return payload.Bytes()
+}
+
+func TestCore_Serve(t *testing.T) {
+	store, chain := servingStore(t)
+	unknown := [32]byte{0xff}
+
+	headersPayload := func(headers ...model.BlockHeader) []byte {
+		var payload bytes.Buffer
+		require.NoError(t, service.NewEncodeService().EncodeHeadersMessage(&payload, model.HeadersMessage{Headers: headers}))
+		return payload.Bytes()
+	}
+	frame := func(command string, payload []byte) nodetest.Frame {
+		return nodetest.Frame{Header: model.MessageHeader{Command: command}, Payload: payload}
+	}
+	blockInv := func(h model.BlockHeader) model.InvVect {
+		return model.InvVect{Type: model.InvTypeBlock, Hash: h.Hash()}
+	}
+
+	testCases := []struct {
+		name      string
+		opts      []Option
+		command   string
+		payload   []byte
+		expFrames []nodetest.Frame
+	}{
+		{
+			name:      "getheaders",
+			opts:      []Option{WithBlockStore(store)},
+			command:   model.GetHeadersCMD,
+			payload:   locatorPayload(t, unknown, chain[2].Hash()),
+			expFrames: []nodetest.Frame{frame(model.HeadersCMD, headersPayload(chain[3:]...))},
+		},
+		{
+			name:      "getheaders/synced",
+			opts:      []Option{WithBlockStore(store)},
+			command:   model.GetHeadersCMD,
+			payload:   locatorPayload(t, chain[5].Hash()),
+			expFrames: []nodetest.Frame{frame(model.HeadersCMD, headersPayload())},
+		},
+		{
+			name:    "getheaders/no_store",
+			command: model.GetHeadersCMD,
+			payload: locatorPayload(t, chain[0].Hash()),
+		},
+		{
+			name:    "getblocks",
+			opts:    []Option{WithBlockStore(store)},
+			command: model.GetBlocksCMD,
+			payload: locatorPayload(t, chain[1].Hash()),
+			expFrames: []nodetest.Frame{
+				frame(model.InvCMD, invPayload(t, blockInv(chain[2]), blockInv(chain[3]), blockInv(chain[4]), blockInv(chain[5]))),
+			},
+		},
+		{
+			name:    "getblocks/not_stored",
+			opts:    []Option{WithBlockStore(store)},
+			command: model.GetBlocksCMD,
+			payload: locatorPayload(t, chain[0].Hash()),
+		},
+		{
+			name:    "getdata",
+			opts:    []Option{WithBlockStore(store)},
+			command: model.GetDataCMD,
+			payload: invPayload(t,
+				blockInv(chain[5]),
+				model.InvVect{Type: model.InvTypeWitnessBlock, Hash: chain[4].Hash()},
+				model.InvVect{Type: model.InvTypeTx, Hash: chain[3].Hash()},
+				blockInv(chain[1]),
+			),
+			expFrames: []nodetest.Frame{
+				frame(model.BlockCMD, rawServedBlock(chain[5], false)),
+				frame(model.BlockCMD, rawServedBlock(chain[4], true)),
+				frame(model.NotFoundCMD, invPayload(t, model.InvVect{Type: model.InvTypeTx, Hash: chain[3].Hash()}, blockInv(chain[1]))),
+			},
+		},
+		{
+			name:    "getdata/historical",
+			opts:    []Option{WithBlockStore(store), WithUploadTarget(uploadTargetStub(false))},
+			command: model.GetDataCMD,
+			payload: invPayload(t, blockInv(chain[2]), blockInv(chain[5])),
+			expFrames: []nodetest.Frame{
+				frame(model.BlockCMD, rawServedBlock(chain[5], false)),
+				frame(model.NotFoundCMD, invPayload(t, blockInv(chain[2]))),
+			},
+		},
+		{
+			name:      "getdata/no_store",
+			command:   model.GetDataCMD,
+			payload:   invPayload(t, blockInv(chain[5])),
+			expFrames: []nodetest.Frame{frame(model.NotFoundCMD, invPayload(t, blockInv(chain[5])))},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			steps := append(nodetest.Handshake(), nodetest.SendMessage(tc.command, tc.payload))
+			if len(tc.expFrames) == 0 {
+				steps = append(steps, nodetest.ExpectNothing(50*time.Millisecond))
+			}
+			_, peer := handshakeWithOptions(t, tc.opts, steps...)
+
+			require.Eventually(t, func() bool {
+				return len(peer.Received()) >= 2+len(tc.expFrames)
+			}, time.Second, time.Millisecond, "%v", peer.Commands())
+			frames := peer.Received()[2:]
+			require.Len(t, frames, len(tc.expFrames), "%v", peer.Commands())
+			for i, f := range frames {
+				assert.Equal(t, tc.expFrames[i].Header.Command, f.Header.Command)
+				assert.Equal(t, tc.expFrames[i].Payload, f.Payload, f.Header.Command)
+			}
+		})
+	}
+}
+
+func TestCore_SendVersionMessage_Services(t *testing.T) {
+	store, _ := servingStore(t)
+	full, chain := servingStore(t)
+	require.NoError(t, full.AddBlock(rawServedBlock(chain[0], true)))
+	require.NoError(t, full.AddBlock(rawServedBlock(chain[1], true)))
+
+	testCases := []struct {
+		name        string
+		opts        []Option
+		expServices model.ServiceFlag
+		expHeight   int32
+	}{
+		{name: "no_store"},
+		{name: "partial", opts: []Option{WithBlockStore(store)}, expHeight: 5},
+		{name: "full", opts: []Option{WithBlockStore(full)}, expServices: model.ServiceNodeNetwork | model.ServiceNodeWitness, expHeight: 5},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			_, peer := handshakeWithOptions(t, tc.opts, nodetest.Handshake()...)
+
+			version, err := service.NewDecodeService().DecodeVersionMessage(bytes.NewReader(peer.Received()[0].Payload))
+			require.NoError(t, err)
+			assert.Equal(t, tc.expServices, version.Services)
+			assert.Equal(t, tc.expServices, version.AddrFrom.Services)
+			assert.Equal(t, tc.expHeight, version.StartHeight)
+		})
+	}
+}
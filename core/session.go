@@ -0,0 +1,183 @@
+package core
+
+import (
+	"context"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/senseyman/bitcoin-handshake/model"
+	"github.com/senseyman/bitcoin-handshake/timedata"
+)
+
+const (
+	subscriberChannelSize = 100
+	rehandshakeTimeout    = time.Minute
+)
+
+// handshakeWaiter passes handshake messages from the listening goroutine to the running handshake.
+type handshakeWaiter struct {
+	versionCh chan struct{}
+	verackCh  chan struct{}
+	// gaveUpCh gets the error of the client which stopped reconnecting, the handshake can't be finished then
+	gaveUpCh chan error
+}
+
+func (c *Core) startHandshake() *handshakeWaiter {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.handshakeDone = false
+	// the node doesn't remember the features of the previous connection
+	c.peerFeatures = model.PeerFeatures{}
+	c.wtxidRelaySent, c.wtxidRelayReceived = false, false
+	c.versionReceived, c.verackReceived = false, false
+	c.getAddrReceived = false
+	c.waiter = &handshakeWaiter{
+		versionCh: make(chan struct{}, 1),
+		verackCh:  make(chan struct{}, 1),
+		gaveUpCh:  make(chan error, 1),
+	}
+
+	return c.waiter
+}
+
+// finishHandshake completes stats with the version of the node and returns them.
+func (c *Core) finishHandshake(stats model.HandshakeStats) model.HandshakeStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.handshakeDone = true
+	c.waiter = nil
+	stats.RemoteVersion = c.remoteVersion
+	stats.TimeOffset = c.remoteTimeOffset
+	c.lastHandshake = stats
+
+	return stats
+}
+
+// LastHandshake returns timings of the last successful handshake and the version message received during it.
+func (c *Core) LastHandshake() model.HandshakeStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.lastHandshake
+}
+
+func (c *Core) setRemoteVersion(msg model.MessageFromNode) {
+	version, ok := msg.Payload.(model.VersionMessage)
+	if !ok {
+		return
+	}
+
+	c.mu.Lock()
+	c.remoteVersion = version
+	c.remoteTimeOffset = timedata.Offset(version.Timestamp, time.Now())
+	c.mu.Unlock()
+}
+
+// notifyHandshake passes the handshake message to the running handshake, false is returned if the message
+// is unsolicited, i.e. no handshake is running or the message is duplicated.
+func (c *Core) notifyHandshake(ch func(w *handshakeWaiter) chan struct{}) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.waiter == nil {
+		log.Warn("got handshake message while no handshake is running, skipping")
+		return false
+	}
+
+	select {
+	case ch(c.waiter) <- struct{}{}:
+		return true
+	default:
+		log.Warn("got duplicated handshake message, skipping")
+		return false
+	}
+}
+
+// Subscribe returns a channel which receives all messages from the node after the handshake is done.
+// If the connection is lost, the delivery is paused until the handshake is made again after reconnect.
+func (c *Core) Subscribe() <-chan model.MessageFromNode {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ch := make(chan model.MessageFromNode, subscriberChannelSize)
+	c.subscribers = append(c.subscribers, ch)
+
+	return ch
+}
+
+func (c *Core) deliver(msg model.MessageFromNode) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.handshakeDone {
+		log.Debugf("skipping %s message received before handshake is done", msg.Header.Command)
+		return
+	}
+
+	for _, ch := range c.subscribers {
+		select {
+		case ch <- msg:
+		default:
+			log.Warnf("subscriber is too slow, dropping %s message", msg.Header.Command)
+		}
+	}
+}
+
+// OnConnectionEvent handles connection state changes of the client. After reconnect the handshake
+// is made again, as the node doesn't know anything about the previous connection.
+func (c *Core) OnConnectionEvent(event model.ConnectionEvent) {
+	log.Infof("connection to node is %s", event.State)
+
+	switch event.State {
+	case model.ConnectionStateDisconnected, model.ConnectionStateGaveUp:
+		c.mu.Lock()
+		c.handshakeDone = false
+		// the node sends version, verack and getaddr again on the new connection
+		c.versionReceived, c.verackReceived = false, false
+		c.getAddrReceived = false
+		if event.State == model.ConnectionStateGaveUp && c.waiter != nil {
+			select {
+			case c.waiter.gaveUpCh <- event.Err:
+			default:
+			}
+		}
+		c.mu.Unlock()
+	case model.ConnectionStateConnected:
+		c.mu.Lock()
+		sessionCtx := c.sessionCtx
+		c.mu.Unlock()
+
+		// the first connection is handled by the first handshake
+		if event.Attempt == 0 || sessionCtx == nil {
+			return
+		}
+		go c.rehandshake(sessionCtx)
+	}
+}
+
+func (c *Core) rehandshake(sessionCtx context.Context) {
+	ctx, cancel := context.WithTimeout(sessionCtx, rehandshakeTimeout)
+	defer cancel()
+
+	execTimeMs, err := c.Handshake(ctx)
+	if err != nil {
+		log.Errorf("err while making handshake after reconnect: %v", err)
+		return
+	}
+	log.Infof("handshake after reconnect took %d ms", execTimeMs)
+}
+
+// receivedTwice marks the handshake message as received, true is returned if it's already received during
+// the current connection.
+func (c *Core) receivedTwice(received *bool) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	twice := *received
+	*received = true
+
+	return twice
+}
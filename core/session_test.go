@@ -0,0 +1,116 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+
+	"github.com/senseyman/bitcoin-handshake/client"
+	"github.com/senseyman/bitcoin-handshake/core/mock"
+	"github.com/senseyman/bitcoin-handshake/model"
+	"github.com/senseyman/bitcoin-handshake/nodetest"
+	"github.com/senseyman/bitcoin-handshake/service"
+)
+
+func TestCore_OnConnectionEvent(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	cli := mock.NewMockClient(ctrl)
+	encoder := mock.NewMockEncoder(ctrl)
+	generator := mock.NewMockGenerator(ctrl)
+
+	cli.EXPECT().GetNodeHost().Return("127.0.0.1").Times(2)
+	cli.EXPECT().GetNodePort().Return(8333).Times(2)
+	generator.EXPECT().GenerateNewVersionMessage(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
+		Return(model.VersionMessage{}).Times(2)
+	encoder.EXPECT().EncodeVersionMessage(gomock.Any(), gomock.Any()).Return(nil).Times(2)
+	encoder.EXPECT().EncodeElements(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
+		Return(nil).Times(4)
+	cli.EXPECT().Write(gomock.Any()).Return(0, nil).Times(6)
+
+	c := New(nil, encoder, generator, cli)
+	recCh := c.GetReceiveChannel()
+	subCh := c.Subscribe()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	handshake := func() {
+		// wait for our version message to be sent
+		require.Eventually(t, func() bool {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			return c.waiter != nil
+		}, time.Second, time.Millisecond)
+
+		recCh <- model.MessageFromNode{Header: model.MessageHeader{Command: model.VersionCMD}}
+		recCh <- model.MessageFromNode{Header: model.MessageHeader{Command: model.VerackCMD}}
+	}
+	ping := func(n int) model.MessageFromNode {
+		return model.MessageFromNode{Header: model.MessageHeader{Command: "ping", Length: uint32(n)}}
+	}
+
+	go handshake()
+	_, err := c.Handshake(ctx)
+	require.NoError(t, err)
+
+	recCh <- ping(1)
+	assert.Equal(t, ping(1), <-subCh)
+
+	// messages are not delivered while connection is lost
+	c.OnConnectionEvent(model.ConnectionEvent{State: model.ConnectionStateDisconnected})
+	recCh <- ping(2)
+
+	// reconnect makes handshake again and resumes delivery after it
+	c.OnConnectionEvent(model.ConnectionEvent{State: model.ConnectionStateConnected, Attempt: 1})
+	handshake()
+	assert.Eventually(t, func() bool {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return c.handshakeDone
+	}, time.Second, time.Millisecond)
+
+	recCh <- ping(3)
+	assert.Equal(t, ping(3), <-subCh)
+	assert.Empty(t, subCh)
+}
+
+func TestCore_Handshake_ReconnectGaveUp(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	// the node drops the connection during the handshake and can't be reached again
+	peer := nodetest.NewPeer(nodetest.Expect(model.VersionCMD), nodetest.Close())
+	dials := 0
+	var c *Core
+	cli, err := client.NewBitcoinClient("127.0.0.1", 18333, func(string, int) (client.Connection, error) {
+		dials++
+		if dials > 1 {
+			return nil, errors.New("connection refused")
+		}
+		return peer.Pipe(), nil
+	},
+		client.WithReconnectPolicy(client.ReconnectPolicy{InitialDelay: time.Millisecond, MaxAttempts: 2}),
+		client.WithEventHandler(func(event model.ConnectionEvent) {
+			// the initial connection is made before core is created
+			if c != nil {
+				c.OnConnectionEvent(event)
+			}
+		}),
+	)
+	require.NoError(t, err)
+
+	c = New(service.NewDecodeService(), service.NewEncodeService(), service.NewMessageGenerator(), cli)
+	c.ReceiveMessages(ctx)
+	_, err = c.Handshake(ctx)
+	require.ErrorIs(t, err, model.ErrReconnectGaveUp)
+	assert.Equal(t, model.FailureDisconnected, model.FailureReason(err))
+
+	// nothing can be sent anymore
+	_, err = c.Handshake(ctx)
+	assert.ErrorIs(t, err, model.ErrReconnectGaveUp)
+}
@@ -0,0 +1,67 @@
+package core
+
+import (
+	"context"
+	"time"
+
+	"github.com/senseyman/bitcoin-handshake/tracing"
+)
+
+// Spans of the handshake, all but the root one are its children.
+const (
+	SpanHandshake      = "handshake"
+	SpanDial           = "dial"
+	SpanVersionSend    = "version.send"
+	SpanVersionReceive = "version.receive"
+	SpanVerackSend     = "verack.send"
+	SpanVerackReceive  = "verack.receive"
+)
+
+// Attributes of the handshake spans.
+const (
+	AttrPeerAddress     = "net.peer.address"
+	AttrUserAgent       = "bitcoin.user_agent"
+	AttrProtocolVersion = "bitcoin.protocol_version"
+	AttrServices        = "bitcoin.services"
+)
+
+// startHandshakeSpan starts the root span of the handshake. If the connection was dialed for this handshake,
+// the span starts with the dial, so the time spent on connecting is a part of the trace.
+func (c *Core) startHandshakeSpan(ctx context.Context) (context.Context, *tracing.Span) {
+	if c.tracer == nil {
+		return ctx, nil
+	}
+
+	peer := tracing.String(AttrPeerAddress, c.peerAddress())
+
+	dial := c.client.LastDial()
+	c.mu.Lock()
+	fresh := !dial.Start.IsZero() && !dial.Start.Equal(c.tracedDial)
+	if fresh {
+		c.tracedDial = dial.Start
+	}
+	c.mu.Unlock()
+
+	start := time.Now()
+	if fresh {
+		start = dial.Start
+	}
+	ctx, span := c.tracer.StartAt(ctx, SpanHandshake, start, peer)
+	if fresh {
+		_, dialSpan := c.tracer.StartAt(ctx, SpanDial, dial.Start, peer)
+		dialSpan.EndAt(dial.Start.Add(dial.Duration))
+	}
+
+	return ctx, span
+}
+
+func (c *Core) remoteVersionAttributes() []tracing.Attribute {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return []tracing.Attribute{
+		tracing.String(AttrUserAgent, c.remoteVersion.UserAgent),
+		tracing.Int(AttrProtocolVersion, int64(c.remoteVersion.Version)),
+		tracing.String(AttrServices, c.remoteVersion.Services.String()),
+	}
+}
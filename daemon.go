@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/senseyman/bitcoin-handshake/addrman"
+	"github.com/senseyman/bitcoin-handshake/bandwidth"
+	"github.com/senseyman/bitcoin-handshake/banman"
+	"github.com/senseyman/bitcoin-handshake/chaintip"
+	"github.com/senseyman/bitcoin-handshake/daemon"
+	"github.com/senseyman/bitcoin-handshake/metrics"
+	"github.com/senseyman/bitcoin-handshake/model"
+	"github.com/senseyman/bitcoin-handshake/propagation"
+	"github.com/senseyman/bitcoin-handshake/survey"
+	"github.com/senseyman/bitcoin-handshake/timedata"
+)
+
+const (
+	daemonDialTimeout     = 30 * time.Second
+	daemonShutdownTimeout = 10 * time.Second
+)
+
+// runDaemon is the daemon subcommand: it keeps connections to the peers and serves their status over HTTP.
+func runDaemon(ctx context.Context, args []string, stderr io.Writer) int {
+	cfg := daemon.DefaultConfig()
+
+	fs := flag.NewFlagSet("daemon", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	listen := fs.String("listen", "127.0.0.1:8080", "Address of HTTP API")
+	peers := fs.String("peers", "", "Comma separated host[:port] of the peers to connect to on start")
+	fs.IntVar(&cfg.History, "history", cfg.History, "Number of recent messages kept for every peer")
+	fs.DurationVar(&cfg.HandshakeTimeout, "handshake.timeout", cfg.HandshakeTimeout, "Timeout of the first handshake with a peer")
+	proxyAddr := fs.String("proxy", "", "Connect through SOCKS5 proxy, e.g. Tor at 127.0.0.1:9050")
+	withMetrics := fs.Bool("metrics", true, "Serve Prometheus metrics of handshakes and message traffic on /metrics")
+	traceFile := fs.String("trace.file", "", "File to append the spans of handshakes to, as JSON lines")
+	timeCfg := timedata.DefaultConfig()
+	fs.DurationVar(&timeCfg.WarnThreshold, "time.warn", timeCfg.WarnThreshold,
+		"Warn when the median clock offset of the peers exceeds it, zero disables the warning")
+	uploadRate := fs.Int64("bandwidth.upload", 0, "Upload rate limit of every peer in KiB/s, 0 means no limit")
+	downloadRate := fs.Int64("bandwidth.download", 0, "Download rate limit of every peer in KiB/s, 0 means no limit")
+	uploadTarget := fs.Uint64("maxuploadtarget", 0, "Daily upload limit of all peers in MiB, historical blocks aren't served once it's nearly reached, 0 means no limit")
+	addrManFile := fs.String("addrman.file", "", "Path to the address book file. It gets the addresses relayed by the peers and answers their getaddr")
+	listenAddr := fs.String("listen.addr", "", "Our public host[:port] which accepts connections, it's advertised to the peers. Listener mode is off if empty")
+	fs.DurationVar(&cfg.AdvertiseInterval, "listen.advertise", cfg.AdvertiseInterval, "Average interval of the advertisement of our address")
+	blocksFile := fs.String("blocks.file", "", "Path to blocks in the format of Bitcoin Core's blk*.dat to serve to the peers")
+	chainTip := fs.Bool("chaintip", false, "Follow the headers of the peers, detect reorgs and stale tips, serve the state on /chaintip")
+	chainTipStdout := fs.Bool("chaintip.stdout", false, "Write chain tip events to stdout as JSON lines, implies -chaintip")
+	chainTipWebhook := fs.String("chaintip.webhook", "", "URL to POST chain tip events to as JSON, implies -chaintip")
+	staleTipAfter := fs.Duration("chaintip.stale", chaintip.DefaultStaleTipAfter, "Report the tip as stale when it doesn't change for so long, zero disables it")
+	withPropagation := fs.Bool("propagation", false, "Record when the peers announce blocks and txs, serve the delays on /propagation")
+	banListFile := fs.String("banlist.file", "", "Path to the ban list file. Banned nodes are never connected to and misbehaving peers are added to it")
+	fs.IntVar(&cfg.BanPolicy.Threshold, "banscore", cfg.BanPolicy.Threshold, "Misbehaviour score at which the peer is disconnected and banned")
+	fs.DurationVar(&cfg.BanPolicy.Duration, "bantime", cfg.BanPolicy.Duration, "Duration of the ban of misbehaving peer")
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: daemon [flags]")
+		fmt.Fprintln(stderr, "Keeps connections to the peers and serves their status over HTTP JSON API.")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if cfg.History < 0 || cfg.HandshakeTimeout <= 0 {
+		fmt.Fprintln(stderr, "daemon: history can't be negative and handshake timeout must be positive")
+		return 2
+	}
+
+	log.SetFormatter(&log.JSONFormatter{})
+	log.SetOutput(stderr)
+
+	cfg.UploadRate, cfg.DownloadRate = *uploadRate*1024, *downloadRate*1024
+	cfg.UploadTarget = bandwidth.NewUploadTarget(*uploadTarget * 1024 * 1024)
+	cfg.TimeData = timedata.New(timeCfg)
+	if *withMetrics {
+		cfg.Metrics = metrics.New()
+		cfg.Metrics.WatchTimeData(cfg.TimeData)
+	}
+	if *traceFile != "" {
+		tracer, closeTrace, err := openTracer(*traceFile)
+		if err != nil {
+			fmt.Fprintf(stderr, "daemon: %v\n", err)
+			return 1
+		}
+		defer closeTrace()
+		cfg.Tracer = tracer
+	}
+
+	if *banListFile != "" {
+		banList, err := banman.New(*banListFile)
+		if err != nil {
+			fmt.Fprintf(stderr, "daemon: %v\n", err)
+			return 1
+		}
+		cfg.BanList = banList
+		defer func() {
+			if err := banList.Save(); err != nil {
+				log.Errorf("err while saving ban list: %v", err)
+			}
+		}()
+	}
+
+	if *listenAddr != "" {
+		addr, err := parseListenAddr(*listenAddr)
+		if err != nil {
+			fmt.Fprintf(stderr, "daemon: %v\n", err)
+			return 2
+		}
+		cfg.ListenAddr = &addr
+	}
+	if *addrManFile != "" {
+		addrBook, err := addrman.New(*addrManFile)
+		if err != nil {
+			fmt.Fprintf(stderr, "daemon: %v\n", err)
+			return 1
+		}
+		cfg.AddrBook = addrBook
+		defer func() {
+			if err := addrBook.Save(); err != nil {
+				log.Errorf("err while saving address book: %v", err)
+			}
+		}()
+	}
+	if *blocksFile != "" {
+		store, err := openBlockStore(*blocksFile)
+		if err != nil {
+			fmt.Fprintf(stderr, "daemon: %v\n", err)
+			return 1
+		}
+		cfg.BlockStore = store
+	}
+	if *chainTip || *chainTipStdout || *chainTipWebhook != "" {
+		opts := []chaintip.Option{chaintip.WithStaleTipAfter(*staleTipAfter)}
+		if *chainTipStdout {
+			opts = append(opts, chaintip.WithSink(chaintip.NewJSONSink(os.Stdout)))
+		}
+		if *chainTipWebhook != "" {
+			opts = append(opts, chaintip.WithSink(chaintip.NewWebhookSink(*chainTipWebhook)))
+		}
+		// the headers of the served blocks aren't requested again
+		root, height := model.TestNetGenesis(), 0
+		if cfg.BlockStore != nil {
+			root, height = cfg.BlockStore.Tip()
+		}
+		cfg.ChainTip = chaintip.New(root, height, opts...)
+		go cfg.ChainTip.Run(ctx)
+	}
+
+	if *withPropagation {
+		cfg.Propagation = propagation.New()
+		// the peers in high-bandwidth mode announce the blocks with cmpctblock before validating them, so the earliest
+		// announcements are recorded
+		cfg.Features.CompactBlocks = true
+	}
+
+	manager := daemon.NewManager(ctx, probeDial(daemonDialTimeout, *proxyAddr), cfg)
+	defer manager.Close()
+
+	for _, p := range strings.Split(*peers, ",") {
+		if p = strings.TrimSpace(p); p == "" {
+			continue
+		}
+		target, err := survey.ParseTarget(p, 18333)
+		if err != nil {
+			fmt.Fprintf(stderr, "daemon: %v\n", err)
+			return 2
+		}
+		if _, err := manager.Add(target.Host, target.Port); err != nil {
+			fmt.Fprintf(stderr, "daemon: %v\n", err)
+			return 2
+		}
+	}
+
+	l, err := net.Listen("tcp", *listen)
+	if err != nil {
+		fmt.Fprintf(stderr, "daemon: %v\n", err)
+		return 1
+	}
+	server := &http.Server{
+		Handler:           daemon.NewServer(manager),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), daemonShutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			log.Warnf("err while stopping HTTP API: %v", err)
+		}
+	}()
+
+	log.Infof("Serving HTTP API on %s", l.Addr())
+	if err := server.Serve(l); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Errorf("err while serving HTTP API: %v", err)
+		return 1
+	}
+	log.Info("Stopping the daemon...")
+
+	return 0
+}
@@ -0,0 +1,121 @@
+package daemon
+
+import (
+	"time"
+
+	"github.com/senseyman/bitcoin-handshake/bandwidth"
+	"github.com/senseyman/bitcoin-handshake/model"
+)
+
+type PeerStatus struct {
+	ID    string `json:"id"`
+	Host  string `json:"host"`
+	Port  int    `json:"port"`
+	State string `json:"state"`
+	// Error is the last error of the connection or handshake
+	Error          string     `json:"error,omitempty"`
+	AddedAt        time.Time  `json:"added_at"`
+	ConnectedSince *time.Time `json:"connected_since,omitempty"`
+	// Handshake is the last successful handshake, it's empty until the handshake is done
+	Handshake *HandshakeResult `json:"handshake,omitempty"`
+	// Features are negotiated during the current connection, they are empty until the handshake is done
+	Features *model.PeerFeatures `json:"features,omitempty"`
+	// MisbehaviorScore is the sum of the scores of the protocol violations of the peer
+	MisbehaviorScore int `json:"misbehavior_score"`
+	// Bandwidth is the traffic of the peer per direction and command, including reconnects
+	Bandwidth *bandwidth.Stats `json:"bandwidth,omitempty"`
+	Stats     Stats            `json:"stats"`
+}
+
+// HandshakeResult is the result of the handshake, latencies are in milliseconds.
+type HandshakeResult struct {
+	Host    string     `json:"host,omitempty"`
+	Port    int        `json:"port,omitempty"`
+	Time    *time.Time `json:"time,omitempty"`
+	Success bool       `json:"success"`
+	Reason  string     `json:"reason,omitempty"`
+	Error   string     `json:"error,omitempty"`
+
+	ConnectMs float64 `json:"connect_ms,omitempty"`
+	VersionMs float64 `json:"version_ms,omitempty"`
+	VerackMs  float64 `json:"verack_ms,omitempty"`
+	TotalMs   float64 `json:"total_ms,omitempty"`
+	// TimeOffsetS is the clock offset of the node in seconds: its time minus the local time
+	TimeOffsetS *float64 `json:"time_offset_s,omitempty"`
+
+	Version *RemoteVersion `json:"version,omitempty"`
+}
+
+// RemoteVersion is the version message received from the node.
+type RemoteVersion struct {
+	ProtocolVersion int32             `json:"protocol_version"`
+	Services        model.ServiceFlag `json:"services"`
+	UserAgent       string            `json:"user_agent"`
+	StartHeight     int32             `json:"start_height"`
+	Relay           bool              `json:"relay"`
+	Timestamp       int64             `json:"timestamp"`
+}
+
+// TimeStatus is the clock offsets of the peers, offsets are in seconds. The median is empty until there are
+// enough peers.
+type TimeStatus struct {
+	LocalTime     time.Time        `json:"local_time"`
+	AdjustedTime  time.Time        `json:"adjusted_time"`
+	MedianOffsetS *float64         `json:"median_offset_s,omitempty"`
+	Skewed        bool             `json:"skewed"`
+	Peers         []PeerTimeOffset `json:"peers"`
+}
+
+type PeerTimeOffset struct {
+	ID      string    `json:"id"`
+	OffsetS float64   `json:"offset_s"`
+	Time    time.Time `json:"time"`
+}
+
+type AddPeerRequest struct {
+	Host string `json:"host"`
+	Port int    `json:"port"`
+}
+
+type HandshakeRequest struct {
+	Host string `json:"host"`
+	Port int    `json:"port"`
+	// Timeout is a duration like 10s, the default one is used if it's empty
+	Timeout string `json:"timeout,omitempty"`
+}
+
+type ErrorResponse struct {
+	Error string `json:"error"`
+}
+
+func newHandshakeResult(stats model.HandshakeStats) HandshakeResult {
+	v := stats.RemoteVersion
+	offset := stats.TimeOffset.Seconds()
+
+	return HandshakeResult{
+		Success:     true,
+		VersionMs:   ms(stats.VersionRTT),
+		VerackMs:    ms(stats.VerackRTT),
+		TotalMs:     ms(stats.Total),
+		TimeOffsetS: &offset,
+		Version: &RemoteVersion{
+			ProtocolVersion: v.Version,
+			Services:        v.Services,
+			UserAgent:       v.UserAgent,
+			StartHeight:     v.StartHeight,
+			Relay:           v.Relay,
+			Timestamp:       v.Timestamp,
+		},
+	}
+}
+
+func ms(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// BandwidthStatus is the traffic of all peers, the upload target is set if the manager has one.
+type BandwidthStatus struct {
+	SentBytes     uint64                  `json:"sent_bytes"`
+	ReceivedBytes uint64                  `json:"received_bytes"`
+	UploadTarget  *bandwidth.TargetStatus `json:"upload_target,omitempty"`
+}
@@ -0,0 +1,316 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/senseyman/bitcoin-handshake/addrman"
+	"github.com/senseyman/bitcoin-handshake/bandwidth"
+	"github.com/senseyman/bitcoin-handshake/banman"
+	"github.com/senseyman/bitcoin-handshake/blockstore"
+	"github.com/senseyman/bitcoin-handshake/chaintip"
+	"github.com/senseyman/bitcoin-handshake/client"
+	"github.com/senseyman/bitcoin-handshake/core"
+	"github.com/senseyman/bitcoin-handshake/metrics"
+	"github.com/senseyman/bitcoin-handshake/model"
+	"github.com/senseyman/bitcoin-handshake/probe"
+	"github.com/senseyman/bitcoin-handshake/propagation"
+	"github.com/senseyman/bitcoin-handshake/timedata"
+	"github.com/senseyman/bitcoin-handshake/tracing"
+)
+
+var (
+	ErrPeerExists    = errors.New("peer already exists")
+	ErrPeerNotFound  = errors.New("peer not found")
+	ErrNoTimeData    = errors.New("time data is disabled")
+	ErrNoChainTip    = errors.New("chain tip tracking is disabled")
+	ErrNoPropagation = errors.New("propagation timing is disabled")
+)
+
+type Config struct {
+	// History is the number of recent messages kept for every peer
+	History          int
+	HandshakeTimeout time.Duration
+	ReconnectPolicy  client.ReconnectPolicy
+	// Metrics count handshakes and traffic of all peers and on-demand handshakes, it's optional
+	Metrics *metrics.Metrics
+	// Tracer traces the handshakes, it's optional
+	Tracer *tracing.Tracer
+	// Features are negotiated with the peers, on-demand handshakes negotiate nothing
+	Features core.Features
+	// TimeData keeps the clock offsets of the peers, on-demand handshakes aren't sampled. It's optional
+	TimeData *timedata.Sampler
+	// BanList refuses connections to banned nodes and gets the misbehaving peers, it's optional
+	BanList   *banman.BanMan
+	BanPolicy core.BanPolicy
+	// UploadRate and DownloadRate limit the traffic of every peer in bytes per second, zero means no limit
+	UploadRate   int64
+	DownloadRate int64
+	// UploadTarget is the daily limit of the upload of all peers and on-demand handshakes, it's optional
+	UploadTarget *bandwidth.UploadTarget
+	// BlockStore has the headers and blocks which are served to the peers, it's optional
+	BlockStore *blockstore.Store
+	// AddrBook gets the addresses relayed by the peers and answers their getaddr in listener mode, it's optional
+	AddrBook *addrman.AddrMan
+	// ListenAddr is our listening address which is advertised to the peers once per AdvertiseInterval on
+	// average, it's optional
+	ListenAddr        *model.NetAddressV2
+	AdvertiseInterval time.Duration
+	// ChainTip follows the headers of all peers and detects reorgs and stale tips, it's optional. Its events are
+	// delivered by its Run which isn't called by the manager
+	ChainTip *chaintip.Tracker
+	// Propagation records when the peers announce blocks and txs, so the slow peers are found, it's optional
+	Propagation *propagation.Recorder
+
+	// addrCache is shared by all peers, so they get the same response to getaddr
+	addrCache *addrman.ResponseCache
+}
+
+func DefaultConfig() Config {
+	policy := client.DefaultReconnectPolicy()
+	// the daemon runs for a long time, so it never gives up on the peers
+	policy.MaxAttempts = 0
+
+	return Config{
+		History:           100,
+		HandshakeTimeout:  time.Minute,
+		ReconnectPolicy:   policy,
+		Features:          core.DefaultFeatures(),
+		BanPolicy:         core.DefaultBanPolicy(),
+		AdvertiseInterval: core.DefaultAdvertiseInterval,
+	}
+}
+
+// Manager keeps connections to the peers and makes on-demand handshakes. It's safe for concurrent use.
+type Manager struct {
+	cfg    Config
+	dial   func(host string, port int) (client.Connection, error)
+	prober *probe.Prober
+
+	mu     sync.Mutex
+	ctx    context.Context
+	peers  map[string]*peer
+	closed bool
+}
+
+// NewManager creates the manager, connections to peers live until ctx is done or the manager is closed.
+func NewManager(ctx context.Context, dial func(host string, port int) (client.Connection, error), cfg Config) *Manager {
+	if cfg.BanList != nil {
+		dial = cfg.BanList.Dial(dial)
+	}
+	if cfg.AddrBook != nil {
+		cfg.addrCache = addrman.NewResponseCache(cfg.AddrBook)
+	}
+	// on-demand handshakes upload too, so they count into the target
+	probeDial := bandwidth.NewMeter(bandwidth.WithUploadTarget(cfg.UploadTarget)).ConnectionFn(dial)
+
+	return &Manager{
+		cfg:    cfg,
+		dial:   dial,
+		prober: probe.New(probeDial, probe.WithMetrics(cfg.Metrics), probe.WithTracer(cfg.Tracer)),
+		ctx:    ctx,
+		peers:  make(map[string]*peer),
+	}
+}
+
+func peerID(host string, port int) string {
+	return net.JoinHostPort(host, strconv.Itoa(port))
+}
+
+// Add starts connecting to the peer in background, the progress is visible in its status.
+func (m *Manager) Add(host string, port int) (PeerStatus, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return PeerStatus{}, context.Canceled
+	}
+	id := peerID(host, port)
+	if _, ok := m.peers[id]; ok {
+		return PeerStatus{}, fmt.Errorf("%w: %s", ErrPeerExists, id)
+	}
+
+	p := newPeer(host, port, m.cfg)
+	ctx, cancel := context.WithCancel(m.ctx)
+	p.cancel = cancel
+	m.peers[id] = p
+	go p.run(ctx, m.dial, m.cfg)
+
+	return p.status(), nil
+}
+
+// Remove disconnects from the peer and forgets it.
+func (m *Manager) Remove(id string) error {
+	m.mu.Lock()
+	p, ok := m.peers[id]
+	delete(m.peers, id)
+	m.mu.Unlock()
+
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrPeerNotFound, id)
+	}
+	p.cancel()
+	if m.cfg.TimeData != nil {
+		m.cfg.TimeData.Remove(id)
+	}
+
+	return nil
+}
+
+func (m *Manager) get(id string) (*peer, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	p, ok := m.peers[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrPeerNotFound, id)
+	}
+
+	return p, nil
+}
+
+func (m *Manager) Status(id string) (PeerStatus, error) {
+	p, err := m.get(id)
+	if err != nil {
+		return PeerStatus{}, err
+	}
+
+	return p.status(), nil
+}
+
+// List returns statuses of all peers sorted by id.
+func (m *Manager) List() []PeerStatus {
+	m.mu.Lock()
+	peers := make([]*peer, 0, len(m.peers))
+	for _, p := range m.peers {
+		peers = append(peers, p)
+	}
+	m.mu.Unlock()
+
+	statuses := make([]PeerStatus, 0, len(peers))
+	for _, p := range peers {
+		statuses = append(statuses, p.status())
+	}
+	slices.SortFunc(statuses, func(a, b PeerStatus) int {
+		if a.ID < b.ID {
+			return -1
+		}
+		if a.ID > b.ID {
+			return 1
+		}
+		return 0
+	})
+
+	return statuses
+}
+
+// Messages returns up to limit of the latest messages of the peer, the oldest first. Zero limit returns all kept.
+func (m *Manager) Messages(id string, limit int) ([]Message, error) {
+	p, err := m.get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return p.recent(limit), nil
+}
+
+// Handshake makes a single handshake with any node, it doesn't affect the managed peers.
+func (m *Manager) Handshake(ctx context.Context, host string, port int) HandshakeResult {
+	start := time.Now()
+	result, err := m.prober.Handshake(ctx, host, port)
+	if err != nil {
+		return HandshakeResult{
+			Host:      host,
+			Port:      port,
+			Time:      &start,
+			Reason:    probe.Reason(err),
+			Error:     err.Error(),
+			ConnectMs: ms(result.Connect),
+		}
+	}
+
+	r := newHandshakeResult(result.HandshakeStats)
+	r.Host, r.Port, r.Time = host, port, &start
+	r.ConnectMs = ms(result.Connect)
+
+	return r
+}
+
+// ChainTip returns the state of the chain followed over all peers, it fails if the manager doesn't track it.
+func (m *Manager) ChainTip() (chaintip.Status, error) {
+	if m.cfg.ChainTip == nil {
+		return chaintip.Status{}, ErrNoChainTip
+	}
+
+	return m.cfg.ChainTip.Status(), nil
+}
+
+// Propagation returns how fast the blocks and txs propagate across the peers, it fails if the manager doesn't
+// record the announcements.
+func (m *Manager) Propagation() (propagation.Report, error) {
+	if m.cfg.Propagation == nil {
+		return propagation.Report{}, ErrNoPropagation
+	}
+
+	return m.cfg.Propagation.Report(), nil
+}
+
+// Time returns the clock offsets of the peers and their median, it fails if the manager has no time data.
+func (m *Manager) Time() (TimeStatus, error) {
+	if m.cfg.TimeData == nil {
+		return TimeStatus{}, ErrNoTimeData
+	}
+
+	now := time.Now()
+	status := TimeStatus{LocalTime: now, AdjustedTime: now, Skewed: m.cfg.TimeData.Skewed()}
+	if median, ok := m.cfg.TimeData.Median(); ok {
+		s := median.Seconds()
+		status.MedianOffsetS = &s
+		status.AdjustedTime = now.Add(median)
+	}
+	for _, sample := range m.cfg.TimeData.Samples() {
+		status.Peers = append(status.Peers, PeerTimeOffset{ID: sample.Peer, OffsetS: sample.Offset.Seconds(), Time: sample.Time})
+	}
+
+	return status, nil
+}
+
+// Bandwidth returns the traffic of all peers and the state of the upload target.
+func (m *Manager) Bandwidth() BandwidthStatus {
+	var status BandwidthStatus
+	for _, p := range m.List() {
+		if p.Bandwidth == nil {
+			continue
+		}
+		status.SentBytes += p.Bandwidth.Sent.Bytes
+		status.ReceivedBytes += p.Bandwidth.Received.Bytes
+	}
+	if m.cfg.UploadTarget != nil {
+		target := m.cfg.UploadTarget.Status()
+		status.UploadTarget = &target
+	}
+
+	return status
+}
+
+// Close disconnects from all peers and waits until their connections are closed.
+func (m *Manager) Close() {
+	m.mu.Lock()
+	m.closed = true
+	peers := m.peers
+	m.peers = make(map[string]*peer)
+	m.mu.Unlock()
+
+	for _, p := range peers {
+		p.cancel()
+	}
+	for _, p := range peers {
+		<-p.done
+	}
+}
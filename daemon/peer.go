@@ -0,0 +1,297 @@
+package daemon
+
+import (
+	"context"
+	"encoding/hex"
+	"maps"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/senseyman/bitcoin-handshake/bandwidth"
+	"github.com/senseyman/bitcoin-handshake/capture"
+	"github.com/senseyman/bitcoin-handshake/client"
+	"github.com/senseyman/bitcoin-handshake/core"
+	"github.com/senseyman/bitcoin-handshake/model"
+	"github.com/senseyman/bitcoin-handshake/service"
+)
+
+const (
+	// maxPayloadPreview limits the payload kept for recent messages, blocks would take too much memory
+	maxPayloadPreview = 256
+
+	stateStarting = "starting"
+	stateFailed   = "failed"
+	stateStopped  = "stopped"
+)
+
+// Message is a message sent to or received from the peer.
+type Message struct {
+	Time      time.Time `json:"time"`
+	Direction string    `json:"direction"`
+	Command   string    `json:"command"`
+	Size      int       `json:"size"`
+	// Payload is hex of the payload, cut to maxPayloadPreview bytes
+	Payload   string `json:"payload,omitempty"`
+	Truncated bool   `json:"truncated,omitempty"`
+}
+
+// Stats counts the traffic of the peer.
+type Stats struct {
+	MessagesReceived map[string]uint64 `json:"messages_received"`
+	MessagesSent     map[string]uint64 `json:"messages_sent"`
+	BytesReceived    uint64            `json:"bytes_received"`
+	BytesSent        uint64            `json:"bytes_sent"`
+	LastMessageAt    *time.Time        `json:"last_message_at,omitempty"`
+	Reconnects       int               `json:"reconnects"`
+}
+
+// peer is a long-lived connection to the node, it reconnects and makes the handshake again when the
+// connection is lost.
+type peer struct {
+	id   string
+	host string
+	port int
+
+	cancel context.CancelFunc
+	done   chan struct{}
+	meter  *bandwidth.Meter
+
+	mu             sync.Mutex
+	core           *core.Core
+	state          string
+	err            string
+	addedAt        time.Time
+	connectedSince *time.Time
+	handshakeAt    *time.Time
+	stats          Stats
+	// messages is a ring buffer of recent messages, next is the position of the next message in it
+	messages []Message
+	next     int
+	count    int
+}
+
+func newPeer(host string, port int, cfg Config) *peer {
+	return &peer{
+		id:   peerID(host, port),
+		host: host,
+		port: port,
+		done: make(chan struct{}),
+		meter: bandwidth.NewMeter(
+			bandwidth.WithUploadRate(cfg.UploadRate, 0),
+			bandwidth.WithDownloadRate(cfg.DownloadRate, 0),
+			bandwidth.WithUploadTarget(cfg.UploadTarget),
+		),
+		state:   stateStarting,
+		addedAt: time.Now(),
+		stats: Stats{
+			MessagesReceived: make(map[string]uint64),
+			MessagesSent:     make(map[string]uint64),
+		},
+		messages: make([]Message, cfg.History),
+	}
+}
+
+// run connects to the node and makes the first handshake, then the connection is kept until ctx is done.
+func (p *peer) run(ctx context.Context, dial func(host string, port int) (client.Connection, error), cfg Config) {
+	defer close(p.done)
+
+	clientOpts := []client.Option{
+		client.WithReconnectPolicy(cfg.ReconnectPolicy),
+		client.WithEventHandler(p.onConnectionEvent),
+		client.WithRecorder(p),
+	}
+	coreOpts := []core.Option{core.WithFeatures(cfg.Features)}
+	if cfg.Metrics != nil {
+		clientOpts = append(clientOpts, client.WithMetrics(cfg.Metrics))
+		coreOpts = append(coreOpts, core.WithMetrics(cfg.Metrics))
+	}
+	if cfg.Tracer != nil {
+		coreOpts = append(coreOpts, core.WithTracer(cfg.Tracer))
+	}
+	if cfg.TimeData != nil {
+		coreOpts = append(coreOpts, core.WithTimeData(cfg.TimeData))
+	}
+	if cfg.BanList != nil {
+		coreOpts = append(coreOpts, core.WithBanList(cfg.BanList, cfg.BanPolicy))
+	}
+	if cfg.BlockStore != nil {
+		coreOpts = append(coreOpts, core.WithBlockStore(cfg.BlockStore))
+	}
+	if cfg.AddrBook != nil {
+		coreOpts = append(coreOpts, core.WithAddrBook(cfg.AddrBook), core.WithAddrSource(cfg.addrCache))
+	}
+	if cfg.ListenAddr != nil {
+		coreOpts = append(coreOpts, core.WithSelfAdvertisement(*cfg.ListenAddr, cfg.AdvertiseInterval))
+	}
+	if cfg.UploadTarget != nil {
+		coreOpts = append(coreOpts, core.WithUploadTarget(cfg.UploadTarget))
+	}
+	if cfg.ChainTip != nil {
+		coreOpts = append(coreOpts, core.WithChainTracker(cfg.ChainTip))
+	}
+	if cfg.Propagation != nil {
+		coreOpts = append(coreOpts, core.WithAnnouncementRecorder(cfg.Propagation))
+	}
+
+	cli, err := client.NewBitcoinClient(p.host, p.port, p.meter.ConnectionFn(dial), clientOpts...)
+	if err != nil {
+		p.fail(err)
+		return
+	}
+
+	c := core.New(service.NewDecodeService(), service.NewEncodeService(), service.NewMessageGenerator(), cli, coreOpts...)
+	p.mu.Lock()
+	p.core = c
+	p.mu.Unlock()
+
+	c.ReceiveMessages(ctx)
+	// the peer is kept for the whole ctx, not only for the first handshake
+	c.Listen(ctx)
+
+	handshakeCtx, cancel := context.WithTimeout(ctx, cfg.HandshakeTimeout)
+	defer cancel()
+	if _, err := c.Handshake(handshakeCtx); err != nil {
+		log.Warnf("handshake with peer %s failed: %v", p.id, err)
+		p.fail(err)
+	}
+
+	<-ctx.Done()
+	p.mu.Lock()
+	p.state = stateStopped
+	p.mu.Unlock()
+}
+
+func (p *peer) fail(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.state = stateFailed
+	p.err = err.Error()
+}
+
+func (p *peer) onConnectionEvent(event model.ConnectionEvent) {
+	p.mu.Lock()
+	p.state = event.State.String()
+	switch event.State {
+	case model.ConnectionStateConnected:
+		p.connectedSince = &event.Time
+		if event.Attempt > 0 {
+			p.stats.Reconnects++
+		}
+	case model.ConnectionStateDisconnected, model.ConnectionStateGaveUp:
+		p.connectedSince = nil
+		if event.Err != nil {
+			p.err = event.Err.Error()
+		}
+	}
+	c := p.core
+	p.mu.Unlock()
+
+	// the initial connection is made before core is created
+	if c != nil {
+		c.OnConnectionEvent(event)
+	}
+}
+
+func (p *peer) RecordReceived(header model.MessageHeader, payload []byte) {
+	p.record(capture.Received, header, payload)
+}
+
+func (p *peer) RecordSent(header model.MessageHeader, payload []byte) {
+	p.record(capture.Sent, header, payload)
+}
+
+func (p *peer) record(direction capture.Direction, header model.MessageHeader, payload []byte) {
+	msg := Message{
+		Time:      time.Now(),
+		Direction: direction.String(),
+		Command:   header.Command,
+		Size:      len(payload),
+	}
+	preview := payload
+	if len(preview) > maxPayloadPreview {
+		preview = preview[:maxPayloadPreview]
+		msg.Truncated = true
+	}
+	msg.Payload = hex.EncodeToString(preview)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if direction == capture.Received {
+		p.stats.MessagesReceived[header.Command]++
+		p.stats.BytesReceived += uint64(len(payload))
+	} else {
+		p.stats.MessagesSent[header.Command]++
+		p.stats.BytesSent += uint64(len(payload))
+	}
+	p.stats.LastMessageAt = &msg.Time
+	if header.Command == model.VerackCMD && direction == capture.Received {
+		p.handshakeAt = &msg.Time
+	}
+
+	if len(p.messages) == 0 {
+		return
+	}
+	p.messages[p.next] = msg
+	p.next = (p.next + 1) % len(p.messages)
+	p.count = min(p.count+1, len(p.messages))
+}
+
+// recent returns up to limit of the latest messages, the oldest first.
+func (p *peer) recent(limit int) []Message {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	n := p.count
+	if limit > 0 && limit < n {
+		n = limit
+	}
+
+	messages := make([]Message, 0, n)
+	for i := n; i > 0; i-- {
+		messages = append(messages, p.messages[(p.next-i+len(p.messages))%len(p.messages)])
+	}
+
+	return messages
+}
+
+func (p *peer) status() PeerStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	s := PeerStatus{
+		ID:             p.id,
+		Host:           p.host,
+		Port:           p.port,
+		State:          p.state,
+		Error:          p.err,
+		AddedAt:        p.addedAt,
+		ConnectedSince: p.connectedSince,
+		Stats:          p.stats.clone(),
+	}
+	bandwidthStats := p.meter.Stats()
+	s.Bandwidth = &bandwidthStats
+	if p.core != nil {
+		s.MisbehaviorScore = p.core.MisbehaviorScore()
+		if stats := p.core.LastHandshake(); stats.Total > 0 {
+			handshake := newHandshakeResult(stats)
+			handshake.Time = p.handshakeAt
+			s.Handshake = &handshake
+			features := p.core.PeerFeatures()
+			s.Features = &features
+		}
+	}
+
+	return s
+}
+
+func (s Stats) clone() Stats {
+	c := s
+	c.MessagesReceived = maps.Clone(s.MessagesReceived)
+	c.MessagesSent = maps.Clone(s.MessagesSent)
+
+	return c
+}
@@ -0,0 +1,253 @@
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/senseyman/bitcoin-handshake/tracing"
+)
+
+const (
+	defaultHandshakeTimeout = 10 * time.Second
+	maxHandshakeTimeout     = time.Minute
+	maxRequestSize          = 1 << 16
+	defaultPort             = 18333
+)
+
+var (
+	errBadRequest = errors.New("bad request")
+)
+
+// Server is the HTTP JSON API of the manager:
+//
+//	GET    /healthz                  liveness check
+//	GET    /peers                    list peers with their handshake results and stats
+//	POST   /peers                    add peer {"host": "...", "port": 18333}
+//	GET    /peers/{id}               status of the peer, id is host:port
+//	DELETE /peers/{id}               disconnect from the peer and forget it
+//	GET    /peers/{id}/messages      recent messages of the peer, ?limit=N returns N latest
+//	POST   /handshake                on-demand handshake {"host": "...", "port": 18333, "timeout": "10s"},
+//	                                 the trace of the handshake continues W3C traceparent header of the request
+//	GET    /bandwidth                traffic of all peers and the state of the daily upload target
+//	GET    /time                     clock offsets of the peers and the network-adjusted time, if the manager has time data
+//	GET    /chaintip                 the tip of the chain followed over all peers and the last reorg, if the manager tracks it
+//	GET    /propagation              delays of block and tx announcements across the peers, if the manager records them
+//	GET    /metrics                  metrics in Prometheus text format, if the manager has them
+type Server struct {
+	manager *Manager
+	mux     *http.ServeMux
+}
+
+func NewServer(manager *Manager) *Server {
+	s := &Server{manager: manager, mux: http.NewServeMux()}
+
+	s.mux.HandleFunc("GET /healthz", s.healthz)
+	s.mux.HandleFunc("GET /peers", s.listPeers)
+	s.mux.HandleFunc("POST /peers", s.addPeer)
+	s.mux.HandleFunc("GET /peers/{id}", s.getPeer)
+	s.mux.HandleFunc("DELETE /peers/{id}", s.removePeer)
+	s.mux.HandleFunc("GET /peers/{id}/messages", s.peerMessages)
+	s.mux.HandleFunc("POST /handshake", s.handshake)
+	s.mux.HandleFunc("GET /bandwidth", s.bandwidth)
+	if manager.cfg.TimeData != nil {
+		s.mux.HandleFunc("GET /time", s.time)
+	}
+	if manager.cfg.ChainTip != nil {
+		s.mux.HandleFunc("GET /chaintip", s.chainTip)
+	}
+	if manager.cfg.Propagation != nil {
+		s.mux.HandleFunc("GET /propagation", s.propagation)
+	}
+	if manager.cfg.Metrics != nil {
+		s.mux.Handle("GET /metrics", manager.cfg.Metrics.Handler())
+	}
+
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *Server) healthz(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{"status": "ok", "peers": len(s.manager.List())})
+}
+
+func (s *Server) bandwidth(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, s.manager.Bandwidth())
+}
+
+func (s *Server) listPeers(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, s.manager.List())
+}
+
+func (s *Server) addPeer(w http.ResponseWriter, r *http.Request) {
+	var req AddPeerRequest
+	if err := decodeRequest(r, &req); err != nil {
+		writeError(w, err)
+		return
+	}
+	if err := validateAddress(req.Host, &req.Port); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	status, err := s.manager.Add(req.Host, req.Port)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, status)
+}
+
+func (s *Server) getPeer(w http.ResponseWriter, r *http.Request) {
+	status, err := s.manager.Status(r.PathValue("id"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, status)
+}
+
+func (s *Server) removePeer(w http.ResponseWriter, r *http.Request) {
+	if err := s.manager.Remove(r.PathValue("id")); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) peerMessages(w http.ResponseWriter, r *http.Request) {
+	limit := 0
+	if v := r.URL.Query().Get("limit"); v != "" {
+		var err error
+		if limit, err = strconv.Atoi(v); err != nil || limit < 0 {
+			writeError(w, fmt.Errorf("%w: invalid limit %q", errBadRequest, v))
+			return
+		}
+	}
+
+	messages, err := s.manager.Messages(r.PathValue("id"), limit)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, messages)
+}
+
+func (s *Server) time(w http.ResponseWriter, _ *http.Request) {
+	status, err := s.manager.Time()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, status)
+}
+
+func (s *Server) chainTip(w http.ResponseWriter, _ *http.Request) {
+	status, err := s.manager.ChainTip()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, status)
+}
+
+func (s *Server) propagation(w http.ResponseWriter, _ *http.Request) {
+	report, err := s.manager.Propagation()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, report)
+}
+
+func (s *Server) handshake(w http.ResponseWriter, r *http.Request) {
+	var req HandshakeRequest
+	if err := decodeRequest(r, &req); err != nil {
+		writeError(w, err)
+		return
+	}
+	if err := validateAddress(req.Host, &req.Port); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	timeout := defaultHandshakeTimeout
+	if req.Timeout != "" {
+		var err error
+		if timeout, err = time.ParseDuration(req.Timeout); err != nil || timeout <= 0 || timeout > maxHandshakeTimeout {
+			writeError(w, fmt.Errorf("%w: timeout has to be a duration up to %s", errBadRequest, maxHandshakeTimeout))
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+	if header := r.Header.Get("traceparent"); header != "" {
+		// the broken header of the caller doesn't fail the handshake, it's traced as a new trace
+		if sc, err := tracing.ParseTraceparent(header); err == nil {
+			ctx = tracing.ContextWithSpanContext(ctx, sc)
+		} else {
+			log.Debugf("ignoring traceparent: %v", err)
+		}
+	}
+
+	// failed handshake is a valid result, not an error of the request
+	writeJSON(w, http.StatusOK, s.manager.Handshake(ctx, req.Host, req.Port))
+}
+
+func decodeRequest(r *http.Request, v any) error {
+	dec := json.NewDecoder(http.MaxBytesReader(nil, r.Body, maxRequestSize))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return fmt.Errorf("%w: %v", errBadRequest, err)
+	}
+
+	return nil
+}
+
+func validateAddress(host string, port *int) error {
+	if host == "" {
+		return fmt.Errorf("%w: host is required", errBadRequest)
+	}
+	if *port == 0 {
+		*port = defaultPort
+	}
+	if *port < 0 || *port > 65535 {
+		return fmt.Errorf("%w: invalid port %d", errBadRequest, *port)
+	}
+
+	return nil
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, errBadRequest):
+		status = http.StatusBadRequest
+	case errors.Is(err, ErrPeerNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, ErrPeerExists):
+		status = http.StatusConflict
+	case errors.Is(err, context.Canceled):
+		status = http.StatusServiceUnavailable
+	}
+
+	writeJSON(w, status, ErrorResponse{Error: err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Warnf("err while writing response: %v", err)
+	}
+}
@@ -0,0 +1,529 @@
+package daemon
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/senseyman/bitcoin-handshake/addrman"
+	"github.com/senseyman/bitcoin-handshake/bandwidth"
+	"github.com/senseyman/bitcoin-handshake/banman"
+	"github.com/senseyman/bitcoin-handshake/chaintip"
+	"github.com/senseyman/bitcoin-handshake/client"
+	"github.com/senseyman/bitcoin-handshake/metrics"
+	"github.com/senseyman/bitcoin-handshake/model"
+	"github.com/senseyman/bitcoin-handshake/nodetest"
+	"github.com/senseyman/bitcoin-handshake/probe"
+	"github.com/senseyman/bitcoin-handshake/propagation"
+	"github.com/senseyman/bitcoin-handshake/service"
+	"github.com/senseyman/bitcoin-handshake/timedata"
+	"github.com/senseyman/bitcoin-handshake/tracing"
+)
+
+func newTestServer(t *testing.T, configure ...func(cfg *Config)) *httptest.Server {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	cfg := DefaultConfig()
+	cfg.History = 3
+	cfg.HandshakeTimeout = time.Second
+	cfg.ReconnectPolicy = client.ReconnectPolicy{MaxAttempts: 1}
+	cfg.Metrics = metrics.New()
+	for _, fn := range configure {
+		fn(&cfg)
+	}
+
+	manager := NewManager(ctx, func(host string, port int) (client.Connection, error) {
+		return net.DialTimeout("tcp", net.JoinHostPort(host, strconv.Itoa(port)), time.Second)
+	}, cfg)
+	srv := httptest.NewServer(NewServer(manager))
+	t.Cleanup(func() {
+		srv.Close()
+		cancel()
+		manager.Close()
+	})
+
+	return srv
+}
+
+func listen(t *testing.T, steps ...nodetest.Step) (string, int) {
+	host, port, err := nodetest.NewPeer(steps...).ListenTCP()
+	require.NoError(t, err)
+	return host, port
+}
+
+// call makes the request and decodes the response into out if it's not nil.
+func call(t *testing.T, srv *httptest.Server, method, path, body string, out any) int {
+	req, err := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+	require.NoError(t, err)
+
+	resp, err := srv.Client().Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	raw, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	if out != nil {
+		require.NoError(t, json.Unmarshal(raw, out), string(raw))
+	}
+
+	return resp.StatusCode
+}
+
+func TestServer_Peers(t *testing.T) {
+	srv := newTestServer(t)
+	// ping comes after the features we send once the handshake is done, so it's the latest message
+	host, port := listen(t, append(nodetest.Handshake(),
+		nodetest.SkipUntil(model.FeeFilterCMD),
+		nodetest.SendMessage("ping", make([]byte, 8)),
+	)...)
+	id := url.PathEscape(net.JoinHostPort(host, strconv.Itoa(port)))
+
+	var status PeerStatus
+	body := `{"host":"` + host + `","port":` + strconv.Itoa(port) + `}`
+	require.Equal(t, http.StatusCreated, call(t, srv, http.MethodPost, "/peers", body, &status))
+	assert.Equal(t, net.JoinHostPort(host, strconv.Itoa(port)), status.ID)
+
+	var errResp ErrorResponse
+	assert.Equal(t, http.StatusConflict, call(t, srv, http.MethodPost, "/peers", body, &errResp))
+	assert.Contains(t, errResp.Error, ErrPeerExists.Error())
+
+	// the handshake is made in background
+	require.Eventually(t, func() bool {
+		call(t, srv, http.MethodGet, "/peers/"+id, "", &status)
+		return status.Handshake != nil && status.Stats.MessagesReceived["ping"] == 1
+	}, 2*time.Second, 10*time.Millisecond)
+	assert.Equal(t, model.ConnectionStateConnected.String(), status.State)
+	assert.NotNil(t, status.ConnectedSince)
+	assert.Equal(t, nodetest.DefaultVersion().UserAgent, status.Handshake.Version.UserAgent)
+	assert.Positive(t, status.Handshake.VersionMs)
+	assert.Equal(t, uint64(1), status.Stats.MessagesSent[model.VerackCMD])
+	require.NotNil(t, status.Bandwidth)
+	assert.Equal(t, uint64(1), status.Bandwidth.Sent.Commands[model.VerackCMD].Messages)
+	assert.Equal(t, uint64(24+8), status.Bandwidth.Received.Commands["ping"].Bytes)
+
+	var peers []PeerStatus
+	require.Equal(t, http.StatusOK, call(t, srv, http.MethodGet, "/peers", "", &peers))
+	require.Len(t, peers, 1)
+	assert.Equal(t, status.ID, peers[0].ID)
+
+	// the features are negotiated by the daemon, the peer doesn't ask for any
+	require.NotNil(t, status.Features)
+	assert.Equal(t, model.PeerFeatures{}, *status.Features)
+
+	// the history keeps 3 latest messages out of the handshake, negotiated features and ping
+	var messages []Message
+	require.Equal(t, http.StatusOK, call(t, srv, http.MethodGet, "/peers/"+id+"/messages", "", &messages))
+	require.Len(t, messages, 3)
+	assert.Equal(t, "ping", messages[2].Command)
+	assert.Equal(t, "recv", messages[2].Direction)
+	assert.Equal(t, strings.Repeat("00", 8), messages[2].Payload)
+
+	require.Equal(t, http.StatusOK, call(t, srv, http.MethodGet, "/peers/"+id+"/messages?limit=1", "", &messages))
+	require.Len(t, messages, 1)
+	assert.Equal(t, "ping", messages[0].Command)
+
+	assert.Equal(t, http.StatusBadRequest, call(t, srv, http.MethodGet, "/peers/"+id+"/messages?limit=x", "", nil))
+
+	assert.Equal(t, http.StatusNoContent, call(t, srv, http.MethodDelete, "/peers/"+id, "", nil))
+	assert.Equal(t, http.StatusNotFound, call(t, srv, http.MethodGet, "/peers/"+id, "", nil))
+	assert.Equal(t, http.StatusNotFound, call(t, srv, http.MethodDelete, "/peers/"+id, "", nil))
+}
+
+func TestServer_AddPeer_Unreachable(t *testing.T) {
+	srv := newTestServer(t)
+
+	// the port is closed right after it's taken
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := l.Addr().String()
+	require.NoError(t, l.Close())
+	host, port, _ := net.SplitHostPort(addr)
+
+	require.Equal(t, http.StatusCreated, call(t, srv, http.MethodPost, "/peers", `{"host":"`+host+`","port":`+port+`}`, nil))
+
+	var status PeerStatus
+	require.Eventually(t, func() bool {
+		call(t, srv, http.MethodGet, "/peers/"+url.PathEscape(addr), "", &status)
+		return status.State == stateFailed
+	}, 2*time.Second, 10*time.Millisecond)
+	assert.Contains(t, status.Error, "refused")
+	assert.Nil(t, status.Handshake)
+}
+
+func TestServer_Handshake(t *testing.T) {
+	srv := newTestServer(t)
+
+	okHost, okPort := listen(t, nodetest.Handshake()...)
+	failHost, failPort := listen(t, nodetest.Expect(model.VersionCMD), nodetest.Close())
+
+	testCases := []struct {
+		name       string
+		body       string
+		expStatus  int
+		expSuccess bool
+		expReason  string
+	}{
+		{
+			name:       "success",
+			body:       `{"host":"` + okHost + `","port":` + strconv.Itoa(okPort) + `}`,
+			expStatus:  http.StatusOK,
+			expSuccess: true,
+		},
+		{
+			name:      "success/failed_handshake",
+			body:      `{"host":"` + failHost + `","port":` + strconv.Itoa(failPort) + `,"timeout":"1s"}`,
+			expStatus: http.StatusOK,
+			expReason: probe.ReasonDisconnected,
+		},
+		{
+			name:      "err/no_host",
+			body:      `{"port":18333}`,
+			expStatus: http.StatusBadRequest,
+		},
+		{
+			name:      "err/invalid_timeout",
+			body:      `{"host":"127.0.0.1","timeout":"1h"}`,
+			expStatus: http.StatusBadRequest,
+		},
+		{
+			name:      "err/unknown_field",
+			body:      `{"host":"127.0.0.1","address":"x"}`,
+			expStatus: http.StatusBadRequest,
+		},
+		{
+			name:      "err/invalid_json",
+			body:      `{`,
+			expStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			var result HandshakeResult
+			require.Equal(t, tc.expStatus, call(t, srv, http.MethodPost, "/handshake", tc.body, &result))
+			if tc.expStatus != http.StatusOK {
+				return
+			}
+
+			assert.Equal(t, tc.expSuccess, result.Success)
+			assert.Equal(t, tc.expReason, result.Reason)
+			if tc.expSuccess {
+				require.NotNil(t, result.Version)
+				assert.Equal(t, int32(70016), result.Version.ProtocolVersion)
+				assert.Positive(t, result.TotalMs)
+			}
+		})
+	}
+}
+
+func TestServer_Healthz(t *testing.T) {
+	srv := newTestServer(t)
+
+	var health map[string]any
+	require.Equal(t, http.StatusOK, call(t, srv, http.MethodGet, "/healthz", "", &health))
+	assert.Equal(t, "ok", health["status"])
+	assert.Equal(t, http.StatusMethodNotAllowed, call(t, srv, http.MethodPost, "/healthz", "", nil))
+}
+
+func TestServer_Metrics(t *testing.T) {
+	srv := newTestServer(t)
+	host, port := listen(t, nodetest.Handshake()...)
+
+	var result HandshakeResult
+	body := `{"host":"` + host + `","port":` + strconv.Itoa(port) + `}`
+	require.Equal(t, http.StatusOK, call(t, srv, http.MethodPost, "/handshake", body, &result))
+	require.True(t, result.Success)
+
+	resp, err := srv.Client().Get(srv.URL + "/metrics")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	raw, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, string(raw), "bitcoin_handshake_successes_total 1\n")
+	assert.Contains(t, string(raw), `bitcoin_messages_total{command="version",direction="in"} 1`+"\n")
+}
+
+func TestServer_Handshake_Traceparent(t *testing.T) {
+	exporter := tracing.NewInMemoryExporter()
+	srv := newTestServer(t, func(cfg *Config) {
+		cfg.Tracer = tracing.NewTracer(exporter)
+	})
+	host, port := listen(t, nodetest.Handshake()...)
+
+	traceparent := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	req, err := http.NewRequest(http.MethodPost, srv.URL+"/handshake",
+		strings.NewReader(`{"host":"`+host+`","port":`+strconv.Itoa(port)+`}`))
+	require.NoError(t, err)
+	req.Header.Set("traceparent", traceparent)
+	resp, err := srv.Client().Do(req)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	spans := exporter.Spans()
+	require.NotEmpty(t, spans)
+	root := spans[len(spans)-1]
+	assert.Equal(t, "handshake", root.Name)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", root.Context.TraceID.String())
+	assert.Equal(t, "00f067aa0ba902b7", root.Parent.String())
+	assert.Empty(t, root.Error)
+}
+
+func TestServer_Time(t *testing.T) {
+	assert.Equal(t, http.StatusNotFound, call(t, newTestServer(t), http.MethodGet, "/time", "", nil))
+
+	srv := newTestServer(t, func(cfg *Config) {
+		cfg.TimeData = timedata.New(timedata.Config{MaxSamples: 10, MinSamples: 1, WarnThreshold: time.Minute})
+	})
+	version := nodetest.DefaultVersion()
+	version.Timestamp = time.Now().Add(time.Hour).Unix()
+	host, port := listen(t,
+		nodetest.Expect(model.VersionCMD),
+		nodetest.SendVersion(version),
+		nodetest.SendVerack(),
+		nodetest.SkipUntil(model.VerackCMD),
+	)
+	id := net.JoinHostPort(host, strconv.Itoa(port))
+
+	body := `{"host":"` + host + `","port":` + strconv.Itoa(port) + `}`
+	require.Equal(t, http.StatusCreated, call(t, srv, http.MethodPost, "/peers", body, nil))
+
+	var status TimeStatus
+	require.Eventually(t, func() bool {
+		call(t, srv, http.MethodGet, "/time", "", &status)
+		return len(status.Peers) == 1
+	}, 2*time.Second, 10*time.Millisecond)
+	assert.Equal(t, id, status.Peers[0].ID)
+	assert.InDelta(t, 3600, status.Peers[0].OffsetS, 1)
+	require.NotNil(t, status.MedianOffsetS)
+	assert.InDelta(t, 3600, *status.MedianOffsetS, 1)
+	assert.True(t, status.Skewed)
+	assert.WithinDuration(t, status.LocalTime.Add(time.Hour), status.AdjustedTime, time.Second)
+
+	var peer PeerStatus
+	require.Equal(t, http.StatusOK, call(t, srv, http.MethodGet, "/peers/"+url.PathEscape(id), "", &peer))
+	require.NotNil(t, peer.Handshake.TimeOffsetS)
+	assert.Equal(t, status.Peers[0].OffsetS, *peer.Handshake.TimeOffsetS)
+
+	// the removed peer isn't a part of the median anymore
+	require.Equal(t, http.StatusNoContent, call(t, srv, http.MethodDelete, "/peers/"+url.PathEscape(id), "", nil))
+	var after TimeStatus
+	require.Equal(t, http.StatusOK, call(t, srv, http.MethodGet, "/time", "", &after))
+	assert.Empty(t, after.Peers)
+	assert.Nil(t, after.MedianOffsetS)
+}
+
+func TestServer_BannedPeer(t *testing.T) {
+	banList, err := banman.New("")
+	require.NoError(t, err)
+	srv := newTestServer(t, func(cfg *Config) {
+		cfg.BanList = banList
+	})
+	badMagic := nodetest.EncodeFrame(0xdeadbeef, "ping", nil)
+	host, port := listen(t, append(nodetest.Handshake(), nodetest.SkipUntil(model.FeeFilterCMD), nodetest.SendRaw(badMagic))...)
+	id := url.PathEscape(net.JoinHostPort(host, strconv.Itoa(port)))
+
+	body := `{"host":"` + host + `","port":` + strconv.Itoa(port) + `}`
+	require.Equal(t, http.StatusCreated, call(t, srv, http.MethodPost, "/peers", body, nil))
+
+	// the peer is disconnected and the reconnect is refused by the ban list
+	var status PeerStatus
+	require.Eventually(t, func() bool {
+		status = PeerStatus{}
+		call(t, srv, http.MethodGet, "/peers/"+id, "", &status)
+		return status.State == model.ConnectionStateGaveUp.String()
+	}, 2*time.Second, 10*time.Millisecond)
+	assert.Equal(t, 100, status.MisbehaviorScore)
+	assert.Contains(t, status.Error, model.ErrPeerBanned.Error())
+	assert.True(t, banList.IsBanned(net.ParseIP(host)))
+}
+
+func TestServer_Bandwidth(t *testing.T) {
+	target := bandwidth.NewUploadTarget(1 << 20)
+	srv := newTestServer(t, func(cfg *Config) {
+		cfg.UploadRate = 1 << 20
+		cfg.UploadTarget = target
+	})
+	host, port := listen(t, nodetest.Handshake()...)
+
+	body := `{"host":"` + host + `","port":` + strconv.Itoa(port) + `}`
+	require.Equal(t, http.StatusCreated, call(t, srv, http.MethodPost, "/peers", body, nil))
+
+	var status BandwidthStatus
+	require.Eventually(t, func() bool {
+		status = BandwidthStatus{}
+		call(t, srv, http.MethodGet, "/bandwidth", "", &status)
+		return status.ReceivedBytes > 0 && status.UploadTarget != nil && status.UploadTarget.Used == status.SentBytes
+	}, 2*time.Second, 10*time.Millisecond)
+	assert.Positive(t, status.SentBytes)
+	assert.Equal(t, uint64(1<<20), status.UploadTarget.Limit)
+	assert.False(t, status.UploadTarget.Reached)
+}
+
+func TestServer_GetAddr(t *testing.T) {
+	addrBook, err := addrman.New("")
+	require.NoError(t, err)
+	addrs := make([]model.NetAddressV2, 0, 100)
+	for i := 1; i <= 100; i++ {
+		addrs = append(addrs, model.NewNetAddressV2FromIP(net.IPv4(1, byte(i), 0, 1), 18333, 0, time.Now().Unix()))
+	}
+	// a few of them may collide in the buckets
+	require.Greater(t, addrBook.Add(addrs, addrs[0]), 90)
+	srv := newTestServer(t, func(cfg *Config) {
+		cfg.AddrBook = addrBook
+		cfg.HandshakeTimeout = 100 * time.Millisecond
+		// getaddr is answered in listener mode only
+		local := model.NewNetAddressV2FromIP(net.ParseIP("5.6.7.8"), 18333, 0, 0)
+		cfg.ListenAddr = &local
+	})
+	// getaddr comes when the timeout of the first handshake is over, the peer is still served
+	host, port := listen(t, append(nodetest.Handshake(),
+		// our address is advertised after the handshake
+		nodetest.SkipUntil(model.AddrCMD),
+		nodetest.Delay(300*time.Millisecond),
+		nodetest.SendMessage(model.GetAddrCMD, nil),
+		nodetest.Expect(model.AddrCMD),
+	)...)
+	id := url.PathEscape(net.JoinHostPort(host, strconv.Itoa(port)))
+
+	body := `{"host":"` + host + `","port":` + strconv.Itoa(port) + `}`
+	require.Equal(t, http.StatusCreated, call(t, srv, http.MethodPost, "/peers", body, nil))
+
+	var status PeerStatus
+	require.Eventually(t, func() bool {
+		status = PeerStatus{}
+		call(t, srv, http.MethodGet, "/peers/"+id, "", &status)
+		return status.Stats.MessagesSent[model.AddrCMD] == 2
+	}, 2*time.Second, 10*time.Millisecond)
+	assert.Equal(t, uint64(1), status.Stats.MessagesReceived[model.GetAddrCMD])
+}
+
+func TestServer_ChainTip(t *testing.T) {
+	assert.Equal(t, http.StatusNotFound, call(t, newTestServer(t), http.MethodGet, "/chaintip", "", nil))
+
+	mine := func(parent model.BlockHeader, count int, salt byte) []model.BlockHeader {
+		var headers []model.BlockHeader
+		for i := 0; i < count; i++ {
+			h := model.BlockHeader{Version: 4, PrevBlock: parent.Hash(), Timestamp: parent.Timestamp + 600, Bits: 0x207fffff}
+			h.MerkleRoot[0] = salt
+			for !h.CheckProofOfWork() {
+				h.Nonce++
+			}
+			headers = append(headers, h)
+			parent = h
+		}
+		return headers
+	}
+	headersPayload := func(headers []model.BlockHeader) []byte {
+		var payload bytes.Buffer
+		require.NoError(t, service.NewEncodeService().EncodeHeadersMessage(&payload, model.HeadersMessage{Headers: headers}))
+		return payload.Bytes()
+	}
+	root := model.BlockHeader{Version: 4, Timestamp: uint32(time.Now().Add(-time.Hour).Unix()), Bits: 0x207fffff}
+	chain := mine(root, 3, 0)
+	// the branch of the second peer has more work
+	branch := mine(chain[0], 3, 1)
+
+	tracker := chaintip.New(root, 0)
+	events, unsubscribe := tracker.Subscribe()
+	t.Cleanup(unsubscribe)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go tracker.Run(ctx)
+	srv := newTestServer(t, func(cfg *Config) {
+		cfg.ChainTip = tracker
+	})
+
+	var status chaintip.Status
+	for _, headers := range [][]model.BlockHeader{chain, branch} {
+		host, port := listen(t, append(nodetest.Handshake(),
+			nodetest.SkipUntil(model.GetHeadersCMD),
+			nodetest.SendMessage(model.HeadersCMD, headersPayload(headers)),
+			nodetest.Delay(100*time.Millisecond),
+		)...)
+		body := `{"host":"` + host + `","port":` + strconv.Itoa(port) + `}`
+		require.Equal(t, http.StatusCreated, call(t, srv, http.MethodPost, "/peers", body, nil))
+
+		require.Eventually(t, func() bool {
+			call(t, srv, http.MethodGet, "/chaintip", "", &status)
+			return status.Tip.Hash == model.HashString(headers[len(headers)-1].Hash())
+		}, 2*time.Second, 10*time.Millisecond)
+	}
+	assert.Equal(t, 4, status.Tip.Height)
+	assert.Equal(t, 1, status.Reorgs)
+
+	var got []chaintip.EventType
+	for len(got) < 2 {
+		select {
+		case event := <-events:
+			got = append(got, event.Type)
+			if event.Type == chaintip.EventReorg {
+				assert.Equal(t, 2, event.Depth)
+				assert.Equal(t, model.HashString(chain[0].Hash()), event.ForkPoint.Hash)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("no chain tip events")
+		}
+	}
+	assert.Equal(t, []chaintip.EventType{chaintip.EventTip, chaintip.EventReorg}, got)
+}
+
+func TestServer_Propagation(t *testing.T) {
+	assert.Equal(t, http.StatusNotFound, call(t, newTestServer(t), http.MethodGet, "/propagation", "", nil))
+
+	srv := newTestServer(t, func(cfg *Config) {
+		cfg.Propagation = propagation.New()
+	})
+	var inv bytes.Buffer
+	require.NoError(t, service.NewEncodeService().EncodeInvMessage(&inv, model.InvMessage{Inventory: []model.InvVect{
+		{Type: model.InvTypeBlock, Hash: [32]byte{1}},
+	}}))
+
+	// the second peer announces the block 200ms after the first one
+	var ids []string
+	for _, delay := range []time.Duration{0, 200 * time.Millisecond} {
+		host, port := listen(t, append(nodetest.Handshake(),
+			nodetest.SkipUntil(model.FeeFilterCMD),
+			nodetest.Delay(delay),
+			nodetest.SendMessage(model.InvCMD, inv.Bytes()),
+			nodetest.Delay(100*time.Millisecond),
+		)...)
+		ids = append(ids, net.JoinHostPort(host, strconv.Itoa(port)))
+		body := `{"host":"` + host + `","port":` + strconv.Itoa(port) + `}`
+		require.Equal(t, http.StatusCreated, call(t, srv, http.MethodPost, "/peers", body, nil))
+	}
+
+	var report propagation.Report
+	require.Eventually(t, func() bool {
+		call(t, srv, http.MethodGet, "/propagation", "", &report)
+		return report.Blocks.Announcements == 1
+	}, 2*time.Second, 10*time.Millisecond)
+	assert.Equal(t, 1, report.Blocks.Items)
+	assert.InDelta(t, 200, report.Blocks.MaxMs, 100)
+
+	require.Len(t, report.Peers, 2)
+	first, second := report.Peers[0], report.Peers[1]
+	if first.Peer != ids[0] {
+		first, second = second, first
+	}
+	assert.Equal(t, ids[0], first.Peer)
+	assert.Equal(t, 1, first.Blocks.First)
+	assert.Equal(t, 0, second.Blocks.First)
+	assert.Equal(t, float64(1), second.Blocks.AvgRank)
+}
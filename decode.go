@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/senseyman/bitcoin-handshake/capture"
+	"github.com/senseyman/bitcoin-handshake/inspect"
+)
+
+// runDecode is the decode subcommand: it prints dissection of wire messages given as hex or capture file.
+func runDecode(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("decode", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	jsonOutput := fs.Bool("json", false, "Print the result as JSON")
+	captureFile := fs.String("capture", "", "Capture file (msgs_recv.dat or msgs_sent.dat) or directory to decode instead of hex")
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: decode [-json] [-capture path] [hex ...]")
+		fmt.Fprintln(stderr, "Decodes one or more wire messages with headers. If no hex is given, it's read from stdin.")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	messages, err := decodeMessages(fs.Args(), *captureFile, stdin)
+	if err != nil {
+		fmt.Fprintf(stderr, "decode: %v\n", err)
+		return 1
+	}
+
+	if *jsonOutput {
+		err = inspect.WriteJSON(stdout, messages)
+	} else {
+		err = inspect.WriteText(stdout, messages)
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "decode: %v\n", err)
+		return 1
+	}
+
+	return 0
+}
+
+func decodeMessages(args []string, captureFile string, stdin io.Reader) ([]inspect.Message, error) {
+	inspector := inspect.New()
+
+	if captureFile != "" {
+		records, err := readCapture(captureFile)
+		if err != nil {
+			return nil, err
+		}
+
+		messages := make([]inspect.Message, 0, len(records))
+		for _, r := range records {
+			messages = append(messages, inspector.Record(r))
+		}
+		return messages, nil
+	}
+
+	input := strings.Join(args, "")
+	if len(args) == 0 {
+		raw, err := io.ReadAll(stdin)
+		if err != nil {
+			return nil, err
+		}
+		input = string(raw)
+	}
+
+	data, err := parseHex(input)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, errors.New("no messages to decode")
+	}
+
+	return inspector.Frames(data), nil
+}
+
+func readCapture(path string) ([]capture.Record, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return capture.ReadDir(path)
+	}
+
+	direction := capture.Received
+	if strings.HasSuffix(path, capture.SentFile) {
+		direction = capture.Sent
+	}
+
+	return capture.ReadFile(path, direction)
+}
+
+// parseHex accepts hex with whitespace and optional 0x prefix, as it's usually copied from logs.
+func parseHex(s string) ([]byte, error) {
+	s = strings.Join(strings.Fields(s), "")
+	s = strings.TrimPrefix(strings.TrimPrefix(s, "0x"), "0X")
+
+	data, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid hex: %w", err)
+	}
+
+	return data, nil
+}
@@ -0,0 +1,149 @@
+package dnsseed
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/senseyman/bitcoin-handshake/client"
+	"github.com/senseyman/bitcoin-handshake/model"
+)
+
+const (
+	lookupTimeout = 30 * time.Second
+)
+
+var (
+	ErrNoSeedAddresses = errors.New("no addresses received from DNS seeds")
+)
+
+type Resolver interface {
+	LookupHost(ctx context.Context, host string) ([]string, error)
+}
+
+type Seed struct {
+	Host string
+	// HasServiceBits is true when the seed supports filtering by services with x<services>. subdomains
+	HasServiceBits bool
+}
+
+// TestNetSeeds are the DNS seeds for testnet3 as listed in Bitcoin Core's chainparams.
+var TestNetSeeds = []Seed{
+	{Host: "testnet-seed.bitcoin.jonasschnelli.ch", HasServiceBits: true},
+	{Host: "seed.tbtc.petertodd.net", HasServiceBits: true},
+	{Host: "seed.testnet.bitcoin.sprovoost.nl", HasServiceBits: true},
+	{Host: "testnet-seed.bluematt.me", HasServiceBits: false},
+}
+
+type Seeder struct {
+	resolver Resolver
+	seeds    []Seed
+	port     int
+	services model.ServiceFlag
+}
+
+// New creates seeder which resolves nodes listening on port. If services is not zero, seeds supporting
+// filtering are asked only for nodes which have all required service bits.
+func New(resolver Resolver, seeds []Seed, port int, services model.ServiceFlag) *Seeder {
+	return &Seeder{
+		resolver: resolver,
+		seeds:    seeds,
+		port:     port,
+		services: services,
+	}
+}
+
+func (s *Seeder) seedHost(seed Seed) string {
+	if s.services == 0 || !seed.HasServiceBits {
+		return seed.Host
+	}
+	return fmt.Sprintf("x%x.%s", uint64(s.services), seed.Host)
+}
+
+// Lookup queries all seeds and returns received addresses in random order. Failed seeds are skipped,
+// an error is returned only if no addresses were received at all.
+func (s *Seeder) Lookup(ctx context.Context) ([]model.NetAddressV2, error) {
+	var (
+		addrs   []model.NetAddressV2
+		seen    = make(map[string]struct{})
+		lastErr error
+		now     = time.Now().Unix()
+	)
+
+	for _, seed := range s.seeds {
+		host := s.seedHost(seed)
+		hosts, err := s.resolver.LookupHost(ctx, host)
+		if err != nil {
+			log.Warnf("err while querying DNS seed %s: %v", host, err)
+			lastErr = err
+			continue
+		}
+		log.Debugf("got %d addresses from DNS seed %s", len(hosts), host)
+
+		for _, h := range hosts {
+			ip := net.ParseIP(h)
+			if ip == nil {
+				continue
+			}
+			addr := model.NewNetAddressV2FromIP(ip, uint16(s.port), s.services, now)
+			if _, ok := seen[addr.Key()]; ok {
+				continue
+			}
+			seen[addr.Key()] = struct{}{}
+			addrs = append(addrs, addr)
+		}
+	}
+
+	if len(addrs) == 0 {
+		if lastErr != nil {
+			return nil, fmt.Errorf("%w: %w", ErrNoSeedAddresses, lastErr)
+		}
+		return nil, ErrNoSeedAddresses
+	}
+
+	rand.Shuffle(len(addrs), func(i, j int) {
+		addrs[i], addrs[j] = addrs[j], addrs[i]
+	})
+
+	return addrs, nil
+}
+
+// ConnectionFn wraps dial function, so it can be used by client.NewBitcoinClient without a node host.
+// If host is empty, the seeds are queried and resolved nodes are dialed one by one until one of them accepts
+// the connection. Otherwise, the host is dialed directly.
+func (s *Seeder) ConnectionFn(
+	dial func(host string, port int) (client.Connection, error),
+) func(host string, port int) (client.Connection, error) {
+	return func(host string, port int) (client.Connection, error) {
+		if host != "" {
+			return dial(host, port)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), lookupTimeout)
+		defer cancel()
+
+		addrs, err := s.Lookup(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		var lastErr error
+		for _, addr := range addrs {
+			conn, err := dial(addr.Host(), int(addr.Port))
+			if err != nil {
+				log.Debugf("err while connecting to seed node %s: %v", addr, err)
+				lastErr = err
+				continue
+			}
+			log.Infof("connected to node %s received from DNS seeds", addr)
+			return conn, nil
+		}
+
+		return nil, fmt.Errorf("failed to connect to any of %d seed nodes: %w", len(addrs), lastErr)
+	}
+}
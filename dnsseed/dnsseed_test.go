@@ -0,0 +1,269 @@
+package dnsseed
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/senseyman/bitcoin-handshake/client"
+	"github.com/senseyman/bitcoin-handshake/model"
+)
+
+const (
+	dnsTypeA       = 1
+	dnsRcodeNXName = 3
+)
+
+// fakeDNSServer answers A queries for known names over UDP on the loopback interface.
+type fakeDNSServer struct {
+	conn net.PacketConn
+
+	mu      sync.Mutex
+	records map[string][]string
+	queries []string
+}
+
+func newFakeDNSServer(t *testing.T, records map[string][]string) *fakeDNSServer {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	s := &fakeDNSServer{conn: conn, records: records}
+	go s.serve()
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+
+	return s
+}
+
+func (s *fakeDNSServer) resolver() *net.Resolver {
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "udp", s.conn.LocalAddr().String())
+		},
+	}
+}
+
+func (s *fakeDNSServer) serve() {
+	buf := make([]byte, 512)
+	for {
+		n, addr, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		if resp := s.answer(buf[:n]); resp != nil {
+			_, _ = s.conn.WriteTo(resp, addr)
+		}
+	}
+}
+
+func (s *fakeDNSServer) answer(query []byte) []byte {
+	if len(query) < 12 {
+		return nil
+	}
+
+	// read the name of the first question
+	var labels []string
+	off := 12
+	for off < len(query) && query[off] != 0 {
+		l := int(query[off])
+		if off+1+l > len(query) {
+			return nil
+		}
+		labels = append(labels, string(query[off+1:off+1+l]))
+		off += 1 + l
+	}
+	off++ // zero length root label
+	if off+4 > len(query) {
+		return nil
+	}
+	qtype := binary.BigEndian.Uint16(query[off:])
+	question := query[12 : off+4]
+	name := strings.ToLower(strings.Join(labels, "."))
+
+	s.mu.Lock()
+	ips, ok := s.records[name]
+	if qtype == dnsTypeA {
+		s.queries = append(s.queries, name)
+	}
+	s.mu.Unlock()
+
+	resp := make([]byte, 12, 512)
+	copy(resp, query[:2])                        // id
+	binary.BigEndian.PutUint16(resp[2:], 0x8180) // response, recursion desired and available
+	binary.BigEndian.PutUint16(resp[4:], 1)      // questions
+	if !ok {
+		resp[3] |= dnsRcodeNXName
+	}
+
+	var answers int
+	if ok && qtype == dnsTypeA {
+		answers = len(ips)
+	}
+	binary.BigEndian.PutUint16(resp[6:], uint16(answers))
+	resp = append(resp, question...)
+
+	for i := 0; i < answers; i++ {
+		resp = append(resp, 0xc0, 12)                     // pointer to the name in question
+		resp = append(resp, 0, dnsTypeA, 0, 1)            // type A, class IN
+		resp = append(resp, 0, 0, 0, 60)                  // ttl
+		resp = append(resp, 0, 4)                         // rdata length
+		resp = append(resp, net.ParseIP(ips[i]).To4()...) // rdata
+	}
+
+	return resp
+}
+
+func (s *fakeDNSServer) getQueries() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]string(nil), s.queries...)
+}
+
+func TestSeeder_Lookup(t *testing.T) {
+	seeds := []Seed{
+		{Host: "seed.filtering.test", HasServiceBits: true},
+		{Host: "seed.plain.test", HasServiceBits: false},
+	}
+
+	testCases := []struct {
+		name       string
+		records    map[string][]string
+		services   model.ServiceFlag
+		expAddrs   []string
+		expQueries []string
+		hasErr     bool
+	}{
+		{
+			name: "success",
+			records: map[string][]string{
+				"seed.filtering.test": {"1.1.1.1", "2.2.2.2"},
+				"seed.plain.test":     {"2.2.2.2", "3.3.3.3"},
+			},
+			expAddrs:   []string{"1.1.1.1:18333", "2.2.2.2:18333", "3.3.3.3:18333"},
+			expQueries: []string{"seed.filtering.test", "seed.plain.test"},
+		},
+		{
+			name: "success/service_bits",
+			records: map[string][]string{
+				"x9.seed.filtering.test": {"1.1.1.1"},
+				"seed.plain.test":        {"3.3.3.3"},
+			},
+			services:   9,
+			expAddrs:   []string{"1.1.1.1:18333", "3.3.3.3:18333"},
+			expQueries: []string{"x9.seed.filtering.test", "seed.plain.test"},
+		},
+		{
+			name: "success/one_seed_failed",
+			records: map[string][]string{
+				"seed.plain.test": {"3.3.3.3"},
+			},
+			expAddrs:   []string{"3.3.3.3:18333"},
+			expQueries: []string{"seed.filtering.test", "seed.plain.test"},
+		},
+		{
+			name:       "err/no_addresses",
+			records:    map[string][]string{},
+			expQueries: []string{"seed.filtering.test", "seed.plain.test"},
+			hasErr:     true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			srv := newFakeDNSServer(t, tc.records)
+			seeder := New(srv.resolver(), seeds, 18333, tc.services)
+
+			addrs, err := seeder.Lookup(context.Background())
+			if tc.hasErr {
+				assert.ErrorIs(t, err, ErrNoSeedAddresses)
+			} else {
+				require.NoError(t, err)
+				var got []string
+				for _, addr := range addrs {
+					got = append(got, addr.String())
+					assert.Equal(t, tc.services, addr.Services)
+				}
+				assert.ElementsMatch(t, tc.expAddrs, got)
+			}
+
+			for _, q := range tc.expQueries {
+				assert.Contains(t, srv.getQueries(), q)
+			}
+		})
+	}
+}
+
+func TestSeeder_ConnectionFn(t *testing.T) {
+	srv := newFakeDNSServer(t, map[string][]string{
+		"seed.test": {"1.1.1.1", "2.2.2.2", "3.3.3.3"},
+	})
+	seeder := New(srv.resolver(), []Seed{{Host: "seed.test"}}, 18333, 0)
+
+	testCases := []struct {
+		name       string
+		host       string
+		reachable  string
+		expDialled []string
+		hasErr     bool
+	}{
+		{
+			name:       "success/explicit_host",
+			host:       "4.4.4.4",
+			reachable:  "4.4.4.4",
+			expDialled: []string{"4.4.4.4"},
+		},
+		{
+			name:      "success/from_seeds",
+			reachable: "2.2.2.2",
+		},
+		{
+			name:   "err/nothing_reachable",
+			hasErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			var dialled []string
+			fn := seeder.ConnectionFn(func(host string, port int) (client.Connection, error) {
+				dialled = append(dialled, host)
+				if host != tc.reachable {
+					return nil, errors.New("connection refused")
+				}
+				assert.Equal(t, 18333, port)
+				c1, c2 := net.Pipe()
+				_ = c2.Close()
+				return c1, nil
+			})
+
+			conn, err := fn(tc.host, 18333)
+			if tc.hasErr {
+				assert.Error(t, err)
+				assert.Len(t, dialled, 3)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.NotNil(t, conn)
+			assert.Equal(t, tc.reachable, dialled[len(dialled)-1])
+			if tc.expDialled != nil {
+				assert.Equal(t, tc.expDialled, dialled)
+			}
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/senseyman/bitcoin-handshake/fingerprint"
+)
+
+// runFingerprint is the fingerprint subcommand: it handshakes the node and classifies its implementation.
+func runFingerprint(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	cfg := fingerprint.DefaultConfig()
+
+	fs := flag.NewFlagSet("fingerprint", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	host := fs.String("node.host", "127.0.0.1", "Host of blockchain node")
+	port := fs.Int("node.port", 18333, "Port of blockchain node")
+	fs.DurationVar(&cfg.Timeout, "timeout", cfg.Timeout, "Timeout of the handshake, including connect")
+	fs.DurationVar(&cfg.Observe, "observe", cfg.Observe, "How long to collect the messages of the node after the handshake")
+	asJSON := fs.Bool("json", false, "Print the report as JSON")
+	proxyAddr := fs.String("proxy", "", "Connect through SOCKS5 proxy, e.g. Tor at 127.0.0.1:9050")
+	verbose := fs.Bool("v", false, "Write the logs of the handshake to stderr")
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: fingerprint [flags]")
+		fmt.Fprintln(stderr, "Handshakes the node, classifies its implementation and reports protocol deviations.")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if cfg.Timeout <= 0 || cfg.Observe < 0 {
+		fmt.Fprintln(stderr, "fingerprint: timeout must be positive and observe can't be negative")
+		return 2
+	}
+
+	if !*verbose {
+		log.SetOutput(io.Discard)
+	}
+
+	report, err := fingerprint.Run(ctx, probeDial(cfg.Timeout, *proxyAddr), *host, *port, cfg)
+	if *asJSON {
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(report); err != nil {
+			return 1
+		}
+	} else if err := report.WriteText(stdout); err != nil {
+		return 1
+	}
+	if err != nil {
+		return 1
+	}
+
+	return 0
+}
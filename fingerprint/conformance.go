@@ -0,0 +1,108 @@
+package fingerprint
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Conformance deviations, they are stable so the reports can be grouped by them.
+const (
+	DeviationMissingVersion         = "missing_version"
+	DeviationMissingVerack          = "missing_verack"
+	DeviationDuplicateVersion       = "duplicate_version"
+	DeviationDuplicateVerack        = "duplicate_verack"
+	DeviationMessageBeforeVersion   = "message_before_version"
+	DeviationNegotiationAfterVerack = "negotiation_after_verack"
+	DeviationUnsupportedFeature     = "unsupported_feature"
+	DeviationMissingRelay           = "missing_relay"
+	DeviationUserAgentFormat        = "user_agent_format"
+	DeviationClockSkew              = "clock_skew"
+	DeviationMalformedMessage       = "malformed_message"
+)
+
+const (
+	// maxTimeOffset is the offset of the node clock which Bitcoin Core tolerates
+	maxTimeOffset = 70 * time.Minute
+	// relayProtocolVersion is the version since which the relay field is mandatory (BIP37)
+	relayProtocolVersion = 70001
+)
+
+var (
+	// negotiationMessages must be sent between version and verack, with the protocol version they appeared in
+	negotiationMessages = map[string]int32{
+		"wtxidrelay":  70016, // BIP339
+		"sendaddrv2":  70016, // BIP155
+		"sendtxrcncl": 70016, // BIP330
+	}
+
+	// userAgentFormat is BIP14: /Name:Version(comments)/Name:Version/
+	userAgentFormat = regexp.MustCompile(`^(/[^/:()]+:[^/:()]+(\([^()]*\))?)+/$`)
+)
+
+type Deviation struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Check finds where the node deviates from the protocol, the traits are expected to be of the complete handshake.
+func Check(t Traits) []Deviation {
+	var deviations []Deviation
+	add := func(code, format string, args ...any) {
+		deviations = append(deviations, Deviation{Code: code, Message: fmt.Sprintf(format, args...)})
+	}
+
+	switch {
+	case t.Versions == 0:
+		add(DeviationMissingVersion, "the node didn't send version")
+	case t.Versions > 1:
+		add(DeviationDuplicateVersion, "the node sent version %d times", t.Versions)
+	}
+	switch {
+	case t.Veracks == 0:
+		add(DeviationMissingVerack, "the node didn't send verack")
+	case t.Veracks > 1:
+		add(DeviationDuplicateVerack, "the node sent verack %d times", t.Veracks)
+	}
+	if len(t.BeforeVersion) > 0 {
+		add(DeviationMessageBeforeVersion, "the node sent %s before version", strings.Join(t.BeforeVersion, ", "))
+	}
+
+	for _, cmd := range unique(t.PostVerack) {
+		if _, ok := negotiationMessages[cmd]; ok {
+			add(DeviationNegotiationAfterVerack, "the node sent %s after verack, it must be sent before", cmd)
+		}
+	}
+	if t.Versions > 0 {
+		for _, cmd := range unique(append(slices.Clone(t.PreVerack), t.PostVerack...)) {
+			if minVersion, ok := negotiationMessages[cmd]; ok && t.ProtocolVersion < minVersion {
+				add(DeviationUnsupportedFeature, "the node sent %s with protocol version %d, it needs %d",
+					cmd, t.ProtocolVersion, minVersion)
+			}
+		}
+		if t.Relay == nil && t.ProtocolVersion >= relayProtocolVersion {
+			add(DeviationMissingRelay, "the node didn't send relay field with protocol version %d", t.ProtocolVersion)
+		}
+		if t.UserAgent != "" && !userAgentFormat.MatchString(t.UserAgent) {
+			add(DeviationUserAgentFormat, "user agent %q doesn't follow BIP14", t.UserAgent)
+		}
+		if t.TimeOffset > maxTimeOffset || t.TimeOffset < -maxTimeOffset {
+			add(DeviationClockSkew, "the clock of the node is off by %s", t.TimeOffset.Round(time.Second))
+		}
+	}
+
+	for _, cmd := range t.Malformed {
+		add(DeviationMalformedMessage, "the node sent malformed %s", cmd)
+	}
+
+	return deviations
+}
+
+// unique returns sorted commands without duplicates.
+func unique(commands []string) []string {
+	commands = slices.Clone(commands)
+	slices.Sort(commands)
+	return slices.Compact(commands)
+}
@@ -0,0 +1,104 @@
+package fingerprint
+
+import (
+	"context"
+	"time"
+
+	"github.com/senseyman/bitcoin-handshake/client"
+	"github.com/senseyman/bitcoin-handshake/probe"
+)
+
+type Config struct {
+	// Timeout limits the handshake, including connect
+	Timeout time.Duration
+	// Observe is how long the messages are collected after the handshake
+	Observe time.Duration
+	Rules   []Rule
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Timeout: 30 * time.Second,
+		Observe: 3 * time.Second,
+		Rules:   DefaultRules,
+	}
+}
+
+// Report is the fingerprint of the node.
+type Report struct {
+	Host  string    `json:"host"`
+	Port  int       `json:"port"`
+	Time  time.Time `json:"time"`
+	Error string    `json:"error,omitempty"`
+	// ConnectMs and TotalMs are the timings of the successful handshake
+	ConnectMs      float64         `json:"connect_ms,omitempty"`
+	TotalMs        float64         `json:"total_ms,omitempty"`
+	Traits         Traits          `json:"traits"`
+	Classification Classification  `json:"classification"`
+	Deviations     []Deviation     `json:"deviations"`
+	Timeline       []TimelineEntry `json:"timeline"`
+}
+
+// TimelineEntry is the message of the connection, the time is counted from our first message.
+type TimelineEntry struct {
+	AtMs      float64 `json:"at_ms"`
+	Direction string  `json:"direction"`
+	Command   string  `json:"command"`
+	Size      int     `json:"size"`
+}
+
+// Run handshakes the node, keeps the connection open to observe what the node sends after the handshake,
+// then classifies the node. The report is filled as much as possible if the handshake fails.
+func Run(
+	ctx context.Context,
+	dial func(host string, port int) (client.Connection, error),
+	host string,
+	port int,
+	cfg Config,
+) (Report, error) {
+	report := Report{Host: host, Port: port, Time: time.Now()}
+
+	recorder := NewRecorder()
+	prober := probe.New(dial, probe.WithRecorder(recorder), probe.WithLinger(cfg.Observe))
+
+	ctx, cancel := context.WithTimeout(ctx, cfg.Timeout+cfg.Observe)
+	defer cancel()
+	result, err := prober.Handshake(ctx, host, port)
+	if err != nil {
+		report.Error = err.Error()
+	} else {
+		report.ConnectMs = ms(result.Connect)
+		report.TotalMs = ms(result.Total)
+	}
+
+	messages := recorder.Messages()
+	report.Traits = Extract(messages)
+	report.Timeline = timeline(messages)
+	if report.Traits.Versions > 0 {
+		report.Classification = Classify(report.Traits, cfg.Rules)
+	}
+	// the failed handshake is a deviation by itself only if the node answered at all
+	if len(messages) > 1 {
+		report.Deviations = Check(report.Traits)
+	}
+
+	return report, err
+}
+
+func timeline(messages []Message) []TimelineEntry {
+	entries := make([]TimelineEntry, 0, len(messages))
+	for _, msg := range messages {
+		entries = append(entries, TimelineEntry{
+			AtMs:      ms(msg.Time.Sub(messages[0].Time)),
+			Direction: msg.Direction.String(),
+			Command:   msg.Command,
+			Size:      len(msg.Payload),
+		})
+	}
+
+	return entries
+}
+
+func ms(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
@@ -0,0 +1,259 @@
+package fingerprint
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/senseyman/bitcoin-handshake/client"
+	"github.com/senseyman/bitcoin-handshake/model"
+	"github.com/senseyman/bitcoin-handshake/nodetest"
+	"github.com/senseyman/bitcoin-handshake/service"
+)
+
+func dial(host string, port int) (client.Connection, error) {
+	return net.DialTimeout("tcp", net.JoinHostPort(host, strconv.Itoa(port)), time.Second)
+}
+
+func sendcmpct(version uint64) nodetest.Step {
+	payload := make([]byte, 9)
+	binary.LittleEndian.PutUint64(payload[1:], version)
+	return nodetest.SendMessage("sendcmpct", payload)
+}
+
+func TestRun(t *testing.T) {
+	versionWithoutRelay := func() []byte {
+		msg := nodetest.DefaultVersion()
+		msg.Version = 70015
+		msg.UserAgent = "Satoshi 27"
+		var b bytes.Buffer
+		require.NoError(t, service.NewEncodeService().EncodeVersionMessage(&b, msg))
+		return b.Bytes()[:b.Len()-1]
+	}()
+	btcdVersion := nodetest.DefaultVersion()
+	btcdVersion.UserAgent = "/btcwire:0.5.0/btcd:0.24.2/"
+
+	testCases := []struct {
+		name              string
+		steps             []nodetest.Step
+		expImpl           string
+		expVersion        string
+		expSpoofed        bool
+		expDeviations     []string
+		expPreVerack      []string
+		expCompactVersion []uint64
+		hasErr            bool
+	}{
+		{
+			name: "success/bitcoin_core",
+			steps: []nodetest.Step{
+				nodetest.Expect(model.VersionCMD),
+				nodetest.SendVersion(nodetest.DefaultVersion()),
+				nodetest.SendMessage("wtxidrelay", nil),
+				nodetest.SendMessage("sendaddrv2", nil),
+				nodetest.SendVerack(),
+				nodetest.Expect(model.VerackCMD),
+				nodetest.SendMessage("sendheaders", nil),
+				sendcmpct(2),
+				nodetest.SendMessage("ping", make([]byte, 8)),
+				nodetest.SendMessage("feefilter", []byte{0xe8, 0x03, 0, 0, 0, 0, 0, 0}),
+			},
+			expImpl:           "Bitcoin Core",
+			expVersion:        "27.0.0",
+			expPreVerack:      []string{"wtxidrelay", "sendaddrv2"},
+			expCompactVersion: []uint64{2},
+		},
+		{
+			name: "success/btcd",
+			steps: []nodetest.Step{
+				nodetest.Expect(model.VersionCMD),
+				nodetest.SendVersion(btcdVersion),
+				nodetest.SendMessage("sendaddrv2", nil),
+				nodetest.SendVerack(),
+				nodetest.Expect(model.VerackCMD),
+				nodetest.SendMessage("sendheaders", nil),
+			},
+			expImpl:      "btcd",
+			expVersion:   "0.24.2",
+			expPreVerack: []string{"sendaddrv2"},
+		},
+		{
+			name: "success/btcd_spoofing_bitcoin_core",
+			steps: []nodetest.Step{
+				nodetest.Expect(model.VersionCMD),
+				nodetest.SendVersion(nodetest.DefaultVersion()),
+				nodetest.SendMessage("sendaddrv2", nil),
+				nodetest.SendVerack(),
+				nodetest.Expect(model.VerackCMD),
+				nodetest.SendMessage("sendheaders", nil),
+			},
+			expImpl:      "Bitcoin Core",
+			expVersion:   "27.0.0",
+			expSpoofed:   true,
+			expPreVerack: []string{"sendaddrv2"},
+		},
+		{
+			name: "success/deviations",
+			steps: []nodetest.Step{
+				nodetest.Expect(model.VersionCMD),
+				nodetest.SendMessage("ping", make([]byte, 8)),
+				nodetest.SendMessage(model.VersionCMD, versionWithoutRelay),
+				nodetest.SendVerack(),
+				nodetest.Expect(model.VerackCMD),
+				nodetest.SendMessage("wtxidrelay", nil),
+				nodetest.SendMessage("sendcmpct", []byte{1, 2, 3}),
+				nodetest.SendVerack(),
+			},
+			// the user agent is unknown and the behaviour is closer to bcoin than to old Bitcoin Core
+			expImpl:    "bcoin",
+			expVersion: ">=1.0",
+			expDeviations: []string{
+				DeviationDuplicateVerack,
+				DeviationMessageBeforeVersion,
+				DeviationNegotiationAfterVerack,
+				DeviationUnsupportedFeature,
+				DeviationMissingRelay,
+				DeviationUserAgentFormat,
+				DeviationMalformedMessage,
+			},
+		},
+		{
+			name: "err/closed",
+			steps: []nodetest.Step{
+				nodetest.Expect(model.VersionCMD),
+				nodetest.Close(),
+			},
+			hasErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			host, port, err := nodetest.NewPeer(tc.steps...).ListenTCP()
+			require.NoError(t, err)
+
+			cfg := DefaultConfig()
+			cfg.Timeout = time.Second
+			cfg.Observe = 200 * time.Millisecond
+			report, err := Run(context.Background(), dial, host, port, cfg)
+			if tc.hasErr {
+				assert.Error(t, err)
+				assert.NotEmpty(t, report.Error)
+				assert.Empty(t, report.Classification.Best.Implementation)
+				assert.Empty(t, report.Deviations)
+				require.NotEmpty(t, report.Timeline)
+				assert.Equal(t, model.VersionCMD, report.Timeline[0].Command)
+				return
+			}
+			require.NoError(t, err)
+
+			assert.Equal(t, tc.expImpl, report.Classification.Best.Implementation)
+			assert.Equal(t, tc.expVersion, report.Classification.Best.Version)
+			assert.Equal(t, tc.expSpoofed, report.Classification.Spoofed)
+			assert.Equal(t, tc.expPreVerack, report.Traits.PreVerack)
+			assert.Equal(t, tc.expCompactVersion, report.Traits.CompactVersions)
+
+			codes := make([]string, 0, len(report.Deviations))
+			for _, d := range report.Deviations {
+				codes = append(codes, d.Code)
+			}
+			assert.ElementsMatch(t, tc.expDeviations, codes)
+
+			assert.Positive(t, report.TotalMs)
+			assert.Positive(t, report.Traits.VersionDelay)
+			// the timeline has our messages too
+			assert.Equal(t, model.VersionCMD, report.Timeline[0].Command)
+			assert.Equal(t, "sent", report.Timeline[0].Direction)
+
+			var b bytes.Buffer
+			require.NoError(t, report.WriteText(&b))
+			assert.Contains(t, b.String(), "implementation: "+tc.expImpl+" "+tc.expVersion)
+		})
+	}
+}
+
+func TestClassify(t *testing.T) {
+	testCases := []struct {
+		name          string
+		traits        Traits
+		expImpl       string
+		expVersion    string
+		expUserAgent  bool
+		expMismatches []string
+	}{
+		{
+			name: "knots",
+			traits: Traits{
+				UserAgent:       "/Satoshi:27.1.0/Knots:20240801/",
+				ProtocolVersion: 70016,
+				PreVerack:       []string{"wtxidrelay", "sendaddrv2"},
+			},
+			expImpl:      "Bitcoin Knots",
+			expVersion:   "20240801",
+			expUserAgent: true,
+		},
+		{
+			name: "bcoin",
+			traits: Traits{
+				UserAgent:       "/bcoin:2.2.0/",
+				ProtocolVersion: 70015,
+				PostVerack:      []string{"sendcmpct", "sendheaders"},
+			},
+			expImpl:      "bcoin",
+			expVersion:   "2.2.0",
+			expUserAgent: true,
+		},
+		{
+			name: "unknown_user_agent_by_behaviour",
+			traits: Traits{
+				UserAgent:       "/custom:1.0/",
+				ProtocolVersion: 70016,
+				PreVerack:       []string{"sendaddrv2", "wtxidrelay"},
+			},
+			expImpl:       "Bitcoin Knots",
+			expVersion:    ">=0.21",
+			expMismatches: []string{"user agent /custom:1.0/", "order of wtxidrelay, sendaddrv2"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			c := Classify(tc.traits, DefaultRules)
+			assert.Equal(t, tc.expImpl, c.Best.Implementation)
+			assert.Equal(t, tc.expVersion, c.Best.Version)
+			assert.Equal(t, tc.expUserAgent, c.Best.UserAgentMatch)
+			assert.Equal(t, tc.expMismatches, c.Best.Mismatches)
+			assert.False(t, c.Spoofed)
+			assert.Len(t, c.Candidates, len(DefaultRules))
+		})
+	}
+}
+
+func TestCheck_ClockSkew(t *testing.T) {
+	relay := true
+	traits := Traits{
+		UserAgent:       "/Satoshi:27.0.0/",
+		ProtocolVersion: 70016,
+		Relay:           &relay,
+		Versions:        1,
+		Veracks:         1,
+	}
+	assert.Empty(t, Check(traits))
+
+	traits.TimeOffset = -2 * time.Hour
+	deviations := Check(traits)
+	require.Len(t, deviations, 1)
+	assert.Equal(t, DeviationClockSkew, deviations[0].Code)
+	assert.Contains(t, deviations[0].Message, "-2h0m0s")
+}
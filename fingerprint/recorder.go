@@ -0,0 +1,57 @@
+package fingerprint
+
+import (
+	"sync"
+	"time"
+
+	"github.com/senseyman/bitcoin-handshake/capture"
+	"github.com/senseyman/bitcoin-handshake/model"
+)
+
+// Message is the message of the connection as it was seen on the wire.
+type Message struct {
+	Time      time.Time
+	Direction capture.Direction
+	Command   string
+	Payload   []byte
+}
+
+// Recorder keeps the order and timing of all messages of the connection, including the ones which
+// aren't parsed by core. It implements client.Recorder.
+type Recorder struct {
+	mu       sync.Mutex
+	messages []Message
+}
+
+func NewRecorder() *Recorder {
+	return &Recorder{}
+}
+
+func (r *Recorder) RecordReceived(header model.MessageHeader, payload []byte) {
+	r.record(capture.Received, header, payload)
+}
+
+func (r *Recorder) RecordSent(header model.MessageHeader, payload []byte) {
+	r.record(capture.Sent, header, payload)
+}
+
+func (r *Recorder) record(direction capture.Direction, header model.MessageHeader, payload []byte) {
+	msg := Message{
+		Time:      time.Now(),
+		Direction: direction,
+		Command:   header.Command,
+		Payload:   append([]byte(nil), payload...),
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.messages = append(r.messages, msg)
+}
+
+// Messages returns the recorded messages in the order they were sent and received.
+func (r *Recorder) Messages() []Message {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]Message(nil), r.messages...)
+}
@@ -0,0 +1,70 @@
+package fingerprint
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+func (r Report) WriteText(w io.Writer) error {
+	fmt.Fprintf(w, "node: %s:%d\n", r.Host, r.Port)
+	if r.Error != "" {
+		fmt.Fprintf(w, "handshake failed: %s\n", r.Error)
+	} else {
+		fmt.Fprintf(w, "handshake: connect %.2f ms, total %.2f ms\n", r.ConnectMs, r.TotalMs)
+	}
+
+	t := r.Traits
+	if t.Versions > 0 {
+		relay := "missing"
+		if t.Relay != nil {
+			relay = fmt.Sprint(*t.Relay)
+		}
+		fmt.Fprintf(w, "user agent: %s\n", t.UserAgent)
+		fmt.Fprintf(w, "protocol version: %d, services: %s, start height: %d, relay: %s\n",
+			t.ProtocolVersion, t.Services, t.StartHeight, relay)
+		fmt.Fprintf(w, "clock offset: %s, version delay: %s, verack delay: %s, eager verack: %t\n",
+			t.TimeOffset.Round(time.Second), t.VersionDelay.Round(time.Microsecond),
+			t.VerackDelay.Round(time.Microsecond), t.EagerVerack)
+	}
+	fmt.Fprintf(w, "before verack: %s\n", list(t.PreVerack))
+	fmt.Fprintf(w, "after verack: %s\n", list(t.PostVerack))
+
+	if best := r.Classification.Best; best.Implementation != "" {
+		fmt.Fprintf(w, "\nimplementation: %s %s (score %.2f, behaviour %.2f)\n",
+			best.Implementation, best.Version, best.Score, best.BehaviourScore)
+		if r.Classification.Spoofed {
+			fmt.Fprintln(w, "  the user agent doesn't match the behaviour, it may be spoofed")
+		}
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "  candidate\tversions\tscore\tmismatches")
+		for _, m := range r.Classification.Candidates {
+			fmt.Fprintf(tw, "  %s\t%s\t%.2f\t%s\n", m.Implementation, m.Version, m.Score, list(m.Mismatches))
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+	}
+
+	fmt.Fprintf(w, "\ndeviations: %d\n", len(r.Deviations))
+	for _, d := range r.Deviations {
+		fmt.Fprintf(w, "  %s: %s\n", d.Code, d.Message)
+	}
+
+	fmt.Fprintln(w, "\ntimeline:")
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, e := range r.Timeline {
+		fmt.Fprintf(tw, "  %.2f ms\t%s\t%s\t%d bytes\n", e.AtMs, e.Direction, e.Command, e.Size)
+	}
+
+	return tw.Flush()
+}
+
+func list(items []string) string {
+	if len(items) == 0 {
+		return "-"
+	}
+	return strings.Join(items, ", ")
+}
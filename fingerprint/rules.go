@@ -0,0 +1,207 @@
+package fingerprint
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+)
+
+const (
+	weightUserAgent  = 4
+	weightProtocol   = 1
+	weightPreVerack  = 2
+	weightPostVerack = 2
+)
+
+// Rule is the behaviour of the implementation in a range of its versions.
+type Rule struct {
+	Implementation string
+	// Version is the range of versions which behave like this
+	Version string
+	// UserAgent matches the user agent of the implementation, the first group of it is the exact version
+	UserAgent *regexp.Regexp
+	// ProtocolVersion is the version in version message
+	ProtocolVersion int32
+	// PreVerack are the messages sent between version and verack, in this order
+	PreVerack []string
+	// PostVerack are the messages sent right after verack, only their presence is checked as the order
+	// depends on the state of the node
+	PostVerack []string
+}
+
+// DefaultRules are the known implementations. The behaviour is approximate, it's what the implementations
+// do by default when they accept an inbound connection from a client which doesn't serve blocks.
+var DefaultRules = []Rule{
+	{
+		Implementation:  "Bitcoin Knots",
+		Version:         ">=0.21",
+		UserAgent:       regexp.MustCompile(`^/Satoshi:[0-9.]+/Knots:([0-9]+)[^/]*/$`),
+		ProtocolVersion: 70016,
+		PreVerack:       []string{"wtxidrelay", "sendaddrv2"},
+		PostVerack:      []string{"sendheaders", "sendcmpct", "ping", "feefilter"},
+	},
+	{
+		Implementation:  "Bitcoin Core",
+		Version:         ">=0.21",
+		UserAgent:       regexp.MustCompile(`^/Satoshi:([0-9]+(?:\.[0-9]+)+)/$`),
+		ProtocolVersion: 70016,
+		PreVerack:       []string{"wtxidrelay", "sendaddrv2"},
+		PostVerack:      []string{"sendheaders", "sendcmpct", "ping", "feefilter"},
+	},
+	{
+		Implementation:  "Bitcoin Core",
+		Version:         "0.13-0.20",
+		UserAgent:       regexp.MustCompile(`^/Satoshi:([0-9]+(?:\.[0-9]+)+)/$`),
+		ProtocolVersion: 70015,
+		PostVerack:      []string{"sendheaders", "sendcmpct", "ping", "feefilter"},
+	},
+	{
+		Implementation:  "btcd",
+		Version:         ">=0.23",
+		UserAgent:       regexp.MustCompile(`^/btcwire:[0-9.]+/btcd:([0-9]+(?:\.[0-9]+)+)/`),
+		ProtocolVersion: 70016,
+		PreVerack:       []string{"sendaddrv2"},
+		PostVerack:      []string{"sendheaders"},
+	},
+	{
+		Implementation:  "btcd",
+		Version:         "<0.23",
+		UserAgent:       regexp.MustCompile(`^/btcwire:[0-9.]+/btcd:([0-9]+(?:\.[0-9]+)+)/`),
+		ProtocolVersion: 70013,
+		PostVerack:      []string{"sendheaders"},
+	},
+	{
+		Implementation:  "bcoin",
+		Version:         ">=1.0",
+		UserAgent:       regexp.MustCompile(`^/bcoin:([0-9]+(?:\.[0-9]+)+)/`),
+		ProtocolVersion: 70015,
+		PostVerack:      []string{"sendheaders", "sendcmpct"},
+	},
+	{
+		Implementation:  "libbitcoin",
+		Version:         ">=3.0",
+		UserAgent:       regexp.MustCompile(`^/libbitcoin:([0-9]+(?:\.[0-9]+)+)/`),
+		ProtocolVersion: 70013,
+	},
+}
+
+// Match is how well the traits match the rule.
+type Match struct {
+	Implementation string `json:"implementation"`
+	Version        string `json:"version"`
+	// Score is from 0 to 1, it's the weighted share of the matched traits
+	Score float64 `json:"score"`
+	// BehaviourScore is the score without the user agent, which is trivial to fake
+	BehaviourScore float64  `json:"behaviour_score"`
+	UserAgentMatch bool     `json:"user_agent_match"`
+	Mismatches     []string `json:"mismatches,omitempty"`
+}
+
+type Classification struct {
+	Best Match `json:"best"`
+	// Candidates are all rules from the best match to the worst one
+	Candidates []Match `json:"candidates"`
+	// Spoofed is true if the user agent names the implementation which behaves differently
+	Spoofed bool `json:"spoofed"`
+}
+
+// Classify scores the traits against every rule, post-verack traits are checked only if postVerack
+// messages were observed at all, as the connection may be closed right after the handshake.
+func Classify(t Traits, rules []Rule) Classification {
+	var c Classification
+	for _, rule := range rules {
+		c.Candidates = append(c.Candidates, rule.match(t))
+	}
+	// stable sort keeps the order of rules for equal scores
+	slices.SortStableFunc(c.Candidates, func(a, b Match) int {
+		switch {
+		case a.Score > b.Score:
+			return -1
+		case a.Score < b.Score:
+			return 1
+		}
+		return 0
+	})
+	if len(c.Candidates) == 0 {
+		return c
+	}
+	c.Best = c.Candidates[0]
+
+	bestBehaviour := 0.0
+	for _, m := range c.Candidates {
+		bestBehaviour = max(bestBehaviour, m.BehaviourScore)
+	}
+	// the user agent is trusted if the implementation it names behaves as well as any other one
+	c.Spoofed = c.Best.UserAgentMatch && c.Best.BehaviourScore < bestBehaviour
+
+	return c
+}
+
+func (r Rule) match(t Traits) Match {
+	m := Match{Implementation: r.Implementation, Version: r.Version}
+
+	var score, total float64
+	if groups := r.UserAgent.FindStringSubmatch(t.UserAgent); groups != nil {
+		m.UserAgentMatch = true
+		score += weightUserAgent
+		if len(groups) > 1 && groups[1] != "" {
+			m.Version = groups[1]
+		}
+	} else {
+		m.Mismatches = append(m.Mismatches, "user agent "+t.UserAgent)
+	}
+	total += weightUserAgent
+
+	var behaviour, behaviourTotal float64
+	behaviourTotal += weightProtocol
+	if t.ProtocolVersion == r.ProtocolVersion {
+		behaviour += weightProtocol
+	} else {
+		m.Mismatches = append(m.Mismatches, "protocol version")
+	}
+
+	behaviourTotal += weightPreVerack
+	switch {
+	case slices.Equal(t.PreVerack, r.PreVerack):
+		behaviour += weightPreVerack
+	case sameSet(t.PreVerack, r.PreVerack):
+		behaviour += weightPreVerack / 2
+		m.Mismatches = append(m.Mismatches, "order of "+strings.Join(r.PreVerack, ", "))
+	default:
+		m.Mismatches = append(m.Mismatches, "messages before verack")
+	}
+
+	if len(t.PostVerack) > 0 {
+		behaviourTotal += weightPostVerack
+		if len(r.PostVerack) == 0 {
+			m.Mismatches = append(m.Mismatches, "messages after verack")
+		}
+		var missing []string
+		for _, cmd := range r.PostVerack {
+			if !slices.Contains(t.PostVerack, cmd) {
+				missing = append(missing, cmd)
+			}
+		}
+		if len(r.PostVerack) > 0 {
+			behaviour += weightPostVerack * float64(len(r.PostVerack)-len(missing)) / float64(len(r.PostVerack))
+		}
+		if len(missing) > 0 {
+			m.Mismatches = append(m.Mismatches, "missing "+strings.Join(missing, ", ")+" after verack")
+		}
+	}
+
+	m.BehaviourScore = behaviour / behaviourTotal
+	m.Score = (score + behaviour) / (total + behaviourTotal)
+
+	return m
+}
+
+func sameSet(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	a, b = slices.Clone(a), slices.Clone(b)
+	slices.Sort(a)
+	slices.Sort(b)
+	return slices.Equal(a, b)
+}
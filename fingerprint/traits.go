@@ -0,0 +1,148 @@
+package fingerprint
+
+import (
+	"bytes"
+	"encoding/binary"
+	"time"
+
+	"github.com/senseyman/bitcoin-handshake/capture"
+	"github.com/senseyman/bitcoin-handshake/model"
+	"github.com/senseyman/bitcoin-handshake/service"
+)
+
+// versionFixedSize is the size of version message fields before user agent.
+const versionFixedSize = 4 + 8 + 8 + 26 + 26 + 8
+
+// Traits are the behavioural traits of the node which tell the implementations apart.
+type Traits struct {
+	UserAgent       string            `json:"user_agent"`
+	ProtocolVersion int32             `json:"protocol_version"`
+	Services        model.ServiceFlag `json:"services"`
+	StartHeight     int32             `json:"start_height"`
+	// Relay is nil if the node didn't send the relay field of version message
+	Relay *bool `json:"relay"`
+	// TimeOffset is the time of the node minus our time when its version was received
+	TimeOffset time.Duration `json:"time_offset_ns"`
+
+	// BeforeVersion are the messages received before the version of the node
+	BeforeVersion []string `json:"before_version,omitempty"`
+	// PreVerack are the messages received between the version and the verack of the node
+	PreVerack []string `json:"pre_verack,omitempty"`
+	// PostVerack are the messages received after the verack of the node
+	PostVerack []string `json:"post_verack,omitempty"`
+
+	// VersionDelay is the time from our version until the version of the node
+	VersionDelay time.Duration `json:"version_delay_ns"`
+	// VerackDelay is the time from the version of the node until its verack
+	VerackDelay time.Duration `json:"verack_delay_ns"`
+	// EagerVerack is true if the node sent verack before it got ours
+	EagerVerack bool `json:"eager_verack"`
+
+	Versions int `json:"versions"`
+	Veracks  int `json:"veracks"`
+	// CompactVersions are the versions of compact blocks announced with sendcmpct
+	CompactVersions []uint64 `json:"compact_versions,omitempty"`
+	// FeeFilter is the minimum fee rate of the node in sat/kvB, if it was sent
+	FeeFilter *int64 `json:"fee_filter,omitempty"`
+	// Malformed are the commands of received messages which couldn't be parsed
+	Malformed []string `json:"malformed,omitempty"`
+}
+
+// Extract derives the traits from the messages of the connection, they are expected in the order of the wire.
+func Extract(messages []Message) Traits {
+	var (
+		t             Traits
+		versionSentAt time.Time
+		versionAt     time.Time
+		verackSent    bool
+	)
+	decoder := service.NewDecodeService()
+
+	for _, msg := range messages {
+		if msg.Direction == capture.Sent {
+			switch msg.Command {
+			case model.VersionCMD:
+				versionSentAt = msg.Time
+			case model.VerackCMD:
+				verackSent = true
+			}
+			continue
+		}
+
+		switch msg.Command {
+		case model.VersionCMD:
+			t.Versions++
+			if t.Versions > 1 {
+				continue
+			}
+			versionAt = msg.Time
+			if !versionSentAt.IsZero() {
+				t.VersionDelay = msg.Time.Sub(versionSentAt)
+			}
+			if !t.parseVersion(decoder, msg) {
+				t.Malformed = append(t.Malformed, msg.Command)
+			}
+			continue
+		case model.VerackCMD:
+			t.Veracks++
+			if t.Veracks > 1 {
+				continue
+			}
+			if !versionAt.IsZero() {
+				t.VerackDelay = msg.Time.Sub(versionAt)
+			}
+			t.EagerVerack = !verackSent
+			continue
+		case "sendcmpct":
+			if len(msg.Payload) != 9 {
+				t.Malformed = append(t.Malformed, msg.Command)
+				break
+			}
+			t.CompactVersions = append(t.CompactVersions, binary.LittleEndian.Uint64(msg.Payload[1:]))
+		case "feefilter":
+			if len(msg.Payload) != 8 {
+				t.Malformed = append(t.Malformed, msg.Command)
+				break
+			}
+			rate := int64(binary.LittleEndian.Uint64(msg.Payload))
+			t.FeeFilter = &rate
+		}
+
+		switch {
+		case t.Versions == 0:
+			t.BeforeVersion = append(t.BeforeVersion, msg.Command)
+		case t.Veracks == 0:
+			t.PreVerack = append(t.PreVerack, msg.Command)
+		default:
+			t.PostVerack = append(t.PostVerack, msg.Command)
+		}
+	}
+
+	return t
+}
+
+func (t *Traits) parseVersion(decoder *service.DecodeService, msg Message) bool {
+	version, err := decoder.DecodeVersionMessage(bytes.NewReader(msg.Payload))
+	if err != nil {
+		return false
+	}
+
+	t.UserAgent = version.UserAgent
+	t.ProtocolVersion = version.Version
+	t.Services = version.Services
+	t.StartHeight = version.StartHeight
+	t.TimeOffset = time.Unix(version.Timestamp, 0).Sub(msg.Time.Truncate(time.Second))
+
+	// the relay field is optional, the decoder reports it as true if it's missing
+	uaSize := len(version.UserAgent)
+	varIntSize := 1
+	if uaSize >= 0xfd {
+		varIntSize = 3
+	}
+	if len(msg.Payload) > versionFixedSize+varIntSize+uaSize+4 {
+		relay := version.Relay
+		t.Relay = &relay
+	}
+
+	return true
+}
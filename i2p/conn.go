@@ -0,0 +1,55 @@
+package i2p
+
+import (
+	"net"
+	"time"
+
+	"github.com/senseyman/bitcoin-handshake/model"
+	"github.com/senseyman/bitcoin-handshake/utils"
+)
+
+// Addr is an I2P address of the stream's peer.
+type Addr struct {
+	hash []byte
+}
+
+func (a Addr) Network() string {
+	return "i2p"
+}
+
+// String returns the address with zero port, so it can be parsed by net.SplitHostPort.
+func (a Addr) String() string {
+	return net.JoinHostPort(utils.EncodeI2P(a.hash), "0")
+}
+
+// NetAddress returns the address in addrv2 form.
+func (a Addr) NetAddress() model.NetAddressV2 {
+	return model.NetAddressV2{
+		Timestamp: time.Now().Unix(),
+		NetworkID: model.NetI2P,
+		Addr:      a.hash,
+	}
+}
+
+// Conn is a stream to I2P destination, it implements client.Connection.
+type Conn struct {
+	*samConn
+	remote Addr
+}
+
+func newConn(conn *samConn, hash []byte) (*Conn, error) {
+	// streams may be idle for a long time, so drop command timeout
+	if err := conn.SetDeadline(time.Time{}); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	return &Conn{
+		samConn: conn,
+		remote:  Addr{hash: hash},
+	}, nil
+}
+
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.remote
+}
@@ -0,0 +1,139 @@
+package i2p
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+const (
+	samVersion = "3.1"
+	// maxLineLength limits SAM replies, destinations with certificates are less than 1KB in base64
+	maxLineLength = 4096
+)
+
+var (
+	ErrSAMBadReply = errors.New("malformed reply from SAM bridge")
+)
+
+// samConn is a connection to SAM bridge which is used for commands until it's turned into a data stream.
+type samConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func newSAMConn(conn net.Conn) *samConn {
+	return &samConn{
+		Conn:   conn,
+		reader: bufio.NewReaderSize(conn, maxLineLength),
+	}
+}
+
+// Read reads from the buffer first, so no stream data is lost after the last reply line.
+func (c *samConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
+
+func (c *samConn) readLine() (string, error) {
+	line, isPrefix, err := c.reader.ReadLine()
+	if err != nil {
+		return "", err
+	}
+	if isPrefix {
+		return "", ErrSAMBadReply
+	}
+
+	return string(line), nil
+}
+
+// command sends the request and reads reply, which has to start with expected topic, e.g. "HELLO REPLY".
+func (c *samConn) command(request, expReply string) (map[string]string, error) {
+	if _, err := c.Write([]byte(request + "\n")); err != nil {
+		return nil, err
+	}
+
+	line, err := c.readLine()
+	if err != nil {
+		return nil, err
+	}
+
+	topic, args, ok := parseReply(line)
+	if !ok || topic != expReply {
+		return nil, fmt.Errorf("%w: %q", ErrSAMBadReply, line)
+	}
+
+	if result := args["RESULT"]; result != "" && result != "OK" {
+		if msg := args["MESSAGE"]; msg != "" {
+			return nil, fmt.Errorf("SAM error %s: %s", result, msg)
+		}
+		return nil, fmt.Errorf("SAM error %s", result)
+	}
+
+	return args, nil
+}
+
+func (c *samConn) hello() error {
+	_, err := c.command(fmt.Sprintf("HELLO VERSION MIN=%s MAX=%s", samVersion, samVersion), "HELLO REPLY")
+	return err
+}
+
+func (c *samConn) lookup(name string) (string, error) {
+	args, err := c.command("NAMING LOOKUP NAME="+name, "NAMING REPLY")
+	if err != nil {
+		return "", err
+	}
+
+	dest := args["VALUE"]
+	if dest == "" {
+		return "", fmt.Errorf("%w: no destination for %s", ErrSAMBadReply, name)
+	}
+
+	return dest, nil
+}
+
+// parseReply splits SAM reply into a two words topic and key=value arguments. Values may be quoted.
+func parseReply(line string) (string, map[string]string, bool) {
+	words := splitWords(line)
+	if len(words) < 2 {
+		return "", nil, false
+	}
+
+	args := make(map[string]string, len(words)-2)
+	for _, w := range words[2:] {
+		key, value, _ := strings.Cut(w, "=")
+		args[key] = strings.Trim(value, `"`)
+	}
+
+	return words[0] + " " + words[1], args, true
+}
+
+func splitWords(line string) []string {
+	var (
+		words  []string
+		quoted bool
+		start  = -1
+	)
+	for i, r := range line {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			if start < 0 {
+				start = i
+			}
+		case r == ' ' && !quoted:
+			if start >= 0 {
+				words = append(words, line[start:i])
+				start = -1
+			}
+		case start < 0:
+			start = i
+		}
+	}
+	if start >= 0 {
+		words = append(words, line[start:])
+	}
+
+	return words
+}
@@ -0,0 +1,324 @@
+package i2p
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/senseyman/bitcoin-handshake/client"
+	"github.com/senseyman/bitcoin-handshake/model"
+	"github.com/senseyman/bitcoin-handshake/utils"
+)
+
+const (
+	commandTimeout = 3 * time.Minute
+)
+
+var (
+	ErrSessionClosed = errors.New("i2p session is closed")
+)
+
+// Session is a streaming session at I2P router's SAM v3 bridge. The session is created lazily on the first
+// connect or accept and lives while its control connection is open. All streams of the session share
+// the same local destination.
+type Session struct {
+	samAddr string
+	// keyFile keeps the private key of our destination, so our address stays the same between restarts.
+	// If empty, a new transient destination is created for every session.
+	keyFile string
+
+	dial func(network, address string) (net.Conn, error)
+
+	mu      sync.Mutex
+	control *samConn
+	id      string
+	myDest  string
+	closed  bool
+	// creating is closed when the session being created is ready or failed, it's nil if none is being created
+	creating chan struct{}
+}
+
+func NewSession(samAddr, keyFile string) *Session {
+	return &Session{
+		samAddr: samAddr,
+		keyFile: keyFile,
+		dial:    net.Dial,
+	}
+}
+
+// ConnectionFn returns function which can be used as a connection function of client.NewBitcoinClient.
+// The port is ignored as I2P destinations don't have ports.
+func (s *Session) ConnectionFn() func(host string, port int) (client.Connection, error) {
+	return func(host string, _ int) (client.Connection, error) {
+		return s.Connect(host)
+	}
+}
+
+// Connect opens a stream to .b32.i2p destination.
+func (s *Session) Connect(host string) (*Conn, error) {
+	hash, err := utils.DecodeI2P(host)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", err, host)
+	}
+
+	id, err := s.sessionID()
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := s.newConn()
+	if err != nil {
+		return nil, err
+	}
+
+	dest, err := conn.lookup(host)
+	if err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("failed to resolve %s: %w", host, err)
+	}
+
+	if _, err := conn.command(fmt.Sprintf("STREAM CONNECT ID=%s DESTINATION=%s SILENT=false", id, dest), "STREAM STATUS"); err != nil {
+		_ = conn.Close()
+		if s.isSessionError(err) {
+			s.reset()
+		}
+		return nil, fmt.Errorf("failed to connect to %s: %w", host, err)
+	}
+
+	return newConn(conn, hash)
+}
+
+// Accept waits for an incoming stream.
+func (s *Session) Accept() (*Conn, error) {
+	id, err := s.sessionID()
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := s.newConn()
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := conn.command(fmt.Sprintf("STREAM ACCEPT ID=%s SILENT=false", id), "STREAM STATUS"); err != nil {
+		_ = conn.Close()
+		if s.isSessionError(err) {
+			s.reset()
+		}
+		return nil, fmt.Errorf("failed to accept: %w", err)
+	}
+
+	// no timeout while waiting for incoming connection
+	if err := conn.SetDeadline(time.Time{}); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	// the first line is the destination of the peer, followed by the stream data
+	line, err := conn.readLine()
+	if err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("failed to accept: %w", err)
+	}
+	peerDest, _, _ := strings.Cut(line, " ")
+
+	hash, err := utils.I2PDestinationHash(peerDest)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	return newConn(conn, hash)
+}
+
+// MyAddress returns our address in addrv2 form, so it can be advertised to peers.
+func (s *Session) MyAddress() (model.NetAddressV2, error) {
+	if _, err := s.sessionID(); err != nil {
+		return model.NetAddressV2{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	hash, err := utils.I2PDestinationHash(s.myDest)
+	if err != nil {
+		return model.NetAddressV2{}, err
+	}
+
+	return model.NetAddressV2{
+		Timestamp: time.Now().Unix(),
+		NetworkID: model.NetI2P,
+		Addr:      hash,
+	}, nil
+}
+
+func (s *Session) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+	if s.control == nil {
+		return nil
+	}
+
+	err := s.control.Close()
+	s.control = nil
+
+	return err
+}
+
+// sessionID returns id of the current session, creating a new one if needed. The session is created without
+// holding the lock, so Close and the other calls don't wait for the router, and the concurrent calls wait for
+// the same session.
+func (s *Session) sessionID() (string, error) {
+	s.mu.Lock()
+	for s.creating != nil {
+		creating := s.creating
+		s.mu.Unlock()
+		<-creating
+		s.mu.Lock()
+	}
+	if s.closed {
+		s.mu.Unlock()
+		return "", ErrSessionClosed
+	}
+	if s.control != nil {
+		id := s.id
+		s.mu.Unlock()
+		return id, nil
+	}
+	s.creating = make(chan struct{})
+	s.mu.Unlock()
+
+	conn, id, myDest, err := s.createSession()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	close(s.creating)
+	s.creating = nil
+	if err != nil {
+		return "", err
+	}
+	if s.closed {
+		_ = conn.Close()
+		return "", ErrSessionClosed
+	}
+	s.control, s.id, s.myDest = conn, id, myDest
+	log.Infof("created i2p session %s", id)
+
+	return id, nil
+}
+
+// createSession creates the session at the router and returns its control connection, id and our destination.
+func (s *Session) createSession() (*samConn, string, string, error) {
+	conn, err := s.newConn()
+	if err != nil {
+		return nil, "", "", err
+	}
+
+	privKey, err := s.readPrivateKey()
+	if err != nil {
+		_ = conn.Close()
+		return nil, "", "", err
+	}
+	destination := "TRANSIENT SIGNATURE_TYPE=7"
+	if privKey != "" {
+		destination = privKey
+	}
+
+	idBytes := make([]byte, 5)
+	if _, err := rand.Read(idBytes); err != nil {
+		_ = conn.Close()
+		return nil, "", "", err
+	}
+	id := hex.EncodeToString(idBytes)
+
+	args, err := conn.command(
+		fmt.Sprintf("SESSION CREATE STYLE=STREAM ID=%s DESTINATION=%s", id, destination),
+		"SESSION STATUS",
+	)
+	if err != nil {
+		_ = conn.Close()
+		return nil, "", "", fmt.Errorf("failed to create i2p session: %w", err)
+	}
+
+	if privKey == "" && s.keyFile != "" {
+		if err := os.WriteFile(s.keyFile, []byte(args["DESTINATION"]), 0o600); err != nil {
+			_ = conn.Close()
+			return nil, "", "", fmt.Errorf("failed to save i2p private key: %w", err)
+		}
+	}
+
+	myDest, err := conn.lookup("ME")
+	if err != nil {
+		_ = conn.Close()
+		return nil, "", "", fmt.Errorf("failed to get own i2p destination: %w", err)
+	}
+
+	// the session lives while the control connection is open
+	if err := conn.SetDeadline(time.Time{}); err != nil {
+		_ = conn.Close()
+		return nil, "", "", err
+	}
+
+	return conn, id, myDest, nil
+}
+
+func (s *Session) readPrivateKey() (string, error) {
+	if s.keyFile == "" {
+		return "", nil
+	}
+
+	raw, err := os.ReadFile(s.keyFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read i2p private key: %w", err)
+	}
+
+	return strings.TrimSpace(string(raw)), nil
+}
+
+// isSessionError reports whether the error means that the router lost our session.
+func (s *Session) isSessionError(err error) bool {
+	return strings.Contains(err.Error(), "INVALID_ID")
+}
+
+func (s *Session) reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.control != nil {
+		_ = s.control.Close()
+		s.control = nil
+	}
+}
+
+func (s *Session) newConn() (*samConn, error) {
+	raw, err := s.dial("tcp", s.samAddr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to SAM bridge: %w", err)
+	}
+
+	conn := newSAMConn(raw)
+	if err := conn.SetDeadline(time.Now().Add(commandTimeout)); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	if err := conn.hello(); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	return conn, nil
+}
@@ -0,0 +1,276 @@
+package i2p
+
+import (
+	"bufio"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"net"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/senseyman/bitcoin-handshake/model"
+	"github.com/senseyman/bitcoin-handshake/utils"
+)
+
+func randomDestination(t *testing.T) string {
+	raw := make([]byte, 387)
+	_, err := rand.Read(raw)
+	require.NoError(t, err)
+
+	return utils.I2PBase64.EncodeToString(raw)
+}
+
+// fakeSAMServer is a stand-in for SAM bridge. Outgoing streams are echoed back, incoming streams
+// are coming from the peer destination.
+type fakeSAMServer struct {
+	listener net.Listener
+	myDest   string
+	privKey  string
+	peerDest string
+	// createHold delays the replies to SESSION CREATE until it's closed
+	createHold chan struct{}
+
+	mu       sync.Mutex
+	sessions []string
+}
+
+func newFakeSAMServer(t *testing.T) *fakeSAMServer {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	s := &fakeSAMServer{
+		listener: l,
+		myDest:   randomDestination(t),
+		privKey:  randomDestination(t),
+		peerDest: randomDestination(t),
+	}
+	go s.serve()
+	t.Cleanup(func() {
+		_ = l.Close()
+	})
+
+	return s
+}
+
+func (s *fakeSAMServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *fakeSAMServer) handle(conn net.Conn) {
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	reply := func(format string, args ...any) {
+		_, _ = fmt.Fprintf(conn, format+"\n", args...)
+	}
+
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		_, args, _ := parseReply(strings.TrimSpace(line))
+
+		switch {
+		case strings.HasPrefix(line, "HELLO VERSION"):
+			reply("HELLO REPLY RESULT=OK VERSION=3.1")
+		case strings.HasPrefix(line, "SESSION CREATE"):
+			s.mu.Lock()
+			s.sessions = append(s.sessions, args["DESTINATION"])
+			s.mu.Unlock()
+			if s.createHold != nil {
+				<-s.createHold
+			}
+			reply("SESSION STATUS RESULT=OK DESTINATION=%s", s.privKey)
+		case strings.HasPrefix(line, "NAMING LOOKUP"):
+			switch args["NAME"] {
+			case "ME":
+				reply("NAMING REPLY RESULT=OK NAME=ME VALUE=%s", s.myDest)
+			case b32Of(s.peerDest):
+				reply("NAMING REPLY RESULT=OK NAME=%s VALUE=%s", args["NAME"], s.peerDest)
+			default:
+				reply("NAMING REPLY RESULT=KEY_NOT_FOUND NAME=%s", args["NAME"])
+			}
+		case strings.HasPrefix(line, "STREAM CONNECT"):
+			if args["DESTINATION"] != s.peerDest {
+				reply("STREAM STATUS RESULT=CANT_REACH_PEER MESSAGE=\"peer not found\"")
+				return
+			}
+			reply("STREAM STATUS RESULT=OK")
+			_, _ = io.Copy(conn, r)
+			return
+		case strings.HasPrefix(line, "STREAM ACCEPT"):
+			reply("STREAM STATUS RESULT=OK")
+			reply("%s FROM_PORT=0 TO_PORT=0", s.peerDest)
+			_, _ = conn.Write([]byte("version"))
+			return
+		}
+	}
+}
+
+func b32Of(dest string) string {
+	hash, _ := utils.I2PDestinationHash(dest)
+	return utils.EncodeI2P(hash)
+}
+
+func (s *fakeSAMServer) getSessions() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]string(nil), s.sessions...)
+}
+
+func TestSession_Connect(t *testing.T) {
+	srv := newFakeSAMServer(t)
+
+	testCases := []struct {
+		name   string
+		host   string
+		hasErr bool
+	}{
+		{
+			name: "success",
+			host: b32Of(srv.peerDest),
+		},
+		{
+			name:   "err/unknown_destination",
+			host:   b32Of(randomDestination(t)),
+			hasErr: true,
+		},
+		{
+			name:   "err/invalid_address",
+			host:   "node.example.com",
+			hasErr: true,
+		},
+	}
+
+	s := NewSession(srv.listener.Addr().String(), "")
+	defer s.Close()
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			conn, err := s.ConnectionFn()(tc.host, 18333)
+			if tc.hasErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			defer conn.Close()
+
+			msg := []byte("version")
+			_, err = conn.Write(msg)
+			require.NoError(t, err)
+			buf := make([]byte, len(msg))
+			_, err = io.ReadFull(conn, buf)
+			require.NoError(t, err)
+			assert.Equal(t, msg, buf)
+
+			remote := conn.(*Conn).RemoteAddr()
+			assert.Equal(t, "i2p", remote.Network())
+			assert.Equal(t, tc.host+":0", remote.String())
+		})
+	}
+
+	// all streams share one session
+	assert.Len(t, srv.getSessions(), 1)
+}
+
+func TestSession_Accept(t *testing.T) {
+	srv := newFakeSAMServer(t)
+	s := NewSession(srv.listener.Addr().String(), "")
+	defer s.Close()
+
+	conn, err := s.Accept()
+	require.NoError(t, err)
+	defer conn.Close()
+
+	buf := make([]byte, len("version"))
+	_, err = io.ReadFull(conn, buf)
+	require.NoError(t, err)
+	assert.Equal(t, "version", string(buf))
+
+	addr := conn.remote.NetAddress()
+	assert.Equal(t, model.NetI2P, addr.NetworkID)
+	assert.Equal(t, b32Of(srv.peerDest), addr.Host())
+	assert.Zero(t, addr.Port)
+
+	parsed, err := model.NewNetAddressV2FromHost(addr.Host(), 0, 0, 0)
+	require.NoError(t, err)
+	assert.Equal(t, addr.Addr, parsed.Addr)
+	assert.Equal(t, model.NetI2P, parsed.NetworkID)
+}
+
+func TestSession_PrivateKey(t *testing.T) {
+	srv := newFakeSAMServer(t)
+	keyFile := filepath.Join(t.TempDir(), "i2p_private_key")
+
+	for i := 0; i < 2; i++ {
+		s := NewSession(srv.listener.Addr().String(), keyFile)
+		addr, err := s.MyAddress()
+		require.NoError(t, err)
+		assert.Equal(t, b32Of(srv.myDest), addr.Host())
+		require.NoError(t, s.Close())
+	}
+
+	// the first session creates a new destination, the second one reuses saved private key
+	sessions := srv.getSessions()
+	require.Len(t, sessions, 2)
+	assert.Equal(t, "TRANSIENT", sessions[0])
+	assert.Equal(t, srv.privKey, sessions[1])
+}
+
+func TestSession_Close(t *testing.T) {
+	srv := newFakeSAMServer(t)
+	s := NewSession(srv.listener.Addr().String(), "")
+	require.NoError(t, s.Close())
+
+	_, err := s.Connect(b32Of(srv.peerDest))
+	assert.ErrorIs(t, err, ErrSessionClosed)
+}
+
+func TestSession_Create_Concurrent(t *testing.T) {
+	srv := newFakeSAMServer(t)
+	srv.createHold = make(chan struct{})
+	s := NewSession(srv.listener.Addr().String(), "")
+
+	errs := make(chan error, 2)
+	for i := 0; i < cap(errs); i++ {
+		go func() {
+			_, err := s.MyAddress()
+			errs <- err
+		}()
+	}
+	require.Eventually(t, func() bool { return len(srv.getSessions()) == 1 }, time.Second, time.Millisecond)
+
+	// the lock isn't held while the router creates the session
+	closed := make(chan error, 1)
+	go func() {
+		closed <- s.Close()
+	}()
+	select {
+	case err := <-closed:
+		require.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("close waits for the session being created")
+	}
+
+	close(srv.createHold)
+	for i := 0; i < cap(errs); i++ {
+		assert.ErrorIs(t, <-errs, ErrSessionClosed)
+	}
+	// the calls wait for the same session
+	assert.Len(t, srv.getSessions(), 1)
+}
@@ -0,0 +1,260 @@
+package inspect
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/senseyman/bitcoin-handshake/model"
+	"github.com/senseyman/bitcoin-handshake/service"
+)
+
+// dissectors split payloads of known messages into fields.
+var dissectors = map[string]func(d *dissector){
+	model.VersionCMD: dissectVersion,
+	model.AddrCMD:    dissectAddr,
+	model.AddrV2CMD:  dissectAddrV2,
+	"ping":           dissectNonce,
+	"pong":           dissectNonce,
+	"feefilter": func(d *dissector) {
+		var feeRate int64
+		d.field("feerate", &feeRate, func() any { return fmt.Sprintf("%d sat/kvB", feeRate) })
+	},
+	"sendcmpct": func(d *dissector) {
+		var (
+			announce bool
+			version  uint64
+		)
+		d.field("announce", &announce, nil)
+		d.field("version", &version, nil)
+	},
+}
+
+// dissector decodes fields one by one with DecodeService and remembers where each of them is.
+type dissector struct {
+	decoder *service.DecodeService
+	data    []byte
+	reader  *bytes.Reader
+	base    int
+
+	fields []Field
+	// group collects the fields of the current list entry
+	group *Field
+	err   error
+}
+
+func newDissector(decoder *service.DecodeService, data []byte, base int) *dissector {
+	return &dissector{
+		decoder: decoder,
+		data:    data,
+		reader:  bytes.NewReader(data),
+		base:    base,
+	}
+}
+
+func (d *dissector) pos() int {
+	return len(d.data) - d.reader.Len()
+}
+
+// field decodes the element and adds it as a field. If value is nil, the decoded element itself is the value.
+func (d *dissector) field(name string, element any, value func() any) {
+	if d.err != nil {
+		return
+	}
+
+	start := d.pos()
+	if err := d.decoder.DecodeElements(d.reader, element); err != nil {
+		d.fail(name, start, err)
+		return
+	}
+	d.add(name, start, d.valueOf(element, value))
+}
+
+func (d *dissector) varInt(name string) (uint64, bool) {
+	if d.err != nil {
+		return 0, false
+	}
+
+	start := d.pos()
+	v, err := d.decoder.DecodeVarInt(d.reader)
+	if err != nil {
+		d.fail(name, start, err)
+		return 0, false
+	}
+	d.add(name, start, v)
+
+	return v, true
+}
+
+func (d *dissector) bytes(name string, size uint64, value func(b []byte) any) {
+	if d.err != nil {
+		return
+	}
+
+	start := d.pos()
+	if size > uint64(d.reader.Len()) {
+		d.err = fmt.Errorf("%s: %w", name, ErrTruncated)
+		return
+	}
+	b := make([]byte, size)
+	_, _ = d.reader.Read(b)
+	d.add(name, start, value(b))
+}
+
+// fail rewinds the reader to the start of the field, so its partially read bytes are shown as unparsed.
+func (d *dissector) fail(name string, start int, err error) {
+	d.err = fmt.Errorf("%s: %w", name, err)
+	_, _ = d.reader.Seek(int64(start), io.SeekStart)
+}
+
+func (d *dissector) add(name string, start int, value any) {
+	f := Field{
+		Name:   name,
+		Offset: d.base + start,
+		Raw:    hex.EncodeToString(d.data[start:d.pos()]),
+		Value:  value,
+	}
+	if d.group != nil {
+		d.group.Fields = append(d.group.Fields, f)
+		return
+	}
+	d.fields = append(d.fields, f)
+}
+
+// entry starts a list entry, all fields until the next entry are nested into it.
+func (d *dissector) entry(name string) {
+	d.endEntry()
+	if d.err != nil {
+		return
+	}
+	d.group = &Field{Name: name, Offset: d.base + d.pos()}
+}
+
+func (d *dissector) endEntry() {
+	if d.group == nil {
+		return
+	}
+	if len(d.group.Fields) == 0 {
+		d.group = nil
+		return
+	}
+	start := d.group.Offset - d.base
+	d.group.Raw = hex.EncodeToString(d.data[start:d.pos()])
+	d.fields = append(d.fields, *d.group)
+	d.group = nil
+}
+
+// finish returns the fields, the bytes which were not dissected are added as a raw field.
+func (d *dissector) finish() []Field {
+	d.endEntry()
+	if d.reader.Len() > 0 {
+		start := d.pos()
+		d.fields = append(d.fields, rawField("unparsed", d.base+start, d.data[start:]))
+	}
+
+	return d.fields
+}
+
+func (d *dissector) valueOf(element any, value func() any) any {
+	if value != nil {
+		return value()
+	}
+
+	switch e := element.(type) {
+	case *int32:
+		return *e
+	case *uint32:
+		return *e
+	case *int64:
+		return *e
+	case *uint64:
+		return *e
+	case *bool:
+		return *e
+	case *string:
+		return *e
+	}
+
+	return element
+}
+
+func unixTime(ts int64) string {
+	return fmt.Sprintf("%d (%s)", ts, time.Unix(ts, 0).UTC().Format(time.RFC3339))
+}
+
+func services(s model.ServiceFlag) string {
+	return fmt.Sprintf("0x%x %s", uint64(s), s)
+}
+
+func dissectVersion(d *dissector) {
+	var msg model.VersionMessage
+	d.field("version", &msg.Version, nil)
+	d.field("services", &msg.Services, func() any { return services(msg.Services) })
+	d.field("timestamp", &msg.Timestamp, func() any { return unixTime(msg.Timestamp) })
+	for _, addr := range []struct {
+		name string
+		na   *model.NetAddress
+	}{{"addr_recv", &msg.AddrRecv}, {"addr_from", &msg.AddrFrom}} {
+		d.entry(addr.name)
+		d.field("services", &addr.na.Services, func() any { return services(addr.na.Services) })
+		d.bytes("ip", net.IPv6len, func(b []byte) any { return net.IP(b).String() })
+		d.bytes("port", 2, func(b []byte) any { return binary.BigEndian.Uint16(b) })
+		d.endEntry()
+	}
+	d.field("nonce", &msg.Nonce, func() any { return fmt.Sprintf("0x%016x", msg.Nonce) })
+	d.field("user_agent", &msg.UserAgent, nil)
+	d.field("start_height", &msg.StartHeight, nil)
+	if d.err == nil && d.reader.Len() > 0 {
+		d.field("relay", &msg.Relay, nil)
+	}
+}
+
+func dissectAddr(d *dissector) {
+	count, ok := d.varInt("count")
+	for i := uint64(0); ok && i < count && d.err == nil; i++ {
+		var (
+			ts uint32
+			na model.NetAddress
+		)
+		d.entry(fmt.Sprintf("addr[%d]", i))
+		d.field("time", &ts, func() any { return unixTime(int64(ts)) })
+		d.field("services", &na.Services, func() any { return services(na.Services) })
+		d.bytes("ip", net.IPv6len, func(b []byte) any { return net.IP(b).String() })
+		d.bytes("port", 2, func(b []byte) any { return binary.BigEndian.Uint16(b) })
+	}
+}
+
+func dissectAddrV2(d *dissector) {
+	count, ok := d.varInt("count")
+	for i := uint64(0); ok && i < count && d.err == nil; i++ {
+		var (
+			ts    uint32
+			netID uint8
+		)
+		d.entry(fmt.Sprintf("addr[%d]", i))
+		d.field("time", &ts, func() any { return unixTime(int64(ts)) })
+		d.varInt("services")
+		d.field("network", &netID, func() any { return fmt.Sprintf("%d (%s)", netID, model.NetworkID(netID)) })
+		size, ok := d.varInt("size")
+		if !ok {
+			return
+		}
+		if size > model.MaxAddrV2Size {
+			d.err = fmt.Errorf("addr[%d]: %w", i, model.ErrInvalidAddress)
+			return
+		}
+		d.bytes("addr", size, func(b []byte) any {
+			return model.NetAddressV2{NetworkID: model.NetworkID(netID), Addr: b}.Host()
+		})
+		d.bytes("port", 2, func(b []byte) any { return binary.BigEndian.Uint16(b) })
+	}
+}
+
+func dissectNonce(d *dissector) {
+	var nonce uint64
+	d.field("nonce", &nonce, func() any { return fmt.Sprintf("0x%016x", nonce) })
+}
@@ -0,0 +1,164 @@
+package inspect
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/senseyman/bitcoin-handshake/capture"
+	"github.com/senseyman/bitcoin-handshake/core"
+	"github.com/senseyman/bitcoin-handshake/model"
+	"github.com/senseyman/bitcoin-handshake/service"
+	"github.com/senseyman/bitcoin-handshake/utils"
+)
+
+const (
+	headerSize = model.MagicSize + model.CommandSize + model.LengthSize + model.ChecksumSize
+)
+
+var (
+	ErrTruncated = errors.New("message is truncated")
+)
+
+// networks maps known magic numbers to the network names.
+var networks = map[uint32]string{
+	0xD9B4BEF9:         "mainnet",
+	model.TestNetMagic: "testnet3",
+	0x283F161C:         "testnet4",
+	0x40CF030A:         "signet",
+	0xDAB5BFFA:         "regtest",
+}
+
+// Field is a dissected part of the message.
+type Field struct {
+	Name   string  `json:"name"`
+	Offset int     `json:"offset"`
+	Raw    string  `json:"raw"`
+	Value  any     `json:"value,omitempty"`
+	Fields []Field `json:"fields,omitempty"`
+}
+
+// Message is a dissected message. Offsets of the fields are counted from the start of the input.
+type Message struct {
+	Offset        int        `json:"offset"`
+	Time          *time.Time `json:"time,omitempty"`
+	Direction     string     `json:"direction,omitempty"`
+	Command       string     `json:"command"`
+	Network       string     `json:"network,omitempty"`
+	ChecksumValid bool       `json:"checksum_valid"`
+	Header        []Field    `json:"header,omitempty"`
+	Payload       []Field    `json:"payload"`
+	// Decoded is the payload as it's seen by core.Core, empty for the messages which core doesn't parse.
+	Decoded any    `json:"decoded,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+type Inspector struct {
+	core    *core.Core
+	decoder *service.DecodeService
+}
+
+func New() *Inspector {
+	decoder := service.NewDecodeService()
+	return &Inspector{
+		core:    core.New(decoder, nil, nil, nil),
+		decoder: decoder,
+	}
+}
+
+// Frames dissects all messages in data. Every message has to start with the header.
+func (i *Inspector) Frames(data []byte) []Message {
+	var messages []Message
+	for offset := 0; offset < len(data); {
+		msg, size := i.frame(data[offset:], offset)
+		messages = append(messages, msg)
+		offset += size
+	}
+
+	return messages
+}
+
+// Record dissects the captured message. Capture doesn't keep the header, so only the payload is dissected.
+func (i *Inspector) Record(record capture.Record) Message {
+	msg := Message{
+		Time:          &record.Time,
+		Direction:     record.Direction.String(),
+		Command:       record.Command,
+		ChecksumValid: true,
+	}
+	i.payload(&msg, model.MessageHeader{Command: record.Command, Length: uint32(len(record.Payload))}, record.Payload, 0)
+
+	return msg
+}
+
+func (i *Inspector) frame(data []byte, offset int) (Message, int) {
+	msg := Message{Offset: offset}
+	if len(data) < headerSize {
+		msg.Error = fmt.Sprintf("%v: %d bytes left, header needs %d", ErrTruncated, len(data), headerSize)
+		msg.Payload = []Field{rawField("unparsed", offset, data)}
+		return msg, len(data)
+	}
+
+	hdr, err := i.core.ReadHeader(bytes.NewReader(data[:headerSize]))
+	if err != nil {
+		msg.Error = err.Error()
+		return msg, len(data)
+	}
+	msg.Command = hdr.Command
+
+	network, ok := networks[hdr.Magic]
+	if !ok {
+		network = "unknown"
+	}
+	msg.Network = network
+
+	size := headerSize + int(hdr.Length)
+	payload := data[headerSize:]
+	if len(data) < size {
+		msg.Error = fmt.Sprintf("%v: payload has %d bytes of %d", ErrTruncated, len(payload), hdr.Length)
+		size = len(data)
+	} else {
+		payload = payload[:hdr.Length]
+	}
+	msg.ChecksumValid = bytes.Equal(hdr.Checksum[:], utils.DoubleHashB(payload)[:model.ChecksumSize]) &&
+		len(payload) == int(hdr.Length)
+
+	checksumValue := "valid"
+	if !msg.ChecksumValid {
+		checksumValue = fmt.Sprintf("invalid, expected %x", utils.DoubleHashB(payload)[:model.ChecksumSize])
+	}
+	msg.Header = []Field{
+		{Name: "magic", Offset: offset, Raw: hex.EncodeToString(data[0:4]), Value: fmt.Sprintf("0x%08x (%s)", hdr.Magic, network)},
+		{Name: "command", Offset: offset + 4, Raw: hex.EncodeToString(data[4:16]), Value: hdr.Command},
+		{Name: "length", Offset: offset + 16, Raw: hex.EncodeToString(data[16:20]), Value: hdr.Length},
+		{Name: "checksum", Offset: offset + 20, Raw: hex.EncodeToString(data[20:24]), Value: checksumValue},
+	}
+
+	i.payload(&msg, hdr, payload, offset+headerSize)
+
+	return msg, size
+}
+
+func (i *Inspector) payload(msg *Message, hdr model.MessageHeader, payload []byte, offset int) {
+	d := newDissector(i.decoder, payload, offset)
+	if dissect, ok := dissectors[hdr.Command]; ok {
+		dissect(d)
+	}
+	msg.Payload = d.finish()
+	if d.err != nil && msg.Error == "" {
+		msg.Error = d.err.Error()
+	}
+
+	// the payload is also parsed by core, so the output shows exactly what the app would get
+	if msg.Error == "" {
+		if decoded, err := i.core.ReadPayload(bytes.NewReader(payload), hdr); err == nil {
+			msg.Decoded = decoded
+		}
+	}
+}
+
+func rawField(name string, offset int, raw []byte) Field {
+	return Field{Name: name, Offset: offset, Raw: hex.EncodeToString(raw)}
+}
@@ -0,0 +1,173 @@
+package inspect
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/senseyman/bitcoin-handshake/capture"
+	"github.com/senseyman/bitcoin-handshake/model"
+	"github.com/senseyman/bitcoin-handshake/nodetest"
+)
+
+func captured(t *testing.T, command string) []byte {
+	frame, ok := nodetest.CapturedFrame(command)
+	require.True(t, ok)
+	return frame
+}
+
+func fieldNames(fields []Field) []string {
+	names := make([]string, 0, len(fields))
+	for _, f := range fields {
+		names = append(names, f.Name)
+	}
+	return names
+}
+
+func TestInspector_Frames(t *testing.T) {
+	version := captured(t, model.VersionCMD)
+	ping := captured(t, "ping")
+
+	badChecksum := bytes.Clone(ping)
+	badChecksum[20] ^= 0xff
+
+	unknownMagic := bytes.Clone(ping)
+	copy(unknownMagic, []byte{1, 2, 3, 4})
+
+	testCases := []struct {
+		name        string
+		data        []byte
+		expCommands []string
+		expOffsets  []int
+		expNetwork  string
+		expChecksum bool
+		expPayload  []string
+		expErr      error
+	}{
+		{
+			name:        "success/version",
+			data:        version,
+			expCommands: []string{model.VersionCMD},
+			expOffsets:  []int{0},
+			expNetwork:  "testnet3",
+			expChecksum: true,
+			expPayload: []string{
+				"version", "services", "timestamp", "addr_recv", "addr_from",
+				"nonce", "user_agent", "start_height", "relay",
+			},
+		},
+		{
+			name:        "success/multiple_frames",
+			data:        append(bytes.Clone(version), ping...),
+			expCommands: []string{model.VersionCMD, "ping"},
+			expOffsets:  []int{0, len(version)},
+			expNetwork:  "testnet3",
+			expChecksum: true,
+			expPayload: []string{
+				"version", "services", "timestamp", "addr_recv", "addr_from",
+				"nonce", "user_agent", "start_height", "relay",
+			},
+		},
+		{
+			name:        "success/unknown_magic",
+			data:        unknownMagic,
+			expCommands: []string{"ping"},
+			expOffsets:  []int{0},
+			expNetwork:  "unknown",
+			expChecksum: true,
+			expPayload:  []string{"nonce"},
+		},
+		{
+			name:        "err/bad_checksum",
+			data:        badChecksum,
+			expCommands: []string{"ping"},
+			expOffsets:  []int{0},
+			expNetwork:  "testnet3",
+			expPayload:  []string{"nonce"},
+		},
+		{
+			name:        "err/truncated_payload",
+			data:        version[:len(version)-50],
+			expCommands: []string{model.VersionCMD},
+			expOffsets:  []int{0},
+			expNetwork:  "testnet3",
+			expPayload:  []string{"version", "services", "timestamp", "addr_recv", "unparsed"},
+			expErr:      ErrTruncated,
+		},
+		{
+			name:        "err/truncated_header",
+			data:        ping[:10],
+			expCommands: []string{""},
+			expOffsets:  []int{0},
+			expPayload:  []string{"unparsed"},
+			expErr:      ErrTruncated,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			messages := New().Frames(tc.data)
+			require.Len(t, messages, len(tc.expCommands))
+
+			for n, msg := range messages {
+				assert.Equal(t, tc.expCommands[n], msg.Command)
+				assert.Equal(t, tc.expOffsets[n], msg.Offset)
+			}
+
+			msg := messages[0]
+			assert.Equal(t, tc.expNetwork, msg.Network)
+			assert.Equal(t, tc.expChecksum, msg.ChecksumValid)
+			assert.Equal(t, tc.expPayload, fieldNames(msg.Payload))
+			if tc.expErr != nil {
+				assert.Contains(t, msg.Error, tc.expErr.Error())
+				assert.Nil(t, msg.Decoded)
+				return
+			}
+			assert.Empty(t, msg.Error)
+		})
+	}
+}
+
+func TestInspector_Frames_Offsets(t *testing.T) {
+	version := captured(t, model.VersionCMD)
+	ping := captured(t, "ping")
+
+	messages := New().Frames(append(bytes.Clone(version), ping...))
+	require.Len(t, messages, 2)
+
+	// the offsets are counted from the start of input, so raw bytes can be found there
+	for _, msg := range messages {
+		for _, f := range append(msg.Header, msg.Payload...) {
+			raw := f.Raw
+			assert.Equal(t, raw, hexAt(version, ping, f.Offset, len(raw)/2), f.Name)
+		}
+	}
+
+	assert.IsType(t, model.VersionMessage{}, messages[0].Decoded)
+}
+
+func hexAt(first, second []byte, offset, size int) string {
+	data := append(bytes.Clone(first), second...)
+	return rawField("", 0, data[offset:offset+size]).Raw
+}
+
+func TestInspector_Record(t *testing.T) {
+	payload, ok := nodetest.CapturedPayload(model.AddrV2CMD)
+	require.True(t, ok)
+
+	ts := time.UnixMicro(1713282931000000)
+	msg := New().Record(capture.Record{Time: ts, Direction: capture.Received, Command: model.AddrV2CMD, Payload: payload})
+
+	assert.Equal(t, "recv", msg.Direction)
+	assert.Equal(t, ts, *msg.Time)
+	assert.Empty(t, msg.Header)
+	assert.Empty(t, msg.Error)
+	require.NotEmpty(t, msg.Payload)
+	assert.Equal(t, "count", msg.Payload[0].Name)
+	assert.NotContains(t, fieldNames(msg.Payload), "unparsed")
+}
@@ -0,0 +1,75 @@
+package inspect
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+const (
+	// longer raw values are cut in text output
+	maxRawTextBytes = 16
+)
+
+func WriteJSON(w io.Writer, messages []Message) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(messages)
+}
+
+// WriteText writes the messages as annotated dissection: offset, raw bytes and decoded value of every field.
+func WriteText(w io.Writer, messages []Message) error {
+	var b strings.Builder
+	for n, msg := range messages {
+		if n > 0 {
+			b.WriteString("\n")
+		}
+
+		fmt.Fprintf(&b, "message #%d at offset %d: %s", n, msg.Offset, msg.Command)
+		if msg.Time != nil {
+			fmt.Fprintf(&b, " (%s at %s)", msg.Direction, msg.Time.UTC().Format(time.RFC3339Nano))
+		}
+		b.WriteString("\n")
+
+		if len(msg.Header) > 0 {
+			b.WriteString("  header\n")
+			writeFields(&b, msg.Header, 2)
+		}
+		b.WriteString("  payload\n")
+		if len(msg.Payload) == 0 {
+			b.WriteString("    (empty)\n")
+		}
+		writeFields(&b, msg.Payload, 2)
+
+		if msg.Error != "" {
+			fmt.Fprintf(&b, "  error: %s\n", msg.Error)
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func writeFields(b *strings.Builder, fields []Field, depth int) {
+	indent := strings.Repeat("  ", depth)
+	for _, f := range fields {
+		if len(f.Fields) > 0 {
+			fmt.Fprintf(b, "%s%06d  %s\n", indent, f.Offset, f.Name)
+			writeFields(b, f.Fields, depth+1)
+			continue
+		}
+
+		raw := f.Raw
+		if len(raw) > 2*maxRawTextBytes {
+			raw = raw[:2*maxRawTextBytes] + "…"
+		}
+		fmt.Fprintf(b, "%s%06d  %-14s %-34s", indent, f.Offset, f.Name, raw)
+		if f.Value != nil {
+			fmt.Fprintf(b, " %v", f.Value)
+		}
+		b.WriteString("\n")
+	}
+}
@@ -1,28 +1,114 @@
 package main
 
 import (
+	"bufio"
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"net"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
 	"syscall"
 	"time"
 
 	log "github.com/sirupsen/logrus"
 
+	"github.com/senseyman/bitcoin-handshake/addrman"
+	"github.com/senseyman/bitcoin-handshake/bandwidth"
+	"github.com/senseyman/bitcoin-handshake/banman"
+	"github.com/senseyman/bitcoin-handshake/blockstore"
+	"github.com/senseyman/bitcoin-handshake/capture"
+	"github.com/senseyman/bitcoin-handshake/capture/replay"
+	"github.com/senseyman/bitcoin-handshake/chaos"
 	"github.com/senseyman/bitcoin-handshake/client"
 	"github.com/senseyman/bitcoin-handshake/core"
+	"github.com/senseyman/bitcoin-handshake/dnsseed"
+	"github.com/senseyman/bitcoin-handshake/i2p"
+	"github.com/senseyman/bitcoin-handshake/model"
+	"github.com/senseyman/bitcoin-handshake/proxy"
 	"github.com/senseyman/bitcoin-handshake/service"
+	"github.com/senseyman/bitcoin-handshake/survey"
+	"github.com/senseyman/bitcoin-handshake/timedata"
+	"github.com/senseyman/bitcoin-handshake/tracing"
+	"github.com/senseyman/bitcoin-handshake/utils"
 )
 
 var (
-	nodeHostFlag = flag.String("node.host", "127.0.0.1", "Host of blockchain node")
-	nodePortFlag = flag.Int("node.port", 18333, "Port of blockchain node")
+	nodeHostFlag    = flag.String("node.host", "127.0.0.1", "Host of blockchain node")
+	nodePortFlag    = flag.Int("node.port", 18333, "Port of blockchain node")
+	addrManFileFlag = flag.String("addrman.file", "", "Path to the address book file. If set and node.host is not provided, the node is selected from the book")
+	dnsSeedFlag     = flag.Bool("dnsseed", false, "Query DNS seeds for nodes if node.host is not provided")
+	dnsSeedServices = servicesFlag("dnsseed.services", model.ServiceNodeNetwork, "Services the nodes from DNS seeds must have, e.g. NETWORK,WITNESS")
+
+	proxyFlag          = flag.String("proxy", "", "Connect through SOCKS5 proxy, e.g. Tor at 127.0.0.1:9050. Required for .onion nodes")
+	proxyUserFlag      = flag.String("proxy.user", "", "Username for SOCKS5 proxy")
+	proxyPasswordFlag  = flag.String("proxy.password", "", "Password for SOCKS5 proxy")
+	proxyRandomizeFlag = flag.Bool("proxy.randomize", true, "Use random credentials for every proxy connection to isolate Tor streams, unless proxy.user or proxy.password is set")
+
+	i2pSAMFlag     = flag.String("i2psam", "", "Address of I2P SAM v3 bridge, e.g. 127.0.0.1:7656. Required for .b32.i2p nodes")
+	i2pKeyFileFlag = flag.String("i2p.keyfile", "", "File to keep I2P private key in, so our I2P address is persistent. Transient address is used if empty")
+
+	reconnectAttemptsFlag = flag.Int("reconnect.attempts", 10, "Number of reconnect attempts after the connection to node is lost, 0 means no limit")
+	reconnectMaxDelayFlag = flag.Duration("reconnect.maxdelay", time.Minute, "Maximum delay between reconnect attempts")
+
+	chaosFlag     = flag.String("chaos", "", "Inject network faults into the connection to node, one of: "+strings.Join(chaos.ProfileNames(), ", "))
+	chaosSeedFlag = flag.Int64("chaos.seed", 1, "Seed of the fault schedule, the same seed reproduces the same faults")
+
+	captureFlag        = flag.String("capture", "", "Directory to capture all messages to, in the format of Bitcoin Core's -capturemessages")
+	replayFlag         = flag.String("replay", "", "Directory with captured messages to replay instead of connecting to node")
+	replayRealtimeFlag = flag.Bool("replay.realtime", false, "Keep the pauses between replayed messages")
+
+	traceFileFlag = flag.String("trace.file", "", "File to append the spans of handshakes to, as JSON lines")
+
+	uploadRateFlag   = flag.Int64("bandwidth.upload", 0, "Upload rate limit in KiB/s, 0 means no limit")
+	downloadRateFlag = flag.Int64("bandwidth.download", 0, "Download rate limit in KiB/s, 0 means no limit")
+	uploadTargetFlag = flag.Uint64("maxuploadtarget", 0, "Daily upload limit in MiB, historical blocks aren't served once it's nearly reached, 0 means no limit")
+
+	listenAddrFlag = flag.String("listen.addr", "", "Our public host[:port] which accepts connections, it's advertised to the node after the handshake and then about once a day. Listener mode is off if empty")
+
+	blocksFileFlag = flag.String("blocks.file", "", "Path to blocks in the format of Bitcoin Core's blk*.dat to serve to the node, NODE_NETWORK or NODE_NETWORK_LIMITED is advertised according to them")
+
+	banListFileFlag = flag.String("banlist.file", "", "Path to the ban list file. Banned nodes are never connected to and misbehaving nodes are added to it")
+	banScoreFlag    = flag.Int("banscore", core.DefaultBanPolicy().Threshold, "Misbehaviour score at which the node is disconnected and banned")
+	banTimeFlag     = flag.Duration("bantime", core.DefaultBanPolicy().Duration, "Duration of the ban of misbehaving node")
+
+	sendHeadersFlag = flag.Bool("features.sendheaders", true, "Ask the node to announce new blocks with headers, BIP130")
+	feeFilterFlag   = flag.Int64("features.feefilter", core.DefaultFeatures().FeeFilter, "Fee rate in sat/kvB below which the node shouldn't announce txs, 0 disables feefilter, BIP133")
+	wtxidRelayFlag  = flag.Bool("features.wtxidrelay", true, "Announce txs by wtxid if the node supports it, BIP339")
+	addrV2Flag      = flag.Bool("features.addrv2", true, "Ask the node to relay addresses in addrv2 messages, BIP155")
+	cmpctBlockFlag  = flag.Bool("features.cmpctblock", false, "Ask the node to announce new blocks with cmpctblock, BIP152 high-bandwidth mode")
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "decode":
+			os.Exit(runDecode(os.Args[2:], os.Stdin, os.Stdout, os.Stderr))
+		case "survey":
+			os.Exit(runInterruptible(func(ctx context.Context) int {
+				return runSurvey(ctx, os.Args[2:], os.Stdin, os.Stdout, os.Stderr)
+			}))
+		case "bench":
+			os.Exit(runInterruptible(func(ctx context.Context) int {
+				return runBench(ctx, os.Args[2:], os.Stdout, os.Stderr)
+			}))
+		case "fingerprint":
+			os.Exit(runInterruptible(func(ctx context.Context) int {
+				return runFingerprint(ctx, os.Args[2:], os.Stdout, os.Stderr)
+			}))
+		case "ban":
+			os.Exit(runBan(os.Args[2:], os.Stdout, os.Stderr))
+		case "daemon":
+			os.Exit(runInterruptible(func(ctx context.Context) int {
+				return runDaemon(ctx, os.Args[2:], os.Stderr)
+			}))
+		}
+	}
+
 	flag.Parse()
 
 	// setup logger
@@ -36,20 +122,139 @@ func main() {
 	writeSrv := service.NewEncodeService()
 	msgGenerator := service.NewMessageGenerator()
 
-	// create node client
-	log.Infof("Connecting to bitcoin node, host %s, port %d...", *nodeHostFlag, *nodePortFlag)
-	btcnCli, err := client.NewBitcoinClient(
-		*nodeHostFlag, *nodePortFlag,
-		func(host string, port int) (client.Connection, error) {
-			return net.Dial("tcp", fmt.Sprintf("%s:%d", host, port))
-		},
+	coreOpts := []core.Option{core.WithFeatures(core.Features{
+		SendHeaders:   *sendHeadersFlag,
+		FeeFilter:     *feeFilterFlag,
+		WtxidRelay:    *wtxidRelayFlag,
+		AddrV2:        *addrV2Flag,
+		CompactBlocks: *cmpctBlockFlag,
+	})}
+	addrBook, err := setupAddrBook()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if addrBook != nil {
+		coreOpts = append(coreOpts, core.WithAddrBook(addrBook), core.WithAddrSource(addrman.NewResponseCache(addrBook)))
+	}
+	if *listenAddrFlag != "" {
+		listenAddr, err := parseListenAddr(*listenAddrFlag)
+		if err != nil {
+			log.Fatal(err)
+		}
+		coreOpts = append(coreOpts, core.WithSelfAdvertisement(listenAddr, core.DefaultAdvertiseInterval))
+	}
+	if *traceFileFlag != "" {
+		tracer, closeTrace, err := openTracer(*traceFileFlag)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer closeTrace()
+		log.Infof("Tracing handshakes to %s", *traceFileFlag)
+		coreOpts = append(coreOpts, core.WithTracer(tracer))
+	}
+
+	dial := func(host string, port int) (client.Connection, error) {
+		return net.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+	}
+	if *proxyFlag != "" {
+		// all outgoing connections have to go through the proxy, including DNS seeding
+		if *dnsSeedFlag {
+			log.Fatal("dnsseed can't be used with proxy as DNS queries would bypass it")
+		}
+		log.Infof("Using SOCKS5 proxy %s", *proxyFlag)
+		dial = proxy.NewDialer(*proxyFlag, *proxyUserFlag, *proxyPasswordFlag, *proxyRandomizeFlag).ConnectionFn()
+	}
+	if *i2pSAMFlag != "" {
+		log.Infof("Using I2P SAM bridge %s", *i2pSAMFlag)
+		i2pSession := i2p.NewSession(*i2pSAMFlag, *i2pKeyFileFlag)
+		defer i2pSession.Close()
+		dial = i2pDial(i2pSession, dial)
+	}
+	if *chaosFlag != "" {
+		profile, err := chaos.ParseProfile(*chaosFlag)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Warnf("Injecting %s network faults with seed %d", *chaosFlag, *chaosSeedFlag)
+		dial = chaos.ConnectionFn(dial, profile, *chaosSeedFlag)
+	}
+	banList, err := setupBanList()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if banList != nil {
+		policy := core.DefaultBanPolicy()
+		policy.Threshold, policy.Duration = *banScoreFlag, *banTimeFlag
+		coreOpts = append(coreOpts, core.WithBanList(banList, policy))
+		dial = banList.Dial(dial)
+	}
+	replayPeer, err := setupReplay()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if replayPeer != nil {
+		dial = replayDial(replayPeer)
+	}
+	uploadTarget := bandwidth.NewUploadTarget(*uploadTargetFlag * 1024 * 1024)
+	if uploadTarget != nil {
+		coreOpts = append(coreOpts, core.WithUploadTarget(uploadTarget))
+	}
+	if *blocksFileFlag != "" {
+		store, err := openBlockStore(*blocksFileFlag)
+		if err != nil {
+			log.Fatal(err)
+		}
+		coreOpts = append(coreOpts, core.WithBlockStore(store))
+	}
+	meter := bandwidth.NewMeter(
+		bandwidth.WithUploadRate(*uploadRateFlag*1024, 0),
+		bandwidth.WithDownloadRate(*downloadRateFlag*1024, 0),
+		bandwidth.WithUploadTarget(uploadTarget),
 	)
+	dial = meter.ConnectionFn(dial)
+	nodeHost, nodePort, connectionFn, err := selectNode(addrBook, dial)
 	if err != nil {
 		log.Fatal(err)
 	}
+	if addrBook != nil {
+		if addr, err := model.NewNetAddressV2FromHost(nodeHost, uint16(nodePort), 0, 0); err == nil {
+			addrBook.Attempt(addr)
+		}
+	}
+
+	// create node client
+	if nodeHost == "" {
+		log.Info("Connecting to bitcoin node from DNS seeds...")
+	} else {
+		log.Infof("Connecting to bitcoin node, host %s, port %d...", nodeHost, nodePort)
+	}
+	var coreSystem *core.Core
+	clientOpts := []client.Option{
+		client.WithReconnectPolicy(reconnectPolicy()),
+		client.WithEventHandler(func(event model.ConnectionEvent) {
+			// the initial connection is made before core is created
+			if coreSystem != nil {
+				coreSystem.OnConnectionEvent(event)
+			}
+		}),
+	}
+	if *captureFlag != "" {
+		recorder, err := capture.NewRecorder(*captureFlag)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer recorder.Close()
+		log.Infof("Capturing messages to %s", *captureFlag)
+		clientOpts = append(clientOpts, client.WithRecorder(recorder))
+	}
+	btcnCli, err := client.NewBitcoinClient(nodeHost, nodePort, connectionFn, clientOpts...)
+	if err != nil {
+		saveAddrBook(addrBook)
+		log.Fatal(err)
+	}
 
 	// create main core logic service
-	coreSystem := core.New(readSrv, writeSrv, msgGenerator, btcnCli)
+	coreSystem = core.New(readSrv, writeSrv, msgGenerator, btcnCli, coreOpts...)
 
 	// general context
 	globalCtx, globalCtxCancel := context.WithCancel(context.Background())
@@ -70,14 +275,243 @@ func main() {
 
 	execTimeMs, err := coreSystem.Handshake(handshakeCtx)
 	if err != nil {
+		saveAddrBook(addrBook)
+		saveBanList(banList)
 		log.Fatalf("error while doing main flow: %v", err)
 	}
 
+	if addrBook != nil {
+		addr, err := model.NewNetAddressV2FromHost(btcnCli.GetNodeHost(), uint16(btcnCli.GetNodePort()), 0, time.Now().Unix())
+		if err == nil {
+			addrBook.Good(addr)
+		}
+		saveAddrBook(addrBook)
+	}
+	saveBanList(banList)
+
+	if replayPeer != nil {
+		checkReplay(globalCtx, replayPeer)
+	}
+
 	log.Info("All necessary messages for connection are received.")
+	last := coreSystem.LastHandshake()
+	remote := last.RemoteVersion
+	log.Infof("Node %s runs protocol version %d with services %s.", remote.UserAgent, remote.Version, remote.Services)
+	if last.TimeOffset > timedata.DefaultWarnThreshold || last.TimeOffset < -timedata.DefaultWarnThreshold {
+		log.Warnf("Node clock differs from the local one by %s, one of them is likely wrong.", last.TimeOffset)
+	} else {
+		log.Infof("Node clock differs from the local one by %s.", last.TimeOffset)
+	}
+	log.Infof("Negotiated features: %+v.", coreSystem.PeerFeatures())
 	log.Infof("Handshake took %d ms.", execTimeMs)
+	traffic := meter.Stats()
+	log.Infof("Sent %d bytes in %d messages, received %d bytes in %d messages.",
+		traffic.Sent.Bytes, traffic.Sent.Messages, traffic.Received.Bytes, traffic.Received.Messages)
 	log.Info("Stopping the App...")
 }
 
+// servicesFlag defines the flag of services which are given by names.
+func servicesFlag(name string, value model.ServiceFlag, usage string) *model.ServiceFlag {
+	services := value
+	flag.Var(&services, name, usage)
+	return &services
+}
+
+// openTracer makes the tracer which appends spans to the file.
+func openTracer(path string) (*tracing.Tracer, func(), error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, nil, fmt.Errorf("can't open trace file: %w", err)
+	}
+	closeFn := func() {
+		if err := f.Close(); err != nil {
+			log.Warnf("err while closing trace file: %v", err)
+		}
+	}
+
+	return tracing.NewTracer(tracing.NewJSONExporter(f)), closeFn, nil
+}
+
+// i2pDial routes connections to .b32.i2p nodes through I2P session and all others through dial.
+func i2pDial(
+	session *i2p.Session,
+	dial func(host string, port int) (client.Connection, error),
+) func(host string, port int) (client.Connection, error) {
+	i2pConnect := session.ConnectionFn()
+	return func(host string, port int) (client.Connection, error) {
+		if strings.HasSuffix(strings.ToLower(host), utils.I2PSuffix) {
+			return i2pConnect(host, port)
+		}
+		return dial(host, port)
+	}
+}
+
+func setupReplay() (*replay.Peer, error) {
+	if *replayFlag == "" {
+		return nil, nil
+	}
+	if *dnsSeedFlag || *addrManFileFlag != "" {
+		return nil, errors.New("replay can't be used with dnsseed or address book")
+	}
+
+	records, err := capture.ReadDir(*replayFlag)
+	if err != nil {
+		return nil, err
+	}
+	log.Infof("Replaying %d captured messages from %s", len(records), *replayFlag)
+
+	return replay.NewPeer(records, *replayRealtimeFlag), nil
+}
+
+// replayDial connects to the replaying peer. The capture can be replayed only once, so reconnects fail.
+func replayDial(peer *replay.Peer) func(host string, port int) (client.Connection, error) {
+	var once sync.Once
+	return func(string, int) (client.Connection, error) {
+		var conn client.Connection
+		once.Do(func() {
+			conn = peer.Pipe()
+		})
+		if conn == nil {
+			return nil, errors.New("captured session is over")
+		}
+		return conn, nil
+	}
+}
+
+// checkReplay reports if our messages differ from the ones in the capture.
+func checkReplay(ctx context.Context, peer *replay.Peer) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	if err := peer.Wait(ctx); err != nil {
+		log.Warnf("replayed session differs from the captured one: %v", err)
+		return
+	}
+	log.Info("replayed session matches the captured one")
+}
+
+func reconnectPolicy() client.ReconnectPolicy {
+	policy := client.DefaultReconnectPolicy()
+	policy.MaxAttempts = *reconnectAttemptsFlag
+	policy.MaxDelay = *reconnectMaxDelayFlag
+
+	return policy
+}
+
+func setupAddrBook() (*addrman.AddrMan, error) {
+	if *addrManFileFlag == "" {
+		return nil, nil
+	}
+
+	return addrman.New(*addrManFileFlag)
+}
+
+// selectNode returns the node to connect to. If the node wasn't set explicitly, it is selected from the address book
+// or from DNS seeds. In the latter case host is empty and the returned connection function picks the node by itself.
+func selectNode(
+	addrBook *addrman.AddrMan,
+	dial func(host string, port int) (client.Connection, error),
+) (string, int, func(host string, port int) (client.Connection, error), error) {
+	hostIsSet := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "node.host" {
+			hostIsSet = true
+		}
+	})
+	if hostIsSet || (addrBook == nil && !*dnsSeedFlag) {
+		return *nodeHostFlag, *nodePortFlag, dial, nil
+	}
+
+	seeder := dnsseed.New(net.DefaultResolver, dnsseed.TestNetSeeds, *nodePortFlag, *dnsSeedServices)
+	if addrBook == nil {
+		return "", *nodePortFlag, seeder.ConnectionFn(dial), nil
+	}
+
+	if newCount, triedCount := addrBook.Size(); newCount+triedCount == 0 && *dnsSeedFlag {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+
+		addrs, err := seeder.Lookup(ctx)
+		if err != nil {
+			return "", 0, nil, err
+		}
+		// seeds are not nodes, so all their addresses share the same source group
+		addrBook.Add(addrs, model.NetAddressV2{})
+	}
+
+	addr, ok := addrBook.Select(false)
+	if !ok {
+		return "", 0, nil, fmt.Errorf("address book %s is empty, please provide node.host or enable dnsseed", *addrManFileFlag)
+	}
+	log.Infof("selected node %s from address book", addr)
+
+	return addr.Host(), int(addr.Port), dial, nil
+}
+
+func saveAddrBook(addrBook *addrman.AddrMan) {
+	if addrBook == nil {
+		return
+	}
+	if err := addrBook.Save(); err != nil {
+		log.Errorf("err while saving address book: %v", err)
+	}
+}
+
+func setupBanList() (*banman.BanMan, error) {
+	if *banListFileFlag == "" {
+		return nil, nil
+	}
+
+	return banman.New(*banListFileFlag)
+}
+
+// parseListenAddr parses our public address which is advertised to the nodes, the port is 18333 if omitted.
+func parseListenAddr(s string) (model.NetAddressV2, error) {
+	target, err := survey.ParseTarget(s, 18333)
+	if err != nil {
+		return model.NetAddressV2{}, err
+	}
+
+	return model.NewNetAddressV2FromHost(target.Host, uint16(target.Port), 0, 0)
+}
+
+// openBlockStore imports the blocks of the file into the store of testnet chain.
+func openBlockStore(path string) (*blockstore.Store, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	store := blockstore.New(model.TestNetGenesis())
+	imported, err := store.Import(bufio.NewReader(f), model.TestNetMagic)
+	if err != nil {
+		return nil, fmt.Errorf("import blocks from %s: %w", path, err)
+	}
+	_, height := store.Tip()
+	log.Infof("Imported %d blocks from %s, height %d, services %s", imported, path, height, store.Services())
+
+	return store, nil
+}
+
+func saveBanList(banList *banman.BanMan) {
+	if banList == nil {
+		return
+	}
+	if err := banList.Save(); err != nil {
+		log.Errorf("err while saving ban list: %v", err)
+	}
+}
+
+// runInterruptible runs the subcommand with the context which is canceled on interrupt, so it can report
+// the results collected so far.
+func runInterruptible(run func(ctx context.Context) int) int {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	return run(ctx)
+}
+
 func setupGracefulShutdown(stop func()) {
 	signalChannel := make(chan os.Signal, 1)
 	signal.Notify(signalChannel, os.Interrupt, syscall.SIGTERM)
@@ -0,0 +1,241 @@
+package metrics
+
+import (
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/common/expfmt"
+
+	"github.com/senseyman/bitcoin-handshake/model"
+	"github.com/senseyman/bitcoin-handshake/timedata"
+)
+
+const (
+	namespace = "bitcoin_"
+
+	DirectionIn  = "in"
+	DirectionOut = "out"
+)
+
+// Decode error reasons.
+const (
+	DecodeErrorBadMagic        = "bad_magic"
+	DecodeErrorBadChecksum     = "bad_checksum"
+	DecodeErrorPayloadTooLarge = "payload_too_large"
+	DecodeErrorUnknownCommand  = "unknown_command"
+	DecodeErrorMalformed       = "malformed"
+)
+
+// CommandOther is the command label of messages which aren't in the protocol, so a peer can't blow up the number of series.
+const CommandOther = "other"
+
+var (
+	// commands are the messages of the protocol which are counted by their own label
+	commands = map[string]bool{
+		"version": true, "verack": true, "addr": true, "addrv2": true, "sendaddrv2": true, "getaddr": true,
+		"inv": true, "getdata": true, "notfound": true, "getblocks": true, "getheaders": true, "headers": true,
+		"block": true, "tx": true, "mempool": true, "ping": true, "pong": true, "reject": true,
+		"sendheaders": true, "feefilter": true, "sendcmpct": true, "cmpctblock": true, "getblocktxn": true,
+		"blocktxn": true, "filterload": true, "filteradd": true, "filterclear": true, "merkleblock": true,
+		"getcfilters": true, "cfilter": true, "getcfheaders": true, "cfheaders": true, "getcfcheckpt": true,
+		"cfcheckpt": true, "wtxidrelay": true, "sendtxrcncl": true,
+	}
+
+	// TimeOffsetBuckets are the buckets of clock offsets of nodes in seconds, from a second to the offset
+	// which Bitcoin Core doesn't adjust the time by anymore.
+	TimeOffsetBuckets = []float64{-4200, -600, -60, -10, -1, 1, 10, 60, 600, 4200}
+
+	// DurationBuckets are the buckets of handshake durations in seconds, from LAN to slow Tor circuits.
+	DurationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+)
+
+// Metrics are the metrics of handshakes and message traffic. It's used by core.Core and client.BitcoinClient,
+// all methods are safe for concurrent use.
+type Metrics struct {
+	registry *prometheus.Registry
+
+	handshakeAttempts  prometheus.Counter
+	handshakeSuccesses prometheus.Counter
+	handshakeFailures  *prometheus.CounterVec
+	handshakeDuration  *prometheus.HistogramVec
+	timeOffset         prometheus.Histogram
+	messages           *prometheus.CounterVec
+	bytes              *prometheus.CounterVec
+	decodeErrors       *prometheus.CounterVec
+	reconnects         *prometheus.CounterVec
+}
+
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		handshakeAttempts: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: namespace + "handshake_attempts_total",
+			Help: "Number of started handshakes.",
+		}),
+		handshakeSuccesses: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: namespace + "handshake_successes_total",
+			Help: "Number of successful handshakes.",
+		}),
+		handshakeFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: namespace + "handshake_failures_total",
+			Help: "Number of failed handshakes by reason.",
+		}, []string{"reason"}),
+		handshakeDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    namespace + "handshake_duration_seconds",
+			Help:    "Duration of successful handshakes by phase: version and verack round trips and the whole handshake.",
+			Buckets: DurationBuckets,
+		}, []string{"phase"}),
+		timeOffset: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    namespace + "handshake_time_offset_seconds",
+			Help:    "Clock offset of nodes at successful handshakes: the time of the node minus the local time.",
+			Buckets: TimeOffsetBuckets,
+		}),
+		messages: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: namespace + "messages_total",
+			Help: "Number of messages by direction and command.",
+		}, []string{"direction", "command"}),
+		bytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: namespace + "message_bytes_total",
+			Help: "Size of messages on the wire, including header, by direction and command.",
+		}, []string{"direction", "command"}),
+		decodeErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: namespace + "decode_errors_total",
+			Help: "Number of received messages which were dropped, by reason.",
+		}, []string{"reason"}),
+		reconnects: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: namespace + "reconnects_total",
+			Help: "Number of reconnect attempts by result.",
+		}, []string{"result"}),
+	}
+	m.registry.MustRegister(m.handshakeAttempts, m.handshakeSuccesses, m.handshakeFailures, m.handshakeDuration,
+		m.timeOffset, m.messages, m.bytes, m.decodeErrors, m.reconnects)
+
+	return m
+}
+
+func (m *Metrics) Registry() *prometheus.Registry {
+	return m.registry
+}
+
+// Handler serves the metrics for scraping.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// WriteText writes the metrics in Prometheus text exposition format.
+func (m *Metrics) WriteText(w io.Writer) error {
+	families, err := m.registry.Gather()
+	if err != nil {
+		return err
+	}
+	for _, family := range families {
+		if _, err := expfmt.MetricFamilyToText(w, family); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m *Metrics) HandshakeStarted() {
+	m.handshakeAttempts.Inc()
+}
+
+func (m *Metrics) HandshakeSucceeded(stats model.HandshakeStats) {
+	m.handshakeSuccesses.Inc()
+	m.handshakeDuration.WithLabelValues("version").Observe(stats.VersionRTT.Seconds())
+	m.handshakeDuration.WithLabelValues("verack").Observe(stats.VerackRTT.Seconds())
+	m.handshakeDuration.WithLabelValues("total").Observe(stats.Total.Seconds())
+	m.timeOffset.Observe(stats.TimeOffset.Seconds())
+}
+
+// WatchTimeData exports the clock offsets of peers kept by the sampler and their median, which is the offset
+// of the local clock from the network-adjusted time. The median isn't exported until there are enough samples.
+func (m *Metrics) WatchTimeData(sampler *timedata.Sampler) {
+	m.registry.MustRegister(&timeDataCollector{
+		sampler: sampler,
+		peerOffset: prometheus.NewDesc(namespace+"peer_time_offset_seconds",
+			"Clock offset of the peer at the last handshake: the time of the peer minus the local time.",
+			[]string{"peer"}, nil),
+		networkOffset: prometheus.NewDesc(namespace+"network_time_offset_seconds",
+			"Median clock offset of peers, the offset of the network-adjusted time from the local clock.", nil, nil),
+		skewed: prometheus.NewDesc(namespace+"local_clock_skewed",
+			"1 if the median clock offset of peers exceeds the warn threshold, the local clock is likely wrong.", nil, nil),
+	})
+}
+
+// timeDataCollector reads the offsets from the sampler at every scrape.
+type timeDataCollector struct {
+	sampler       *timedata.Sampler
+	peerOffset    *prometheus.Desc
+	networkOffset *prometheus.Desc
+	skewed        *prometheus.Desc
+}
+
+func (c *timeDataCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.peerOffset
+	ch <- c.networkOffset
+	ch <- c.skewed
+}
+
+func (c *timeDataCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, sample := range c.sampler.Samples() {
+		ch <- prometheus.MustNewConstMetric(c.peerOffset, prometheus.GaugeValue, sample.Offset.Seconds(), sample.Peer)
+	}
+	if median, ok := c.sampler.Median(); ok {
+		ch <- prometheus.MustNewConstMetric(c.networkOffset, prometheus.GaugeValue, median.Seconds())
+	}
+	skewed := 0.0
+	if c.sampler.Skewed() {
+		skewed = 1
+	}
+	ch <- prometheus.MustNewConstMetric(c.skewed, prometheus.GaugeValue, skewed)
+}
+
+// HandshakeFailed counts the failure by the reason of model.FailureReason, the same as the probes report.
+func (m *Metrics) HandshakeFailed(err error) {
+	m.handshakeFailures.WithLabelValues(model.FailureReason(err)).Inc()
+}
+
+func (m *Metrics) MessageReceived(command string, size int) {
+	m.message(DirectionIn, command, size)
+}
+
+func (m *Metrics) MessageSent(command string, size int) {
+	m.message(DirectionOut, command, size)
+}
+
+func (m *Metrics) message(direction, command string, size int) {
+	if !commands[command] {
+		command = CommandOther
+	}
+	m.messages.WithLabelValues(direction, command).Inc()
+	m.bytes.WithLabelValues(direction, command).Add(float64(size))
+}
+
+// DecodeError counts the received message which was dropped because of err.
+func (m *Metrics) DecodeError(err error) {
+	reason := DecodeErrorMalformed
+	switch {
+	case errors.Is(err, model.ErrInvalidMagicNumber):
+		reason = DecodeErrorBadMagic
+	case errors.Is(err, model.ErrInvalidMessageChecksum):
+		reason = DecodeErrorBadChecksum
+	case errors.Is(err, model.ErrPayloadTooLarge):
+		reason = DecodeErrorPayloadTooLarge
+	case errors.Is(err, model.ErrUnknownCommand):
+		reason = DecodeErrorUnknownCommand
+	}
+	m.decodeErrors.WithLabelValues(reason).Inc()
+}
+
+func (m *Metrics) Reconnect(success bool) {
+	result := "success"
+	if !success {
+		result = "failure"
+	}
+	m.reconnects.WithLabelValues(result).Inc()
+}
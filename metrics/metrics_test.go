@@ -0,0 +1,156 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/senseyman/bitcoin-handshake/model"
+	"github.com/senseyman/bitcoin-handshake/timedata"
+)
+
+func scrape(t *testing.T, m *Metrics) string {
+	var b bytes.Buffer
+	require.NoError(t, m.WriteText(&b))
+	return b.String()
+}
+
+func TestMetrics_Handshake(t *testing.T) {
+	m := New()
+	assert.Contains(t, scrape(t, m), "bitcoin_handshake_attempts_total 0\n")
+
+	m.HandshakeStarted()
+	m.HandshakeSucceeded(model.HandshakeStats{
+		VersionRTT: 20 * time.Millisecond,
+		VerackRTT:  30 * time.Millisecond,
+		Total:      60 * time.Millisecond,
+	})
+	m.HandshakeStarted()
+	m.HandshakeFailed(fmt.Errorf("%w: no verack", model.ErrContextTimeout))
+	m.HandshakeStarted()
+	m.HandshakeFailed(model.ErrConnectionClosed)
+	m.HandshakeStarted()
+	m.HandshakeFailed(fmt.Errorf("%w: refused", model.ErrConnect))
+
+	text := scrape(t, m)
+	for _, line := range []string{
+		"bitcoin_handshake_attempts_total 4\n",
+		"bitcoin_handshake_successes_total 1\n",
+		`bitcoin_handshake_failures_total{reason="connect"} 1` + "\n",
+		`bitcoin_handshake_failures_total{reason="disconnected"} 1` + "\n",
+		`bitcoin_handshake_failures_total{reason="timeout"} 1` + "\n",
+		`bitcoin_handshake_duration_seconds_bucket{phase="version",le="0.01"} 0` + "\n",
+		`bitcoin_handshake_duration_seconds_bucket{phase="version",le="0.025"} 1` + "\n",
+		`bitcoin_handshake_duration_seconds_bucket{phase="verack",le="0.05"} 1` + "\n",
+		`bitcoin_handshake_duration_seconds_sum{phase="total"} 0.06` + "\n",
+		`bitcoin_handshake_duration_seconds_count{phase="total"} 1` + "\n",
+	} {
+		assert.Contains(t, text, line)
+	}
+}
+
+func TestMetrics_TimeData(t *testing.T) {
+	m := New()
+	sampler := timedata.New(timedata.Config{MaxSamples: 10, MinSamples: 3, WarnThreshold: time.Minute})
+	m.WatchTimeData(sampler)
+
+	m.HandshakeSucceeded(model.HandshakeStats{TimeOffset: -5 * time.Second})
+	sampler.Add("10.0.0.1:8333", 2*time.Minute)
+	sampler.Add("10.0.0.2:8333", 3*time.Minute)
+
+	// the median isn't exported until there are enough samples
+	text := scrape(t, m)
+	assert.Contains(t, text, `bitcoin_peer_time_offset_seconds{peer="10.0.0.1:8333"} 120`+"\n")
+	assert.NotContains(t, text, "\nbitcoin_network_time_offset_seconds ")
+	assert.Contains(t, text, "bitcoin_local_clock_skewed 0\n")
+	assert.Contains(t, text, `bitcoin_handshake_time_offset_seconds_bucket{le="-10"} 0`+"\n")
+	assert.Contains(t, text, `bitcoin_handshake_time_offset_seconds_bucket{le="-1"} 1`+"\n")
+
+	sampler.Add("10.0.0.3:8333", -time.Minute)
+	text = scrape(t, m)
+	assert.Contains(t, text, "bitcoin_network_time_offset_seconds 120\n")
+	assert.Contains(t, text, "bitcoin_local_clock_skewed 1\n")
+}
+
+func TestMetrics_Messages(t *testing.T) {
+	m := New()
+
+	m.MessageSent(model.VersionCMD, 126)
+	m.MessageReceived(model.VersionCMD, 126)
+	m.MessageReceived("ping", 32)
+	m.MessageReceived("ping", 32)
+	m.MessageReceived("weird", 24)
+
+	text := scrape(t, m)
+	for _, line := range []string{
+		`bitcoin_messages_total{command="other",direction="in"} 1` + "\n",
+		`bitcoin_messages_total{command="ping",direction="in"} 2` + "\n",
+		`bitcoin_messages_total{command="version",direction="in"} 1` + "\n",
+		`bitcoin_messages_total{command="version",direction="out"} 1` + "\n",
+		`bitcoin_message_bytes_total{command="ping",direction="in"} 64` + "\n",
+		`bitcoin_message_bytes_total{command="version",direction="out"} 126` + "\n",
+	} {
+		assert.Contains(t, text, line)
+	}
+	assert.NotContains(t, text, "weird")
+}
+
+func TestMetrics_DecodeError(t *testing.T) {
+	testCases := []struct {
+		name      string
+		err       error
+		expReason string
+	}{
+		{
+			name:      "bad_magic",
+			err:       model.ErrInvalidMagicNumber,
+			expReason: DecodeErrorBadMagic,
+		},
+		{
+			name:      "bad_checksum",
+			err:       model.ErrInvalidMessageChecksum,
+			expReason: DecodeErrorBadChecksum,
+		},
+		{
+			name:      "payload_too_large",
+			err:       model.ErrPayloadTooLarge,
+			expReason: DecodeErrorPayloadTooLarge,
+		},
+		{
+			name:      "unknown_command",
+			err:       fmt.Errorf("%w, can't parse payload: sendcmpct", model.ErrUnknownCommand),
+			expReason: DecodeErrorUnknownCommand,
+		},
+		{
+			name:      "malformed",
+			err:       fmt.Errorf("unexpected EOF"),
+			expReason: DecodeErrorMalformed,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			m := New()
+			m.DecodeError(tc.err)
+
+			assert.Contains(t, scrape(t, m), `bitcoin_decode_errors_total{reason="`+tc.expReason+`"} 1`+"\n")
+		})
+	}
+}
+
+func TestMetrics_Reconnect(t *testing.T) {
+	m := New()
+	m.Reconnect(true)
+	m.Reconnect(false)
+	m.Reconnect(false)
+
+	text := scrape(t, m)
+	assert.Contains(t, text, `bitcoin_reconnects_total{result="failure"} 2`+"\n")
+	assert.Contains(t, text, `bitcoin_reconnects_total{result="success"} 1`+"\n")
+}
@@ -0,0 +1,180 @@
+package model
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/senseyman/bitcoin-handshake/utils"
+)
+
+// NetworkID is the BIP155 network identifier used by addrv2 messages.
+type NetworkID uint8
+
+const (
+	NetIPv4  NetworkID = 1
+	NetIPv6  NetworkID = 2
+	NetTorV2 NetworkID = 3
+	NetTorV3 NetworkID = 4
+	NetI2P   NetworkID = 5
+	NetCJDNS NetworkID = 6
+)
+
+// AddrSize returns the expected address length for the network or 0 if the network is unknown.
+func (n NetworkID) AddrSize() int {
+	switch n {
+	case NetIPv4:
+		return 4
+	case NetIPv6, NetCJDNS:
+		return 16
+	case NetTorV2:
+		return 10
+	case NetTorV3, NetI2P:
+		return 32
+	}
+	return 0
+}
+
+func (n NetworkID) String() string {
+	switch n {
+	case NetIPv4:
+		return "ipv4"
+	case NetIPv6:
+		return "ipv6"
+	case NetTorV2:
+		return "torv2"
+	case NetTorV3:
+		return "onion"
+	case NetI2P:
+		return "i2p"
+	case NetCJDNS:
+		return "cjdns"
+	}
+	return "net" + strconv.Itoa(int(n))
+}
+
+// NetAddressV2 is a network address as it is relayed by addrv2 messages (BIP155).
+// Unlike NetAddress it can hold non-IP addresses such as Tor and I2P ones.
+type NetAddressV2 struct {
+	Timestamp int64
+	Services  ServiceFlag
+	NetworkID NetworkID
+	Addr      []byte
+	Port      uint16
+}
+
+type AddrMessage struct {
+	AddrList []NetAddress
+}
+
+type AddrV2Message struct {
+	AddrList []NetAddressV2
+}
+
+// NewNetAddressV2FromIP converts an IP based address to its addrv2 form. IPv4-mapped IPv6 addresses are stored as IPv4.
+func NewNetAddressV2FromIP(ip net.IP, port uint16, services ServiceFlag, timestamp int64) NetAddressV2 {
+	na := NetAddressV2{
+		Timestamp: timestamp,
+		Services:  services,
+		Port:      port,
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		na.NetworkID = NetIPv4
+		na.Addr = append([]byte(nil), ip4...)
+	} else {
+		na.NetworkID = NetIPv6
+		na.Addr = append([]byte(nil), ip.To16()...)
+	}
+
+	return na
+}
+
+// NewNetAddressV2FromHost parses the host name which can be IP, .onion or .b32.i2p address.
+func NewNetAddressV2FromHost(host string, port uint16, services ServiceFlag, timestamp int64) (NetAddressV2, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return NewNetAddressV2FromIP(ip, port, services, timestamp), nil
+	}
+
+	if strings.HasSuffix(strings.ToLower(host), utils.OnionSuffix) {
+		pubKey, err := utils.DecodeOnionV3(host)
+		if err != nil {
+			return NetAddressV2{}, err
+		}
+		return NetAddressV2{
+			Timestamp: timestamp,
+			Services:  services,
+			NetworkID: NetTorV3,
+			Addr:      pubKey,
+			Port:      port,
+		}, nil
+	}
+
+	if strings.HasSuffix(strings.ToLower(host), utils.I2PSuffix) {
+		hash, err := utils.DecodeI2P(host)
+		if err != nil {
+			return NetAddressV2{}, err
+		}
+		// I2P has no ports, SAM 3.1 used by nodes always relays them as 0
+		return NetAddressV2{
+			Timestamp: timestamp,
+			Services:  services,
+			NetworkID: NetI2P,
+			Addr:      hash,
+		}, nil
+	}
+
+	return NetAddressV2{}, fmt.Errorf("%w: %s", ErrInvalidAddress, host)
+}
+
+// ToV2 converts the address to its addrv2 form.
+func (na NetAddress) ToV2() NetAddressV2 {
+	return NewNetAddressV2FromIP(na.IP, na.Port, na.Services, na.Timestamp)
+}
+
+// ToV1 converts the address to the form of addr message, false is returned if it's not IP based.
+func (na NetAddressV2) ToV1() (NetAddress, bool) {
+	ip := na.IP()
+	if ip == nil {
+		return NetAddress{}, false
+	}
+
+	return NetAddress{Timestamp: na.Timestamp, Services: na.Services, IP: ip, Port: na.Port}, true
+}
+
+// IP returns the address as net.IP for IP based networks and nil otherwise.
+func (na NetAddressV2) IP() net.IP {
+	switch na.NetworkID {
+	case NetIPv4, NetIPv6:
+		if len(na.Addr) == na.NetworkID.AddrSize() {
+			return net.IP(na.Addr)
+		}
+	}
+	return nil
+}
+
+// Host returns the address without a port in the form which can be used for dialing.
+func (na NetAddressV2) Host() string {
+	if ip := na.IP(); ip != nil {
+		return ip.String()
+	}
+	if len(na.Addr) == na.NetworkID.AddrSize() {
+		switch na.NetworkID {
+		case NetTorV3:
+			return utils.EncodeOnionV3(na.Addr)
+		case NetI2P:
+			return utils.EncodeI2P(na.Addr)
+		}
+	}
+	return na.NetworkID.String() + ":" + hex.EncodeToString(na.Addr)
+}
+
+// Key returns a unique string identifying the address and port.
+func (na NetAddressV2) Key() string {
+	return fmt.Sprintf("%d/%x/%d", na.NetworkID, na.Addr, na.Port)
+}
+
+func (na NetAddressV2) String() string {
+	return net.JoinHostPort(na.Host(), strconv.Itoa(int(na.Port)))
+}
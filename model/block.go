@@ -0,0 +1,145 @@
+package model
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"slices"
+
+	"github.com/senseyman/bitcoin-handshake/utils"
+)
+
+// BlockHeaderSize is the size of the serialized block header.
+const BlockHeaderSize = 80
+
+type BlockHeader struct {
+	Version    int32
+	PrevBlock  [32]byte
+	MerkleRoot [32]byte
+	Timestamp  uint32
+	Bits       uint32
+	Nonce      uint32
+}
+
+// Bytes returns the header as it's serialized in block and headers messages.
+func (h BlockHeader) Bytes() []byte {
+	b := make([]byte, 0, BlockHeaderSize)
+	b = binary.LittleEndian.AppendUint32(b, uint32(h.Version))
+	b = append(b, h.PrevBlock[:]...)
+	b = append(b, h.MerkleRoot[:]...)
+	b = binary.LittleEndian.AppendUint32(b, h.Timestamp)
+	b = binary.LittleEndian.AppendUint32(b, h.Bits)
+	b = binary.LittleEndian.AppendUint32(b, h.Nonce)
+
+	return b
+}
+
+// Hash is the double SHA-256 of the header, in the byte order of the wire.
+func (h BlockHeader) Hash() [32]byte {
+	var hash [32]byte
+	copy(hash[:], utils.DoubleHashB(h.Bytes()))
+
+	return hash
+}
+
+// HashString is the hash as it's displayed by nodes and explorers, in reversed byte order.
+func HashString(hash [32]byte) string {
+	b := hash[:]
+	reversed := slices.Clone(b)
+	slices.Reverse(reversed)
+
+	return hex.EncodeToString(reversed)
+}
+
+type HeadersMessage struct {
+	Headers []BlockHeader
+}
+
+// CmpctBlockMessage is the compact block announcement, BIP152. Only its header is decoded, the short tx ids
+// and the prefilled txs aren't needed to tell which block is announced.
+type CmpctBlockMessage struct {
+	Header BlockHeader
+}
+
+// BlockLocatorMessage is the payload of getheaders and getblocks. The locator lists the hashes of the chain of the
+// peer from its tip backwards, zero hash stop means as many as allowed.
+type BlockLocatorMessage struct {
+	Version  uint32
+	Locator  [][32]byte
+	HashStop [32]byte
+}
+
+// TestNetGenesis is the genesis block header of testnet3.
+func TestNetGenesis() BlockHeader {
+	h := BlockHeader{Version: 1, Timestamp: 1296688602, Bits: 0x1d00ffff, Nonce: 414098458}
+	merkleRoot, _ := hex.DecodeString("3ba3edfd7a7b12b27ac72c3e67768f617fc81bc3888a51323a9fb8aa4b1e5e4a")
+	copy(h.MerkleRoot[:], merkleRoot)
+
+	return h
+}
+
+// CheckProofOfWork reports if the hash of the header is not above the target encoded in bits.
+func (h BlockHeader) CheckProofOfWork() bool {
+	target, ok := compactToTarget(h.Bits)
+	if !ok {
+		return false
+	}
+
+	hash := h.Hash()
+	slices.Reverse(hash[:])
+
+	return new(big.Int).SetBytes(hash[:]).Cmp(target) <= 0
+}
+
+// Work is the expected number of hashes to find the header, 2^256 / (target + 1) as in Bitcoin Core. The chain
+// with the most cumulative work is the best one. Invalid bits have no work.
+func (h BlockHeader) Work() *big.Int {
+	target, ok := compactToTarget(h.Bits)
+	if !ok {
+		return new(big.Int)
+	}
+
+	denominator := new(big.Int).Add(target, big.NewInt(1))
+
+	return new(big.Int).Div(new(big.Int).Lsh(big.NewInt(1), 256), denominator)
+}
+
+// compactToTarget decodes the target from the compact form of bits, negative and zero targets are invalid.
+func compactToTarget(bits uint32) (*big.Int, bool) {
+	mantissa := int64(bits & 0x007fffff)
+	exponent := uint(bits >> 24)
+	if bits&0x00800000 != 0 || mantissa == 0 {
+		return nil, false
+	}
+
+	target := big.NewInt(mantissa)
+	if exponent <= 3 {
+		target.Rsh(target, 8*(3-exponent))
+	} else {
+		target.Lsh(target, 8*(exponent-3))
+	}
+	if target.Sign() == 0 || target.BitLen() > 256 {
+		return nil, false
+	}
+
+	return target, true
+}
+
+// ValidateHeaders checks that every header has valid proof of work and the headers connect to each other.
+func ValidateHeaders(headers []BlockHeader) error {
+	if len(headers) > MaxHeadersPerMessage {
+		return fmt.Errorf("%w: %d headers", ErrInvalidHeaders, len(headers))
+	}
+
+	for i, h := range headers {
+		if !h.CheckProofOfWork() {
+			return fmt.Errorf("%w: header %s has invalid proof of work", ErrInvalidHeaders, HashString(h.Hash()))
+		}
+		if i > 0 && h.PrevBlock != headers[i-1].Hash() {
+			return fmt.Errorf("%w: header %s doesn't connect to the previous one", ErrInvalidHeaders, HashString(h.Hash()))
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,124 @@
+package model
+
+import (
+	"encoding/hex"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBlockHeader_Hash(t *testing.T) {
+	t.Parallel()
+
+	merkleRoot, err := hex.DecodeString("4a5e1e4baab89f3a32518a88c31bc87f618f76673e2cc77ab2127b7afdeda33b")
+	require.NoError(t, err)
+	slices.Reverse(merkleRoot)
+
+	// the genesis block of mainnet
+	header := BlockHeader{Version: 1, Timestamp: 1231006505, Bits: 0x1d00ffff, Nonce: 2083236893}
+	copy(header.MerkleRoot[:], merkleRoot)
+
+	assert.Len(t, header.Bytes(), BlockHeaderSize)
+	assert.Equal(t, "000000000019d6689c085ae165831e934ff763ae46a2a6c172b3f1b60a8ce26f", HashString(header.Hash()))
+}
+
+// mineHeaders builds the chain of headers with the minimal difficulty of regtest.
+func mineHeaders(tb testing.TB, count int) []BlockHeader {
+	tb.Helper()
+
+	headers := make([]BlockHeader, 0, count)
+	var prev [32]byte
+	for i := 0; i < count; i++ {
+		h := BlockHeader{Version: 4, PrevBlock: prev, Timestamp: uint32(1700000000 + i), Bits: 0x207fffff}
+		for !h.CheckProofOfWork() {
+			h.Nonce++
+		}
+		headers = append(headers, h)
+		prev = h.Hash()
+	}
+
+	return headers
+}
+
+func TestValidateHeaders(t *testing.T) {
+	t.Parallel()
+
+	genesis := BlockHeader{Version: 1, Timestamp: 1231006505, Bits: 0x1d00ffff, Nonce: 2083236893}
+	merkleRoot, err := hex.DecodeString("4a5e1e4baab89f3a32518a88c31bc87f618f76673e2cc77ab2127b7afdeda33b")
+	require.NoError(t, err)
+	slices.Reverse(merkleRoot)
+	copy(genesis.MerkleRoot[:], merkleRoot)
+
+	disconnected := mineHeaders(t, 3)
+	disconnected[2].PrevBlock = disconnected[0].Hash()
+	for !disconnected[2].CheckProofOfWork() {
+		disconnected[2].Nonce++
+	}
+
+	badPoW := genesis
+	badPoW.Nonce++
+
+	negativeTarget := mineHeaders(t, 1)
+	negativeTarget[0].Bits = 0x04923456
+
+	tests := []struct {
+		name    string
+		headers []BlockHeader
+		expErr  error
+	}{
+		{name: "empty"},
+		{name: "genesis", headers: []BlockHeader{genesis}},
+		{name: "chain", headers: mineHeaders(t, 10)},
+		{name: "invalid proof of work", headers: []BlockHeader{badPoW}, expErr: ErrInvalidHeaders},
+		{name: "negative target", headers: negativeTarget, expErr: ErrInvalidHeaders},
+		{name: "not connected", headers: disconnected, expErr: ErrInvalidHeaders},
+		{name: "too many", headers: make([]BlockHeader, MaxHeadersPerMessage+1), expErr: ErrInvalidHeaders},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := ValidateHeaders(tt.headers)
+			if tt.expErr != nil {
+				assert.ErrorIs(t, err, tt.expErr)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestTestNetGenesis(t *testing.T) {
+	t.Parallel()
+
+	genesis := TestNetGenesis()
+	assert.True(t, genesis.CheckProofOfWork())
+	assert.Equal(t, "000000000933ea01ad0ee984209779baaec3ced90fa3f408719526f8d77f4943", HashString(genesis.Hash()))
+}
+
+func TestBlockHeader_Work(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name    string
+		bits    uint32
+		expWork string
+	}{
+		// the work of the genesis block as reported by getblockheader of Bitcoin Core
+		{name: "difficulty_1", bits: 0x1d00ffff, expWork: "4295032833"},
+		{name: "regtest", bits: 0x207fffff, expWork: "2"},
+		{name: "invalid", bits: 0x1d800000, expWork: "0"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tc.expWork, BlockHeader{Bits: tc.bits}.Work().String())
+		})
+	}
+}
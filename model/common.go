@@ -2,11 +2,12 @@ package model
 
 import (
 	"net"
+	"time"
 )
 
 type NetAddress struct {
 	Timestamp int64
-	Services  uint64
+	Services  ServiceFlag
 	IP        net.IP
 	Port      uint16
 }
@@ -24,6 +25,8 @@ type EmptyMessage struct {
 type MessageFromNode struct {
 	Header  MessageHeader
 	Payload any
+	// ReceivedAt is when the whole message was read from the connection, it's set for the valid messages only
+	ReceivedAt time.Time
 
 	Error *error
 }
@@ -0,0 +1,36 @@
+package model
+
+import (
+	"time"
+)
+
+type ConnectionState int
+
+const (
+	ConnectionStateConnected ConnectionState = iota
+	ConnectionStateDisconnected
+	ConnectionStateReconnecting
+	ConnectionStateGaveUp
+)
+
+func (s ConnectionState) String() string {
+	switch s {
+	case ConnectionStateConnected:
+		return "connected"
+	case ConnectionStateDisconnected:
+		return "disconnected"
+	case ConnectionStateReconnecting:
+		return "reconnecting"
+	case ConnectionStateGaveUp:
+		return "gave_up"
+	}
+	return "unknown"
+}
+
+type ConnectionEvent struct {
+	State ConnectionState
+	// Attempt is the number of the reconnect attempt, it's zero for the initial connection
+	Attempt int
+	Err     error
+	Time    time.Time
+}
@@ -8,12 +8,40 @@ const (
 )
 
 const (
-	VersionCMD = "version"
-	VerackCMD  = "verack"
+	VersionCMD     = "version"
+	VerackCMD      = "verack"
+	AddrCMD        = "addr"
+	AddrV2CMD      = "addrv2"
+	GetAddrCMD     = "getaddr"
+	SendAddrV2CMD  = "sendaddrv2"
+	SendHeadersCMD = "sendheaders"
+	FeeFilterCMD   = "feefilter"
+	WtxidRelayCMD  = "wtxidrelay"
+	InvCMD         = "inv"
+	HeadersCMD     = "headers"
+	GetHeadersCMD  = "getheaders"
+	GetBlocksCMD   = "getblocks"
+	GetDataCMD     = "getdata"
+	BlockCMD       = "block"
+	NotFoundCMD    = "notfound"
+	CmpctBlockCMD  = "cmpctblock"
+	SendCmpctCMD   = "sendcmpct"
 )
 
 const (
-	ProtocolVersion = 70015
+	ProtocolVersion = 70016
+
+	// SendHeadersVersion is the first protocol version with sendheaders message, BIP130.
+	SendHeadersVersion = 70012
+	// FeeFilterVersion is the first protocol version with feefilter message, BIP133.
+	FeeFilterVersion = 70013
+	// CompactBlocksVersion is the first protocol version with compact blocks, BIP152.
+	CompactBlocksVersion = 70014
+	// CompactBlocksEncoding is the version of compact blocks which is asked for in sendcmpct, 2 is the one with
+	// the witness data, BIP152.
+	CompactBlocksEncoding = 2
+	// WtxidRelayVersion is the first protocol version with wtxidrelay message, BIP339.
+	WtxidRelayVersion = 70016
 )
 
 const (
@@ -21,5 +49,23 @@ const (
 )
 
 const (
-	ServiceNodeNetwork = uint64(1)
+	// MaxAddrPerMessage is the maximum number of addresses allowed in a single addr or addrv2 message.
+	MaxAddrPerMessage = 1000
+	// MaxAddrV2Size is the maximum size of an address in addrv2 message.
+	MaxAddrV2Size = 512
+	// MaxHeadersPerMessage is the maximum number of block headers allowed in a single headers message.
+	MaxHeadersPerMessage = 2000
+	// MaxInvPerMessage is the maximum number of items allowed in a single inv, getdata or notfound message.
+	MaxInvPerMessage = 50000
+	// MaxBlocksPerInv is the maximum number of blocks announced in reply to getblocks.
+	MaxBlocksPerInv = 500
+	// MaxLocatorSize is the maximum number of hashes in the block locator of getheaders and getblocks.
+	MaxLocatorSize = 101
+)
+
+const (
+	// MaxPayloadSize is the maximum size of a message payload accepted from the node, the same as in Bitcoin Core.
+	MaxPayloadSize = 4_000_000
+	// MaxUserAgentSize is the maximum length of user agent in version message.
+	MaxUserAgentSize = 256
 )
@@ -7,6 +7,19 @@ import (
 var (
 	ErrContextTimeout         = errors.New("cancel by context timeout")
 	ErrConnectionClosed       = errors.New("connection to node is closed")
+	ErrReconnectGaveUp        = errors.New("gave up reconnecting to node")
+	ErrConnect                = errors.New("failed to connect")
+	ErrDisconnected           = errors.New("node closed the connection during handshake")
 	ErrInvalidMessageChecksum = errors.New("invalid message checksum")
 	ErrInvalidMagicNumber     = errors.New("invalid message magic number")
+	ErrTooManyAddresses       = errors.New("too many addresses in message")
+	ErrInvalidAddress         = errors.New("invalid network address")
+	ErrPayloadTooLarge        = errors.New("message payload is too large")
+	ErrStringTooLong          = errors.New("string is too long")
+	ErrUnknownCommand         = errors.New("unknown command")
+	ErrUnknownService         = errors.New("unknown service")
+	ErrInvalidHeaders         = errors.New("invalid block headers")
+	ErrPeerBanned             = errors.New("peer is banned")
+	ErrTooManyInventory       = errors.New("too many inventory items in message")
+	ErrLocatorTooLarge        = errors.New("block locator is too large")
 )
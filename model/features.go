@@ -0,0 +1,19 @@
+package model
+
+// PeerFeatures are the optional features of the protocol negotiated with the peer, they are valid until
+// the connection is lost.
+type PeerFeatures struct {
+	// SendHeaders is true if the peer wants new blocks announced with headers instead of inv, BIP130
+	SendHeaders bool `json:"send_headers"`
+	// FeeFilter is the fee rate in satoshis per kilobyte below which the peer doesn't want txs announced, BIP133
+	FeeFilter int64 `json:"fee_filter"`
+	// WtxidRelay is true if both sides sent wtxidrelay, so txs are announced by wtxid, BIP339
+	WtxidRelay bool `json:"wtxid_relay"`
+	// AddrV2 is true if the peer wants addresses in addrv2 messages, BIP155
+	AddrV2 bool `json:"addr_v2"`
+}
+
+// FeeFilterMessage is the fee rate in satoshis per kilobyte below which the sender doesn't want txs announced.
+type FeeFilterMessage struct {
+	FeeRate int64
+}
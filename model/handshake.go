@@ -0,0 +1,57 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+)
+
+// Handshake failure reasons, they are stable so the results and the metrics can be grouped by them.
+const (
+	FailureConnect      = "connect"
+	FailureTimeout      = "timeout"
+	FailureDisconnected = "disconnected"
+	FailureCanceled     = "canceled"
+	FailureOther        = "other"
+)
+
+// HandshakeStats describes the last handshake with the node.
+type HandshakeStats struct {
+	// VersionRTT is the time from sending our version message until the version of the node is received
+	VersionRTT time.Duration
+	// VerackRTT is the time from sending our verack message until the verack of the node is received
+	VerackRTT time.Duration
+	Total     time.Duration
+	// TimeOffset is the clock offset of the node: its timestamp minus the local time, in whole seconds
+	TimeOffset time.Duration
+	// RemoteVersion is the version message received from the node
+	RemoteVersion VersionMessage
+}
+
+// DialTiming describes the connection to the node which is used now.
+type DialTiming struct {
+	Start    time.Time
+	Duration time.Duration
+}
+
+// FailureReason classifies the error of the handshake. The broken messages are skipped during the handshake, so
+// they end up as timeout, or as disconnected if the connection is dropped for them.
+func FailureReason(err error) string {
+	var netErr net.Error
+	switch {
+	case err == nil:
+		return ""
+	case errors.Is(err, ErrConnect):
+		return FailureConnect
+	case errors.Is(err, ErrDisconnected), errors.Is(err, ErrConnectionClosed), errors.Is(err, ErrReconnectGaveUp):
+		return FailureDisconnected
+	case errors.Is(err, context.Canceled):
+		return FailureCanceled
+	case errors.Is(err, ErrContextTimeout), errors.Is(err, context.DeadlineExceeded),
+		errors.As(err, &netErr) && netErr.Timeout():
+		return FailureTimeout
+	}
+
+	return FailureOther
+}
@@ -0,0 +1,30 @@
+package model
+
+// InvType is the type of the object in inv and getdata messages.
+type InvType uint32
+
+const (
+	InvTypeError InvType = 0
+	InvTypeTx    InvType = 1
+	InvTypeBlock InvType = 2
+	// InvTypeWtx is the tx identified by wtxid, it's used when wtxidrelay is negotiated, BIP339
+	InvTypeWtx InvType = 5
+	// InvTypeWitnessBlock is the block with witness data, it's requested in getdata only, BIP144
+	InvTypeWitnessBlock InvType = InvTypeBlock | 1<<30
+)
+
+type InvVect struct {
+	Type InvType
+	Hash [32]byte
+}
+
+type InvMessage struct {
+	Inventory []InvVect
+}
+
+// TxAnnouncement is the tx which is announced to the peer, FeeRate is in satoshis per kilobyte.
+type TxAnnouncement struct {
+	TxID    [32]byte
+	WTxID   [32]byte
+	FeeRate int64
+}
@@ -0,0 +1,150 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/bits"
+	"strconv"
+	"strings"
+)
+
+// ServiceFlag is the bitfield of services the node provides, as it's sent in version and addr messages.
+type ServiceFlag uint64
+
+const (
+	// ServiceNodeNetwork means the node serves the full block chain.
+	ServiceNodeNetwork ServiceFlag = 1 << 0
+	// ServiceNodeGetUTXO means the node supports getutxo requests (BIP64).
+	ServiceNodeGetUTXO ServiceFlag = 1 << 1
+	// ServiceNodeBloom means the node supports bloom filters (BIP111).
+	ServiceNodeBloom ServiceFlag = 1 << 2
+	// ServiceNodeWitness means the node serves blocks and transactions with witness data (BIP144).
+	ServiceNodeWitness ServiceFlag = 1 << 3
+	// ServiceNodeXthin means the node supports Xtreme Thinblocks, it's never used by Bitcoin Core.
+	ServiceNodeXthin ServiceFlag = 1 << 4
+	// ServiceNodeCompactFilters means the node serves compact block filters (BIP157).
+	ServiceNodeCompactFilters ServiceFlag = 1 << 6
+	// ServiceNodeNetworkLimited means the node serves only the last 288 blocks (BIP159).
+	ServiceNodeNetworkLimited ServiceFlag = 1 << 10
+	// ServiceNodeP2PV2 means the node supports v2 encrypted transport (BIP324).
+	ServiceNodeP2PV2 ServiceFlag = 1 << 11
+)
+
+var serviceNames = []struct {
+	flag ServiceFlag
+	name string
+}{
+	{ServiceNodeNetwork, "NETWORK"},
+	{ServiceNodeGetUTXO, "GETUTXO"},
+	{ServiceNodeBloom, "BLOOM"},
+	{ServiceNodeWitness, "WITNESS"},
+	{ServiceNodeXthin, "XTHIN"},
+	{ServiceNodeCompactFilters, "COMPACT_FILTERS"},
+	{ServiceNodeNetworkLimited, "NETWORK_LIMITED"},
+	{ServiceNodeP2PV2, "P2P_V2"},
+}
+
+// Has is true if all services of other are provided.
+func (f ServiceFlag) Has(other ServiceFlag) bool {
+	return f&other == other
+}
+
+// Names returns the names of known services in the order of bits, unknown bits are given as hex, e.g. 0x1000000.
+func (f ServiceFlag) Names() []string {
+	names := make([]string, 0, bits.OnesCount64(uint64(f)))
+	for _, s := range serviceNames {
+		if f.Has(s.flag) {
+			names = append(names, s.name)
+		}
+	}
+	for rest := uint64(f &^ knownServices()); rest != 0; rest &= rest - 1 {
+		names = append(names, fmt.Sprintf("0x%x", uint64(1)<<bits.TrailingZeros64(rest)))
+	}
+
+	return names
+}
+
+func knownServices() ServiceFlag {
+	var known ServiceFlag
+	for _, s := range serviceNames {
+		known |= s.flag
+	}
+	return known
+}
+
+// String returns the names joined by |, e.g. NETWORK|WITNESS, or NONE if there are no services.
+func (f ServiceFlag) String() string {
+	if f == 0 {
+		return "NONE"
+	}
+	return strings.Join(f.Names(), "|")
+}
+
+// ParseServiceFlag parses the names separated by | or comma, names are case-insensitive and NODE_ prefix is
+// optional. Numbers, decimal or hex with 0x prefix, are accepted too, so any bits can be set.
+func ParseServiceFlag(s string) (ServiceFlag, error) {
+	var f ServiceFlag
+	for _, part := range strings.FieldsFunc(s, func(r rune) bool { return r == '|' || r == ',' }) {
+		part = strings.ToUpper(strings.TrimSpace(part))
+		if part == "" || part == "NONE" {
+			continue
+		}
+
+		if v, err := strconv.ParseUint(part, 0, 64); err == nil {
+			f |= ServiceFlag(v)
+			continue
+		}
+
+		name := strings.TrimPrefix(part, "NODE_")
+		found := false
+		for _, sn := range serviceNames {
+			if sn.name == name {
+				f |= sn.flag
+				found = true
+				break
+			}
+		}
+		if !found {
+			return 0, fmt.Errorf("%w: %q", ErrUnknownService, part)
+		}
+	}
+
+	return f, nil
+}
+
+// MarshalJSON renders the services as the list of names.
+func (f ServiceFlag) MarshalJSON() ([]byte, error) {
+	return json.Marshal(f.Names())
+}
+
+// UnmarshalJSON accepts the list of names and the raw number, which was used before the names.
+func (f *ServiceFlag) UnmarshalJSON(b []byte) error {
+	var v uint64
+	if err := json.Unmarshal(b, &v); err == nil {
+		*f = ServiceFlag(v)
+		return nil
+	}
+
+	var names []string
+	if err := json.Unmarshal(b, &names); err != nil {
+		return fmt.Errorf("%w: %s", ErrUnknownService, b)
+	}
+	parsed, err := ParseServiceFlag(strings.Join(names, ","))
+	if err != nil {
+		return err
+	}
+	*f = parsed
+
+	return nil
+}
+
+// Set implements flag.Value, so the services can be given by names in command line.
+func (f *ServiceFlag) Set(s string) error {
+	parsed, err := ParseServiceFlag(s)
+	if err != nil {
+		return err
+	}
+	*f = parsed
+
+	return nil
+}
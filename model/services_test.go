@@ -0,0 +1,181 @@
+package model
+
+import (
+	"encoding/json"
+	"flag"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServiceFlag_String(t *testing.T) {
+	testCases := []struct {
+		name     string
+		services ServiceFlag
+		exp      string
+	}{
+		{
+			name:     "none",
+			services: 0,
+			exp:      "NONE",
+		},
+		{
+			name:     "single",
+			services: ServiceNodeNetwork,
+			exp:      "NETWORK",
+		},
+		{
+			name:     "all_known",
+			services: 0xc5f,
+			exp:      "NETWORK|GETUTXO|BLOOM|WITNESS|XTHIN|COMPACT_FILTERS|NETWORK_LIMITED|P2P_V2",
+		},
+		{
+			name:     "unknown_bits",
+			services: ServiceNodeWitness | 1<<5 | 1<<24,
+			exp:      "WITNESS|0x20|0x1000000",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tc.exp, tc.services.String())
+		})
+	}
+}
+
+func TestServiceFlag_Has(t *testing.T) {
+	services := ServiceNodeNetwork | ServiceNodeWitness
+
+	assert.True(t, services.Has(ServiceNodeNetwork))
+	assert.True(t, services.Has(ServiceNodeNetwork|ServiceNodeWitness))
+	assert.False(t, services.Has(ServiceNodeNetwork|ServiceNodeBloom))
+	assert.True(t, services.Has(0))
+}
+
+func TestParseServiceFlag(t *testing.T) {
+	testCases := []struct {
+		name   string
+		input  string
+		exp    ServiceFlag
+		hasErr bool
+	}{
+		{
+			name:  "success/empty",
+			input: "",
+			exp:   0,
+		},
+		{
+			name:  "success/none",
+			input: "NONE",
+			exp:   0,
+		},
+		{
+			name:  "success/names",
+			input: "NETWORK|WITNESS|NETWORK_LIMITED",
+			exp:   0x409,
+		},
+		{
+			name:  "success/comma_lower_case_and_prefix",
+			input: "node_network, witness ,p2p_v2",
+			exp:   ServiceNodeNetwork | ServiceNodeWitness | ServiceNodeP2PV2,
+		},
+		{
+			name:  "success/numbers",
+			input: "0x409,64",
+			exp:   0x409 | ServiceNodeCompactFilters,
+		},
+		{
+			name:  "success/round_trip_of_unknown_bits",
+			input: (ServiceNodeWitness | 1<<24).String(),
+			exp:   ServiceNodeWitness | 1<<24,
+		},
+		{
+			name:   "err/unknown_name",
+			input:  "NETWORK|FULL",
+			hasErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			services, err := ParseServiceFlag(tc.input)
+			if tc.hasErr {
+				assert.ErrorIs(t, err, ErrUnknownService)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.exp, services)
+		})
+	}
+}
+
+func TestServiceFlag_JSON(t *testing.T) {
+	raw, err := json.Marshal(VersionMessage{Services: ServiceNodeNetwork | ServiceNodeWitness})
+	require.NoError(t, err)
+	assert.Contains(t, string(raw), `"Services":["NETWORK","WITNESS"]`)
+
+	raw, err = json.Marshal(ServiceFlag(0))
+	require.NoError(t, err)
+	assert.Equal(t, `[]`, string(raw))
+
+	testCases := []struct {
+		name   string
+		input  string
+		exp    ServiceFlag
+		hasErr bool
+	}{
+		{
+			name:  "success/names",
+			input: `["NETWORK","WITNESS"]`,
+			exp:   ServiceNodeNetwork | ServiceNodeWitness,
+		},
+		{
+			name:  "success/number",
+			input: `1033`,
+			exp:   0x409,
+		},
+		{
+			name:   "err/unknown_name",
+			input:  `["FULL"]`,
+			hasErr: true,
+		},
+		{
+			name:   "err/invalid_type",
+			input:  `"NETWORK"`,
+			hasErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			var services ServiceFlag
+			err := json.Unmarshal([]byte(tc.input), &services)
+			if tc.hasErr {
+				assert.ErrorIs(t, err, ErrUnknownService)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.exp, services)
+		})
+	}
+}
+
+func TestServiceFlag_Set(t *testing.T) {
+	services := ServiceNodeNetwork
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	fs.Var(&services, "services", "")
+
+	require.NoError(t, fs.Parse([]string{"-services=witness|compact_filters"}))
+	assert.Equal(t, ServiceNodeWitness|ServiceNodeCompactFilters, services)
+
+	assert.Error(t, fs.Parse([]string{"-services=unknown"}))
+}
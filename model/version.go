@@ -2,7 +2,7 @@ package model
 
 type VersionMessage struct {
 	Version     int32
-	Services    uint64
+	Services    ServiceFlag
 	Timestamp   int64
 	AddrRecv    NetAddress
 	AddrFrom    NetAddress
@@ -0,0 +1,59 @@
+package nodetest
+
+import (
+	"embed"
+	"encoding/hex"
+	"path"
+	"sort"
+	"strings"
+)
+
+// captured keeps synthetic messages, one hex encoded frame with header per file. They aren't recorded from real
+// nodes, the payloads are made by hand in the format and the handshake order Bitcoin Core 26 uses with a new outbound
+// peer, so addresses and hashes in them are made up.
+//
+//go:embed captured/*.hex
+var captured embed.FS
+
+// HandshakeSequence is the order the captured messages are sent by Bitcoin Core during the handshake.
+var HandshakeSequence = []string{
+	"version", "wtxidrelay", "sendaddrv2", "verack", "sendcmpct", "ping", "feefilter", "addrv2",
+}
+
+// CapturedFrame returns captured message with the command, including the header.
+func CapturedFrame(command string) ([]byte, bool) {
+	raw, err := captured.ReadFile(path.Join("captured", command+".hex"))
+	if err != nil {
+		return nil, false
+	}
+
+	frame, err := hex.DecodeString(strings.TrimSpace(string(raw)))
+	if err != nil {
+		return nil, false
+	}
+
+	return frame, true
+}
+
+// CapturedPayload returns payload of captured message with the command.
+func CapturedPayload(command string) ([]byte, bool) {
+	frame, ok := CapturedFrame(command)
+	if !ok {
+		return nil, false
+	}
+
+	return frame[headerSize:], true
+}
+
+// CapturedCommands returns commands of all captured messages.
+func CapturedCommands() []string {
+	entries, _ := captured.ReadDir("captured")
+
+	commands := make([]string, 0, len(entries))
+	for _, e := range entries {
+		commands = append(commands, strings.TrimSuffix(e.Name(), ".hex"))
+	}
+	sort.Strings(commands)
+
+	return commands
+}
@@ -0,0 +1,242 @@
+package nodetest
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/senseyman/bitcoin-handshake/model"
+	"github.com/senseyman/bitcoin-handshake/service"
+	"github.com/senseyman/bitcoin-handshake/utils"
+)
+
+const (
+	headerSize = model.MagicSize + model.CommandSize + model.LengthSize + model.ChecksumSize
+
+	defaultExpectTimeout = 5 * time.Second
+	// maxPayloadSize protects the peer from allocating huge buffers if the client sends garbage
+	maxPayloadSize = 32 * 1024 * 1024
+)
+
+var (
+	ErrExpectTimeout = errors.New("timeout waiting for message from client")
+	ErrPeerClosed    = errors.New("connection is closed")
+)
+
+// Frame is a message received from the client.
+type Frame struct {
+	Header  model.MessageHeader
+	Payload []byte
+	Time    time.Time
+}
+
+// Validate checks the frame the same way a real node does before processing it.
+func (f Frame) Validate(magic uint32) error {
+	if f.Header.Magic != magic {
+		return fmt.Errorf("%s: %w", f.Header.Command, model.ErrInvalidMagicNumber)
+	}
+	if !bytes.Equal(f.Header.Checksum[:], utils.DoubleHashB(f.Payload)[:model.ChecksumSize]) {
+		return fmt.Errorf("%s: %w", f.Header.Command, model.ErrInvalidMessageChecksum)
+	}
+
+	return nil
+}
+
+// Peer is a fake Bitcoin node which speaks the real wire format and follows a script of steps.
+// All frames sent by the client are recorded and can be checked after the script is done.
+type Peer struct {
+	steps         []Step
+	expectTimeout time.Duration
+	magic         uint32
+
+	encoder *service.EncodeService
+	rnd     *rand.Rand
+
+	conn    net.Conn
+	inbox   chan Frame
+	readErr chan error
+
+	mu       sync.Mutex
+	received []Frame
+
+	done chan struct{}
+	err  error
+}
+
+func NewPeer(steps ...Step) *Peer {
+	return &Peer{
+		steps:         steps,
+		expectTimeout: defaultExpectTimeout,
+		magic:         model.TestNetMagic,
+		encoder:       service.NewEncodeService(),
+		rnd:           rand.New(rand.NewSource(1)), //nolint:gosec // deterministic garbage is intended
+		inbox:         make(chan Frame, 100),
+		readErr:       make(chan error, 1),
+		done:          make(chan struct{}),
+	}
+}
+
+// SetExpectTimeout changes how long Expect steps wait for a message from the client.
+func (p *Peer) SetExpectTimeout(timeout time.Duration) *Peer {
+	p.expectTimeout = timeout
+	return p
+}
+
+// Pipe starts the script over in-memory connection and returns the client side of it.
+func (p *Peer) Pipe() net.Conn {
+	clientConn, peerConn := net.Pipe()
+	p.start(peerConn)
+
+	return clientConn
+}
+
+// ListenTCP starts listening on loopback interface and runs the script for the first accepted connection.
+func (p *Peer) ListenTCP() (string, int, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", 0, err
+	}
+
+	go func() {
+		defer l.Close()
+
+		conn, err := l.Accept()
+		if err != nil {
+			p.finish(err)
+			return
+		}
+		p.start(conn)
+	}()
+
+	host, port, _ := net.SplitHostPort(l.Addr().String())
+	portNum, _ := strconv.Atoi(port)
+
+	return host, portNum, nil
+}
+
+// Wait waits for the script to finish and returns the error of the failed step if any.
+func (p *Peer) Wait(ctx context.Context) error {
+	select {
+	case <-p.done:
+		return p.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Received returns all frames received from the client so far.
+func (p *Peer) Received() []Frame {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return append([]Frame(nil), p.received...)
+}
+
+// Commands returns commands of all frames received from the client so far.
+func (p *Peer) Commands() []string {
+	frames := p.Received()
+	commands := make([]string, 0, len(frames))
+	for _, f := range frames {
+		commands = append(commands, f.Header.Command)
+	}
+
+	return commands
+}
+
+func (p *Peer) start(conn net.Conn) {
+	p.conn = conn
+
+	go p.readLoop()
+	go func() {
+		for i, step := range p.steps {
+			if err := step(p); err != nil {
+				p.finish(fmt.Errorf("step %d: %w", i, err))
+				return
+			}
+		}
+		p.finish(nil)
+	}()
+}
+
+func (p *Peer) finish(err error) {
+	p.err = err
+	close(p.done)
+}
+
+// readLoop reads frames all the time, so the client is never blocked on writing to the pipe.
+func (p *Peer) readLoop() {
+	for {
+		frame, err := p.readFrame()
+		if err != nil {
+			p.readErr <- err
+			close(p.inbox)
+			return
+		}
+
+		p.mu.Lock()
+		p.received = append(p.received, frame)
+		p.mu.Unlock()
+
+		p.inbox <- frame
+	}
+}
+
+func (p *Peer) readFrame() (Frame, error) {
+	var hdrBytes [headerSize]byte
+	if _, err := io.ReadFull(p.conn, hdrBytes[:]); err != nil {
+		return Frame{}, err
+	}
+
+	hdr := model.MessageHeader{
+		Magic:   binary.LittleEndian.Uint32(hdrBytes[:4]),
+		Command: string(bytes.TrimRight(hdrBytes[4:16], "\x00")),
+		Length:  binary.LittleEndian.Uint32(hdrBytes[16:20]),
+	}
+	copy(hdr.Checksum[:], hdrBytes[20:24])
+
+	if hdr.Length > maxPayloadSize {
+		return Frame{}, fmt.Errorf("payload of %s is too large: %d", hdr.Command, hdr.Length)
+	}
+
+	payload := make([]byte, hdr.Length)
+	if _, err := io.ReadFull(p.conn, payload); err != nil {
+		return Frame{}, err
+	}
+
+	return Frame{Header: hdr, Payload: payload, Time: time.Now()}, nil
+}
+
+// expect waits for the next frame from the client.
+func (p *Peer) expect() (Frame, error) {
+	select {
+	case frame, ok := <-p.inbox:
+		if !ok {
+			return Frame{}, fmt.Errorf("%w: %v", ErrPeerClosed, <-p.readErr)
+		}
+		return frame, nil
+	case <-time.After(p.expectTimeout):
+		return Frame{}, ErrExpectTimeout
+	}
+}
+
+// EncodeFrame returns the message with header as it's sent over the wire.
+func EncodeFrame(magic uint32, command string, payload []byte) []byte {
+	var cmd [model.CommandSize]byte
+	copy(cmd[:], command)
+
+	frame := make([]byte, 0, headerSize+len(payload))
+	frame = binary.LittleEndian.AppendUint32(frame, magic)
+	frame = append(frame, cmd[:]...)
+	frame = binary.LittleEndian.AppendUint32(frame, uint32(len(payload)))
+	frame = append(frame, utils.DoubleHashB(payload)[:model.ChecksumSize]...)
+
+	return append(frame, payload...)
+}
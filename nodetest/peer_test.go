@@ -0,0 +1,104 @@
+package nodetest
+
+import (
+	"context"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/senseyman/bitcoin-handshake/model"
+)
+
+func TestPeer(t *testing.T) {
+	verack := EncodeFrame(model.TestNetMagic, model.VerackCMD, nil)
+	badChecksum := append([]byte(nil), verack...)
+	badChecksum[20] ^= 0xff
+
+	testCases := []struct {
+		name        string
+		send        [][]byte
+		steps       []Step
+		expCommands []string
+		hasErr      bool
+		expErr      error
+	}{
+		{
+			name:        "success",
+			send:        [][]byte{EncodeFrame(model.TestNetMagic, "ping", make([]byte, 8)), verack},
+			steps:       []Step{SkipUntil(model.VerackCMD), SendVerack()},
+			expCommands: []string{"ping", model.VerackCMD},
+		},
+		{
+			name:        "err/unexpected_command",
+			send:        [][]byte{EncodeFrame(model.TestNetMagic, "ping", make([]byte, 8))},
+			steps:       []Step{Expect(model.VerackCMD)},
+			expCommands: []string{"ping"},
+			hasErr:      true,
+		},
+		{
+			name:        "err/invalid_checksum",
+			send:        [][]byte{badChecksum},
+			steps:       []Step{Expect(model.VerackCMD)},
+			expCommands: []string{model.VerackCMD},
+			hasErr:      true,
+			expErr:      model.ErrInvalidMessageChecksum,
+		},
+		{
+			name:        "err/invalid_magic",
+			send:        [][]byte{EncodeFrame(0xD9B4BEF9, model.VerackCMD, nil)},
+			steps:       []Step{Expect(model.VerackCMD)},
+			expCommands: []string{model.VerackCMD},
+			hasErr:      true,
+			expErr:      model.ErrInvalidMagicNumber,
+		},
+		{
+			name:        "err/timeout",
+			steps:       []Step{Expect(model.VerackCMD)},
+			expCommands: []string{},
+			hasErr:      true,
+			expErr:      ErrExpectTimeout,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+
+			peer := NewPeer(tc.steps...).SetExpectTimeout(100 * time.Millisecond)
+			host, port, err := peer.ListenTCP()
+			require.NoError(t, err)
+
+			conn, err := net.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+			require.NoError(t, err)
+			defer conn.Close()
+
+			for _, b := range tc.send {
+				_, err := conn.Write(b)
+				require.NoError(t, err)
+			}
+
+			err = peer.Wait(ctx)
+			if tc.hasErr {
+				assert.Error(t, err)
+				if tc.expErr != nil {
+					assert.ErrorIs(t, err, tc.expErr)
+				}
+			} else {
+				require.NoError(t, err)
+				reply := make([]byte, len(verack))
+				_, err = io.ReadFull(conn, reply)
+				require.NoError(t, err)
+				assert.Equal(t, verack, reply)
+			}
+			assert.Equal(t, tc.expCommands, peer.Commands())
+		})
+	}
+}
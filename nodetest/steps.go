@@ -0,0 +1,169 @@
+package nodetest
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/senseyman/bitcoin-handshake/model"
+)
+
+// Step is a single action of the fake peer script.
+type Step func(p *Peer) error
+
+// DefaultVersion returns version message similar to the one sent by Bitcoin Core on testnet.
+func DefaultVersion() model.VersionMessage {
+	return model.VersionMessage{
+		Version:   70016,
+		Services:  0x409, // NETWORK, WITNESS, NETWORK_LIMITED
+		Timestamp: time.Now().Unix(),
+		AddrRecv: model.NetAddress{
+			IP:   net.ParseIP("127.0.0.1"),
+			Port: 0,
+		},
+		AddrFrom: model.NetAddress{
+			Services: 0x409,
+			IP:       net.IPv6zero,
+		},
+		Nonce:       0x1122334455667788,
+		UserAgent:   "/Satoshi:27.0.0/",
+		StartHeight: 2_812_345,
+		Relay:       true,
+	}
+}
+
+// Handshake is the script of a well-behaved node: it answers our version and verack. Like a real node, it
+// accepts the features which are negotiated between version and verack.
+func Handshake() []Step {
+	return []Step{
+		Expect(model.VersionCMD),
+		SendVersion(DefaultVersion()),
+		SendVerack(),
+		SkipUntil(model.VerackCMD),
+	}
+}
+
+// Expect waits for the next message from the client and fails if it's not the expected command
+// or if a real node would reject it.
+func Expect(command string) Step {
+	return func(p *Peer) error {
+		frame, err := p.expect()
+		if err != nil {
+			return fmt.Errorf("expecting %s: %w", command, err)
+		}
+		if frame.Header.Command != command {
+			return fmt.Errorf("expected %s, got %s", command, frame.Header.Command)
+		}
+		return frame.Validate(p.magic)
+	}
+}
+
+// SkipUntil waits for the message with given command, ignoring all others.
+func SkipUntil(command string) Step {
+	return func(p *Peer) error {
+		for {
+			frame, err := p.expect()
+			if err != nil {
+				return fmt.Errorf("waiting for %s: %w", command, err)
+			}
+			if frame.Header.Command == command {
+				return nil
+			}
+		}
+	}
+}
+
+// ExpectNothing fails if the client sends any message during the duration.
+func ExpectNothing(d time.Duration) Step {
+	return func(p *Peer) error {
+		select {
+		case frame, ok := <-p.inbox:
+			if ok {
+				return fmt.Errorf("expected no messages, got %s", frame.Header.Command)
+			}
+			return nil
+		case <-time.After(d):
+			return nil
+		}
+	}
+}
+
+func SendVersion(msg model.VersionMessage) Step {
+	return func(p *Peer) error {
+		var payload bytes.Buffer
+		if err := p.encoder.EncodeVersionMessage(&payload, msg); err != nil {
+			return err
+		}
+		return p.write(EncodeFrame(p.magic, model.VersionCMD, payload.Bytes()))
+	}
+}
+
+func SendVerack() Step {
+	return SendMessage(model.VerackCMD, nil)
+}
+
+// SendMessage sends the message with valid header.
+func SendMessage(command string, payload []byte) Step {
+	return func(p *Peer) error {
+		return p.write(EncodeFrame(p.magic, command, payload))
+	}
+}
+
+// SendRaw sends bytes as is, e.g. a message with broken header.
+func SendRaw(b []byte) Step {
+	return func(p *Peer) error {
+		return p.write(b)
+	}
+}
+
+// SendGarbage sends n pseudo-random bytes. The bytes are the same for every run.
+func SendGarbage(n int) Step {
+	return func(p *Peer) error {
+		b := make([]byte, n)
+		p.rnd.Read(b)
+		return p.write(b)
+	}
+}
+
+// Delay pauses the script.
+func Delay(d time.Duration) Step {
+	return func(p *Peer) error {
+		time.Sleep(d)
+		return nil
+	}
+}
+
+// CloseMidMessage sends the header and a half of the payload, then closes the connection.
+func CloseMidMessage(command string, payload []byte) Step {
+	return func(p *Peer) error {
+		frame := EncodeFrame(p.magic, command, payload)
+		if err := p.write(frame[:headerSize+len(payload)/2]); err != nil {
+			return err
+		}
+		return p.conn.Close()
+	}
+}
+
+// Close closes the connection.
+func Close() Step {
+	return func(p *Peer) error {
+		return p.conn.Close()
+	}
+}
+
+// Do runs arbitrary function, e.g. to signal the test that some point of the script is reached.
+func Do(fn func()) Step {
+	return func(p *Peer) error {
+		fn()
+		return nil
+	}
+}
+
+func (p *Peer) write(b []byte) error {
+	if err := p.conn.SetWriteDeadline(time.Now().Add(p.expectTimeout)); err != nil {
+		return err
+	}
+	_, err := p.conn.Write(b)
+	return err
+}
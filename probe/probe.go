@@ -0,0 +1,217 @@
+package probe
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/senseyman/bitcoin-handshake/client"
+	"github.com/senseyman/bitcoin-handshake/core"
+	"github.com/senseyman/bitcoin-handshake/metrics"
+	"github.com/senseyman/bitcoin-handshake/model"
+	"github.com/senseyman/bitcoin-handshake/service"
+	"github.com/senseyman/bitcoin-handshake/tracing"
+)
+
+// Failure reasons, they are stable so the results can be grouped by them.
+const (
+	ReasonConnect      = model.FailureConnect
+	ReasonTimeout      = model.FailureTimeout
+	ReasonDisconnected = model.FailureDisconnected
+	ReasonCanceled     = model.FailureCanceled
+	ReasonOther        = model.FailureOther
+)
+
+var (
+	ErrConnect      = model.ErrConnect
+	ErrDisconnected = model.ErrDisconnected
+)
+
+// Result is the outcome of a single handshake.
+type Result struct {
+	Host string
+	Port int
+	// Connect is the time of establishing the connection, e.g. TCP connect
+	Connect time.Duration
+	model.HandshakeStats
+}
+
+// Prober makes single handshakes with nodes, without reconnecting. It's safe for concurrent use.
+type Prober struct {
+	dial     func(host string, port int) (client.Connection, error)
+	metrics  *metrics.Metrics
+	tracer   *tracing.Tracer
+	timeData core.TimeData
+	recorder client.Recorder
+	linger   time.Duration
+}
+
+type Option func(p *Prober)
+
+// WithMetrics makes the handshakes and their traffic counted in metrics.
+func WithMetrics(m *metrics.Metrics) Option {
+	return func(p *Prober) {
+		p.metrics = m
+	}
+}
+
+// WithTracer makes every handshake traced, the spans are children of the span in the context of Handshake.
+func WithTracer(tracer *tracing.Tracer) Option {
+	return func(p *Prober) {
+		p.tracer = tracer
+	}
+}
+
+// WithTimeData reports the clock offsets of the nodes at successful handshakes to timeData.
+func WithTimeData(timeData core.TimeData) Option {
+	return func(p *Prober) {
+		p.timeData = timeData
+	}
+}
+
+// WithRecorder passes every message of the handshakes to the recorder.
+func WithRecorder(recorder client.Recorder) Option {
+	return func(p *Prober) {
+		p.recorder = recorder
+	}
+}
+
+// WithLinger keeps the connection open for d after the successful handshake, so the messages which the node
+// sends right after the handshake are received too.
+func WithLinger(d time.Duration) Option {
+	return func(p *Prober) {
+		p.linger = d
+	}
+}
+
+func New(dial func(host string, port int) (client.Connection, error), opts ...Option) *Prober {
+	p := &Prober{dial: dial}
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	return p
+}
+
+// Handshake connects to the node, makes the handshake and closes the connection. The context limits the whole
+// handshake, while the dial function is responsible for the connect timeout.
+func (p *Prober) Handshake(ctx context.Context, host string, port int) (Result, error) {
+	result := Result{Host: host, Port: port}
+
+	parent := ctx
+	ctx, cancel := context.WithCancel(ctx)
+	// the receiving goroutine closes the connection when the context is done
+	defer cancel()
+
+	var (
+		mu         sync.Mutex
+		disconnect error
+	)
+	onEvent := func(event model.ConnectionEvent) {
+		if event.State != model.ConnectionStateDisconnected {
+			return
+		}
+		mu.Lock()
+		disconnect = event.Err
+		mu.Unlock()
+		// waiting for the handshake makes no sense after the connection is lost
+		cancel()
+	}
+
+	var (
+		dialed  atomic.Bool
+		dialErr error
+	)
+	connectionFn := func(host string, port int) (client.Connection, error) {
+		// the result is about one connection, so the client must not reconnect
+		if dialed.Swap(true) {
+			return nil, ErrDisconnected
+		}
+
+		start := time.Now()
+		conn, err := p.dial(host, port)
+		result.Connect = time.Since(start)
+		dialErr = err
+		return conn, err
+	}
+
+	clientOpts := []client.Option{
+		client.WithReconnectPolicy(client.ReconnectPolicy{MaxAttempts: 1}),
+		client.WithEventHandler(onEvent),
+	}
+	var coreOpts []core.Option
+	if p.metrics != nil {
+		clientOpts = append(clientOpts, client.WithMetrics(p.metrics))
+		coreOpts = append(coreOpts, core.WithMetrics(attemptMetrics{p.metrics}))
+	}
+	if p.tracer != nil {
+		coreOpts = append(coreOpts, core.WithTracer(p.tracer))
+	}
+	if p.timeData != nil {
+		coreOpts = append(coreOpts, core.WithTimeData(p.timeData))
+	}
+	if p.recorder != nil {
+		clientOpts = append(clientOpts, client.WithRecorder(p.recorder))
+	}
+
+	cli, err := client.NewBitcoinClient(host, port, connectionFn, clientOpts...)
+	if err != nil {
+		if p.metrics != nil {
+			p.metrics.HandshakeStarted()
+		}
+		// the client wraps the error into its own message, so the original one is reported
+		return result, p.failed(fmt.Errorf("%w: %w", ErrConnect, dialErr))
+	}
+
+	c := core.New(service.NewDecodeService(), service.NewEncodeService(), service.NewMessageGenerator(), cli, coreOpts...)
+	c.ReceiveMessages(ctx)
+	if _, err := c.Handshake(ctx); err != nil {
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case disconnect != nil:
+			return result, p.failed(fmt.Errorf("%w: %w", ErrDisconnected, disconnect))
+		case errors.Is(parent.Err(), context.Canceled):
+			return result, p.failed(parent.Err())
+		}
+		return result, p.failed(err)
+	}
+	result.HandshakeStats = c.LastHandshake()
+
+	if p.linger > 0 {
+		// the handshake is done, so neither the end of the context nor the disconnect is an error here
+		timer := time.NewTimer(p.linger)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+		}
+	}
+
+	return result, nil
+}
+
+// failed counts the failure by the reason which is reported for the error and returns the error.
+func (p *Prober) failed(err error) error {
+	if p.metrics != nil {
+		p.metrics.HandshakeFailed(err)
+	}
+
+	return err
+}
+
+// attemptMetrics lets core count the attempts and the successes of the handshakes, while the failures are counted
+// by the prober, as core sees e.g. the lost connection as the timeout.
+type attemptMetrics struct {
+	*metrics.Metrics
+}
+
+func (attemptMetrics) HandshakeFailed(error) {}
+
+// Reason classifies the error returned by Handshake, the metrics count the failures by the same reasons.
+func Reason(err error) string {
+	return model.FailureReason(err)
+}
@@ -0,0 +1,174 @@
+package probe
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/senseyman/bitcoin-handshake/client"
+	"github.com/senseyman/bitcoin-handshake/metrics"
+	"github.com/senseyman/bitcoin-handshake/model"
+	"github.com/senseyman/bitcoin-handshake/nodetest"
+)
+
+func TestProber_Handshake(t *testing.T) {
+	testCases := []struct {
+		name      string
+		steps     []nodetest.Step
+		dialErr   error
+		expReason string
+	}{
+		{
+			name:  "success",
+			steps: nodetest.Handshake(),
+		},
+		{
+			name:      "err/connect",
+			dialErr:   errors.New("connection refused"),
+			expReason: ReasonConnect,
+		},
+		{
+			name: "err/closed",
+			steps: []nodetest.Step{
+				nodetest.Expect(model.VersionCMD),
+				nodetest.Close(),
+			},
+			expReason: ReasonDisconnected,
+		},
+		{
+			name: "err/no_verack",
+			steps: []nodetest.Step{
+				nodetest.Expect(model.VersionCMD),
+				nodetest.SendVersion(nodetest.DefaultVersion()),
+				nodetest.Expect(model.VerackCMD),
+				nodetest.ExpectNothing(time.Second),
+			},
+			expReason: ReasonTimeout,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+			defer cancel()
+
+			peer := nodetest.NewPeer(tc.steps...)
+			host, port, err := peer.ListenTCP()
+			require.NoError(t, err)
+
+			prober := New(func(host string, port int) (client.Connection, error) {
+				if tc.dialErr != nil {
+					return nil, tc.dialErr
+				}
+				return net.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+			})
+
+			result, err := prober.Handshake(ctx, host, port)
+			assert.Equal(t, tc.expReason, Reason(err))
+			if tc.expReason != "" {
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, host, result.Host)
+			assert.Equal(t, port, result.Port)
+			assert.Positive(t, result.Connect)
+			assert.Positive(t, result.VersionRTT)
+			assert.Positive(t, result.VerackRTT)
+			assert.Equal(t, nodetest.DefaultVersion().UserAgent, result.RemoteVersion.UserAgent)
+		})
+	}
+}
+
+func TestReason(t *testing.T) {
+	testCases := []struct {
+		name string
+		err  error
+		exp  string
+	}{
+		{
+			name: "success",
+		},
+		{
+			name: "connect",
+			err:  fmt.Errorf("%w: %w", ErrConnect, errors.New("refused")),
+			exp:  ReasonConnect,
+		},
+		{
+			name: "connect_timeout",
+			err:  fmt.Errorf("%w: %w", ErrConnect, context.DeadlineExceeded),
+			exp:  ReasonConnect,
+		},
+		{
+			name: "timeout",
+			err:  model.ErrContextTimeout,
+			exp:  ReasonTimeout,
+		},
+		{
+			name: "connection_closed",
+			err:  fmt.Errorf("write: %w", model.ErrConnectionClosed),
+			exp:  ReasonDisconnected,
+		},
+		{
+			name: "canceled",
+			err:  context.Canceled,
+			exp:  ReasonCanceled,
+		},
+		{
+			name: "other",
+			err:  errors.New("something"),
+			exp:  ReasonOther,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tc.exp, Reason(tc.err))
+		})
+	}
+}
+
+func TestProber_Handshake_Metrics(t *testing.T) {
+	m := metrics.New()
+	closed := nodetest.NewPeer(nodetest.Expect(model.VersionCMD), nodetest.Close())
+	host, port, err := closed.ListenTCP()
+	require.NoError(t, err)
+
+	prober := New(func(host string, port int) (client.Connection, error) {
+		if port == 0 {
+			return nil, errors.New("connection refused")
+		}
+		return net.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+	}, WithMetrics(m))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_, err = prober.Handshake(ctx, host, 0)
+	require.Equal(t, ReasonConnect, Reason(err))
+	_, err = prober.Handshake(ctx, host, port)
+	require.Equal(t, ReasonDisconnected, Reason(err))
+
+	var b bytes.Buffer
+	require.NoError(t, m.WriteText(&b))
+	text := b.String()
+	for _, line := range []string{
+		"bitcoin_handshake_attempts_total 2\n",
+		`bitcoin_handshake_failures_total{reason="connect"} 1` + "\n",
+		`bitcoin_handshake_failures_total{reason="disconnected"} 1` + "\n",
+	} {
+		assert.Contains(t, text, line)
+	}
+	assert.NotContains(t, text, `reason="timeout"`)
+}
@@ -0,0 +1,281 @@
+package propagation
+
+import (
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/senseyman/bitcoin-handshake/model"
+	"github.com/senseyman/bitcoin-handshake/utils"
+)
+
+const (
+	// DefaultMaxItems is the number of the latest blocks and, separately, txs the announcements are kept for.
+	DefaultMaxItems = 10000
+	// MinRatedItems is the number of the items announced by the peer and some other peer, since which the peer
+	// is rated as early or late.
+	MinRatedItems = 10
+	// earlyRank and lateRank are the bounds of the average rank of the peer among the announcers of the same
+	// items, 0 is always the first and 1 is always the last
+	earlyRank = 0.25
+	lateRank  = 0.75
+)
+
+type Kind string
+
+const (
+	KindBlock Kind = "block"
+	KindTx    Kind = "tx"
+)
+
+const (
+	RatingEarly = "early"
+	RatingLate  = "late"
+)
+
+// kindOf returns the kind of the announced item, the other inventory types aren't announcements.
+func kindOf(invType model.InvType) (Kind, bool) {
+	switch invType {
+	case model.InvTypeBlock:
+		return KindBlock, true
+	case model.InvTypeTx, model.InvTypeWtx:
+		return KindTx, true
+	}
+
+	return "", false
+}
+
+type item struct {
+	kind Kind
+	// seen is the first time every peer has announced the item
+	seen map[string]time.Time
+}
+
+type Option func(r *Recorder)
+
+// WithMaxItems sets the number of the latest blocks and, separately, txs the announcements are kept for.
+func WithMaxItems(n int) Option {
+	return func(r *Recorder) {
+		r.maxItems = n
+	}
+}
+
+// Recorder keeps the first time every peer has announced the blocks and txs, the delay of the peer is how much
+// later it has announced the item than the first peer. The txs are identified by the hash they are announced with,
+// so the txs announced by txid and by wtxid aren't matched. It's safe for concurrent use.
+type Recorder struct {
+	maxItems int
+
+	mu    sync.Mutex
+	items map[model.InvVect]*item
+	// order is the items of every kind in the order they were first announced, the oldest is evicted first
+	order map[Kind][]model.InvVect
+
+	now func() time.Time
+}
+
+func New(opts ...Option) *Recorder {
+	r := &Recorder{
+		maxItems: DefaultMaxItems,
+		items:    make(map[model.InvVect]*item),
+		order:    make(map[Kind][]model.InvVect),
+		now:      time.Now,
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	return r
+}
+
+// Announced records that the peer has announced the item at the time, the repeated announcements are ignored.
+func (r *Recorder) Announced(peer string, inv model.InvVect, at time.Time) {
+	kind, ok := kindOf(inv.Type)
+	if !ok {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	it, ok := r.items[inv]
+	if !ok {
+		it = &item{kind: kind, seen: make(map[string]time.Time)}
+		r.items[inv] = it
+		r.order[kind] = append(r.order[kind], inv)
+		if len(r.order[kind]) > r.maxItems {
+			delete(r.items, r.order[kind][0])
+			r.order[kind] = r.order[kind][1:]
+		}
+	}
+	// the messages of different peers are processed concurrently, so the earlier time may come later
+	if first, ok := it.seen[peer]; !ok || at.Before(first) {
+		it.seen[peer] = at
+	}
+}
+
+// Distribution is the delays of the announcements after the first one of the same item, the first
+// announcements aren't counted.
+type Distribution struct {
+	// Items is the number of the kept items, Announcements is the number of the delays
+	Items         int     `json:"items"`
+	Announcements int     `json:"announcements"`
+	AvgMs         float64 `json:"avg_ms"`
+	P50Ms         float64 `json:"p50_ms"`
+	P90Ms         float64 `json:"p90_ms"`
+	P99Ms         float64 `json:"p99_ms"`
+	MaxMs         float64 `json:"max_ms"`
+}
+
+// PeerStats is how early the peer announces the items of one kind.
+type PeerStats struct {
+	Announced int `json:"announced"`
+	// First is the number of the items the peer has announced before any other peer
+	First         int     `json:"first"`
+	MedianDelayMs float64 `json:"median_delay_ms"`
+	P90DelayMs    float64 `json:"p90_delay_ms"`
+	// AvgRank is the average position of the peer among the announcers of the same items, from 0 when it's
+	// always the first to 1 when it's always the last. Only the items announced by several peers are ranked
+	AvgRank     float64 `json:"avg_rank"`
+	RankedItems int     `json:"ranked_items"`
+	// Rating is early or late when the peer is consistently among the first or the last announcers
+	Rating string `json:"rating,omitempty"`
+}
+
+type PeerReport struct {
+	Peer   string    `json:"peer"`
+	Blocks PeerStats `json:"blocks"`
+	Txs    PeerStats `json:"txs"`
+}
+
+// Report is the propagation of the kept items across the peers, the peers are sorted by name.
+type Report struct {
+	Time   time.Time    `json:"time"`
+	Blocks Distribution `json:"blocks"`
+	Txs    Distribution `json:"txs"`
+	Peers  []PeerReport `json:"peers"`
+}
+
+// peerSamples are the delays and the ranks of the peer for the items of one kind.
+type peerSamples struct {
+	delays []time.Duration
+	first  int
+	ranks  []float64
+}
+
+func (r *Recorder) Report() Report {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delays := make(map[Kind][]time.Duration)
+	items := make(map[Kind]int)
+	peers := make(map[string]map[Kind]*peerSamples)
+	for _, it := range r.items {
+		items[it.kind]++
+
+		announcers := make([]string, 0, len(it.seen))
+		for peer := range it.seen {
+			announcers = append(announcers, peer)
+		}
+		// the peers which announced at the same time are ranked by name, so the report is the same every time
+		slices.SortFunc(announcers, func(a, b string) int {
+			if c := it.seen[a].Compare(it.seen[b]); c != 0 {
+				return c
+			}
+			return strings.Compare(a, b)
+		})
+		first := it.seen[announcers[0]]
+
+		for i, peer := range announcers {
+			if peers[peer] == nil {
+				peers[peer] = make(map[Kind]*peerSamples)
+			}
+			samples := peers[peer][it.kind]
+			if samples == nil {
+				samples = &peerSamples{}
+				peers[peer][it.kind] = samples
+			}
+
+			delay := it.seen[peer].Sub(first)
+			samples.delays = append(samples.delays, delay)
+			if i == 0 {
+				samples.first++
+			} else {
+				delays[it.kind] = append(delays[it.kind], delay)
+			}
+			if len(announcers) > 1 {
+				samples.ranks = append(samples.ranks, float64(i)/float64(len(announcers)-1))
+			}
+		}
+	}
+
+	report := Report{
+		Time:   r.now(),
+		Blocks: newDistribution(items[KindBlock], delays[KindBlock]),
+		Txs:    newDistribution(items[KindTx], delays[KindTx]),
+		Peers:  make([]PeerReport, 0, len(peers)),
+	}
+	for peer, kinds := range peers {
+		report.Peers = append(report.Peers, PeerReport{
+			Peer:   peer,
+			Blocks: newPeerStats(kinds[KindBlock]),
+			Txs:    newPeerStats(kinds[KindTx]),
+		})
+	}
+	slices.SortFunc(report.Peers, func(a, b PeerReport) int { return strings.Compare(a.Peer, b.Peer) })
+
+	return report
+}
+
+func newDistribution(items int, delays []time.Duration) Distribution {
+	stats := utils.NewDurationStats(delays)
+
+	return Distribution{
+		Items:         items,
+		Announcements: stats.Count,
+		AvgMs:         ms(stats.Avg),
+		P50Ms:         ms(stats.P50),
+		P90Ms:         ms(stats.P90),
+		P99Ms:         ms(stats.P99),
+		MaxMs:         ms(stats.Max),
+	}
+}
+
+func newPeerStats(samples *peerSamples) PeerStats {
+	if samples == nil {
+		return PeerStats{}
+	}
+
+	stats := utils.NewDurationStats(samples.delays)
+	s := PeerStats{
+		Announced:     stats.Count,
+		First:         samples.first,
+		MedianDelayMs: ms(stats.P50),
+		P90DelayMs:    ms(stats.P90),
+		RankedItems:   len(samples.ranks),
+	}
+	if len(samples.ranks) == 0 {
+		return s
+	}
+
+	var sum float64
+	for _, rank := range samples.ranks {
+		sum += rank
+	}
+	s.AvgRank = sum / float64(len(samples.ranks))
+	if s.RankedItems >= MinRatedItems {
+		switch {
+		case s.AvgRank <= earlyRank:
+			s.Rating = RatingEarly
+		case s.AvgRank >= lateRank:
+			s.Rating = RatingLate
+		}
+	}
+
+	return s
+}
+
+func ms(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
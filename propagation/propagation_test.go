@@ -0,0 +1,81 @@
+package propagation
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/senseyman/bitcoin-handshake/model"
+)
+
+func TestRecorder_Report(t *testing.T) {
+	t.Parallel()
+
+	start := time.Unix(1700000000, 0)
+	at := func(ms int) time.Time { return start.Add(time.Duration(ms) * time.Millisecond) }
+	block := func(b byte) model.InvVect { return model.InvVect{Type: model.InvTypeBlock, Hash: [32]byte{b}} }
+	tx := func(b byte) model.InvVect { return model.InvVect{Type: model.InvTypeWtx, Hash: [32]byte{b}} }
+
+	r := New()
+	// peer a announces every block first, b 100ms and c 300ms later
+	for i := byte(0); i < MinRatedItems; i++ {
+		r.Announced("c", block(i), at(300))
+		r.Announced("a", block(i), at(0))
+		r.Announced("b", block(i), at(100))
+		// the repeated announcement is ignored
+		r.Announced("a", block(i), at(500))
+	}
+	// the txs are announced by b first, a is never rated as it's late for too few of them
+	r.Announced("b", tx(1), at(0))
+	r.Announced("a", tx(1), at(20))
+	r.Announced("b", tx(2), at(0))
+	r.Announced("c", tx(3), at(0))
+	// not an announcement
+	r.Announced("a", model.InvVect{Type: model.InvTypeWitnessBlock, Hash: [32]byte{0xff}}, at(0))
+
+	report := r.Report()
+
+	assert.Equal(t, Distribution{
+		Items: MinRatedItems, Announcements: 2 * MinRatedItems, AvgMs: 200, P50Ms: 100, P90Ms: 300, P99Ms: 300, MaxMs: 300,
+	}, report.Blocks)
+	assert.Equal(t, Distribution{Items: 3, Announcements: 1, AvgMs: 20, P50Ms: 20, P90Ms: 20, P99Ms: 20, MaxMs: 20}, report.Txs)
+
+	require.Len(t, report.Peers, 3)
+	assert.Equal(t, PeerReport{
+		Peer:   "a",
+		Blocks: PeerStats{Announced: MinRatedItems, First: MinRatedItems, AvgRank: 0, RankedItems: MinRatedItems, Rating: RatingEarly},
+		Txs:    PeerStats{Announced: 1, MedianDelayMs: 20, P90DelayMs: 20, AvgRank: 1, RankedItems: 1},
+	}, report.Peers[0])
+	assert.Equal(t, PeerReport{
+		Peer:   "b",
+		Blocks: PeerStats{Announced: MinRatedItems, MedianDelayMs: 100, P90DelayMs: 100, AvgRank: 0.5, RankedItems: MinRatedItems},
+		Txs:    PeerStats{Announced: 2, First: 2, RankedItems: 1},
+	}, report.Peers[1])
+	assert.Equal(t, PeerReport{
+		Peer:   "c",
+		Blocks: PeerStats{Announced: MinRatedItems, MedianDelayMs: 300, P90DelayMs: 300, AvgRank: 1, RankedItems: MinRatedItems, Rating: RatingLate},
+		Txs:    PeerStats{Announced: 1, First: 1},
+	}, report.Peers[2])
+}
+
+func TestRecorder_MaxItems(t *testing.T) {
+	t.Parallel()
+
+	r := New(WithMaxItems(2))
+	now := time.Now()
+	for i := byte(0); i < 5; i++ {
+		r.Announced("a", model.InvVect{Type: model.InvTypeBlock, Hash: [32]byte{i}}, now)
+	}
+	r.Announced("a", model.InvVect{Type: model.InvTypeTx, Hash: [32]byte{1}}, now)
+	// the evicted block is a new item again
+	r.Announced("b", model.InvVect{Type: model.InvTypeBlock, Hash: [32]byte{0}}, now)
+
+	report := r.Report()
+	assert.Equal(t, 2, report.Blocks.Items)
+	assert.Equal(t, 1, report.Txs.Items)
+	require.Len(t, report.Peers, 2)
+	assert.Equal(t, 1, report.Peers[0].Blocks.Announced)
+	assert.Equal(t, 1, report.Peers[1].Blocks.First)
+}
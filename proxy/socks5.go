@@ -0,0 +1,266 @@
+package proxy
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/senseyman/bitcoin-handshake/client"
+	"github.com/senseyman/bitcoin-handshake/utils"
+)
+
+const (
+	socksVersion       = 0x05
+	socksAuthVersion   = 0x01
+	socksCmdConnect    = 0x01
+	socksAtypIPv4      = 0x01
+	socksAtypDomain    = 0x03
+	socksAtypIPv6      = 0x04
+	socksMethodNoAuth  = 0x00
+	socksMethodUser    = 0x02
+	socksMethodNoMatch = 0xff
+
+	handshakeTimeout = 30 * time.Second
+)
+
+var (
+	ErrProxyAuthFailed       = errors.New("proxy authentication failed")
+	ErrProxyNoAcceptedMethod = errors.New("proxy has not accepted any authentication method")
+	ErrProxyBadReply         = errors.New("malformed reply from proxy")
+)
+
+// replyErrors are the failure codes of CONNECT request (RFC 1928) and Tor extensions.
+var replyErrors = map[byte]string{
+	0x01: "general failure",
+	0x02: "connection not allowed",
+	0x03: "network unreachable",
+	0x04: "host unreachable",
+	0x05: "connection refused",
+	0x06: "TTL expired",
+	0x07: "protocol error",
+	0x08: "address type not supported",
+	0xf0: "onion service descriptor can not be found",
+	0xf1: "onion service descriptor is invalid",
+	0xf2: "onion service introduction failed",
+	0xf3: "onion service rendezvous failed",
+	0xf4: "onion service missing client authorization",
+	0xf5: "onion service wrong client authorization",
+	0xf6: "onion service invalid address",
+	0xf7: "onion service introduction timed out",
+}
+
+// Dialer connects to nodes through SOCKS5 proxy, e.g. Tor. Host names are resolved by the proxy,
+// so no DNS queries leave the local machine.
+type Dialer struct {
+	proxyAddr string
+	username  string
+	password  string
+
+	// isolateStreams makes every connection use unique random credentials, which Tor uses to put the connection
+	// into a separate circuit. The configured credentials are used as they are, the proxy authorizes by them
+	isolateStreams bool
+
+	dial func(network, address string) (net.Conn, error)
+}
+
+func NewDialer(proxyAddr, username, password string, isolateStreams bool) *Dialer {
+	return &Dialer{
+		proxyAddr:      proxyAddr,
+		username:       username,
+		password:       password,
+		isolateStreams: isolateStreams,
+		dial:           net.Dial,
+	}
+}
+
+// ConnectionFn returns function which can be used as a connection function of client.NewBitcoinClient.
+func (d *Dialer) ConnectionFn() func(host string, port int) (client.Connection, error) {
+	return func(host string, port int) (client.Connection, error) {
+		return d.Dial(host, port)
+	}
+}
+
+func (d *Dialer) Dial(host string, port int) (net.Conn, error) {
+	// validate onion addresses locally, so typos are not sent to the proxy
+	if strings.HasSuffix(strings.ToLower(host), utils.OnionSuffix) {
+		if _, err := utils.DecodeOnionV3(host); err != nil {
+			return nil, fmt.Errorf("%w: %s", err, host)
+		}
+	}
+
+	conn, err := d.dial("tcp", d.proxyAddr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to proxy: %w", err)
+	}
+
+	if err := conn.SetDeadline(time.Now().Add(handshakeTimeout)); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	if err := d.handshake(conn, host, port); err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("failed to connect to %s through proxy: %w", net.JoinHostPort(host, strconv.Itoa(port)), err)
+	}
+
+	if err := conn.SetDeadline(time.Time{}); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+func (d *Dialer) credentials() (string, string, error) {
+	if !d.isolateStreams || d.username != "" || d.password != "" {
+		return d.username, d.password, nil
+	}
+
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", err
+	}
+	random := hex.EncodeToString(buf)
+
+	return random[:16], random[16:], nil
+}
+
+func (d *Dialer) handshake(conn net.Conn, host string, port int) error {
+	username, password, err := d.credentials()
+	if err != nil {
+		return err
+	}
+	useAuth := username != "" || password != ""
+
+	// greeting with the list of supported authentication methods
+	greeting := []byte{socksVersion, 1, socksMethodNoAuth}
+	if useAuth {
+		greeting = []byte{socksVersion, 2, socksMethodNoAuth, socksMethodUser}
+	}
+	if _, err := conn.Write(greeting); err != nil {
+		return err
+	}
+
+	resp := make([]byte, 2)
+	if _, err := io.ReadFull(conn, resp); err != nil {
+		return err
+	}
+	if resp[0] != socksVersion {
+		return ErrProxyBadReply
+	}
+
+	switch resp[1] {
+	case socksMethodNoAuth:
+	case socksMethodUser:
+		if !useAuth {
+			return ErrProxyBadReply
+		}
+		if err := authenticate(conn, username, password); err != nil {
+			return err
+		}
+	case socksMethodNoMatch:
+		return ErrProxyNoAcceptedMethod
+	default:
+		return ErrProxyBadReply
+	}
+
+	if err := sendConnect(conn, host, port); err != nil {
+		return err
+	}
+
+	return readConnectReply(conn)
+}
+
+// authenticate performs username/password sub-negotiation (RFC 1929).
+func authenticate(conn net.Conn, username, password string) error {
+	if len(username) > 255 || len(password) > 255 {
+		return ErrProxyAuthFailed
+	}
+
+	req := make([]byte, 0, 3+len(username)+len(password))
+	req = append(req, socksAuthVersion, byte(len(username)))
+	req = append(req, username...)
+	req = append(req, byte(len(password)))
+	req = append(req, password...)
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+
+	resp := make([]byte, 2)
+	if _, err := io.ReadFull(conn, resp); err != nil {
+		return err
+	}
+	if resp[0] != socksAuthVersion || resp[1] != 0x00 {
+		return ErrProxyAuthFailed
+	}
+
+	return nil
+}
+
+func sendConnect(conn net.Conn, host string, port int) error {
+	req := []byte{socksVersion, socksCmdConnect, 0x00}
+
+	ip := net.ParseIP(host)
+	switch {
+	case ip != nil && ip.To4() != nil:
+		req = append(req, socksAtypIPv4)
+		req = append(req, ip.To4()...)
+	case ip != nil:
+		req = append(req, socksAtypIPv6)
+		req = append(req, ip.To16()...)
+	default:
+		if len(host) > 255 {
+			return fmt.Errorf("host name is too long: %s", host)
+		}
+		req = append(req, socksAtypDomain, byte(len(host)))
+		req = append(req, host...)
+	}
+	req = binary.BigEndian.AppendUint16(req, uint16(port))
+
+	_, err := conn.Write(req)
+	return err
+}
+
+func readConnectReply(conn net.Conn) error {
+	// version, reply code, reserved, address type
+	resp := make([]byte, 4)
+	if _, err := io.ReadFull(conn, resp); err != nil {
+		return err
+	}
+	if resp[0] != socksVersion {
+		return ErrProxyBadReply
+	}
+	if resp[1] != 0x00 {
+		if reason, ok := replyErrors[resp[1]]; ok {
+			return fmt.Errorf("proxy error: %s", reason)
+		}
+		return fmt.Errorf("proxy error: unknown code 0x%02x", resp[1])
+	}
+
+	// skip bound address, it's not used
+	var addrLen int
+	switch resp[3] {
+	case socksAtypIPv4:
+		addrLen = net.IPv4len
+	case socksAtypIPv6:
+		addrLen = net.IPv6len
+	case socksAtypDomain:
+		l := make([]byte, 1)
+		if _, err := io.ReadFull(conn, l); err != nil {
+			return err
+		}
+		addrLen = int(l[0])
+	default:
+		return ErrProxyBadReply
+	}
+
+	_, err := io.ReadFull(conn, make([]byte, addrLen+2))
+	return err
+}
@@ -0,0 +1,314 @@
+package proxy
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testOnion = "2gzyxa5ihm7nsggfxnu52rck2vv4rvmdlkiu3zzui5du4xyclen53wid.onion"
+
+type socksRequest struct {
+	username string
+	password string
+	target   string
+}
+
+// fakeSocksServer is a minimal SOCKS5 server which accepts CONNECT requests and echoes everything back
+// instead of connecting to the target.
+type fakeSocksServer struct {
+	listener    net.Listener
+	requireAuth bool
+	replyCode   byte
+	// username and password are checked if they are set, like a real authenticating proxy does
+	username string
+	password string
+
+	mu       sync.Mutex
+	requests []socksRequest
+}
+
+func newFakeSocksServer(t *testing.T, requireAuth bool, replyCode byte) *fakeSocksServer {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	s := &fakeSocksServer{listener: l, requireAuth: requireAuth, replyCode: replyCode}
+	go s.serve()
+	t.Cleanup(func() {
+		_ = l.Close()
+	})
+
+	return s
+}
+
+func (s *fakeSocksServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *fakeSocksServer) handle(conn net.Conn) {
+	defer conn.Close()
+
+	var req socksRequest
+
+	hdr := make([]byte, 2)
+	if _, err := io.ReadFull(conn, hdr); err != nil {
+		return
+	}
+	methods := make([]byte, hdr[1])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return
+	}
+
+	method := byte(socksMethodNoMatch)
+	for _, m := range methods {
+		if m == socksMethodUser || (m == socksMethodNoAuth && !s.requireAuth && method == socksMethodNoMatch) {
+			method = m
+		}
+	}
+	_, _ = conn.Write([]byte{socksVersion, method})
+	if method == socksMethodNoMatch {
+		return
+	}
+
+	if method == socksMethodUser {
+		var err error
+		if req.username, req.password, err = readCredentials(conn); err != nil {
+			return
+		}
+		if s.username != "" && (req.username != s.username || req.password != s.password) {
+			_, _ = conn.Write([]byte{socksAuthVersion, 0x01})
+			return
+		}
+		_, _ = conn.Write([]byte{socksAuthVersion, 0x00})
+	}
+
+	target, err := readTarget(conn)
+	if err != nil {
+		return
+	}
+	req.target = target
+
+	s.mu.Lock()
+	s.requests = append(s.requests, req)
+	s.mu.Unlock()
+
+	_, _ = conn.Write([]byte{socksVersion, s.replyCode, 0x00, socksAtypIPv4, 0, 0, 0, 0, 0, 0})
+	if s.replyCode != 0 {
+		return
+	}
+
+	_, _ = io.Copy(conn, conn)
+}
+
+func readCredentials(conn net.Conn) (string, string, error) {
+	readString := func() (string, error) {
+		l := make([]byte, 1)
+		if _, err := io.ReadFull(conn, l); err != nil {
+			return "", err
+		}
+		b := make([]byte, l[0])
+		_, err := io.ReadFull(conn, b)
+		return string(b), err
+	}
+
+	if _, err := io.ReadFull(conn, make([]byte, 1)); err != nil {
+		return "", "", err
+	}
+	username, err := readString()
+	if err != nil {
+		return "", "", err
+	}
+	password, err := readString()
+	return username, password, err
+}
+
+func readTarget(conn net.Conn) (string, error) {
+	hdr := make([]byte, 4)
+	if _, err := io.ReadFull(conn, hdr); err != nil {
+		return "", err
+	}
+
+	var host string
+	switch hdr[3] {
+	case socksAtypIPv4, socksAtypIPv6:
+		ip := make([]byte, net.IPv4len)
+		if hdr[3] == socksAtypIPv6 {
+			ip = make([]byte, net.IPv6len)
+		}
+		if _, err := io.ReadFull(conn, ip); err != nil {
+			return "", err
+		}
+		host = net.IP(ip).String()
+	case socksAtypDomain:
+		l := make([]byte, 1)
+		if _, err := io.ReadFull(conn, l); err != nil {
+			return "", err
+		}
+		name := make([]byte, l[0])
+		if _, err := io.ReadFull(conn, name); err != nil {
+			return "", err
+		}
+		host = string(name)
+	}
+
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(conn, port); err != nil {
+		return "", err
+	}
+
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))), nil
+}
+
+func (s *fakeSocksServer) getRequests() []socksRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]socksRequest(nil), s.requests...)
+}
+
+func TestDialer_Dial(t *testing.T) {
+	testCases := []struct {
+		name        string
+		requireAuth bool
+		replyCode   byte
+		username    string
+		password    string
+		isolate     bool
+		host        string
+		expTarget   string
+		hasErr      bool
+	}{
+		{
+			name:      "success/ipv4",
+			host:      "1.2.3.4",
+			expTarget: "1.2.3.4:18333",
+		},
+		{
+			name:      "success/ipv6",
+			host:      "2001:db8::1",
+			expTarget: "[2001:db8::1]:18333",
+		},
+		{
+			name:      "success/onion",
+			host:      testOnion,
+			expTarget: testOnion + ":18333",
+		},
+		{
+			name:        "success/credentials",
+			requireAuth: true,
+			username:    "user",
+			password:    "pass",
+			host:        "1.2.3.4",
+			expTarget:   "1.2.3.4:18333",
+		},
+		{
+			name:        "success/stream_isolation",
+			requireAuth: true,
+			isolate:     true,
+			host:        testOnion,
+			expTarget:   testOnion + ":18333",
+		},
+		{
+			name:   "err/invalid_onion",
+			host:   "2gzyxa5ihm7nsggfxnu52rck2vv4rvmdlkiu3zzui5du4xyclen53wia.onion",
+			hasErr: true,
+		},
+		{
+			name:        "err/auth_required",
+			requireAuth: true,
+			host:        "1.2.3.4",
+			hasErr:      true,
+		},
+		{
+			name:      "err/connection_refused",
+			replyCode: 0x05,
+			host:      "1.2.3.4",
+			hasErr:    true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			srv := newFakeSocksServer(t, tc.requireAuth, tc.replyCode)
+			d := NewDialer(srv.listener.Addr().String(), tc.username, tc.password, tc.isolate)
+
+			conn, err := d.ConnectionFn()(tc.host, 18333)
+			if tc.hasErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			defer conn.Close()
+
+			// the connection is usable after the handshake
+			msg := []byte("version")
+			_, err = conn.Write(msg)
+			require.NoError(t, err)
+			buf := make([]byte, len(msg))
+			_, err = io.ReadFull(conn, buf)
+			require.NoError(t, err)
+			assert.Equal(t, msg, buf)
+
+			reqs := srv.getRequests()
+			require.Len(t, reqs, 1)
+			assert.Equal(t, tc.expTarget, reqs[0].target)
+			if !tc.isolate {
+				assert.Equal(t, tc.username, reqs[0].username)
+				assert.Equal(t, tc.password, reqs[0].password)
+			}
+		})
+	}
+}
+
+func TestDialer_StreamIsolation(t *testing.T) {
+	srv := newFakeSocksServer(t, true, 0)
+	d := NewDialer(srv.listener.Addr().String(), "", "", true)
+
+	for i := 0; i < 3; i++ {
+		conn, err := d.Dial(testOnion, 18333)
+		require.NoError(t, err)
+		_ = conn.Close()
+	}
+
+	reqs := srv.getRequests()
+	require.Len(t, reqs, 3)
+
+	seen := make(map[string]bool)
+	for _, req := range reqs {
+		assert.NotEmpty(t, req.username)
+		seen[req.username+":"+req.password] = true
+	}
+	assert.Len(t, seen, 3)
+}
+
+func TestDialer_StreamIsolation_Credentials(t *testing.T) {
+	srv := newFakeSocksServer(t, true, 0)
+	srv.username, srv.password = "user", "secret"
+
+	// the configured credentials aren't randomized, otherwise the proxy rejects them
+	conn, err := NewDialer(srv.listener.Addr().String(), "user", "secret", true).Dial(testOnion, 18333)
+	require.NoError(t, err)
+	_ = conn.Close()
+
+	_, err = NewDialer(srv.listener.Addr().String(), "user", "wrong", true).Dial(testOnion, 18333)
+	assert.ErrorIs(t, err, ErrProxyAuthFailed)
+
+	reqs := srv.getRequests()
+	require.Len(t, reqs, 1)
+	assert.Equal(t, "user", reqs[0].username)
+	assert.Equal(t, "secret", reqs[0].password)
+}
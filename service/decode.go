@@ -2,11 +2,17 @@ package service
 
 import (
 	"encoding/binary"
+	"errors"
+	"fmt"
 	"io"
 
 	"github.com/senseyman/bitcoin-handshake/model"
 )
 
+// maxInvPrealloc limits the inventory preallocated from the count, the count is just claimed by the peer and the
+// items may never come.
+const maxInvPrealloc = 1000
+
 type DecodeService struct {
 }
 
@@ -58,6 +64,14 @@ func (s *DecodeService) decodeElement(r io.Reader, element any) error {
 		*e = rv
 		return nil
 
+	case *model.ServiceFlag:
+		rv, err := s.uint64(r, littleEndian)
+		if err != nil {
+			return err
+		}
+		*e = model.ServiceFlag(rv)
+		return nil
+
 	case *bool:
 		rv, err := s.uint8(r)
 		if err != nil {
@@ -96,13 +110,258 @@ func (s *DecodeService) decodeElement(r io.Reader, element any) error {
 
 		return nil
 	case *string:
-		_, err := io.ReadFull(r, []byte(*e))
-		return err
+		str, err := s.decodeVarString(r, model.MaxUserAgentSize)
+		if err != nil {
+			return err
+		}
+		*e = str
+		return nil
 	}
 
 	return binary.Read(r, littleEndian, element)
 }
 
+func (s *DecodeService) DecodeVersionMessage(r io.Reader) (model.VersionMessage, error) {
+	var msg model.VersionMessage
+	err := s.DecodeElements(r,
+		&msg.Version,
+		&msg.Services,
+		&msg.Timestamp,
+		&msg.AddrRecv,
+		&msg.AddrFrom,
+		&msg.Nonce,
+		&msg.UserAgent,
+		&msg.StartHeight,
+	)
+	if err != nil {
+		return model.VersionMessage{}, err
+	}
+
+	// relay flag was added in BIP37 and is optional, nodes assume true if it's missing
+	msg.Relay = true
+	err = s.decodeElement(r, &msg.Relay)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return model.VersionMessage{}, err
+	}
+
+	return msg, nil
+}
+
+func (s *DecodeService) DecodeAddrMessage(r io.Reader) (model.AddrMessage, error) {
+	count, err := s.DecodeVarInt(r)
+	if err != nil {
+		return model.AddrMessage{}, err
+	}
+	if count > model.MaxAddrPerMessage {
+		return model.AddrMessage{}, model.ErrTooManyAddresses
+	}
+
+	msg := model.AddrMessage{
+		AddrList: make([]model.NetAddress, 0, count),
+	}
+	for i := uint64(0); i < count; i++ {
+		timestamp, err := s.uint32(r, littleEndian)
+		if err != nil {
+			return model.AddrMessage{}, err
+		}
+
+		na, err := s.decodeNetAddress(r)
+		if err != nil {
+			return model.AddrMessage{}, err
+		}
+		na.Timestamp = int64(timestamp)
+
+		msg.AddrList = append(msg.AddrList, na)
+	}
+
+	return msg, nil
+}
+
+func (s *DecodeService) DecodeAddrV2Message(r io.Reader) (model.AddrV2Message, error) {
+	count, err := s.DecodeVarInt(r)
+	if err != nil {
+		return model.AddrV2Message{}, err
+	}
+	if count > model.MaxAddrPerMessage {
+		return model.AddrV2Message{}, model.ErrTooManyAddresses
+	}
+
+	msg := model.AddrV2Message{
+		AddrList: make([]model.NetAddressV2, 0, count),
+	}
+	for i := uint64(0); i < count; i++ {
+		na, err := s.decodeNetAddressV2(r)
+		if err != nil {
+			return model.AddrV2Message{}, err
+		}
+
+		msg.AddrList = append(msg.AddrList, na)
+	}
+
+	return msg, nil
+}
+
+func (s *DecodeService) DecodeFeeFilterMessage(r io.Reader) (model.FeeFilterMessage, error) {
+	feeRate, err := s.uint64(r, littleEndian)
+	if err != nil {
+		return model.FeeFilterMessage{}, err
+	}
+
+	return model.FeeFilterMessage{FeeRate: int64(feeRate)}, nil
+}
+
+// DecodeHeadersMessage reads the headers, each of them has to be followed by zero number of txs.
+func (s *DecodeService) DecodeHeadersMessage(r io.Reader) (model.HeadersMessage, error) {
+	count, err := s.DecodeVarInt(r)
+	if err != nil {
+		return model.HeadersMessage{}, err
+	}
+	if count > model.MaxHeadersPerMessage {
+		return model.HeadersMessage{}, fmt.Errorf("%w: %d headers", model.ErrInvalidHeaders, count)
+	}
+
+	msg := model.HeadersMessage{
+		Headers: make([]model.BlockHeader, 0, count),
+	}
+	for i := uint64(0); i < count; i++ {
+		var h model.BlockHeader
+		err := s.DecodeElements(r, &h.Version, &h.PrevBlock, &h.MerkleRoot, &h.Timestamp, &h.Bits, &h.Nonce)
+		if err != nil {
+			return model.HeadersMessage{}, err
+		}
+
+		txCount, err := s.DecodeVarInt(r)
+		if err != nil {
+			return model.HeadersMessage{}, err
+		}
+		if txCount != 0 {
+			return model.HeadersMessage{}, fmt.Errorf("%w: header with %d txs", model.ErrInvalidHeaders, txCount)
+		}
+
+		msg.Headers = append(msg.Headers, h)
+	}
+
+	return msg, nil
+}
+
+// DecodeCmpctBlockMessage reads only the 80-byte header of the compact block. The nonce, the short tx ids and the
+// prefilled txs are left unread, they are dropped with the payload, as the client reads the whole payload first.
+func (s *DecodeService) DecodeCmpctBlockMessage(r io.Reader) (model.CmpctBlockMessage, error) {
+	var h model.BlockHeader
+	if err := s.DecodeElements(r, &h.Version, &h.PrevBlock, &h.MerkleRoot, &h.Timestamp, &h.Bits, &h.Nonce); err != nil {
+		return model.CmpctBlockMessage{}, err
+	}
+
+	return model.CmpctBlockMessage{Header: h}, nil
+}
+
+// DecodeInvMessage reads the inventory of inv, getdata and notfound messages.
+func (s *DecodeService) DecodeInvMessage(r io.Reader) (model.InvMessage, error) {
+	count, err := s.DecodeVarInt(r)
+	if err != nil {
+		return model.InvMessage{}, err
+	}
+	if count > model.MaxInvPerMessage {
+		return model.InvMessage{}, fmt.Errorf("%w: %d items", model.ErrTooManyInventory, count)
+	}
+
+	msg := model.InvMessage{
+		Inventory: make([]model.InvVect, 0, min(count, maxInvPrealloc)),
+	}
+	for i := uint64(0); i < count; i++ {
+		var (
+			inv     model.InvVect
+			invType uint32
+		)
+		if err := s.DecodeElements(r, &invType, &inv.Hash); err != nil {
+			return model.InvMessage{}, err
+		}
+		inv.Type = model.InvType(invType)
+
+		msg.Inventory = append(msg.Inventory, inv)
+	}
+
+	return msg, nil
+}
+
+// DecodeBlockLocatorMessage reads the payload of getheaders and getblocks messages.
+func (s *DecodeService) DecodeBlockLocatorMessage(r io.Reader) (model.BlockLocatorMessage, error) {
+	var msg model.BlockLocatorMessage
+	if err := s.DecodeElements(r, &msg.Version); err != nil {
+		return model.BlockLocatorMessage{}, err
+	}
+
+	count, err := s.DecodeVarInt(r)
+	if err != nil {
+		return model.BlockLocatorMessage{}, err
+	}
+	if count > model.MaxLocatorSize {
+		return model.BlockLocatorMessage{}, fmt.Errorf("%w: %d hashes", model.ErrLocatorTooLarge, count)
+	}
+
+	msg.Locator = make([][32]byte, count)
+	for i := range msg.Locator {
+		if err := s.DecodeElements(r, &msg.Locator[i]); err != nil {
+			return model.BlockLocatorMessage{}, err
+		}
+	}
+	if err := s.DecodeElements(r, &msg.HashStop); err != nil {
+		return model.BlockLocatorMessage{}, err
+	}
+
+	return msg, nil
+}
+
+func (s *DecodeService) decodeNetAddressV2(r io.Reader) (model.NetAddressV2, error) {
+	timestamp, err := s.uint32(r, littleEndian)
+	if err != nil {
+		return model.NetAddressV2{}, err
+	}
+
+	// services are encoded as CompactSize in addrv2
+	services, err := s.DecodeVarInt(r)
+	if err != nil {
+		return model.NetAddressV2{}, err
+	}
+
+	networkID, err := s.uint8(r)
+	if err != nil {
+		return model.NetAddressV2{}, err
+	}
+
+	size, err := s.DecodeVarInt(r)
+	if err != nil {
+		return model.NetAddressV2{}, err
+	}
+	if size > model.MaxAddrV2Size {
+		return model.NetAddressV2{}, model.ErrInvalidAddress
+	}
+
+	addr := make([]byte, size)
+	if _, err := io.ReadFull(r, addr); err != nil {
+		return model.NetAddressV2{}, err
+	}
+
+	// unknown networks must be skipped, but known ones have to match the expected size
+	netID := model.NetworkID(networkID)
+	if expSize := netID.AddrSize(); expSize != 0 && expSize != len(addr) {
+		return model.NetAddressV2{}, model.ErrInvalidAddress
+	}
+
+	buf := make([]byte, 2)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return model.NetAddressV2{}, err
+	}
+
+	return model.NetAddressV2{
+		Timestamp: int64(timestamp),
+		Services:  model.ServiceFlag(services),
+		NetworkID: netID,
+		Addr:      addr,
+		Port:      bigEndian.Uint16(buf),
+	}, nil
+}
+
 func (s *DecodeService) decodeNetAddress(r io.Reader) (model.NetAddress, error) {
 	var (
 		services uint64
@@ -127,12 +386,54 @@ func (s *DecodeService) decodeNetAddress(r io.Reader) (model.NetAddress, error)
 	port = bigEndian.Uint16(buf[:2])
 
 	return model.NetAddress{
-		Services: services,
+		Services: model.ServiceFlag(services),
 		IP:       ip[:],
 		Port:     port,
 	}, nil
 }
 
+func (s *DecodeService) DecodeVarInt(r io.Reader) (uint64, error) {
+	discriminant, err := s.uint8(r)
+	if err != nil {
+		return 0, err
+	}
+
+	switch discriminant {
+	case 0xff:
+		return s.uint64(r, littleEndian)
+
+	case 0xfe:
+		rv, err := s.uint32(r, littleEndian)
+		return uint64(rv), err
+
+	case 0xfd:
+		buf := make([]byte, 2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return 0, err
+		}
+		return uint64(littleEndian.Uint16(buf)), nil
+	}
+
+	return uint64(discriminant), nil
+}
+
+func (s *DecodeService) decodeVarString(r io.Reader, maxSize uint64) (string, error) {
+	size, err := s.DecodeVarInt(r)
+	if err != nil {
+		return "", err
+	}
+	if size > maxSize {
+		return "", model.ErrStringTooLong
+	}
+
+	buf := make([]byte, size)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return "", err
+	}
+
+	return string(buf), nil
+}
+
 func (s *DecodeService) uint64(r io.Reader, byteOrder binary.ByteOrder) (uint64, error) {
 	buf := make([]byte, 8)
 
@@ -0,0 +1,281 @@
+package service
+
+import (
+	"bytes"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/senseyman/bitcoin-handshake/model"
+)
+
+const (
+	// decoded message may take more memory than its wire form, e.g. IP is stored as slice
+	allocPerInputByte = 64
+	// allocSlack covers fixed size allocations, e.g. address list preallocated for MaxAddrPerMessage
+	allocSlack = 1 << 20
+)
+
+// capturedFrame reads the synthetic message of nodetest fake peer. The messages are kept in nodetest
+// package, which can't be imported here as it depends on service.
+func capturedFrame(tb testing.TB, command string) []byte {
+	raw, err := os.ReadFile(filepath.Join("..", "nodetest", "captured", command+".hex"))
+	require.NoError(tb, err)
+
+	frame, err := hex.DecodeString(strings.TrimSpace(string(raw)))
+	require.NoError(tb, err)
+
+	return frame
+}
+
+func capturedPayload(tb testing.TB, command string) []byte {
+	return capturedFrame(tb, command)[model.MagicSize+model.CommandSize+model.LengthSize+model.ChecksumSize:]
+}
+
+// requireBoundedAlloc fails if fn allocates more memory than the input size can justify.
+func requireBoundedAlloc(t *testing.T, inputSize int, fn func()) {
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	fn()
+	runtime.ReadMemStats(&after)
+
+	allocated := after.TotalAlloc - before.TotalAlloc
+	require.LessOrEqualf(t, allocated, uint64(inputSize*allocPerInputByte+allocSlack),
+		"decoding %d bytes allocated %d bytes", inputSize, allocated)
+}
+
+func FuzzDecodeService_DecodeVersionMessage(f *testing.F) {
+	f.Add(capturedPayload(f, model.VersionCMD))
+
+	var own bytes.Buffer
+	msg := NewMessageGenerator().GenerateNewVersionMessage("127.0.0.1", 18333, "127.0.0.1", 0)
+	require.NoError(f, NewEncodeService().EncodeVersionMessage(&own, msg))
+	f.Add(own.Bytes())
+	// without relay flag
+	f.Add(own.Bytes()[:own.Len()-1])
+
+	decoder, encoder := NewDecodeService(), NewEncodeService()
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var (
+			msg model.VersionMessage
+			err error
+		)
+		requireBoundedAlloc(t, len(data), func() {
+			msg, err = decoder.DecodeVersionMessage(bytes.NewReader(data))
+		})
+		if err != nil {
+			return
+		}
+
+		var encoded bytes.Buffer
+		require.NoError(t, encoder.EncodeVersionMessage(&encoded, msg))
+		decoded, err := decoder.DecodeVersionMessage(&encoded)
+		require.NoError(t, err)
+		assert.Equal(t, msg, decoded)
+	})
+}
+
+func FuzzDecodeService_DecodeAddrMessage(f *testing.F) {
+	f.Add(capturedPayload(f, model.AddrCMD))
+	f.Add([]byte{0xfd, 0xe9, 0x03})
+
+	decoder, encoder := NewDecodeService(), NewEncodeService()
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var (
+			msg model.AddrMessage
+			err error
+		)
+		requireBoundedAlloc(t, len(data), func() {
+			msg, err = decoder.DecodeAddrMessage(bytes.NewReader(data))
+		})
+		if err != nil {
+			return
+		}
+
+		var encoded bytes.Buffer
+		require.NoError(t, encoder.EncodeAddrMessage(&encoded, msg))
+		decoded, err := decoder.DecodeAddrMessage(&encoded)
+		require.NoError(t, err)
+		assert.Equal(t, msg, decoded)
+	})
+}
+
+func FuzzDecodeService_DecodeAddrV2Message(f *testing.F) {
+	f.Add(capturedPayload(f, model.AddrV2CMD))
+	f.Add([]byte{0x01, 0, 0, 0, 0, 0, 0x01, 0xfd, 0x00, 0x02})
+
+	decoder, encoder := NewDecodeService(), NewEncodeService()
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var (
+			msg model.AddrV2Message
+			err error
+		)
+		requireBoundedAlloc(t, len(data), func() {
+			msg, err = decoder.DecodeAddrV2Message(bytes.NewReader(data))
+		})
+		if err != nil {
+			return
+		}
+
+		var encoded bytes.Buffer
+		require.NoError(t, encoder.EncodeAddrV2Message(&encoded, msg))
+		decoded, err := decoder.DecodeAddrV2Message(&encoded)
+		require.NoError(t, err)
+		assert.Equal(t, msg, decoded)
+	})
+}
+
+func FuzzDecodeService_DecodeHeadersMessage(f *testing.F) {
+	var own bytes.Buffer
+	require.NoError(f, NewEncodeService().EncodeHeadersMessage(&own, model.HeadersMessage{
+		Headers: []model.BlockHeader{model.TestNetGenesis()},
+	}))
+	f.Add(own.Bytes())
+	f.Add([]byte{0xfd, 0xd0, 0x07})
+
+	decoder, encoder := NewDecodeService(), NewEncodeService()
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var (
+			msg model.HeadersMessage
+			err error
+		)
+		requireBoundedAlloc(t, len(data), func() {
+			msg, err = decoder.DecodeHeadersMessage(bytes.NewReader(data))
+		})
+		if err != nil {
+			return
+		}
+
+		var encoded bytes.Buffer
+		require.NoError(t, encoder.EncodeHeadersMessage(&encoded, msg))
+		decoded, err := decoder.DecodeHeadersMessage(&encoded)
+		require.NoError(t, err)
+		assert.Equal(t, msg, decoded)
+	})
+}
+
+func FuzzDecodeService_DecodeInvMessage(f *testing.F) {
+	var own bytes.Buffer
+	require.NoError(f, NewEncodeService().EncodeInvMessage(&own, model.InvMessage{
+		Inventory: []model.InvVect{{Type: model.InvTypeBlock, Hash: model.TestNetGenesis().Hash()}},
+	}))
+	f.Add(own.Bytes())
+	f.Add([]byte{0xfd, 0x50, 0xc3})
+
+	decoder, encoder := NewDecodeService(), NewEncodeService()
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var (
+			msg model.InvMessage
+			err error
+		)
+		requireBoundedAlloc(t, len(data), func() {
+			msg, err = decoder.DecodeInvMessage(bytes.NewReader(data))
+		})
+		if err != nil {
+			return
+		}
+
+		var encoded bytes.Buffer
+		require.NoError(t, encoder.EncodeInvMessage(&encoded, msg))
+		decoded, err := decoder.DecodeInvMessage(&encoded)
+		require.NoError(t, err)
+		assert.Equal(t, msg, decoded)
+	})
+}
+
+func FuzzDecodeService_DecodeBlockLocatorMessage(f *testing.F) {
+	var own bytes.Buffer
+	require.NoError(f, NewEncodeService().EncodeBlockLocatorMessage(&own, model.BlockLocatorMessage{
+		Version: model.ProtocolVersion,
+		Locator: [][32]byte{model.TestNetGenesis().Hash()},
+	}))
+	f.Add(own.Bytes())
+	f.Add([]byte{0x80, 0x11, 0x01, 0x00, 0x65})
+
+	decoder, encoder := NewDecodeService(), NewEncodeService()
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var (
+			msg model.BlockLocatorMessage
+			err error
+		)
+		requireBoundedAlloc(t, len(data), func() {
+			msg, err = decoder.DecodeBlockLocatorMessage(bytes.NewReader(data))
+		})
+		if err != nil {
+			return
+		}
+
+		var encoded bytes.Buffer
+		require.NoError(t, encoder.EncodeBlockLocatorMessage(&encoded, msg))
+		decoded, err := decoder.DecodeBlockLocatorMessage(&encoded)
+		require.NoError(t, err)
+		assert.Equal(t, msg, decoded)
+	})
+}
+
+func FuzzDecodeService_DecodeCmpctBlockMessage(f *testing.F) {
+	// header, nonce and empty short ids and prefilled txs
+	h := model.TestNetGenesis()
+	var own bytes.Buffer
+	require.NoError(f, NewEncodeService().EncodeElements(&own, h.Version, h.PrevBlock, h.MerkleRoot, h.Timestamp,
+		h.Bits, h.Nonce, uint64(0), uint8(0), uint8(0)))
+	f.Add(own.Bytes())
+	f.Add(own.Bytes()[:80])
+
+	decoder, encoder := NewDecodeService(), NewEncodeService()
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var (
+			msg model.CmpctBlockMessage
+			err error
+		)
+		requireBoundedAlloc(t, len(data), func() {
+			msg, err = decoder.DecodeCmpctBlockMessage(bytes.NewReader(data))
+		})
+		if err != nil {
+			return
+		}
+
+		// only the header is decoded, the rest of the payload is ignored
+		h := msg.Header
+		var encoded bytes.Buffer
+		require.NoError(t, encoder.EncodeElements(&encoded, h.Version, h.PrevBlock, h.MerkleRoot, h.Timestamp,
+			h.Bits, h.Nonce))
+		assert.Equal(t, data[:encoded.Len()], encoded.Bytes())
+		decoded, err := decoder.DecodeCmpctBlockMessage(&encoded)
+		require.NoError(t, err)
+		assert.Equal(t, msg, decoded)
+	})
+}
+
+func FuzzDecodeService_DecodeElements(f *testing.F) {
+	f.Add(make([]byte, 24))
+	f.Add(capturedFrame(f, model.VersionCMD))
+	f.Add(capturedFrame(f, model.VerackCMD))
+
+	decoder, encoder := NewDecodeService(), NewEncodeService()
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var (
+			magic    uint32
+			command  [model.CommandSize]byte
+			length   uint32
+			checksum [4]byte
+			err      error
+		)
+		requireBoundedAlloc(t, len(data), func() {
+			err = decoder.DecodeElements(bytes.NewReader(data), &magic, &command, &length, &checksum)
+		})
+		if err != nil {
+			return
+		}
+
+		var encoded bytes.Buffer
+		require.NoError(t, encoder.EncodeElements(&encoded, magic, command, length, checksum))
+		assert.Equal(t, data[:encoded.Len()], encoded.Bytes())
+	})
+}
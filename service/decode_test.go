@@ -0,0 +1,304 @@
+package service
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/senseyman/bitcoin-handshake/model"
+)
+
+func TestDecodeService_DecodeVersionMessage(t *testing.T) {
+	captured := capturedPayload(t, model.VersionCMD)
+	tooLongUserAgent := append(append([]byte(nil), captured[:80]...), 0xfd, 0x01, 0x01)
+
+	testCases := []struct {
+		name   string
+		data   []byte
+		exp    model.VersionMessage
+		hasErr bool
+		expErr error
+	}{
+		{
+			name: "success",
+			data: captured,
+			exp: model.VersionMessage{
+				Version:   70016,
+				Services:  0x40d,
+				Timestamp: 1713282931,
+				AddrRecv: model.NetAddress{
+					IP:   net.ParseIP("31.50.193.200"),
+					Port: 55752,
+				},
+				AddrFrom: model.NetAddress{
+					Services: 0x40d,
+					IP:       net.IPv6zero,
+				},
+				Nonce:       8996837035657133647,
+				UserAgent:   "/Satoshi:26.0.0/",
+				StartHeight: 2583417,
+				Relay:       true,
+			},
+		},
+		{
+			name: "success/no_relay",
+			data: captured[:len(captured)-1],
+			exp: model.VersionMessage{
+				Version:   70016,
+				Services:  0x40d,
+				Timestamp: 1713282931,
+				AddrRecv: model.NetAddress{
+					IP:   net.ParseIP("31.50.193.200"),
+					Port: 55752,
+				},
+				AddrFrom: model.NetAddress{
+					Services: 0x40d,
+					IP:       net.IPv6zero,
+				},
+				Nonce:       8996837035657133647,
+				UserAgent:   "/Satoshi:26.0.0/",
+				StartHeight: 2583417,
+				Relay:       true,
+			},
+		},
+		{
+			name:   "err/too_long_user_agent",
+			data:   tooLongUserAgent,
+			hasErr: true,
+			expErr: model.ErrStringTooLong,
+		},
+		{
+			name:   "err/truncated",
+			data:   captured[:50],
+			hasErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			msg, err := NewDecodeService().DecodeVersionMessage(bytes.NewReader(tc.data))
+			if tc.hasErr {
+				assert.Error(t, err)
+				if tc.expErr != nil {
+					assert.ErrorIs(t, err, tc.expErr)
+				}
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.exp, msg)
+		})
+	}
+}
+
+func TestDecodeService_DecodeHeadersMessage(t *testing.T) {
+	headers := model.HeadersMessage{Headers: []model.BlockHeader{
+		{Version: 4, Timestamp: 1700000000, Bits: 0x207fffff, Nonce: 1},
+		{Version: 4, PrevBlock: [32]byte{1}, Timestamp: 1700000001, Bits: 0x207fffff, Nonce: 2},
+	}}
+	var encoded bytes.Buffer
+	require.NoError(t, NewEncodeService().EncodeHeadersMessage(&encoded, headers))
+
+	withTxs := bytes.Clone(encoded.Bytes())
+	withTxs[1+model.BlockHeaderSize] = 1
+
+	testCases := []struct {
+		name   string
+		data   []byte
+		exp    model.HeadersMessage
+		hasErr bool
+		expErr error
+	}{
+		{
+			name: "success",
+			data: encoded.Bytes(),
+			exp:  headers,
+		},
+		{
+			name: "success/empty",
+			data: []byte{0x00},
+			exp:  model.HeadersMessage{Headers: []model.BlockHeader{}},
+		},
+		{
+			name:   "err/with_txs",
+			data:   withTxs,
+			hasErr: true,
+			expErr: model.ErrInvalidHeaders,
+		},
+		{
+			name:   "err/too_many",
+			data:   []byte{0xfd, 0xd1, 0x07},
+			hasErr: true,
+			expErr: model.ErrInvalidHeaders,
+		},
+		{
+			name:   "err/truncated",
+			data:   encoded.Bytes()[:50],
+			hasErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			msg, err := NewDecodeService().DecodeHeadersMessage(bytes.NewReader(tc.data))
+			if tc.hasErr {
+				assert.Error(t, err)
+				if tc.expErr != nil {
+					assert.ErrorIs(t, err, tc.expErr)
+				}
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.exp, msg)
+		})
+	}
+}
+
+func TestDecodeService_DecodeBlockLocatorMessage(t *testing.T) {
+	msg := model.BlockLocatorMessage{
+		Version:  model.ProtocolVersion,
+		Locator:  [][32]byte{{1}, {2}},
+		HashStop: [32]byte{3},
+	}
+	var encoded bytes.Buffer
+	require.NoError(t, NewEncodeService().EncodeBlockLocatorMessage(&encoded, msg))
+
+	tooLarge := []byte{0x01, 0x00, 0x00, 0x00, 0x66}
+
+	testCases := []struct {
+		name   string
+		data   []byte
+		exp    model.BlockLocatorMessage
+		hasErr bool
+		expErr error
+	}{
+		{
+			name: "success",
+			data: encoded.Bytes(),
+			exp:  msg,
+		},
+		{
+			name:   "err/too_large",
+			data:   tooLarge,
+			hasErr: true,
+			expErr: model.ErrLocatorTooLarge,
+		},
+		{
+			name:   "err/truncated",
+			data:   encoded.Bytes()[:40],
+			hasErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			msg, err := NewDecodeService().DecodeBlockLocatorMessage(bytes.NewReader(tc.data))
+			if tc.hasErr {
+				assert.Error(t, err)
+				if tc.expErr != nil {
+					assert.ErrorIs(t, err, tc.expErr)
+				}
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.exp, msg)
+		})
+	}
+}
+
+func TestDecodeService_DecodeInvMessage(t *testing.T) {
+	msg := model.InvMessage{Inventory: []model.InvVect{
+		{Type: model.InvTypeWitnessBlock, Hash: [32]byte{1}},
+		{Type: model.InvTypeWtx, Hash: [32]byte{2}},
+	}}
+	var encoded bytes.Buffer
+	require.NoError(t, NewEncodeService().EncodeInvMessage(&encoded, msg))
+
+	testCases := []struct {
+		name   string
+		data   []byte
+		exp    model.InvMessage
+		hasErr bool
+		expErr error
+	}{
+		{
+			name: "success",
+			data: encoded.Bytes(),
+			exp:  msg,
+		},
+		{
+			name:   "err/too_many",
+			data:   []byte{0xfe, 0x51, 0xc3, 0x00, 0x00},
+			hasErr: true,
+			expErr: model.ErrTooManyInventory,
+		},
+		{
+			name:   "err/truncated",
+			data:   encoded.Bytes()[:20],
+			hasErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			msg, err := NewDecodeService().DecodeInvMessage(bytes.NewReader(tc.data))
+			if tc.hasErr {
+				assert.Error(t, err)
+				if tc.expErr != nil {
+					assert.ErrorIs(t, err, tc.expErr)
+				}
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.exp, msg)
+		})
+	}
+}
+
+func TestDecodeService_DecodeCmpctBlockMessage(t *testing.T) {
+	header := model.BlockHeader{Version: 4, PrevBlock: [32]byte{1}, Timestamp: 1700000000, Bits: 0x207fffff, Nonce: 1}
+	// the header is followed by the nonce, short tx ids and prefilled txs which aren't decoded
+	data := append(header.Bytes(), bytes.Repeat([]byte{0xaa}, 10)...)
+
+	testCases := []struct {
+		name   string
+		data   []byte
+		exp    model.CmpctBlockMessage
+		hasErr bool
+	}{
+		{
+			name: "success",
+			data: data,
+			exp:  model.CmpctBlockMessage{Header: header},
+		},
+		{
+			name:   "err/truncated",
+			data:   data[:model.BlockHeaderSize-1],
+			hasErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			msg, err := NewDecodeService().DecodeCmpctBlockMessage(bytes.NewReader(tc.data))
+			if tc.hasErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.exp, msg)
+		})
+	}
+}
@@ -41,11 +41,111 @@ func (s *EncodeService) EncodeVersionMessage(w io.Writer, msg model.VersionMessa
 		return err
 	}
 
-	err = s.encodeElement(w, msg.StartHeight)
-	if err != nil {
+	return s.EncodeElements(w, msg.StartHeight, msg.Relay)
+}
+
+func (s *EncodeService) EncodeAddrMessage(w io.Writer, msg model.AddrMessage) error {
+	buf := make([]byte, 8)
+	if err := s.encodeVarIntBuf(w, uint64(len(msg.AddrList)), buf); err != nil {
 		return err
 	}
 
+	for i := range msg.AddrList {
+		if err := s.putUint32(w, littleEndian, uint32(msg.AddrList[i].Timestamp)); err != nil {
+			return err
+		}
+		if err := s.encodeNetAddressBuf(w, &msg.AddrList[i], buf); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *EncodeService) EncodeAddrV2Message(w io.Writer, msg model.AddrV2Message) error {
+	buf := make([]byte, 8)
+	if err := s.encodeVarIntBuf(w, uint64(len(msg.AddrList)), buf); err != nil {
+		return err
+	}
+
+	for _, na := range msg.AddrList {
+		if err := s.putUint32(w, littleEndian, uint32(na.Timestamp)); err != nil {
+			return err
+		}
+		if err := s.encodeVarIntBuf(w, uint64(na.Services), buf); err != nil {
+			return err
+		}
+		if err := s.putBytes(w, []byte{uint8(na.NetworkID)}); err != nil {
+			return err
+		}
+		if err := s.encodeVarIntBuf(w, uint64(len(na.Addr)), buf); err != nil {
+			return err
+		}
+		if err := s.putBytes(w, na.Addr); err != nil {
+			return err
+		}
+
+		bigEndian.PutUint16(buf[:2], na.Port)
+		if err := s.putBytes(w, buf[:2]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *EncodeService) EncodeInvMessage(w io.Writer, msg model.InvMessage) error {
+	buf := make([]byte, 8)
+	if err := s.encodeVarIntBuf(w, uint64(len(msg.Inventory)), buf); err != nil {
+		return err
+	}
+
+	for _, inv := range msg.Inventory {
+		if err := s.putUint32(w, littleEndian, uint32(inv.Type)); err != nil {
+			return err
+		}
+		if err := s.putBytes(w, inv.Hash[:]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// EncodeBlockLocatorMessage writes the payload of getheaders and getblocks messages.
+func (s *EncodeService) EncodeBlockLocatorMessage(w io.Writer, msg model.BlockLocatorMessage) error {
+	buf := make([]byte, 8)
+	if err := s.putUint32(w, littleEndian, msg.Version); err != nil {
+		return err
+	}
+	if err := s.encodeVarIntBuf(w, uint64(len(msg.Locator)), buf); err != nil {
+		return err
+	}
+	for _, hash := range msg.Locator {
+		if err := s.putBytes(w, hash[:]); err != nil {
+			return err
+		}
+	}
+
+	return s.putBytes(w, msg.HashStop[:])
+}
+
+// EncodeHeadersMessage writes the headers, each of them is followed by zero number of txs.
+func (s *EncodeService) EncodeHeadersMessage(w io.Writer, msg model.HeadersMessage) error {
+	buf := make([]byte, 8)
+	if err := s.encodeVarIntBuf(w, uint64(len(msg.Headers)), buf); err != nil {
+		return err
+	}
+
+	for _, h := range msg.Headers {
+		if err := s.putBytes(w, h.Bytes()); err != nil {
+			return err
+		}
+		if err := s.encodeVarIntBuf(w, 0, buf); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -89,6 +189,13 @@ func (s *EncodeService) encodeElement(w io.Writer, element any) error {
 		}
 		return nil
 
+	case model.ServiceFlag:
+		err := s.putUint64(w, littleEndian, uint64(e))
+		if err != nil {
+			return err
+		}
+		return nil
+
 	// Message header checksum.
 	case [4]byte:
 		_, err := w.Write(e[:])
@@ -134,7 +241,7 @@ func (s *EncodeService) encodeNetAddress(w io.Writer, na *model.NetAddress) erro
 }
 
 func (s *EncodeService) encodeNetAddressBuf(w io.Writer, na *model.NetAddress, buf []byte) error {
-	littleEndian.PutUint64(buf, na.Services)
+	littleEndian.PutUint64(buf, uint64(na.Services))
 	if _, err := w.Write(buf); err != nil {
 		return err
 	}
@@ -14,23 +14,22 @@ func NewMessageGenerator() *MessageGenerator {
 	return &MessageGenerator{}
 }
 
+// GenerateNewVersionMessage generates the version message of the node which serves nothing, the services and the start
+// height are set by the caller which has the blocks to serve.
 func (g *MessageGenerator) GenerateNewVersionMessage(
 	remoteHost string, remotePort uint16,
 	localHost string, localPort uint16,
 ) model.VersionMessage {
 	return model.VersionMessage{
 		Version:   model.ProtocolVersion,
-		Services:  model.ServiceNodeNetwork,
 		Timestamp: time.Now().Unix(),
 		AddrRecv: model.NetAddress{
 			Timestamp: time.Now().Unix(),
-			Services:  model.ServiceNodeNetwork,
 			IP:        net.ParseIP(remoteHost),
 			Port:      remotePort,
 		},
 		AddrFrom: model.NetAddress{
 			Timestamp: time.Now().Unix(),
-			Services:  model.ServiceNodeNetwork,
 			IP:        net.ParseIP(localHost),
 			Port:      localPort,
 		},
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/senseyman/bitcoin-handshake/client"
+	"github.com/senseyman/bitcoin-handshake/probe"
+	"github.com/senseyman/bitcoin-handshake/proxy"
+	"github.com/senseyman/bitcoin-handshake/survey"
+)
+
+// runSurvey is the survey subcommand: it handshakes every node from the list and writes a result record per node.
+func runSurvey(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	cfg := survey.DefaultConfig()
+
+	fs := flag.NewFlagSet("survey", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	targetsFile := fs.String("targets", "-", "File with the nodes to survey, one host[:port] per line. - reads from stdin")
+	format := fs.String("format", survey.FormatJSON, "Output format, one of: "+survey.FormatJSON+", "+survey.FormatCSV)
+	port := fs.Int("port", 18333, "Port of the nodes which are listed without port")
+	fs.IntVar(&cfg.Concurrency, "concurrency", cfg.Concurrency, "Number of nodes handshaked at the same time")
+	fs.DurationVar(&cfg.Timeout, "timeout", cfg.Timeout, "Timeout of every handshake attempt, including connect")
+	fs.IntVar(&cfg.Retries, "retries", cfg.Retries, "Number of extra attempts for the failed nodes")
+	fs.DurationVar(&cfg.RetryDelay, "retry.delay", cfg.RetryDelay, "Delay before the next attempt")
+	proxyAddr := fs.String("proxy", "", "Connect through SOCKS5 proxy, e.g. Tor at 127.0.0.1:9050")
+	verbose := fs.Bool("v", false, "Write the logs of handshakes to stderr")
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: survey [flags]")
+		fmt.Fprintln(stderr, "Handshakes every node from the list. Records go to stdout, the summary goes to stderr.")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if cfg.Concurrency <= 0 || cfg.Timeout <= 0 || cfg.Retries < 0 {
+		fmt.Fprintln(stderr, "survey: concurrency and timeout must be positive, retries can't be negative")
+		return 2
+	}
+
+	targets, err := readTargets(*targetsFile, stdin, *port)
+	if err != nil {
+		fmt.Fprintf(stderr, "survey: %v\n", err)
+		return 1
+	}
+	out, err := survey.NewWriter(stdout, *format)
+	if err != nil {
+		fmt.Fprintf(stderr, "survey: %v\n", err)
+		return 2
+	}
+
+	// the logs of hundreds of handshakes would hide the summary
+	if !*verbose {
+		log.SetOutput(io.Discard)
+	}
+
+	var writeErr error
+	results := survey.Run(ctx, probe.New(probeDial(cfg.Timeout, *proxyAddr)), targets, cfg, func(r survey.Result) {
+		if err := out.Write(r); err != nil && writeErr == nil {
+			writeErr = err
+		}
+	})
+	if err := errors.Join(writeErr, out.Flush()); err != nil {
+		fmt.Fprintf(stderr, "survey: %v\n", err)
+		return 1
+	}
+
+	if err := survey.Summarize(results).WriteText(stderr); err != nil {
+		return 1
+	}
+
+	return 0
+}
+
+func readTargets(path string, stdin io.Reader, defaultPort int) ([]survey.Target, error) {
+	r := stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	targets, err := survey.ReadTargets(r, defaultPort)
+	if err != nil {
+		return nil, err
+	}
+	if len(targets) == 0 {
+		return nil, errors.New("no targets to survey")
+	}
+
+	return targets, nil
+}
+
+// probeDial returns the connection function for single handshakes, the connect is limited by timeout.
+func probeDial(timeout time.Duration, proxyAddr string) func(host string, port int) (client.Connection, error) {
+	if proxyAddr != "" {
+		return proxy.NewDialer(proxyAddr, "", "", true).ConnectionFn()
+	}
+
+	dialer := net.Dialer{Timeout: timeout}
+	return func(host string, port int) (client.Connection, error) {
+		return dialer.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+	}
+}
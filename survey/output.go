@@ -0,0 +1,156 @@
+package survey
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/senseyman/bitcoin-handshake/model"
+	"github.com/senseyman/bitcoin-handshake/probe"
+)
+
+const (
+	FormatJSON = "jsonl"
+	FormatCSV  = "csv"
+)
+
+var (
+	ErrUnknownFormat = errors.New("unknown output format")
+
+	csvColumns = []string{
+		"target", "host", "port", "success", "reason", "error", "attempts", "started_at",
+		"connect_ms", "version_ms", "verack_ms", "total_ms",
+		"protocol_version", "services", "user_agent", "start_height", "relay", "time_offset_s",
+	}
+)
+
+// Writer writes a record per result in one of the formats.
+type Writer interface {
+	Write(r Result) error
+	Flush() error
+}
+
+func NewWriter(w io.Writer, format string) (Writer, error) {
+	switch format {
+	case FormatJSON:
+		return &jsonWriter{enc: json.NewEncoder(w)}, nil
+	case FormatCSV:
+		return &csvWriter{w: csv.NewWriter(w)}, nil
+	}
+
+	return nil, fmt.Errorf("%w: %s", ErrUnknownFormat, format)
+}
+
+// record is the result as it's written, latencies are in milliseconds.
+type record struct {
+	Target          string            `json:"target"`
+	Host            string            `json:"host"`
+	Port            int               `json:"port"`
+	Success         bool              `json:"success"`
+	Reason          string            `json:"reason,omitempty"`
+	Error           string            `json:"error,omitempty"`
+	Attempts        int               `json:"attempts"`
+	StartedAt       time.Time         `json:"started_at"`
+	ConnectMs       float64           `json:"connect_ms"`
+	VersionMs       float64           `json:"version_ms"`
+	VerackMs        float64           `json:"verack_ms"`
+	TotalMs         float64           `json:"total_ms"`
+	ProtocolVersion int32             `json:"protocol_version,omitempty"`
+	Services        model.ServiceFlag `json:"services,omitempty"`
+	UserAgent       string            `json:"user_agent,omitempty"`
+	StartHeight     int32             `json:"start_height,omitempty"`
+	Relay           bool              `json:"relay,omitempty"`
+	// TimeOffsetS is the clock offset of the node in seconds, it's set for the successful targets only
+	TimeOffsetS *float64 `json:"time_offset_s,omitempty"`
+}
+
+func newRecord(r Result) record {
+	rec := record{
+		Target:    r.Target.String(),
+		Host:      r.Target.Host,
+		Port:      r.Target.Port,
+		Success:   r.Err == nil,
+		Reason:    probe.Reason(r.Err),
+		Attempts:  r.Attempts,
+		StartedAt: r.StartedAt.UTC(),
+		ConnectMs: ms(r.Connect),
+	}
+	if r.Err != nil {
+		rec.Error = r.Err.Error()
+		return rec
+	}
+
+	rec.VersionMs = ms(r.VersionRTT)
+	rec.VerackMs = ms(r.VerackRTT)
+	rec.TotalMs = ms(r.Latency())
+	rec.ProtocolVersion = r.RemoteVersion.Version
+	rec.Services = r.RemoteVersion.Services
+	rec.UserAgent = r.RemoteVersion.UserAgent
+	rec.StartHeight = r.RemoteVersion.StartHeight
+	rec.Relay = r.RemoteVersion.Relay
+	offset := r.TimeOffset.Seconds()
+	rec.TimeOffsetS = &offset
+
+	return rec
+}
+
+func ms(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+type jsonWriter struct {
+	enc *json.Encoder
+}
+
+func (w *jsonWriter) Write(r Result) error {
+	return w.enc.Encode(newRecord(r))
+}
+
+func (w *jsonWriter) Flush() error {
+	return nil
+}
+
+type csvWriter struct {
+	w             *csv.Writer
+	headerWritten bool
+}
+
+func (w *csvWriter) Write(r Result) error {
+	if !w.headerWritten {
+		if err := w.w.Write(csvColumns); err != nil {
+			return err
+		}
+		w.headerWritten = true
+	}
+
+	rec := newRecord(r)
+	formatMs := func(v float64) string { return strconv.FormatFloat(v, 'f', 3, 64) }
+	services, offset := "", ""
+	if rec.Success {
+		services = rec.Services.String()
+		offset = strconv.FormatFloat(*rec.TimeOffsetS, 'f', 0, 64)
+	}
+	row := []string{
+		rec.Target, rec.Host, strconv.Itoa(rec.Port), strconv.FormatBool(rec.Success), rec.Reason, rec.Error,
+		strconv.Itoa(rec.Attempts), rec.StartedAt.Format(time.RFC3339Nano),
+		formatMs(rec.ConnectMs), formatMs(rec.VersionMs), formatMs(rec.VerackMs), formatMs(rec.TotalMs),
+		strconv.Itoa(int(rec.ProtocolVersion)), services, rec.UserAgent,
+		strconv.Itoa(int(rec.StartHeight)), strconv.FormatBool(rec.Relay), offset,
+	}
+	if err := w.w.Write(row); err != nil {
+		return err
+	}
+	// records are written as they come, so the output can be followed while the survey runs
+	w.w.Flush()
+
+	return w.w.Error()
+}
+
+func (w *csvWriter) Flush() error {
+	w.w.Flush()
+	return w.w.Error()
+}
@@ -0,0 +1,87 @@
+package survey
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/senseyman/bitcoin-handshake/probe"
+	"github.com/senseyman/bitcoin-handshake/timedata"
+	"github.com/senseyman/bitcoin-handshake/utils"
+)
+
+// Summary aggregates the results of the survey. Latencies are counted for the successful targets only.
+type Summary struct {
+	Total       int
+	Succeeded   int
+	Failed      int
+	SuccessRate float64
+	// Reasons is the number of failed targets by failure reason
+	Reasons utils.FailureCounts
+
+	Connect    utils.DurationStats
+	VersionRTT utils.DurationStats
+	VerackRTT  utils.DurationStats
+	Latency    utils.DurationStats
+	// TimeOffset is the clock offsets of the nodes, its median is the offset of the network-adjusted time
+	TimeOffset utils.DurationStats
+}
+
+func Summarize(results []Result) Summary {
+	s := Summary{Total: len(results), Reasons: utils.NewFailureCounts()}
+
+	var connect, version, verack, latency, offset []time.Duration
+	for _, r := range results {
+		if r.Err != nil {
+			s.Failed++
+			s.Reasons.Add(probe.Reason(r.Err), r.Err)
+			continue
+		}
+
+		s.Succeeded++
+		connect = append(connect, r.Connect)
+		version = append(version, r.VersionRTT)
+		verack = append(verack, r.VerackRTT)
+		latency = append(latency, r.Latency())
+		offset = append(offset, r.TimeOffset)
+	}
+
+	if s.Total > 0 {
+		s.SuccessRate = float64(s.Succeeded) / float64(s.Total)
+	}
+	s.Connect = utils.NewDurationStats(connect)
+	s.VersionRTT = utils.NewDurationStats(version)
+	s.VerackRTT = utils.NewDurationStats(verack)
+	s.Latency = utils.NewDurationStats(latency)
+	s.TimeOffset = utils.NewDurationStats(offset)
+
+	return s
+}
+
+func (s Summary) WriteText(w io.Writer) error {
+	fmt.Fprintf(w, "targets: %d, succeeded: %d (%.1f%%), failed: %d\n",
+		s.Total, s.Succeeded, 100*s.SuccessRate, s.Failed)
+	s.Reasons.WriteText(w)
+
+	if s.Succeeded == 0 {
+		return nil
+	}
+
+	if err := utils.WriteLatencyTable(w,
+		utils.LatencyRow{Name: "connect", Stats: s.Connect},
+		utils.LatencyRow{Name: "version", Stats: s.VersionRTT},
+		utils.LatencyRow{Name: "verack", Stats: s.VerackRTT},
+		utils.LatencyRow{Name: "total", Stats: s.Latency},
+	); err != nil {
+		return err
+	}
+
+	st := s.TimeOffset
+	fmt.Fprintf(w, "clock offset of nodes, s: median %+.0f, min %+.0f, max %+.0f\n",
+		st.P50.Seconds(), st.Min.Seconds(), st.Max.Seconds())
+	if st.P50 > timedata.DefaultWarnThreshold || st.P50 < -timedata.DefaultWarnThreshold {
+		fmt.Fprintln(w, "  local clock differs from the nodes, check that the date and time of the computer are correct")
+	}
+
+	return nil
+}
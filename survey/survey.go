@@ -0,0 +1,115 @@
+package survey
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/senseyman/bitcoin-handshake/probe"
+)
+
+type Handshaker interface {
+	Handshake(ctx context.Context, host string, port int) (probe.Result, error)
+}
+
+type Config struct {
+	// Concurrency is the number of targets handshaked at the same time
+	Concurrency int
+	// Timeout limits every attempt of the handshake with a target
+	Timeout time.Duration
+	// Retries is the number of extra attempts for the failed targets
+	Retries    int
+	RetryDelay time.Duration
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Concurrency: 20,
+		Timeout:     10 * time.Second,
+		Retries:     1,
+		RetryDelay:  time.Second,
+	}
+}
+
+// Result is the outcome of the survey of one target. For failed targets it's the result of the last attempt.
+type Result struct {
+	Target    Target
+	StartedAt time.Time
+	Attempts  int
+	Err       error
+	probe.Result
+}
+
+// Latency is the time from starting the connection until the handshake is done.
+func (r Result) Latency() time.Duration {
+	return r.Connect + r.Total
+}
+
+// Run handshakes all targets and returns the results in the order of targets. Every result is also passed to emit
+// as soon as it's ready, emit is never called concurrently. If the context is done, the targets which were not
+// surveyed yet are reported as failed with the context error.
+func Run(ctx context.Context, h Handshaker, targets []Target, cfg Config, emit func(Result)) []Result {
+	results := make([]Result, len(targets))
+
+	var emitMu sync.Mutex
+	report := func(i int, r Result) {
+		results[i] = r
+		if emit == nil {
+			return
+		}
+		emitMu.Lock()
+		defer emitMu.Unlock()
+		emit(r)
+	}
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < min(max(cfg.Concurrency, 1), len(targets)); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				report(i, surveyTarget(ctx, h, targets[i], cfg))
+			}
+		}()
+	}
+
+	next := 0
+feed:
+	for ; next < len(targets); next++ {
+		select {
+		case jobs <- next:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	for i := next; i < len(targets); i++ {
+		report(i, Result{Target: targets[i], StartedAt: time.Now(), Err: ctx.Err()})
+	}
+
+	return results
+}
+
+func surveyTarget(ctx context.Context, h Handshaker, target Target, cfg Config) Result {
+	result := Result{Target: target, StartedAt: time.Now()}
+	for {
+		result.Attempts++
+
+		attemptCtx, cancel := context.WithTimeout(ctx, cfg.Timeout)
+		result.Result, result.Err = h.Handshake(attemptCtx, target.Host, target.Port)
+		cancel()
+
+		if result.Err == nil || result.Attempts > cfg.Retries || ctx.Err() != nil {
+			return result
+		}
+
+		select {
+		case <-time.After(cfg.RetryDelay):
+		case <-ctx.Done():
+			return result
+		}
+	}
+}
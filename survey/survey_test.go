@@ -0,0 +1,255 @@
+package survey
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/senseyman/bitcoin-handshake/client"
+	"github.com/senseyman/bitcoin-handshake/model"
+	"github.com/senseyman/bitcoin-handshake/nodetest"
+	"github.com/senseyman/bitcoin-handshake/probe"
+)
+
+// fakeHandshaker fails the given number of first attempts of every target.
+type fakeHandshaker struct {
+	failures int
+	delay    time.Duration
+
+	mu       sync.Mutex
+	attempts map[string]int
+
+	running    atomic.Int32
+	maxRunning atomic.Int32
+}
+
+func (h *fakeHandshaker) Handshake(ctx context.Context, host string, port int) (probe.Result, error) {
+	running := h.running.Add(1)
+	defer h.running.Add(-1)
+	for {
+		maxRunning := h.maxRunning.Load()
+		if running <= maxRunning || h.maxRunning.CompareAndSwap(maxRunning, running) {
+			break
+		}
+	}
+
+	select {
+	case <-time.After(h.delay):
+	case <-ctx.Done():
+		return probe.Result{}, model.ErrContextTimeout
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	target := net.JoinHostPort(host, strconv.Itoa(port))
+	h.attempts[target]++
+	if h.attempts[target] <= h.failures {
+		return probe.Result{}, fmt.Errorf("%w: refused", probe.ErrConnect)
+	}
+
+	return probe.Result{
+		Host:    host,
+		Port:    port,
+		Connect: time.Millisecond,
+		HandshakeStats: model.HandshakeStats{
+			VersionRTT:    2 * time.Millisecond,
+			VerackRTT:     3 * time.Millisecond,
+			Total:         5 * time.Millisecond,
+			TimeOffset:    -2 * time.Second,
+			RemoteVersion: nodetest.DefaultVersion(),
+		},
+	}, nil
+}
+
+func targets(n int) []Target {
+	targets := make([]Target, 0, n)
+	for i := 0; i < n; i++ {
+		targets = append(targets, Target{Host: fmt.Sprintf("10.0.0.%d", i), Port: 18333})
+	}
+	return targets
+}
+
+func TestRun(t *testing.T) {
+	testCases := []struct {
+		name         string
+		failures     int
+		retries      int
+		expAttempts  int
+		expSucceeded bool
+	}{
+		{
+			name:         "success",
+			expAttempts:  1,
+			expSucceeded: true,
+		},
+		{
+			name:         "success/retried",
+			failures:     2,
+			retries:      2,
+			expAttempts:  3,
+			expSucceeded: true,
+		},
+		{
+			name:        "err/retries_exhausted",
+			failures:    2,
+			retries:     1,
+			expAttempts: 2,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			h := &fakeHandshaker{failures: tc.failures, delay: 5 * time.Millisecond, attempts: make(map[string]int)}
+			cfg := Config{Concurrency: 3, Timeout: time.Second, Retries: tc.retries}
+
+			var emitted []Result
+			results := Run(context.Background(), h, targets(10), cfg, func(r Result) {
+				emitted = append(emitted, r)
+			})
+
+			require.Len(t, results, 10)
+			assert.Len(t, emitted, 10)
+			assert.LessOrEqual(t, h.maxRunning.Load(), int32(3))
+			for i, r := range results {
+				assert.Equal(t, targets(10)[i], r.Target)
+				assert.Equal(t, tc.expAttempts, r.Attempts)
+				assert.Equal(t, tc.expSucceeded, r.Err == nil)
+			}
+
+			summary := Summarize(results)
+			if tc.expSucceeded {
+				assert.Equal(t, 1.0, summary.SuccessRate)
+				assert.Equal(t, 6*time.Millisecond, summary.Latency.P99)
+				assert.Equal(t, -2*time.Second, summary.TimeOffset.P50)
+				return
+			}
+			assert.Equal(t, map[string]int{probe.ReasonConnect: 10}, summary.Reasons.Counts)
+			assert.Zero(t, summary.Latency.Count)
+		})
+	}
+}
+
+func TestRun_Timeout(t *testing.T) {
+	h := &fakeHandshaker{delay: time.Second, attempts: make(map[string]int)}
+	cfg := Config{Concurrency: 2, Timeout: 10 * time.Millisecond}
+
+	results := Run(context.Background(), h, targets(2), cfg, nil)
+	for _, r := range results {
+		assert.Equal(t, probe.ReasonTimeout, probe.Reason(r.Err))
+	}
+}
+
+func TestRun_Canceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	h := &fakeHandshaker{attempts: make(map[string]int)}
+	results := Run(ctx, h, targets(5), Config{Concurrency: 1, Timeout: time.Second}, nil)
+
+	// every target gets a result even if it wasn't surveyed
+	require.Len(t, results, 5)
+	for _, r := range results {
+		assert.Error(t, r.Err)
+	}
+}
+
+func TestRun_FakeNodes(t *testing.T) {
+	var list []Target
+	for _, steps := range [][]nodetest.Step{
+		nodetest.Handshake(),
+		nodetest.Handshake(),
+		{nodetest.Expect(model.VersionCMD), nodetest.Close()},
+	} {
+		peer := nodetest.NewPeer(steps...)
+		host, port, err := peer.ListenTCP()
+		require.NoError(t, err)
+		list = append(list, Target{Host: host, Port: port})
+	}
+
+	prober := probe.New(func(host string, port int) (client.Connection, error) {
+		return net.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+	})
+	results := Run(context.Background(), prober, list, Config{Concurrency: 3, Timeout: time.Second}, nil)
+
+	require.NoError(t, results[0].Err)
+	require.NoError(t, results[1].Err)
+	assert.Equal(t, probe.ReasonDisconnected, probe.Reason(results[2].Err))
+	assert.Equal(t, nodetest.DefaultVersion().UserAgent, results[0].RemoteVersion.UserAgent)
+
+	summary := Summarize(results)
+	assert.Equal(t, 2, summary.Succeeded)
+	assert.InDelta(t, 2.0/3, summary.SuccessRate, 0.001)
+
+	var text bytes.Buffer
+	require.NoError(t, summary.WriteText(&text))
+	assert.Contains(t, text.String(), "targets: 3, succeeded: 2 (66.7%), failed: 1")
+	assert.Contains(t, text.String(), "disconnected: 1")
+	assert.Contains(t, text.String(), "clock offset of nodes, s: median ")
+	assert.NotContains(t, text.String(), "local clock differs")
+}
+
+func TestWriter(t *testing.T) {
+	h := &fakeHandshaker{attempts: make(map[string]int)}
+	results := Run(context.Background(), h, targets(2), Config{Concurrency: 1, Timeout: time.Second}, nil)
+	results[1].Err = fmt.Errorf("%w: refused", probe.ErrConnect)
+
+	t.Run("jsonl", func(t *testing.T) {
+		var buf bytes.Buffer
+		w, err := NewWriter(&buf, FormatJSON)
+		require.NoError(t, err)
+		for _, r := range results {
+			require.NoError(t, w.Write(r))
+		}
+		require.NoError(t, w.Flush())
+
+		dec := json.NewDecoder(&buf)
+		var ok, failed record
+		require.NoError(t, dec.Decode(&ok))
+		require.NoError(t, dec.Decode(&failed))
+
+		assert.True(t, ok.Success)
+		assert.Equal(t, 6.0, ok.TotalMs)
+		assert.Equal(t, "/Satoshi:27.0.0/", ok.UserAgent)
+		assert.False(t, failed.Success)
+		assert.Equal(t, probe.ReasonConnect, failed.Reason)
+		assert.Empty(t, failed.UserAgent)
+		require.NotNil(t, ok.TimeOffsetS)
+		assert.Equal(t, -2.0, *ok.TimeOffsetS)
+		assert.Nil(t, failed.TimeOffsetS)
+	})
+
+	t.Run("csv", func(t *testing.T) {
+		var buf bytes.Buffer
+		w, err := NewWriter(&buf, FormatCSV)
+		require.NoError(t, err)
+		for _, r := range results {
+			require.NoError(t, w.Write(r))
+		}
+		require.NoError(t, w.Flush())
+
+		rows, err := csv.NewReader(&buf).ReadAll()
+		require.NoError(t, err)
+		require.Len(t, rows, 3)
+		assert.Equal(t, csvColumns, rows[0])
+		assert.Equal(t, []string{"10.0.0.0:18333", "10.0.0.0", "18333", "true", ""}, rows[1][:5])
+		assert.Equal(t, "connect", rows[2][4])
+		assert.Equal(t, "-2", rows[1][len(csvColumns)-1])
+		assert.Empty(t, rows[2][len(csvColumns)-1])
+	})
+
+	_, err := NewWriter(&bytes.Buffer{}, "xml")
+	assert.ErrorIs(t, err, ErrUnknownFormat)
+}
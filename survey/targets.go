@@ -0,0 +1,68 @@
+package survey
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrInvalidTarget = errors.New("invalid target")
+)
+
+type Target struct {
+	Host string
+	Port int
+}
+
+func (t Target) String() string {
+	return net.JoinHostPort(t.Host, strconv.Itoa(t.Port))
+}
+
+// ReadTargets reads one target per line as host, host:port or [ipv6]:port. Empty lines and lines starting
+// with # are skipped, everything after the first whitespace is ignored, so the list may have comments.
+func ReadTargets(r io.Reader, defaultPort int) ([]Target, error) {
+	var targets []Target
+
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		target, err := ParseTarget(fields[0], defaultPort)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		targets = append(targets, target)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return targets, nil
+}
+
+func ParseTarget(s string, defaultPort int) (Target, error) {
+	host, portStr, err := net.SplitHostPort(s)
+	if err != nil {
+		// no port, IPv6 address may be in brackets
+		host = strings.TrimSuffix(strings.TrimPrefix(s, "["), "]")
+		if host == "" || strings.ContainsAny(host, "[]") || (strings.Contains(host, ":") && net.ParseIP(host) == nil) {
+			return Target{}, fmt.Errorf("%w: %s", ErrInvalidTarget, s)
+		}
+		return Target{Host: host, Port: defaultPort}, nil
+	}
+
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port <= 0 || port > 65535 || host == "" {
+		return Target{}, fmt.Errorf("%w: %s", ErrInvalidTarget, s)
+	}
+
+	return Target{Host: host, Port: port}, nil
+}
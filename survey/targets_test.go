@@ -0,0 +1,100 @@
+package survey
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTarget(t *testing.T) {
+	testCases := []struct {
+		name   string
+		input  string
+		exp    Target
+		hasErr bool
+	}{
+		{
+			name:  "success/host",
+			input: "node.example.com",
+			exp:   Target{Host: "node.example.com", Port: 18333},
+		},
+		{
+			name:  "success/host_port",
+			input: "10.0.0.1:8333",
+			exp:   Target{Host: "10.0.0.1", Port: 8333},
+		},
+		{
+			name:  "success/ipv6",
+			input: "2001:db8::1",
+			exp:   Target{Host: "2001:db8::1", Port: 18333},
+		},
+		{
+			name:  "success/ipv6_brackets",
+			input: "[2001:db8::1]",
+			exp:   Target{Host: "2001:db8::1", Port: 18333},
+		},
+		{
+			name:  "success/ipv6_port",
+			input: "[2001:db8::1]:8333",
+			exp:   Target{Host: "2001:db8::1", Port: 8333},
+		},
+		{
+			name:   "err/port",
+			input:  "10.0.0.1:port",
+			hasErr: true,
+		},
+		{
+			name:   "err/port_range",
+			input:  "10.0.0.1:70000",
+			hasErr: true,
+		},
+		{
+			name:   "err/no_host",
+			input:  ":8333",
+			hasErr: true,
+		},
+		{
+			name:   "err/garbage",
+			input:  "a:b:c",
+			hasErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			target, err := ParseTarget(tc.input, 18333)
+			if tc.hasErr {
+				assert.ErrorIs(t, err, ErrInvalidTarget)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tc.exp, target)
+		})
+	}
+}
+
+func TestReadTargets(t *testing.T) {
+	input := `
+# testnet fleet
+10.0.0.1
+10.0.0.2:18444   rack 2
+
+[::1]:18333
+`
+	targets, err := ReadTargets(strings.NewReader(input), 18333)
+	require.NoError(t, err)
+	assert.Equal(t, []Target{
+		{Host: "10.0.0.1", Port: 18333},
+		{Host: "10.0.0.2", Port: 18444},
+		{Host: "::1", Port: 18333},
+	}, targets)
+
+	_, err = ReadTargets(strings.NewReader("10.0.0.1\n10.0.0.1:x\n"), 18333)
+	require.ErrorIs(t, err, ErrInvalidTarget)
+	assert.Contains(t, err.Error(), "line 2")
+}
@@ -0,0 +1,151 @@
+package timedata
+
+import (
+	"slices"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// DefaultMaxSamples is the number of peers the median is computed over, the same as in Bitcoin Core.
+	DefaultMaxSamples = 200
+	// DefaultMinSamples is the number of peers needed before the median is trusted.
+	DefaultMinSamples = 5
+	// DefaultWarnThreshold is the median offset since which the local clock is reported as skewed,
+	// the same as in Bitcoin Core.
+	DefaultWarnThreshold = 10 * time.Minute
+)
+
+type Config struct {
+	MaxSamples    int
+	MinSamples    int
+	WarnThreshold time.Duration
+}
+
+func DefaultConfig() Config {
+	return Config{
+		MaxSamples:    DefaultMaxSamples,
+		MinSamples:    DefaultMinSamples,
+		WarnThreshold: DefaultWarnThreshold,
+	}
+}
+
+// Sampler keeps the clock offsets of peers and their median, which is how much the local clock differs from
+// the network-adjusted time. Every peer has one sample, the latest one. It's safe for concurrent use.
+type Sampler struct {
+	cfg Config
+
+	mu sync.Mutex
+	// samples are in the order they were added, so the oldest one is evicted first
+	samples []Sample
+	skewed  bool
+}
+
+// Sample is the clock offset of the peer: its time minus the local time.
+type Sample struct {
+	Peer   string
+	Offset time.Duration
+	Time   time.Time
+}
+
+func New(cfg Config) *Sampler {
+	return &Sampler{cfg: cfg}
+}
+
+// Add sets the offset of the peer. A warning is logged when the median offset crosses the warn threshold.
+func (s *Sampler) Add(peer string, offset time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.samples = slices.DeleteFunc(s.samples, func(sample Sample) bool { return sample.Peer == peer })
+	if len(s.samples) >= s.cfg.MaxSamples {
+		s.samples = s.samples[1:]
+	}
+	s.samples = append(s.samples, Sample{Peer: peer, Offset: offset, Time: time.Now()})
+
+	median, ok := s.median()
+	skewed := ok && s.cfg.WarnThreshold > 0 && (median > s.cfg.WarnThreshold || median < -s.cfg.WarnThreshold)
+	switch {
+	case skewed && !s.skewed:
+		log.Warnf("median clock offset of %d peers is %s, please check that the date and time of the computer "+
+			"are correct", len(s.samples), median.Round(time.Second))
+	case !skewed && s.skewed:
+		log.Infof("local clock is in sync with peers again, median offset is %s", median.Round(time.Second))
+	}
+	s.skewed = skewed
+}
+
+// Remove forgets the peer, e.g. when it's disconnected for good.
+func (s *Sampler) Remove(peer string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.samples = slices.DeleteFunc(s.samples, func(sample Sample) bool { return sample.Peer == peer })
+}
+
+// Median returns the median offset of peers, false is returned if there are not enough samples to trust it.
+func (s *Sampler) Median() (time.Duration, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.median()
+}
+
+func (s *Sampler) median() (time.Duration, bool) {
+	if len(s.samples) == 0 || len(s.samples) < s.cfg.MinSamples {
+		return 0, false
+	}
+
+	offsets := make([]time.Duration, 0, len(s.samples))
+	for _, sample := range s.samples {
+		offsets = append(offsets, sample.Offset)
+	}
+	slices.Sort(offsets)
+
+	mid := len(offsets) / 2
+	if len(offsets)%2 == 0 {
+		return (offsets[mid-1] + offsets[mid]) / 2, true
+	}
+	return offsets[mid], true
+}
+
+// Skewed is true if the median offset exceeds the warn threshold.
+func (s *Sampler) Skewed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.skewed
+}
+
+// AdjustedTime returns the local time corrected by the median offset of peers.
+func (s *Sampler) AdjustedTime() time.Time {
+	median, _ := s.Median()
+	return time.Now().Add(median)
+}
+
+// Samples returns the offsets of all peers sorted by peer.
+func (s *Sampler) Samples() []Sample {
+	s.mu.Lock()
+	samples := slices.Clone(s.samples)
+	s.mu.Unlock()
+
+	slices.SortFunc(samples, func(a, b Sample) int {
+		switch {
+		case a.Peer < b.Peer:
+			return -1
+		case a.Peer > b.Peer:
+			return 1
+		}
+		return 0
+	})
+
+	return samples
+}
+
+// Offset is the clock offset of the remote node given its timestamp and the local time it was received at.
+// Timestamps of the protocol are in seconds, so is the offset.
+func Offset(timestamp int64, local time.Time) time.Duration {
+	return time.Duration(timestamp-local.Unix()) * time.Second
+}
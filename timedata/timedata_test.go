@@ -0,0 +1,103 @@
+package timedata
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSampler_Median(t *testing.T) {
+	testCases := []struct {
+		name      string
+		offsets   []time.Duration
+		expMedian time.Duration
+		expOk     bool
+		expSkewed bool
+	}{
+		{
+			name:    "not_enough_samples",
+			offsets: []time.Duration{time.Hour, time.Hour},
+		},
+		{
+			name:      "odd",
+			offsets:   []time.Duration{-time.Second, 3 * time.Second, 2 * time.Second},
+			expMedian: 2 * time.Second,
+			expOk:     true,
+		},
+		{
+			name:      "even",
+			offsets:   []time.Duration{time.Second, 3 * time.Second, 2 * time.Second, 10 * time.Second},
+			expMedian: 2500 * time.Millisecond,
+			expOk:     true,
+		},
+		{
+			name:      "skewed",
+			offsets:   []time.Duration{-20 * time.Minute, -15 * time.Minute, time.Second},
+			expMedian: -15 * time.Minute,
+			expOk:     true,
+			expSkewed: true,
+		},
+		{
+			name:      "outliers_ignored",
+			offsets:   []time.Duration{-time.Hour, time.Second, 2 * time.Second, 3 * time.Second, time.Hour},
+			expMedian: 2 * time.Second,
+			expOk:     true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			s := New(Config{MaxSamples: DefaultMaxSamples, MinSamples: 3, WarnThreshold: DefaultWarnThreshold})
+			for i, offset := range tc.offsets {
+				s.Add(fmt.Sprintf("10.0.0.%d:8333", i), offset)
+			}
+
+			median, ok := s.Median()
+			assert.Equal(t, tc.expOk, ok)
+			assert.Equal(t, tc.expMedian, median)
+			assert.Equal(t, tc.expSkewed, s.Skewed())
+		})
+	}
+}
+
+func TestSampler_Add(t *testing.T) {
+	t.Parallel()
+
+	s := New(Config{MaxSamples: 2, MinSamples: 1, WarnThreshold: time.Minute})
+
+	// the peer has a single sample, the latest one
+	s.Add("a", time.Hour)
+	s.Add("a", time.Second)
+	samples := s.Samples()
+	require.Len(t, samples, 1)
+	assert.Equal(t, time.Second, samples[0].Offset)
+	assert.False(t, s.Skewed())
+
+	// the oldest sample is evicted
+	s.Add("b", 2*time.Hour)
+	s.Add("c", 3*time.Hour)
+	samples = s.Samples()
+	require.Len(t, samples, 2)
+	assert.Equal(t, "b", samples[0].Peer)
+	assert.Equal(t, "c", samples[1].Peer)
+	assert.True(t, s.Skewed())
+
+	s.Remove("c")
+	median, ok := s.Median()
+	require.True(t, ok)
+	assert.Equal(t, 2*time.Hour, median)
+	assert.WithinDuration(t, time.Now().Add(2*time.Hour), s.AdjustedTime(), time.Second)
+}
+
+func TestOffset(t *testing.T) {
+	t.Parallel()
+
+	local := time.Unix(1700000000, 900_000_000)
+	assert.Equal(t, 90*time.Second, Offset(1700000090, local))
+	assert.Equal(t, -time.Hour, Offset(1700000000-3600, local))
+}
@@ -0,0 +1,86 @@
+package tracing
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// InMemoryExporter keeps all exported spans, e.g. to check them in tests.
+type InMemoryExporter struct {
+	mu    sync.Mutex
+	spans []SpanData
+}
+
+func NewInMemoryExporter() *InMemoryExporter {
+	return &InMemoryExporter{}
+}
+
+func (e *InMemoryExporter) Export(span SpanData) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.spans = append(e.spans, span)
+}
+
+// Spans returns the exported spans in the order they ended.
+func (e *InMemoryExporter) Spans() []SpanData {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return append([]SpanData(nil), e.spans...)
+}
+
+func (e *InMemoryExporter) Reset() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.spans = nil
+}
+
+// JSONExporter writes every span as a JSON line, e.g. to be shipped to the tracing backend by a log collector.
+type JSONExporter struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+func NewJSONExporter(w io.Writer) *JSONExporter {
+	return &JSONExporter{enc: json.NewEncoder(w)}
+}
+
+type jsonSpan struct {
+	TraceID    string      `json:"traceId"`
+	SpanID     string      `json:"spanId"`
+	ParentID   string      `json:"parentSpanId,omitempty"`
+	Name       string      `json:"name"`
+	Start      time.Time   `json:"startTime"`
+	End        time.Time   `json:"endTime"`
+	DurationMs float64     `json:"durationMs"`
+	Attributes []Attribute `json:"attributes,omitempty"`
+	Error      string      `json:"error,omitempty"`
+}
+
+func (e *JSONExporter) Export(span SpanData) {
+	s := jsonSpan{
+		TraceID:    span.Context.TraceID.String(),
+		SpanID:     span.Context.SpanID.String(),
+		Name:       span.Name,
+		Start:      span.Start,
+		End:        span.End,
+		DurationMs: float64(span.Duration().Microseconds()) / 1000,
+		Attributes: span.Attributes,
+		Error:      span.Error,
+	}
+	if span.Parent.IsValid() {
+		s.ParentID = span.Parent.String()
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err := e.enc.Encode(s); err != nil {
+		log.Warnf("err while exporting span %s: %v", span.Name, err)
+	}
+}
@@ -0,0 +1,242 @@
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrInvalidTraceparent = errors.New("invalid traceparent")
+)
+
+type TraceID [16]byte
+
+func (id TraceID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+func (id TraceID) IsValid() bool {
+	return id != TraceID{}
+}
+
+type SpanID [8]byte
+
+func (id SpanID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+func (id SpanID) IsValid() bool {
+	return id != SpanID{}
+}
+
+// SpanContext identifies the span across services.
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+}
+
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID.IsValid() && sc.SpanID.IsValid()
+}
+
+// Traceparent formats the span context as W3C traceparent header, so the trace is continued by other services.
+func (sc SpanContext) Traceparent() string {
+	return fmt.Sprintf("00-%s-%s-01", sc.TraceID, sc.SpanID)
+}
+
+// ParseTraceparent parses W3C traceparent header of the span started by another service.
+func ParseTraceparent(s string) (SpanContext, error) {
+	var sc SpanContext
+
+	parts := strings.Split(strings.TrimSpace(s), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return sc, fmt.Errorf("%w: %q", ErrInvalidTraceparent, s)
+	}
+	traceID, err := hex.DecodeString(parts[1])
+	if err != nil || len(traceID) != len(sc.TraceID) {
+		return sc, fmt.Errorf("%w: bad trace id %q", ErrInvalidTraceparent, parts[1])
+	}
+	spanID, err := hex.DecodeString(parts[2])
+	if err != nil || len(spanID) != len(sc.SpanID) {
+		return sc, fmt.Errorf("%w: bad span id %q", ErrInvalidTraceparent, parts[2])
+	}
+	copy(sc.TraceID[:], traceID)
+	copy(sc.SpanID[:], spanID)
+	if !sc.IsValid() {
+		return sc, fmt.Errorf("%w: zero id in %q", ErrInvalidTraceparent, s)
+	}
+
+	return sc, nil
+}
+
+type spanContextKey struct{}
+
+// ContextWithSpanContext makes spans started from the context children of sc, e.g. of the span of another service.
+func ContextWithSpanContext(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, spanContextKey{}, sc)
+}
+
+func SpanContextFromContext(ctx context.Context) (SpanContext, bool) {
+	sc, ok := ctx.Value(spanContextKey{}).(SpanContext)
+	return sc, ok && sc.IsValid()
+}
+
+type Attribute struct {
+	Key   string `json:"key"`
+	Value any    `json:"value"`
+}
+
+func String(key, value string) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+func Int(key string, value int64) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+// SpanData is the finished span passed to the exporter.
+type SpanData struct {
+	Name       string
+	Context    SpanContext
+	Parent     SpanID
+	Start      time.Time
+	End        time.Time
+	Attributes []Attribute
+	// Error is empty if the operation succeeded
+	Error string
+}
+
+func (d SpanData) Duration() time.Duration {
+	return d.End.Sub(d.Start)
+}
+
+// Attribute returns the value of the attribute, the last one wins if it's set several times.
+func (d SpanData) Attribute(key string) (any, bool) {
+	for i := len(d.Attributes) - 1; i >= 0; i-- {
+		if d.Attributes[i].Key == key {
+			return d.Attributes[i].Value, true
+		}
+	}
+	return nil, false
+}
+
+// Exporter gets every finished span. It's called synchronously by Span.End, so it must not block for long.
+type Exporter interface {
+	Export(span SpanData)
+}
+
+// Tracer starts spans and passes them to the exporter when they end. Nil tracer is valid and records nothing,
+// so the code doesn't have to check whether tracing is enabled.
+type Tracer struct {
+	exporter Exporter
+}
+
+func NewTracer(exporter Exporter) *Tracer {
+	return &Tracer{exporter: exporter}
+}
+
+// Start starts the span now, it's a child of the span in ctx if there is one, otherwise a new trace is started.
+func (t *Tracer) Start(ctx context.Context, name string, attrs ...Attribute) (context.Context, *Span) {
+	return t.StartAt(ctx, name, time.Now(), attrs...)
+}
+
+// StartAt starts the span at given time, e.g. for the operation which was done before the trace had started.
+func (t *Tracer) StartAt(ctx context.Context, name string, start time.Time, attrs ...Attribute) (context.Context, *Span) {
+	if t == nil {
+		return ctx, nil
+	}
+
+	s := &Span{tracer: t, data: SpanData{Name: name, Start: start, Attributes: attrs}}
+	if parent, ok := SpanContextFromContext(ctx); ok {
+		s.data.Context.TraceID = parent.TraceID
+		s.data.Parent = parent.SpanID
+	} else {
+		s.data.Context.TraceID = newTraceID()
+	}
+	s.data.Context.SpanID = newSpanID()
+
+	return ContextWithSpanContext(ctx, s.data.Context), s
+}
+
+func newTraceID() TraceID {
+	var id TraceID
+	for !id.IsValid() {
+		_, _ = rand.Read(id[:])
+	}
+	return id
+}
+
+func newSpanID() SpanID {
+	var id SpanID
+	for !id.IsValid() {
+		_, _ = rand.Read(id[:])
+	}
+	return id
+}
+
+// Span is the running operation. All methods are safe for concurrent use and do nothing on nil span.
+type Span struct {
+	tracer *Tracer
+
+	mu    sync.Mutex
+	data  SpanData
+	ended bool
+}
+
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.data.Context
+}
+
+func (s *Span) SetAttributes(attrs ...Attribute) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Attributes = append(s.data.Attributes, attrs...)
+}
+
+// RecordError marks the span as failed, nil error is ignored.
+func (s *Span) RecordError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Error = err.Error()
+}
+
+func (s *Span) End() {
+	s.EndAt(time.Now())
+}
+
+// EndAt ends the span at given time and exports it, only the first call has effect.
+func (s *Span) EndAt(end time.Time) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = end
+	data := s.data
+	data.Attributes = append([]Attribute(nil), s.data.Attributes...)
+	s.mu.Unlock()
+
+	s.tracer.exporter.Export(data)
+}
@@ -0,0 +1,188 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	testCases := []struct {
+		name       string
+		header     string
+		expTraceID string
+		expSpanID  string
+		hasErr     bool
+	}{
+		{
+			name:       "success",
+			header:     "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+			expTraceID: "4bf92f3577b34da6a3ce929d0e0e4736",
+			expSpanID:  "00f067aa0ba902b7",
+		},
+		{
+			name:       "success/future_version_with_extra_fields",
+			header:     "01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00-extra",
+			expTraceID: "4bf92f3577b34da6a3ce929d0e0e4736",
+			expSpanID:  "00f067aa0ba902b7",
+		},
+		{
+			name:   "err/empty",
+			header: "",
+			hasErr: true,
+		},
+		{
+			name:   "err/version_00_with_extra_fields",
+			header: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+			hasErr: true,
+		},
+		{
+			name:   "err/forbidden_version",
+			header: "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+			hasErr: true,
+		},
+		{
+			name:   "err/short_trace_id",
+			header: "00-4bf92f3577b34da6-00f067aa0ba902b7-01",
+			hasErr: true,
+		},
+		{
+			name:   "err/not_hex",
+			header: "00-4bf92f3577b34da6a3ce929d0e0e473x-00f067aa0ba902b7-01",
+			hasErr: true,
+		},
+		{
+			name:   "err/zero_span_id",
+			header: "00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+			hasErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			sc, err := ParseTraceparent(tc.header)
+			if tc.hasErr {
+				assert.ErrorIs(t, err, ErrInvalidTraceparent)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expTraceID, sc.TraceID.String())
+			assert.Equal(t, tc.expSpanID, sc.SpanID.String())
+		})
+	}
+}
+
+func TestSpanContext_Traceparent(t *testing.T) {
+	header := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	sc, err := ParseTraceparent(header)
+	require.NoError(t, err)
+	assert.Equal(t, header, sc.Traceparent())
+}
+
+func TestTracer_Start(t *testing.T) {
+	exporter := NewInMemoryExporter()
+	tracer := NewTracer(exporter)
+
+	ctx, root := tracer.Start(context.Background(), "root", String("peer", "127.0.0.1:18333"))
+	_, child := tracer.Start(ctx, "child")
+	child.SetAttributes(Int("version", 70016))
+	child.RecordError(nil)
+	child.End()
+	root.RecordError(errors.New("test error"))
+	root.End()
+	// only the first end is exported
+	root.End()
+
+	spans := exporter.Spans()
+	require.Len(t, spans, 2)
+	assert.Equal(t, "child", spans[0].Name)
+	assert.Equal(t, "root", spans[1].Name)
+
+	assert.Equal(t, spans[1].Context.TraceID, spans[0].Context.TraceID)
+	assert.Equal(t, spans[1].Context.SpanID, spans[0].Parent)
+	assert.False(t, spans[1].Parent.IsValid())
+	assert.NotEqual(t, spans[0].Context.SpanID, spans[1].Context.SpanID)
+
+	version, ok := spans[0].Attribute("version")
+	assert.True(t, ok)
+	assert.Equal(t, int64(70016), version)
+	assert.Empty(t, spans[0].Error)
+	assert.Equal(t, "test error", spans[1].Error)
+	assert.GreaterOrEqual(t, spans[1].Duration(), spans[0].Duration())
+
+	exporter.Reset()
+	assert.Empty(t, exporter.Spans())
+}
+
+func TestTracer_Start_RemoteParent(t *testing.T) {
+	exporter := NewInMemoryExporter()
+	parent, err := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	require.NoError(t, err)
+
+	ctx := ContextWithSpanContext(context.Background(), parent)
+	_, span := NewTracer(exporter).StartAt(ctx, "remote_child", time.Unix(100, 0))
+	span.EndAt(time.Unix(101, 0))
+
+	spans := exporter.Spans()
+	require.Len(t, spans, 1)
+	assert.Equal(t, parent.TraceID, spans[0].Context.TraceID)
+	assert.Equal(t, parent.SpanID, spans[0].Parent)
+	assert.Equal(t, time.Second, spans[0].Duration())
+}
+
+func TestTracer_Nil(t *testing.T) {
+	var tracer *Tracer
+
+	ctx := context.Background()
+	spanCtx, span := tracer.Start(ctx, "nothing")
+	assert.Equal(t, ctx, spanCtx)
+	assert.Nil(t, span)
+
+	assert.NotPanics(t, func() {
+		span.SetAttributes(String("a", "b"))
+		span.RecordError(errors.New("test error"))
+		span.End()
+		assert.False(t, span.SpanContext().IsValid())
+	})
+}
+
+func TestJSONExporter_Export(t *testing.T) {
+	var b bytes.Buffer
+	tracer := NewTracer(NewJSONExporter(&b))
+
+	start := time.Date(2024, 4, 16, 16, 55, 31, 0, time.UTC)
+	ctx, root := tracer.StartAt(context.Background(), "root", start)
+	_, child := tracer.StartAt(ctx, "child", start, String("peer", "127.0.0.1:18333"))
+	child.RecordError(errors.New("test error"))
+	child.EndAt(start.Add(1500 * time.Microsecond))
+	root.EndAt(start.Add(2 * time.Millisecond))
+
+	dec := json.NewDecoder(&b)
+	var spans []map[string]any
+	for dec.More() {
+		var span map[string]any
+		require.NoError(t, dec.Decode(&span))
+		spans = append(spans, span)
+	}
+	require.Len(t, spans, 2)
+
+	assert.Equal(t, "child", spans[0]["name"])
+	assert.Equal(t, root.SpanContext().SpanID.String(), spans[0]["parentSpanId"])
+	assert.Equal(t, root.SpanContext().TraceID.String(), spans[0]["traceId"])
+	assert.Equal(t, 1.5, spans[0]["durationMs"])
+	assert.Equal(t, "test error", spans[0]["error"])
+	assert.Equal(t, []any{map[string]any{"key": "peer", "value": "127.0.0.1:18333"}}, spans[0]["attributes"])
+
+	assert.Equal(t, "root", spans[1]["name"])
+	assert.NotContains(t, spans[1], "parentSpanId")
+	assert.NotContains(t, spans[1], "error")
+	assert.Equal(t, "2024-04-16T16:55:31Z", spans[1]["startTime"])
+}
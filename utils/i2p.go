@@ -0,0 +1,52 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strings"
+)
+
+const (
+	I2PSuffix = ".b32.i2p"
+
+	i2pHashLen = 32
+)
+
+var (
+	ErrInvalidI2PAddress = errors.New("invalid i2p address")
+
+	// I2PBase64 is the base64 variant used by I2P for destinations, it uses '-' and '~' instead of '+' and '/'.
+	I2PBase64 = base64.NewEncoding("ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789-~")
+)
+
+// EncodeI2P returns .b32.i2p host name for the hash of I2P destination.
+func EncodeI2P(hash []byte) string {
+	return lowerBase32.EncodeToString(hash) + I2PSuffix
+}
+
+// DecodeI2P validates .b32.i2p host name and returns the hash of I2P destination.
+func DecodeI2P(host string) ([]byte, error) {
+	name, ok := strings.CutSuffix(strings.ToLower(host), I2PSuffix)
+	if !ok {
+		return nil, ErrInvalidI2PAddress
+	}
+
+	hash, err := lowerBase32.DecodeString(name)
+	if err != nil || len(hash) != i2pHashLen {
+		return nil, ErrInvalidI2PAddress
+	}
+
+	return hash, nil
+}
+
+// I2PDestinationHash returns the hash of base64 encoded I2P destination which identifies it in .b32.i2p addresses.
+func I2PDestinationHash(dest string) ([]byte, error) {
+	raw, err := I2PBase64.DecodeString(dest)
+	if err != nil {
+		return nil, ErrInvalidI2PAddress
+	}
+
+	hash := sha256.Sum256(raw)
+	return hash[:], nil
+}
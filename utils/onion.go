@@ -0,0 +1,64 @@
+package utils
+
+import (
+	"encoding/base32"
+	"errors"
+	"strings"
+
+	"golang.org/x/crypto/sha3"
+)
+
+const (
+	OnionSuffix = ".onion"
+
+	onionV3Version   = 3
+	onionV3PubKeyLen = 32
+	onionV3Len       = onionV3PubKeyLen + 2 + 1 // pubkey, checksum, version
+)
+
+var (
+	ErrInvalidOnionAddress = errors.New("invalid onion v3 address")
+
+	lowerBase32 = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+)
+
+// EncodeOnionV3 returns the .onion host name of the hidden service with given ed25519 public key.
+func EncodeOnionV3(pubKey []byte) string {
+	buf := make([]byte, 0, onionV3Len)
+	buf = append(buf, pubKey...)
+	buf = append(buf, onionChecksum(pubKey)...)
+	buf = append(buf, onionV3Version)
+
+	return lowerBase32.EncodeToString(buf) + OnionSuffix
+}
+
+// DecodeOnionV3 validates .onion host name and returns the public key of the hidden service.
+func DecodeOnionV3(host string) ([]byte, error) {
+	name, ok := strings.CutSuffix(strings.ToLower(host), OnionSuffix)
+	if !ok {
+		return nil, ErrInvalidOnionAddress
+	}
+
+	raw, err := lowerBase32.DecodeString(name)
+	if err != nil || len(raw) != onionV3Len {
+		return nil, ErrInvalidOnionAddress
+	}
+
+	pubKey := raw[:onionV3PubKeyLen]
+	checksum := raw[onionV3PubKeyLen : onionV3PubKeyLen+2]
+	if raw[onionV3Len-1] != onionV3Version || string(checksum) != string(onionChecksum(pubKey)) {
+		return nil, ErrInvalidOnionAddress
+	}
+
+	return pubKey, nil
+}
+
+// onionChecksum is the first 2 bytes of SHA3-256(".onion checksum" | pubkey | version) as defined by rend-spec-v3.
+func onionChecksum(pubKey []byte) []byte {
+	h := sha3.New256()
+	h.Write([]byte(".onion checksum"))
+	h.Write(pubKey)
+	h.Write([]byte{onionV3Version})
+
+	return h.Sum(nil)[:2]
+}
@@ -0,0 +1,56 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeOnionV3(t *testing.T) {
+	testCases := []struct {
+		name   string
+		host   string
+		hasErr bool
+	}{
+		{
+			name: "success",
+			host: "2gzyxa5ihm7nsggfxnu52rck2vv4rvmdlkiu3zzui5du4xyclen53wid.onion",
+		},
+		{
+			name: "success/upper_case",
+			host: "2GZYXA5IHM7NSGGFXNU52RCK2VV4RVMDLKIU3ZZUI5DU4XYCLEN53WID.onion",
+		},
+		{
+			name:   "err/checksum",
+			host:   "2gzyxa5ihm7nsggfxnu52rck2vv4rvmdlkiu3zzui5du4xyclen53wia.onion",
+			hasErr: true,
+		},
+		{
+			name:   "err/v2",
+			host:   "expyuzz4wqqyqhjn.onion",
+			hasErr: true,
+		},
+		{
+			name:   "err/suffix",
+			host:   "2gzyxa5ihm7nsggfxnu52rck2vv4rvmdlkiu3zzui5du4xyclen53wid.i2p",
+			hasErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			pubKey, err := DecodeOnionV3(tc.host)
+			if tc.hasErr {
+				assert.ErrorIs(t, err, ErrInvalidOnionAddress)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Len(t, pubKey, onionV3PubKeyLen)
+			assert.Equal(t, "2gzyxa5ihm7nsggfxnu52rck2vv4rvmdlkiu3zzui5du4xyclen53wid.onion", EncodeOnionV3(pubKey))
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package utils
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"text/tabwriter"
+	"time"
+)
+
+// DurationStats summarizes the distribution of measured durations.
+type DurationStats struct {
+	Count int
+	Min   time.Duration
+	Avg   time.Duration
+	P50   time.Duration
+	P90   time.Duration
+	P95   time.Duration
+	P99   time.Duration
+	Max   time.Duration
+}
+
+func NewDurationStats(samples []time.Duration) DurationStats {
+	if len(samples) == 0 {
+		return DurationStats{}
+	}
+
+	sorted := slices.Clone(samples)
+	slices.Sort(sorted)
+
+	var sum time.Duration
+	for _, d := range sorted {
+		sum += d
+	}
+
+	return DurationStats{
+		Count: len(sorted),
+		Min:   sorted[0],
+		Avg:   sum / time.Duration(len(sorted)),
+		P50:   Percentile(sorted, 50),
+		P90:   Percentile(sorted, 90),
+		P95:   Percentile(sorted, 95),
+		P99:   Percentile(sorted, 99),
+		Max:   sorted[len(sorted)-1],
+	}
+}
+
+// Percentile returns the p-th percentile of sorted samples using the nearest-rank method.
+func Percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	rank = min(max(rank, 1), len(sorted))
+
+	return sorted[rank-1]
+}
+
+// LatencyRow is the named distribution of the latency table.
+type LatencyRow struct {
+	Name  string
+	Stats DurationStats
+}
+
+// WriteLatencyTable writes the distributions as the table of milliseconds, one row per distribution.
+func WriteLatencyTable(w io.Writer, rows ...LatencyRow) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "latency, ms\tmin\tavg\tp50\tp90\tp95\tp99\tmax\t")
+	for _, row := range rows {
+		st := row.Stats
+		fmt.Fprintf(tw, "%s\t", row.Name)
+		for _, d := range []time.Duration{st.Min, st.Avg, st.P50, st.P90, st.P95, st.P99, st.Max} {
+			fmt.Fprintf(tw, "%.2f\t", float64(d.Microseconds())/1000)
+		}
+		fmt.Fprintln(tw)
+	}
+
+	return tw.Flush()
+}
+
+// FailureCounts counts the failures by reason and keeps the first error of every reason, so it's clear what is
+// behind it.
+type FailureCounts struct {
+	Counts  map[string]int
+	Samples map[string]string
+}
+
+func NewFailureCounts() FailureCounts {
+	return FailureCounts{Counts: make(map[string]int), Samples: make(map[string]string)}
+}
+
+func (f FailureCounts) Add(reason string, err error) {
+	f.Counts[reason]++
+	if _, ok := f.Samples[reason]; !ok {
+		f.Samples[reason] = err.Error()
+	}
+}
+
+// WriteText writes the counts sorted by reason, one per line.
+func (f FailureCounts) WriteText(w io.Writer) {
+	reasons := make([]string, 0, len(f.Counts))
+	for reason := range f.Counts {
+		reasons = append(reasons, reason)
+	}
+	slices.Sort(reasons)
+	for _, reason := range reasons {
+		fmt.Fprintf(w, "  %s: %d (%s)\n", reason, f.Counts[reason], f.Samples[reason])
+	}
+}
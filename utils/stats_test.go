@@ -0,0 +1,113 @@
+package utils
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPercentile(t *testing.T) {
+	samples := make([]time.Duration, 0, 100)
+	for i := 1; i <= 100; i++ {
+		samples = append(samples, time.Duration(i)*time.Millisecond)
+	}
+
+	testCases := []struct {
+		name    string
+		samples []time.Duration
+		p       float64
+		exp     time.Duration
+	}{
+		{
+			name: "success/empty",
+			p:    50,
+		},
+		{
+			name:    "success/single",
+			samples: []time.Duration{time.Second},
+			p:       99,
+			exp:     time.Second,
+		},
+		{
+			name:    "success/p50",
+			samples: samples,
+			p:       50,
+			exp:     50 * time.Millisecond,
+		},
+		{
+			name:    "success/p99",
+			samples: samples,
+			p:       99,
+			exp:     99 * time.Millisecond,
+		},
+		{
+			name:    "success/p0",
+			samples: samples,
+			p:       0,
+			exp:     time.Millisecond,
+		},
+		{
+			name:    "success/p100",
+			samples: samples,
+			p:       100,
+			exp:     100 * time.Millisecond,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tc.exp, Percentile(tc.samples, tc.p))
+		})
+	}
+}
+
+func TestNewDurationStats(t *testing.T) {
+	stats := NewDurationStats([]time.Duration{4, 1, 3, 2})
+
+	assert.Equal(t, DurationStats{
+		Count: 4,
+		Min:   1,
+		Avg:   2,
+		P50:   2,
+		P90:   4,
+		P95:   4,
+		P99:   4,
+		Max:   4,
+	}, stats)
+	assert.Equal(t, DurationStats{}, NewDurationStats(nil))
+}
+
+func TestWriteLatencyTable(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, WriteLatencyTable(&buf,
+		LatencyRow{Name: "connect", Stats: NewDurationStats([]time.Duration{time.Millisecond, 3 * time.Millisecond})},
+		LatencyRow{Name: "total", Stats: NewDurationStats([]time.Duration{1500 * time.Microsecond})},
+	))
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 3)
+	assert.Equal(t, []string{"latency,", "ms", "min", "avg", "p50", "p90", "p95", "p99", "max"}, strings.Fields(lines[0]))
+	assert.Equal(t, []string{"connect", "1.00", "2.00", "1.00", "3.00", "3.00", "3.00", "3.00"}, strings.Fields(lines[1]))
+	assert.Equal(t, []string{"total", "1.50", "1.50", "1.50", "1.50", "1.50", "1.50", "1.50"}, strings.Fields(lines[2]))
+}
+
+func TestFailureCounts(t *testing.T) {
+	counts := NewFailureCounts()
+	counts.Add("timeout", errors.New("first timeout"))
+	counts.Add("connect", errors.New("refused"))
+	counts.Add("timeout", errors.New("second timeout"))
+
+	assert.Equal(t, map[string]int{"timeout": 2, "connect": 1}, counts.Counts)
+
+	// sorted by reason with the first error of every reason
+	var buf bytes.Buffer
+	counts.WriteText(&buf)
+	assert.Equal(t, "  connect: 1 (refused)\n  timeout: 2 (first timeout)\n", buf.String())
+}